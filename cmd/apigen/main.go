@@ -0,0 +1,516 @@
+// Command apigen generates the Go client of the JSON API in pkg/client from
+// its OpenAPI document, pkg/openapi/openapi.json. Run it through go
+// generate after changing the document:
+//
+//	go generate ./pkg/client
+//
+// Each schema becomes a type and each operation a method on Client named
+// after its operationId. Methods unwrap the {"data": ...} envelope, and list
+// operations return their pagination next to the data.
+package main
+
+import (
+    "flag"
+    "fmt"
+    "go/format"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/openapi"
+    "log"
+    "os"
+    "sort"
+    "strings"
+    "unicode"
+)
+
+func main() {
+    output := flag.String("o", "client_gen.go", "file to write the client to")
+    pkg := flag.String("package", "client", "package of the client")
+    spec := flag.String("spec", "", "OpenAPI document to read instead of the embedded one")
+    flag.Parse()
+
+    data := openapi.Spec
+    if *spec != "" {
+        var err error
+        if data, err = os.ReadFile(*spec); err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    doc, err := openapi.Parse(data)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    g := &generator{doc: doc, imports: make(map[string]bool)}
+    source, err := g.generate(*pkg)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    if err := os.WriteFile(*output, source, 0o644); err != nil {
+        log.Fatal(err)
+    }
+}
+
+type generator struct {
+    doc     *openapi.Document
+    imports map[string]bool
+    body    strings.Builder
+}
+
+func (g *generator) printf(format string, args ...any) {
+    fmt.Fprintf(&g.body, format, args...)
+}
+
+func (g *generator) generate(pkg string) ([]byte, error) {
+    names := make([]string, 0, len(g.doc.Components.Schemas))
+    for name := range g.doc.Components.Schemas {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        g.schemaType(name, g.doc.Components.Schemas[name])
+    }
+
+    for _, path := range g.doc.PathNames() {
+        operations := g.doc.Paths[path].Operations()
+        methods := make([]string, 0, len(operations))
+        for method := range operations {
+            methods = append(methods, method)
+        }
+        sort.Strings(methods)
+
+        for _, method := range methods {
+            if err := g.operation(method, path, operations[method]); err != nil {
+                return nil, fmt.Errorf("%s %s: %w", method, path, err)
+            }
+        }
+    }
+
+    var source strings.Builder
+    fmt.Fprintf(&source, "// Code generated by apigen from the OpenAPI document of %s %s. DO NOT EDIT.\n\n", g.doc.Info.Title, g.doc.Info.Version)
+    fmt.Fprintf(&source, "package %s\n\n", pkg)
+
+    imports := make([]string, 0, len(g.imports))
+    for path := range g.imports {
+        imports = append(imports, path)
+    }
+    sort.Strings(imports)
+    source.WriteString("import (\n")
+    for _, path := range imports {
+        fmt.Fprintf(&source, "%q\n", path)
+    }
+    source.WriteString(")\n")
+    source.WriteString(g.body.String())
+
+    return format.Source([]byte(source.String()))
+}
+
+// schemaType declares the Go type of a named schema: a struct for an
+// object, or a string type with constants for an enum.
+func (g *generator) schemaType(name string, schema *openapi.Schema) {
+    g.printf("\n")
+    g.comment(name, schema.Description)
+
+    switch {
+    case schema.Type == "object":
+        g.printf("type %s struct {\n", name)
+        properties := make([]string, 0, len(schema.Properties))
+        for property := range schema.Properties {
+            properties = append(properties, property)
+        }
+        sort.Slice(properties, func(i, j int) bool {
+            return propertyOrder(schema, properties[i]) < propertyOrder(schema, properties[j])
+        })
+        for _, property := range properties {
+            propertySchema := schema.Properties[property]
+            required := contains(schema.Required, property)
+            tag := property
+            if !required {
+                tag += ",omitempty"
+            }
+            for _, line := range wrap(propertySchema.Description, 72) {
+                g.printf("// %s\n", line)
+            }
+            g.printf("%s %s `json:%q`\n", goName(property), g.goType(propertySchema, !required), tag)
+        }
+        g.printf("}\n")
+
+    case schema.Type == "string" && len(schema.Enum) > 0:
+        g.printf("type %s string\n\nconst (\n", name)
+        for _, value := range schema.Enum {
+            g.printf("%s%s %s = %q\n", name, goName(fmt.Sprint(value)), name, value)
+        }
+        g.printf(")\n")
+
+    default:
+        g.printf("type %s %s\n", name, g.goType(schema, false))
+    }
+}
+
+// propertyOrder keeps struct fields in the order of the required list,
+// which follows the order of the JSON, with optional fields after them.
+func propertyOrder(schema *openapi.Schema, property string) string {
+    for i, name := range schema.Required {
+        if name == property {
+            return fmt.Sprintf("0%03d", i)
+        }
+    }
+    return "1" + property
+}
+
+// goType is the Go type of a schema. Optional or nullable numbers and
+// booleans are pointers, so that zero can be told from missing or null,
+// and so are optional or nullable objects.
+func (g *generator) goType(schema *openapi.Schema, optional bool) string {
+    if schema.Ref != "" {
+        name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+        target := g.doc.Components.Schemas[name]
+        if target.Type == "object" && (optional || schema.Nullable) {
+            return "*" + name
+        }
+        return name
+    }
+
+    if len(schema.AllOf) == 1 && schema.Type == "" {
+        inner := g.goType(schema.AllOf[0], optional || schema.Nullable)
+        if (optional || schema.Nullable) && !strings.HasPrefix(inner, "*") {
+            return "*" + inner
+        }
+        return inner
+    }
+
+    pointer := ""
+    if optional || schema.Nullable {
+        pointer = "*"
+    }
+
+    switch schema.Type {
+    case "string":
+        switch schema.Format {
+        case "uuid":
+            g.imports["github.com/google/uuid"] = true
+            return "uuid.UUID"
+        case "date-time":
+            g.imports["time"] = true
+            return "time.Time"
+        }
+        return "string"
+    case "integer":
+        return pointer + "int"
+    case "number":
+        return pointer + "float64"
+    case "boolean":
+        return pointer + "bool"
+    case "array":
+        return "[]" + g.goType(schema.Items, false)
+    default:
+        return "map[string]any"
+    }
+}
+
+// operation writes the Client method of an operation, and the struct of its
+// query parameters if it has any.
+func (g *generator) operation(method, path string, operation *openapi.Operation) error {
+    route, err := g.doc.Find(method, g.doc.BasePath()+examplePath(path))
+    if err != nil {
+        return err
+    }
+
+    name := goName(operation.OperationID)
+    g.imports["context"] = true
+
+    args := []string{"ctx context.Context"}
+    var query []*openapi.Parameter
+    pathValues := make(map[string]string)
+    for _, parameter := range route.Parameters {
+        switch parameter.In {
+        case "path":
+            argument := goName(parameter.Name)
+            if strings.ToUpper(argument) == argument {
+                argument = strings.ToLower(argument)
+            } else {
+                argument = lowerFirst(argument)
+            }
+            argType := g.goType(parameter.Schema, false)
+            args = append(args, argument+" "+argType)
+            value := argument
+            if argType != "string" {
+                value += ".String()"
+            }
+            g.imports["net/url"] = true
+            pathValues[parameter.Name] = "url.PathEscape(" + value + ")"
+        case "query":
+            query = append(query, parameter)
+        }
+    }
+
+    if len(query) > 0 {
+        g.printf("\n// %sParams are the query parameters of %s.\n", name, name)
+        g.printf("type %sParams struct {\n", name)
+        for _, parameter := range query {
+            for _, line := range wrap(parameter.Description, 72) {
+                g.printf("// %s\n", line)
+            }
+            g.printf("%s %s\n", goName(parameter.Name), g.goType(parameter.Schema, false))
+        }
+        g.printf("}\n")
+        args = append(args, "params *"+name+"Params")
+    }
+
+    body := operation.RequestBody
+    if body != nil {
+        media, ok := body.Content["application/json"]
+        if !ok || media.Schema == nil {
+            return fmt.Errorf("only JSON request bodies are supported")
+        }
+        args = append(args, "body *"+g.goType(media.Schema, false))
+    }
+
+    result, err := g.result(operation)
+    if err != nil {
+        return err
+    }
+
+    g.printf("\n")
+    summary := strings.TrimSuffix(operation.Summary, ".")
+    g.comment(name, fmt.Sprintf("calls %s %s: %s.", method, path, lowerFirst(summary)), operation.Description)
+    g.printf("func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), result.signature())
+
+    queryArg := "nil"
+    if len(query) > 0 {
+        queryArg = "query"
+        g.printf("query := url.Values{}\nif params != nil {\n")
+        for _, parameter := range query {
+            field := "params." + goName(parameter.Name)
+            zero := `""`
+            switch g.goType(parameter.Schema, false) {
+            case "int", "float64":
+                zero = "0"
+            }
+            g.imports["fmt"] = true
+            g.printf("if %s != %s {\nquery.Set(%q, fmt.Sprint(%s))\n}\n", field, zero, parameter.Name, field)
+        }
+        g.printf("}\n")
+    }
+
+    bodyArg := "nil"
+    if body != nil {
+        bodyArg = "body"
+        if !body.Required {
+            bodyArg = "payload"
+            g.printf("var payload any\nif body != nil {\npayload = body\n}\n")
+        }
+    }
+
+    pathExpr := pathExpression(path, pathValues)
+    if result.envelope == "" {
+        g.printf("return c.do(ctx, %q, %s, %s, %s, nil)\n}\n", method, pathExpr, queryArg, bodyArg)
+        return nil
+    }
+
+    g.printf("var out %s\n", result.envelope)
+    g.printf("if err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\nreturn %s\n}\n", method, pathExpr, queryArg, bodyArg, result.failure())
+    g.printf("return %s\n}\n", result.success())
+    return nil
+}
+
+// result is what an operation method returns: the data of the envelope
+// of its success response, with the pagination of lists.
+type result struct {
+    envelope  string
+    dataType  string
+    paginated bool
+}
+
+func (g *generator) result(operation *openapi.Operation) (*result, error) {
+    var statuses []string
+    for status := range operation.Responses {
+        if strings.HasPrefix(status, "2") {
+            statuses = append(statuses, status)
+        }
+    }
+    if len(statuses) != 1 {
+        return nil, fmt.Errorf("expected one success response, found %d", len(statuses))
+    }
+
+    response, err := g.doc.Response(operation.Responses[statuses[0]])
+    if err != nil {
+        return nil, err
+    }
+    if len(response.Content) == 0 {
+        return &result{}, nil
+    }
+
+    media, ok := response.Content["application/json"]
+    if !ok || media.Schema == nil || media.Schema.Ref == "" {
+        return nil, fmt.Errorf("success responses must refer to a named JSON schema")
+    }
+
+    envelope, err := g.doc.Schema(media.Schema)
+    if err != nil {
+        return nil, err
+    }
+    data, ok := envelope.Properties["data"]
+    if !ok {
+        return nil, fmt.Errorf("success responses must have data")
+    }
+
+    r := &result{
+        envelope:  strings.TrimPrefix(media.Schema.Ref, "#/components/schemas/"),
+        dataType:  g.goType(data, false),
+        paginated: envelope.Properties["pagination"] != nil,
+    }
+    if !strings.HasPrefix(r.dataType, "[]") {
+        r.dataType = "*" + r.dataType
+    }
+    return r, nil
+}
+
+func (r *result) signature() string {
+    switch {
+    case r.envelope == "":
+        return "error"
+    case r.paginated:
+        return "(" + r.dataType + ", *Pagination, error)"
+    default:
+        return "(" + r.dataType + ", error)"
+    }
+}
+
+func (r *result) failure() string {
+    if r.paginated {
+        return "nil, nil, err"
+    }
+    return "nil, err"
+}
+
+func (r *result) success() string {
+    data := "out.Data"
+    if !strings.HasPrefix(r.dataType, "[]") {
+        data = "&out.Data"
+    }
+    if r.paginated {
+        return data + ", &out.Pagination, nil"
+    }
+    return data + ", nil"
+}
+
+// pathExpression is the Go expression of a path template with its
+// {parameters} replaced by their values.
+func pathExpression(path string, values map[string]string) string {
+    var parts []string
+    rest := path
+    for {
+        start := strings.Index(rest, "{")
+        if start == -1 {
+            break
+        }
+        end := strings.Index(rest, "}")
+        parts = append(parts, fmt.Sprintf("%q", rest[:start]), values[rest[start+1:end]])
+        rest = rest[end+1:]
+    }
+    if rest != "" || len(parts) == 0 {
+        parts = append(parts, fmt.Sprintf("%q", rest))
+    }
+    return strings.Join(parts, " + ")
+}
+
+// examplePath fills in the parameters of a path template so that the
+// document can match it to its operation.
+func examplePath(path string) string {
+    var b strings.Builder
+    inParameter := false
+    for _, r := range path {
+        switch {
+        case r == '{':
+            inParameter = true
+            b.WriteString("x")
+        case r == '}':
+            inParameter = false
+        case !inParameter:
+            b.WriteRune(r)
+        }
+    }
+    return b.String()
+}
+
+// comment writes a doc comment for name, wrapping its paragraphs.
+func (g *generator) comment(name string, paragraphs ...string) {
+    first := true
+    for _, paragraph := range paragraphs {
+        if paragraph == "" {
+            continue
+        }
+        if first && !strings.HasPrefix(paragraph, name) {
+            paragraph = name + " " + lowerFirst(paragraph)
+        } else if !first {
+            g.printf("//\n")
+        }
+        first = false
+
+        for _, line := range wrap(paragraph, 76) {
+            g.printf("// %s\n", line)
+        }
+    }
+}
+
+func wrap(text string, width int) []string {
+    if text == "" {
+        return nil
+    }
+
+    var lines []string
+    for _, paragraph := range strings.Split(text, "\n") {
+        line := ""
+        for _, word := range strings.Fields(paragraph) {
+            if line != "" && len(line)+1+len(word) > width {
+                lines = append(lines, line)
+                line = ""
+            }
+            if line != "" {
+                line += " "
+            }
+            line += word
+        }
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+// initialisms are written in capitals in Go names.
+var initialisms = map[string]bool{"api": true, "id": true, "sku": true, "url": true, "uuid": true}
+
+// goName turns a JSON or enum name such as redirect_url, read-only or
+// createSession into an exported Go name.
+func goName(name string) string {
+    words := strings.FieldsFunc(name, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+
+    var b strings.Builder
+    for _, word := range words {
+        if initialisms[strings.ToLower(word)] {
+            b.WriteString(strings.ToUpper(word))
+            continue
+        }
+        b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+    }
+    return b.String()
+}
+
+func lowerFirst(s string) string {
+    if s == "" || (len(s) > 1 && unicode.IsUpper(rune(s[1]))) {
+        return s
+    }
+    return strings.ToLower(s[:1]) + s[1:]
+}
+
+func contains(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,75 @@
+// Command payment-webhook plays the payment provider: it signs a webhook
+// fixture and posts it to the shop, the way the provider would.
+//
+//	go run ./cmd/payment-webhook -reference <payment id> pkg/payment/testdata/intent_captured.json
+//
+// It signs with the shop's secret, from -secret or PAYMENT_WEBHOOK_SECRET.
+//
+// Posting the same fixture twice exercises deduplication; pass -new-id to
+// send it as a new event instead.
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+func main() {
+    url := flag.String("url", "http://localhost:5000/payments/webhook", "webhook endpoint of the shop")
+    secret := flag.String("secret", os.Getenv("PAYMENT_WEBHOOK_SECRET"), "webhook signing secret")
+    reference := flag.String("reference", "", "payment ID to put in intent.reference")
+    ref := flag.String("ref", "", "provider intent reference to put in intent.ref")
+    newID := flag.Bool("new-id", false, "give the event a fresh ID")
+    flag.Parse()
+
+    if flag.NArg() != 1 || *secret == "" {
+        fmt.Fprintln(os.Stderr, "usage: payment-webhook [flags] fixture.json")
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+
+    fixture, err := os.ReadFile(flag.Arg(0))
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    var event payment.WebhookEvent
+    if err := json.Unmarshal(fixture, &event); err != nil {
+        log.Fatal(err)
+    }
+
+    if *newID {
+        event.ID = "evt_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+    }
+    if *reference != "" {
+        event.Intent.Reference = *reference
+    }
+    if *ref != "" {
+        event.Intent.Ref = *ref
+    }
+    event.CreatedAt = time.Now().UTC()
+
+    body, err := json.Marshal(event)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    err = payment.PostWebhook(ctx, &http.Client{}, *url, *secret, body)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    fmt.Printf("posted %s %s\n", event.Type, event.ID)
+}
@@ -1,15 +1,40 @@
 package main
 
 import (
+    "context"
     "database/sql"
     _ "github.com/go-sql-driver/mysql"
     "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/database"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/grpcserver"
     "github.com/kimhien2301/go-htmx-shopping-app/pkg/handlers"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/health"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/invoice"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/mailer"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/outbox"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/openapi"
     "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/tracing"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/webhook"
+    "google.golang.org/grpc"
     "html/template"
     "log"
+    "log/slog"
+    "net"
     "net/http"
+    "os"
+    "os/signal"
     "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
 )
 
 const (
@@ -17,24 +42,125 @@ const (
     staticDir   = "./static"
 )
 
+// Server timeouts. Handlers that stream large downloads or read large
+// uploads lift the read or write deadline for themselves.
+const (
+    readHeaderTimeout = 10 * time.Second
+    readTimeout       = time.Minute
+    writeTimeout      = 2 * time.Minute
+    idleTimeout       = 2 * time.Minute
+    // shutdownTimeout is how long requests in flight get to finish on
+    // shutdown; it stays under the 30 seconds most orchestrators wait
+    // between SIGTERM and SIGKILL, less SHUTDOWN_DELAY.
+    shutdownTimeout = 25 * time.Second
+)
+
 var (
     tmpl *template.Template
     db   *sql.DB
     fs   http.Handler
 
-    repo    *repository.Repository
-    handler *handlers.Handler
+    repo     *repository.Repository
+    handler  *handlers.Handler
+    webhooks *webhook.Dispatcher
+    relay    *outbox.Relay
+    payments *payment.FakeProvider
+    checker  *health.Checker
+
+    shutdownTracing func(context.Context) error
+    shutdownDelay   time.Duration
 )
 
 func init() {
+    initLogger()
+    initTracing()
+
     pattern := filepath.Join(templateDir, "**", "*.html")
-    tmpl = template.Must(template.ParseGlob(pattern))
+    tmpl = template.Must(template.New("").Funcs(handlers.TemplateFuncs()).ParseGlob(pattern))
 
     fs = http.FileServer(http.Dir(staticDir))
 
     initDB()
     repo = repository.NewRepository(db)
-    handler = handlers.NewHandler(repo, tmpl, staticDir+"/uploads")
+    initOwner()
+    webhooks = webhook.NewDispatcher(repo.Webhook)
+    invoices := newInvoicer()
+
+    baseURL := getEnv("BASE_URL", "http://localhost:5000")
+
+    notifier, err := mailer.NewNotifier(newMailer(), repo.Order, baseURL)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    // The relay records which subscribers handled a message, so a retry
+    // caused by a failed invoice does not send the mail or webhooks again.
+    relay = outbox.NewRelay(repo.Outbox)
+    relay.Subscribe("webhooks", webhooks.HandleOutbox)
+    relay.Subscribe("mail", notifier.HandleOutbox)
+    relay.Subscribe("invoices", invoices.HandleOutbox)
+
+    // The fake gateway is the only provider so far; see pkg/payment for its
+    // test card numbers. It posts its webhooks back to this server, signed
+    // with PAYMENT_WEBHOOK_SECRET, which has no default so that webhooks
+    // cannot be forged with a well-known one.
+    webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+    if webhookSecret == "" {
+        log.Fatal("PAYMENT_WEBHOOK_SECRET is not set")
+    }
+    payments = payment.NewFakeProvider(baseURL+handlers.PaymentWebhookPath, webhookSecret)
+
+    handler = handlers.NewHandler(repo, tmpl, staticDir+"/uploads", webhooks, payments, invoices)
+    handler.ImportPath = os.Getenv("PRODUCT_IMPORT_DIR")
+
+    initHealth()
+}
+
+// initLogger logs JSON lines to stderr, through log/slog and the standard
+// log package alike, leaving out records below LOG_LEVEL (debug, info, warn
+// or error). Debug logs every database query.
+func initLogger() {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+        log.Fatal("LOG_LEVEL: ", err)
+    }
+    slog.SetDefault(logging.New(os.Stderr, level))
+}
+
+// initTracing exports traces as OTEL_TRACES_EXPORTER says: "otlp" to the
+// collector at OTEL_EXPORTER_OTLP_ENDPOINT, "stdout", or "file" to
+// TRACES_FILE. By default spans are not recorded, but trace context still
+// passes through.
+func initTracing() {
+    var err error
+    shutdownTracing, err = tracing.Setup(context.Background(), tracing.Config{
+        ServiceName: "go-htmx-shopping-app",
+        Exporter:    getEnv("OTEL_TRACES_EXPORTER", tracing.None),
+        File:        getEnv("TRACES_FILE", "traces.jsonl"),
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+}
+
+// initHealth sets up the readiness checks. The workers poll every few
+// seconds and take at most seconds per message or delivery, so a minute
+// without a heartbeat means one is stuck or has died. SHUTDOWN_DELAY, such
+// as 5s, keeps serving for that long after readiness starts failing on
+// shutdown, so that the orchestrator can stop routing traffic here first.
+func initHealth() {
+    checker = health.NewChecker()
+    checker.Add("database", health.Database(db))
+    checker.Add("migrations", health.Migrations(db))
+    checker.Add("uploads", health.Writable(handler.ImageStoragePath))
+    relay.Heartbeat = checker.Heartbeat("outbox", time.Minute)
+    webhooks.Heartbeat = checker.Heartbeat("webhooks", time.Minute)
+
+    var err error
+    shutdownDelay, err = time.ParseDuration(getEnv("SHUTDOWN_DELAY", "0s"))
+    if err != nil {
+        log.Fatal("SHUTDOWN_DELAY: ", err)
+    }
 }
 
 func initDB() {
@@ -48,37 +174,198 @@ func initDB() {
     if err = db.Ping(); err != nil {
         log.Fatal(err)
     }
+
+    if err = database.Migrate(db); err != nil {
+        log.Fatal(err)
+    }
+
+    metrics.RegisterDB(db, "shopping")
 }
 
-func main() {
-    defer func(db *sql.DB) {
-        err := db.Close()
-        if err != nil {
-            log.Fatal(err)
-        }
-    }(db)
+// newMailer sends mail through SMTP_ADDR when it is set, e.g. a local sink
+// such as MailHog on localhost:1025, and only logs messages otherwise.
+func newMailer() mailer.Mailer {
+    addr := os.Getenv("SMTP_ADDR")
+    if addr == "" {
+        return mailer.LogMailer{}
+    }
+
+    return &mailer.SMTPMailer{
+        Addr:     addr,
+        From:     getEnv("MAIL_FROM", "The Identity Store <no-reply@localhost>"),
+        Username: os.Getenv("SMTP_USERNAME"),
+        Password: os.Getenv("SMTP_PASSWORD"),
+    }
+}
+
+// newInvoicer names the shop on invoices from the SELLER_* variables. Shop
+// prices include tax at TAX_RATE percent.
+func newInvoicer() *invoice.Invoicer {
+    taxRate, err := strconv.ParseFloat(getEnv("TAX_RATE", "10"), 64)
+    if err != nil {
+        log.Fatal("TAX_RATE: ", err)
+    }
+
+    seller := models.Party{
+        Name:    getEnv("SELLER_NAME", "The Identity Store"),
+        Email:   os.Getenv("SELLER_EMAIL"),
+        Address: strings.ReplaceAll(os.Getenv("SELLER_ADDRESS"), `\n`, "\n"),
+        TaxID:   os.Getenv("SELLER_TAX_ID"),
+    }
+
+    return invoice.NewInvoicer(repo, seller, taxRate)
+}
+
+// newGRPCServer sets up the order service for internal services on
+// GRPC_ADDR, by default on the loopback interface only. GRPC_TLS_CERT and
+// GRPC_TLS_KEY turn on TLS, and GRPC_TLS_CLIENT_CA requires client
+// certificates signed by that CA; other clients send a staff session as a
+// bearer token. Without TLS the server only starts with GRPC_INSECURE set.
+func newGRPCServer() (*grpcserver.OrderServer, *grpc.Server, net.Listener) {
+    orders := grpcserver.NewOrderServer(repo)
+    orders.Cancelled = handler.VoidPayments
+
+    server, err := grpcserver.NewServer(orders, grpcserver.TLSConfig{
+        CertFile:     os.Getenv("GRPC_TLS_CERT"),
+        KeyFile:      os.Getenv("GRPC_TLS_KEY"),
+        ClientCAFile: os.Getenv("GRPC_TLS_CLIENT_CA"),
+        Insecure:     os.Getenv("GRPC_INSECURE") != "",
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    listener, err := net.Listen("tcp", getEnv("GRPC_ADDR", "127.0.0.1:5001"))
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    return orders, server, listener
+}
+
+func getEnv(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
 
+// initOwner creates the first owner account from ADMIN_EMAIL and
+// ADMIN_PASSWORD so a fresh install has someone who can sign in to the admin.
+func initOwner() {
+    email := os.Getenv("ADMIN_EMAIL")
+    password := os.Getenv("ADMIN_PASSWORD")
+    if email == "" || password == "" {
+        return
+    }
+
+    ctx := context.Background()
+    if _, err := repo.User.GetByEmail(ctx, email); err == nil {
+        return
+    }
+
+    hash, err := auth.HashPassword(password)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    _, err = repo.User.Create(ctx, &models.User{
+        Email:        email,
+        Name:         "Owner",
+        PasswordHash: hash,
+        Role:         models.Owner,
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+}
+
+func main() {
     router := mux.NewRouter()
+    router.Use(tracing.Route)
+    router.Use(logging.Route)
+    router.Use(metrics.Instrument)
 
     router.PathPrefix("/static").Handler(http.StripPrefix("/static", fs))
 
+    // Probes for the orchestrator: liveness, and readiness with a JSON
+    // report of every dependency.
+    router.HandleFunc(health.LivenessPath, health.Live).Methods("GET")
+    router.HandleFunc(health.ReadinessPath, checker.ServeReady).Methods("GET")
+
+    // Prometheus scrapes with METRICS_TOKEN as a bearer token when it is set.
+    router.Handle(metrics.Path, metrics.Handler(os.Getenv("METRICS_TOKEN"))).Methods("GET")
+
     // Admin routes
-    router.HandleFunc("/seed-products", handler.SeedProduct).Methods("POST")
-    router.HandleFunc("/manage-products", handler.ProductsPage).Methods("GET")
-    router.HandleFunc("/all-products", handler.AllProductsView).Methods("GET")
-    router.HandleFunc("/products", handler.ListProducts).Methods("GET")
-    router.HandleFunc("/product/{id}", handler.ProductView).Methods("GET")
-    router.HandleFunc("/create-product", handler.CreateProductView).Methods("GET")
-    router.HandleFunc("/product", handler.CreateProduct).Methods("POST")
-    router.HandleFunc("/product/{id}", handler.DeleteProduct).Methods("DELETE")
-    router.HandleFunc("/product/{id}/edit", handler.EditProductView).Methods("GET")
-    router.HandleFunc("/product/{id}", handler.EditProduct).Methods("PUT")
-
-    router.HandleFunc("/manage-orders", handler.ManageOrdersPage).Methods("GET")
-    router.HandleFunc("/order-table", handler.OrderTableView).Methods("GET")
-    router.HandleFunc("/orders", handler.OrderTableRowsView).Methods("GET")
-    router.HandleFunc("/order/{id}", handler.OrderDetailView).Methods("GET")
-    router.HandleFunc("/order/{id}", handler.UpdateOrderStatus).Methods("PUT")
+    router.HandleFunc("/admin/login", handler.AdminLoginView).Methods("GET")
+    router.HandleFunc("/admin/login", handler.AdminLogin).Methods("POST")
+    router.HandleFunc("/admin/logout", handler.AdminLogout).Methods("POST")
+
+    admin := router.NewRoute().Subrouter()
+    admin.Use(handler.RequireAdmin)
+
+    adminRoutes := func(permission auth.Permission) *mux.Router {
+        routes := admin.NewRoute().Subrouter()
+        routes.Use(handler.RequirePermission(permission))
+        return routes
+    }
+
+    seedProducts := adminRoutes(auth.SeedProducts)
+    seedProducts.HandleFunc("/seed-products", handler.SeedProduct).Methods("POST")
+
+    viewProducts := adminRoutes(auth.ViewProducts)
+    viewProducts.HandleFunc("/manage-products", handler.ProductsPage).Methods("GET")
+    viewProducts.HandleFunc("/all-products", handler.AllProductsView).Methods("GET")
+    viewProducts.HandleFunc("/products", handler.ListProducts).Methods("GET")
+    viewProducts.HandleFunc("/product/{id}", handler.ProductView).Methods("GET")
+    viewProducts.HandleFunc("/products/export", handler.ExportProducts).Methods("GET")
+
+    editProducts := adminRoutes(auth.EditProducts)
+    editProducts.HandleFunc("/create-product", handler.CreateProductView).Methods("GET")
+    editProducts.HandleFunc("/product", handler.CreateProduct).Methods("POST")
+    editProducts.HandleFunc("/product/{id}", handler.DeleteProduct).Methods("DELETE")
+    editProducts.HandleFunc("/product/{id}/edit", handler.EditProductView).Methods("GET")
+    editProducts.HandleFunc("/product/{id}", handler.EditProduct).Methods("PUT")
+    editProducts.HandleFunc("/product-import", handler.ProductImportView).Methods("GET")
+    editProducts.HandleFunc("/product-import", handler.ImportProducts).Methods("POST")
+
+    viewOrders := adminRoutes(auth.ViewOrders)
+    viewOrders.HandleFunc("/manage-orders", handler.ManageOrdersPage).Methods("GET")
+    viewOrders.HandleFunc("/order-table", handler.OrderTableView).Methods("GET")
+    viewOrders.HandleFunc("/orders", handler.OrderTableRowsView).Methods("GET")
+    viewOrders.HandleFunc("/orders/export", handler.ExportOrders).Methods("GET")
+    viewOrders.HandleFunc("/order/{id}", handler.OrderDetailView).Methods("GET")
+    viewOrders.HandleFunc("/order/{id}/invoice", handler.OrderInvoice).Methods("GET")
+    viewOrders.HandleFunc("/invoice/{id}", handler.InvoiceDownload).Methods("GET")
+    viewOrders.HandleFunc("/fulfilment/packing-slips", handler.PackingSlips).Methods("GET")
+    viewOrders.HandleFunc("/fulfilment/pick-list", handler.PickList).Methods("GET")
+    viewOrders.HandleFunc("/manage-returns", handler.ReturnsPage).Methods("GET")
+    viewOrders.HandleFunc("/returns", handler.ReturnTableView).Methods("GET")
+    viewOrders.HandleFunc("/return/{id}", handler.ReturnDetailView).Methods("GET")
+
+    updateOrders := adminRoutes(auth.UpdateOrders)
+    updateOrders.HandleFunc("/order/{id}", handler.UpdateOrderStatus).Methods("PUT")
+    updateOrders.HandleFunc("/return/{id}", handler.UpdateReturnStatus).Methods("PUT")
+    updateOrders.HandleFunc("/payment-event/{id}/reprocess", handler.ReprocessPaymentEvent).Methods("POST")
+
+    refundOrders := adminRoutes(auth.RefundOrders)
+    refundOrders.HandleFunc("/order/{id}/refund", handler.RefundOrder).Methods("POST")
+
+    viewPayments := adminRoutes(auth.ViewPayments)
+    viewPayments.HandleFunc("/manage-payments", handler.PaymentEventsPage).Methods("GET")
+
+    manageStaff := adminRoutes(auth.ManageStaff)
+    manageStaff.HandleFunc("/manage-staff", handler.StaffPage).Methods("GET")
+    manageStaff.HandleFunc("/staff", handler.CreateStaff).Methods("POST")
+    manageStaff.HandleFunc("/staff/{id}", handler.UpdateStaffRole).Methods("PUT")
+
+    manageWebhooks := adminRoutes(auth.ManageWebhooks)
+    manageWebhooks.HandleFunc("/manage-webhooks", handler.WebhooksPage).Methods("GET")
+    manageWebhooks.HandleFunc("/webhook", handler.CreateWebhook).Methods("POST")
+    manageWebhooks.HandleFunc("/webhook/{id}", handler.UpdateWebhook).Methods("PUT")
+    manageWebhooks.HandleFunc("/webhook/{id}", handler.DeleteWebhook).Methods("DELETE")
+    manageWebhooks.HandleFunc("/webhook/{id}/deliveries", handler.WebhookDeliveriesView).Methods("GET")
+    manageWebhooks.HandleFunc("/webhook-delivery/{id}/replay", handler.ReplayWebhookDelivery).Methods("POST")
 
     // User shopping routes
     router.HandleFunc("/", handler.ShoppingHomePage).Methods("GET")
@@ -87,18 +374,183 @@ func main() {
     router.HandleFunc("/cart/add/{id}", handler.AddCartItem).Methods("POST")
     router.HandleFunc("/shopping-cart", handler.ShoppingCartView).Methods("GET")
     router.HandleFunc("/cart/{id}", handler.ShoppingCartUpdate).Methods("PUT")
-    router.HandleFunc("/order-complete", handler.PlaceOrder)
-
-    // Logger
-    router.Use(func(next http.Handler) http.Handler {
-        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
-            next.ServeHTTP(w, r)
-        })
-    })
+    router.HandleFunc("/checkout", handler.Checkout).Methods("POST")
+    router.HandleFunc("/checkout/return/{id}", handler.CheckoutReturn).Methods("GET")
+    router.HandleFunc("/order-complete/{id}", handler.OrderCompletePage).Methods("GET")
+    router.PathPrefix("/payments/fake/").Handler(payments).Methods("GET")
+    router.HandleFunc(handlers.PaymentWebhookPath, handler.PaymentWebhook).Methods("POST")
 
-    err := http.ListenAndServe(":5000", router)
-    if err != nil {
+    // Customer account routes
+    router.HandleFunc("/register", handler.RegisterView).Methods("GET")
+    router.HandleFunc("/register", handler.Register).Methods("POST")
+    router.HandleFunc("/login", handler.LoginView).Methods("GET")
+    router.HandleFunc("/login", handler.Login).Methods("POST")
+    router.HandleFunc("/logout", handler.Logout).Methods("POST")
+    router.HandleFunc("/account/orders", handler.AccountOrdersPage).Methods("GET")
+    router.HandleFunc("/account/orders/{id}", handler.AccountOrderPage).Methods("GET")
+    router.HandleFunc("/account/orders/{id}/returns", handler.RequestReturn).Methods("POST")
+    router.HandleFunc("/account/orders/{id}/invoice", handler.AccountOrderInvoice).Methods("GET")
+    router.HandleFunc("/account/orders/{id}/invoices/{invoiceID}", handler.AccountInvoiceDownload).Methods("GET")
+
+    router.HandleFunc("/api/openapi.json", handler.OpenAPISpec).Methods("GET")
+    router.HandleFunc("/api/docs", handler.APIDocs).Methods("GET")
+
+    // GraphQL API for storefront clients, authenticated like the JSON API.
+    router.HandleFunc(handlers.GraphQLPath, handler.GraphQL).Methods("GET", "POST")
+    router.HandleFunc(handlers.GraphQLPath+"/schema", handler.GraphQLSchema).Methods("GET")
+
+    // JSON API, for the mobile app and integrations. Clients authenticate
+    // with the token from POST /api/v1/session as a bearer token.
+    api := router.PathPrefix(handlers.APIPrefix).Subrouter()
+    api.NotFoundHandler = http.HandlerFunc(handler.APINotFound)
+    api.MethodNotAllowedHandler = http.HandlerFunc(handler.APIMethodNotAllowed)
+    if os.Getenv("OPENAPI_VALIDATE") != "" {
+        // Logs every request and response that breaks the OpenAPI
+        // document, to catch the API drifting from its contract.
+        spec, err := openapi.Load()
+        if err != nil {
+            log.Fatal(err)
+        }
+        api.Use(spec.Validator(func(r *http.Request, err error) {
+            logging.FromContext(r.Context()).Warn("openapi: contract violation", "error", err)
+        }))
+    }
+    api.Use(handler.APIContent)
+    api.HandleFunc("/session", handler.APICreateSession).Methods("POST")
+    api.HandleFunc("/session", handler.APIDeleteSession).Methods("DELETE")
+    api.HandleFunc("/products", handler.APIListProducts).Methods("GET")
+    api.HandleFunc("/products/{id}", handler.APIGetProduct).Methods("GET")
+    api.HandleFunc("/cart", handler.APIGetCart).Methods("GET")
+    api.HandleFunc("/cart/items", handler.APIAddCartItem).Methods("POST")
+    api.HandleFunc("/cart/items/{id}", handler.APIUpdateCartItem).Methods("PUT")
+    api.HandleFunc("/cart/items/{id}", handler.APIRemoveCartItem).Methods("DELETE")
+    api.HandleFunc("/orders", handler.APICheckout).Methods("POST")
+
+    apiUsers := api.NewRoute().Subrouter()
+    apiUsers.Use(handler.APIRequireUser)
+    apiUsers.HandleFunc("/orders", handler.APIListOrders).Methods("GET")
+    apiUsers.HandleFunc("/orders/{id}", handler.APIGetOrder).Methods("GET")
+    apiUsers.HandleFunc("/orders/{id}/status", handler.APIGetOrderStatus).Methods("GET")
+
+    apiRoutes := func(permission auth.Permission) *mux.Router {
+        routes := apiUsers.NewRoute().Subrouter()
+        routes.Use(handler.APIRequirePermission(permission))
+        return routes
+    }
+
+    apiEditProducts := apiRoutes(auth.EditProducts)
+    apiEditProducts.HandleFunc("/products", handler.APICreateProduct).Methods("POST")
+    apiEditProducts.HandleFunc("/products/{id}", handler.APIUpdateProduct).Methods("PUT")
+    apiEditProducts.HandleFunc("/products/{id}", handler.APIDeleteProduct).Methods("DELETE")
+
+    apiRoutes(auth.ViewOrders).HandleFunc("/admin/orders", handler.APIListAllOrders).Methods("GET")
+    apiRoutes(auth.UpdateOrders).HandleFunc("/orders/{id}/status", handler.APIUpdateOrderStatus).Methods("PUT")
+
+    router.Use(handler.LoadSession)
+    router.Use(handler.VerifyCSRF)
+
+    // Traces, request IDs and the access log cover requests no route
+    // matched too.
+    if err := serve(tracing.Middleware(logging.Middleware(router))); err != nil {
         log.Fatal(err)
     }
 }
+
+// serve runs the HTTP and gRPC servers and the background workers until
+// SIGINT or SIGTERM, then shuts down in order: readiness starts failing,
+// for shutdownDelay before anything stops; the servers stop taking new
+// requests and finish those in flight, such as checkouts, within
+// shutdownTimeout; then the workers stop and the last spans are exported;
+// then the database pool closes.
+// It returns the error of a server that failed, after shutting down.
+func serve(router http.Handler) error {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    workerCtx, stopWorkers := context.WithCancel(context.Background())
+    var workers sync.WaitGroup
+    for _, run := range []func(context.Context){webhooks.Run, relay.Run, handler.ExpireSessions, handler.ReconcileRefunds} {
+        workers.Add(1)
+        go func() {
+            defer workers.Done()
+            run(workerCtx)
+        }()
+    }
+
+    server := &http.Server{
+        Addr:              ":5000",
+        Handler:           router,
+        ReadHeaderTimeout: readHeaderTimeout,
+        ReadTimeout:       readTimeout,
+        WriteTimeout:      writeTimeout,
+        IdleTimeout:       idleTimeout,
+        ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+    }
+    orders, grpcServer, grpcListener := newGRPCServer()
+
+    errs := make(chan error, 2)
+    go func() {
+        if err := server.ListenAndServe(); err != http.ErrServerClosed {
+            errs <- err
+        }
+    }()
+    go func() {
+        errs <- grpcServer.Serve(grpcListener)
+    }()
+
+    var serveErr error
+    select {
+    case <-ctx.Done():
+        slog.Info("shutting down")
+    case serveErr = <-errs:
+        slog.Error("shutting down", "error", serveErr)
+    }
+    stop()
+
+    checker.ShutDown()
+    time.Sleep(shutdownDelay)
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+
+    var servers sync.WaitGroup
+    servers.Add(2)
+    go func() {
+        defer servers.Done()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            slog.Error("http: shutdown", "error", err)
+        }
+    }()
+    go func() {
+        defer servers.Done()
+        // Watch streams never finish by themselves, so they are ended
+        // first; whatever else is still running at the deadline is cut off.
+        orders.Close()
+        stopped := make(chan struct{})
+        go func() {
+            grpcServer.GracefulStop()
+            close(stopped)
+        }()
+        select {
+        case <-stopped:
+        case <-shutdownCtx.Done():
+            slog.Warn("grpc: shutdown", "error", shutdownCtx.Err())
+            grpcServer.Stop()
+        }
+    }()
+    servers.Wait()
+
+    stopWorkers()
+    workers.Wait()
+
+    // Spans of the last requests are still waiting to be exported.
+    if err := shutdownTracing(shutdownCtx); err != nil {
+        slog.Error("tracing: shutdown", "error", err)
+    }
+
+    if err := db.Close(); err != nil {
+        slog.Error("db: close", "error", err)
+    }
+    slog.Info("stopped")
+    return serveErr
+}
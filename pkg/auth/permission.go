@@ -0,0 +1,45 @@
+package auth
+
+import (
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "slices"
+)
+
+type Permission string
+
+const (
+    ViewProducts   Permission = "products:view"
+    EditProducts   Permission = "products:edit"
+    SeedProducts   Permission = "products:seed"
+    ViewOrders     Permission = "orders:view"
+    UpdateOrders   Permission = "orders:update"
+    ManageStaff    Permission = "staff:manage"
+    ManageWebhooks Permission = "webhooks:manage"
+    ViewPayments   Permission = "payments:view"
+    RefundOrders   Permission = "orders:refund"
+)
+
+var rolePermissions = map[Role][]Permission{
+    Owner: {
+        ViewProducts, EditProducts, SeedProducts,
+        ViewOrders, UpdateOrders, RefundOrders, ViewPayments,
+        ManageStaff, ManageWebhooks,
+    },
+    Staff: {
+        ViewProducts, EditProducts,
+        ViewOrders, UpdateOrders, RefundOrders, ViewPayments,
+    },
+    ReadOnly: {
+        ViewProducts,
+        ViewOrders, ViewPayments,
+    },
+    Fulfillment: {
+        ViewProducts,
+        ViewOrders, UpdateOrders,
+    },
+}
+
+// Can reports whether role has been granted permission.
+func Can(role Role, permission Permission) bool {
+    return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,84 @@
+// Package catalog moves the product catalogue in and out of CSV files. An
+// import is read into a Plan first, which says what each row would do and
+// what is wrong with it, so it can be previewed before it is applied.
+package catalog
+
+import (
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Columns are the columns of an exported catalogue, which can be imported
+// again as it is.
+var Columns = []string{"id", "sku", "name", "price", "description", "stock", "image"}
+
+// Header returns Columns as the first row of an export.
+func Header() []any {
+    header := make([]any, len(Columns))
+    for i, column := range Columns {
+        header[i] = column
+    }
+    return header
+}
+
+// Record returns the exported row of product, in the order of Columns.
+// An untracked stock is left empty.
+func Record(product *Product) []any {
+    var stock any = ""
+    if product.Stock != nil {
+        stock = *product.Stock
+    }
+    return []any{product.ID.String(), product.SKU, product.Name, product.Price, product.Description, stock, product.Image}
+}
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ParseProduct checks the fields of a product as typed into the product
+// form or a CSV file and returns the product, without an ID or image, or a
+// message for each field that is wrong. An empty stock means the stock is
+// not tracked.
+func ParseProduct(name, sku, price, description, stock string) (*Product, []string) {
+    var errorMessages []string
+
+    sku = strings.TrimSpace(sku)
+
+    if name == "" {
+        errorMessages = append(errorMessages, "Product name is required.")
+    }
+
+    if sku != "" && !skuPattern.MatchString(sku) {
+        errorMessages = append(errorMessages, "Invalid SKU: use up to 64 letters, digits, dots, dashes or underscores.")
+    }
+
+    if price == "" {
+        errorMessages = append(errorMessages, "Product price is required.")
+    }
+
+    priceParsed, err := strconv.ParseFloat(price, 64)
+    if err != nil {
+        errorMessages = append(errorMessages, "Invalid price value.")
+    }
+
+    var stockParsed *int
+    if stock = strings.TrimSpace(stock); stock != "" {
+        units, err := strconv.Atoi(stock)
+        if err != nil || units < 0 {
+            errorMessages = append(errorMessages, "Invalid stock value.")
+        }
+        stockParsed = &units
+    }
+
+    if len(errorMessages) > 0 {
+        return nil, errorMessages
+    }
+
+    return &Product{
+        SKU:         sku,
+        Name:        name,
+        Price:       priceParsed,
+        Stock:       stockParsed,
+        Description: description,
+    }, nil
+}
@@ -0,0 +1,91 @@
+package catalog
+
+import (
+    "archive/zip"
+    "errors"
+    "io"
+    "io/fs"
+    "os"
+    "path"
+    "path/filepath"
+    "slices"
+    "strings"
+)
+
+// Images is where an import takes the product images named in its image
+// column from.
+type Images interface {
+    Open(name string) (io.ReadCloser, error)
+}
+
+// ImageExtensions are the kinds of image files an import accepts.
+var ImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// Dir takes images from a folder on the server. Names must stay inside it.
+type Dir string
+
+func (d Dir) Open(name string) (io.ReadCloser, error) {
+    name = filepath.FromSlash(name)
+    if !filepath.IsLocal(name) {
+        return nil, fs.ErrNotExist
+    }
+    return os.Open(filepath.Join(string(d), name))
+}
+
+// Zip takes images from an uploaded zip archive. A name matches a file by
+// its path in the archive or, when only one file has that name, by its base
+// name.
+type Zip struct {
+    files  map[string]*zip.File
+    byBase map[string][]*zip.File
+}
+
+func NewZip(r *zip.Reader) *Zip {
+    z := &Zip{files: make(map[string]*zip.File), byBase: make(map[string][]*zip.File)}
+
+    for _, f := range r.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+        z.files[path.Clean(f.Name)] = f
+        z.byBase[path.Base(f.Name)] = append(z.byBase[path.Base(f.Name)], f)
+    }
+
+    return z
+}
+
+func (z *Zip) Open(name string) (io.ReadCloser, error) {
+    name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+    if f, ok := z.files[name]; ok {
+        return f.Open()
+    }
+    if files := z.byBase[name]; len(files) == 1 {
+        return files[0].Open()
+    }
+    return nil, fs.ErrNotExist
+}
+
+// checkImage returns why name cannot be imported from images, or "".
+func checkImage(images Images, name string) string {
+    if !isImage(name) {
+        return "Image " + name + " is not a " + strings.Join(ImageExtensions, ", ") + " file."
+    }
+    if images == nil {
+        return "Image " + name + " is new, but no zip archive or image folder was given to take it from."
+    }
+
+    f, err := images.Open(name)
+    if errors.Is(err, fs.ErrNotExist) {
+        return "Image " + name + " was not found."
+    }
+    if err != nil {
+        return "Image " + name + " cannot be read: " + err.Error()
+    }
+    f.Close()
+
+    return ""
+}
+
+func isImage(name string) bool {
+    return slices.Contains(ImageExtensions, strings.ToLower(filepath.Ext(name)))
+}
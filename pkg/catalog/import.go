@@ -0,0 +1,223 @@
+package catalog
+
+import (
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/export"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "io"
+    "slices"
+    "strings"
+)
+
+// MaxRows caps how many products one file may import.
+const MaxRows = 10000
+
+type Action string
+
+const (
+    Create Action = "create"
+    Update Action = "update"
+)
+
+// Row is what one line of an import would do. Image is the name of a new
+// image to take from the import's images; it is empty when the product
+// keeps the image it has.
+type Row struct {
+    Line    int
+    Action  Action
+    Product Product
+    Image   string
+    Errors  []string
+}
+
+type Plan struct {
+    Rows    []Row
+    Creates int
+    Updates int
+    Invalid int
+}
+
+// Valid reports whether every row can be applied. Imports are all or
+// nothing, so a plan with any invalid row must not be.
+func (p *Plan) Valid() bool {
+    return p.Invalid == 0 && len(p.Rows) > 0
+}
+
+// Read reads a CSV file of products and plans its import into the existing
+// catalogue. The header names the columns, in any order: name, price and
+// stock are required, and id, sku, description and image are optional. An
+// empty stock means the stock is not tracked.
+// A row updates the product with its id or else with its sku, and creates a
+// new product when neither matches. Columns left out keep their values on
+// update. An error is only returned when the file itself cannot be read;
+// problems with rows are reported on the rows.
+func Read(r io.Reader, existing []Product, images Images) (*Plan, error) {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+    reader.TrimLeadingSpace = true
+
+    header, err := reader.Read()
+    if errors.Is(err, io.EOF) {
+        return nil, errors.New("the file is empty")
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    columns := make(map[string]int)
+    for i, name := range header {
+        name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+        if !slices.Contains(Columns, name) {
+            return nil, fmt.Errorf("unknown column %q; the columns are %s", name, strings.Join(Columns, ", "))
+        }
+        if _, ok := columns[name]; ok {
+            return nil, fmt.Errorf("column %q appears twice", name)
+        }
+        columns[name] = i
+    }
+    for _, name := range []string{"name", "price", "stock"} {
+        if _, ok := columns[name]; !ok {
+            return nil, fmt.Errorf("column %q is required", name)
+        }
+    }
+
+    byID := make(map[uuid.UUID]*Product, len(existing))
+    bySKU := make(map[string]*Product, len(existing))
+    for i := range existing {
+        byID[existing[i].ID] = &existing[i]
+        if existing[i].SKU != "" {
+            bySKU[strings.ToUpper(existing[i].SKU)] = &existing[i]
+        }
+    }
+
+    plan := &Plan{}
+    seenIDs := make(map[uuid.UUID]int)
+    seenSKUs := make(map[string]int)
+
+    for {
+        record, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        line, _ := reader.FieldPos(0)
+        if err != nil {
+            return nil, err
+        }
+
+        if len(plan.Rows) == MaxRows {
+            return nil, fmt.Errorf("the file has more than %d products; split it up", MaxRows)
+        }
+
+        row := planRow(record, len(header), columns, byID, bySKU, images)
+        row.Line = line
+
+        // Each product may only appear once, whether it is found by ID or
+        // SKU, and new products may not share a SKU.
+        if row.Product.ID != uuid.Nil {
+            if first, ok := seenIDs[row.Product.ID]; ok {
+                row.Errors = append(row.Errors, fmt.Sprintf("The product is already on line %d.", first))
+            } else {
+                seenIDs[row.Product.ID] = line
+            }
+        }
+        if sku := strings.ToUpper(row.Product.SKU); sku != "" {
+            if first, ok := seenSKUs[sku]; ok {
+                row.Errors = append(row.Errors, fmt.Sprintf("SKU %s is already used on line %d.", row.Product.SKU, first))
+            } else {
+                seenSKUs[sku] = line
+            }
+        }
+
+        switch {
+        case len(row.Errors) > 0:
+            plan.Invalid++
+        case row.Action == Create:
+            plan.Creates++
+        default:
+            plan.Updates++
+        }
+        plan.Rows = append(plan.Rows, row)
+    }
+
+    return plan, nil
+}
+
+func planRow(record []string, fields int, columns map[string]int, byID map[uuid.UUID]*Product, bySKU map[string]*Product, images Images) Row {
+    row := Row{Action: Create}
+
+    if len(record) != fields {
+        row.Errors = append(row.Errors, fmt.Sprintf("Expected %d fields but found %d.", fields, len(record)))
+        return row
+    }
+
+    value := func(name string) (string, bool) {
+        i, ok := columns[name]
+        if !ok {
+            return "", false
+        }
+        return export.Restore(strings.TrimSpace(record[i])), true
+    }
+
+    // The name is shown in the preview even when the row is wrong.
+    name, _ := value("name")
+    row.Product.Name = name
+
+    var current *Product
+    id, _ := value("id")
+    sku, hasSKU := value("sku")
+    if id != "" {
+        parsed, err := uuid.Parse(id)
+        if err != nil {
+            row.Errors = append(row.Errors, "Invalid product ID "+id+".")
+            return row
+        }
+        current = byID[parsed]
+        row.Product.ID = parsed
+    } else if sku != "" {
+        current = bySKU[strings.ToUpper(sku)]
+    }
+
+    // Columns that are left out keep the current values.
+    description, hasDescription := value("description")
+    if current != nil {
+        row.Action = Update
+        row.Product = *current
+        if !hasSKU {
+            sku = current.SKU
+        }
+        if !hasDescription {
+            description = current.Description
+        }
+    }
+
+    price, _ := value("price")
+    stock, _ := value("stock")
+    product, errorMessages := ParseProduct(name, sku, price, description, stock)
+    if len(errorMessages) > 0 {
+        row.Errors = append(row.Errors, errorMessages...)
+        return row
+    }
+
+    product.ID = row.Product.ID
+    product.Image = row.Product.Image
+    product.CreatedDate = row.Product.CreatedDate
+    row.Product = *product
+
+    if owner, ok := bySKU[strings.ToUpper(product.SKU)]; ok && owner.ID != product.ID {
+        row.Errors = append(row.Errors, fmt.Sprintf("SKU %s belongs to another product, %s.", product.SKU, owner.Name))
+    }
+
+    if image, _ := value("image"); image != "" && image != product.Image {
+        if problem := checkImage(images, image); problem != "" {
+            row.Errors = append(row.Errors, problem)
+        } else {
+            row.Image = image
+        }
+    }
+
+    return row
+}
+
@@ -0,0 +1,128 @@
+// Package client is a Go client for the JSON API of the shop, for our other
+// services to import. The types and methods in client_gen.go are generated
+// from the API's OpenAPI document; this file holds the transport they share.
+//
+//	c := client.New("https://shop.example.com/api/v1")
+//	if _, err := c.SignIn(ctx, email, password); err != nil {
+//	    return err
+//	}
+//	products, pagination, err := c.ListProducts(ctx, &client.ListProductsParams{Size: 50})
+//
+// Failed requests return an *APIError carrying the code of the error.
+package client
+
+//go:generate go run ../../cmd/apigen -o client_gen.go
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+type Client struct {
+    // BaseURL is where the API is served, ending in its version, such as
+    // https://shop.example.com/api/v1.
+    BaseURL string
+    // Token is the session token sent as a bearer token. SignIn and
+    // StartGuestSession set it.
+    Token      string
+    HTTPClient *http.Client
+}
+
+func New(baseURL string) *Client {
+    return &Client{
+        BaseURL:    strings.TrimSuffix(baseURL, "/"),
+        HTTPClient: http.DefaultClient,
+    }
+}
+
+// SignIn starts a session as the user with email and password, and uses it
+// for later requests.
+func (c *Client) SignIn(ctx context.Context, email, password string) (*Session, error) {
+    session, err := c.CreateSession(ctx, &SessionInput{Email: email, Password: password})
+    if err != nil {
+        return nil, err
+    }
+
+    c.Token = session.Token
+    return session, nil
+}
+
+// StartGuestSession starts a guest session, enough to fill a cart and check
+// out, and uses it for later requests.
+func (c *Client) StartGuestSession(ctx context.Context) (*Session, error) {
+    session, err := c.CreateSession(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    c.Token = session.Token
+    return session, nil
+}
+
+func (e *APIError) Error() string {
+    message := fmt.Sprintf("api: %d %s: %s", e.Status, e.Code, e.Message)
+    if len(e.Details) > 0 {
+        message += " (" + strings.Join(e.Details, "; ") + ")"
+    }
+    return message
+}
+
+// do sends a request to the API and decodes the JSON response into out,
+// unless out is nil. Error responses become an *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+    endpoint := c.BaseURL + path
+    if len(query) > 0 {
+        endpoint += "?" + query.Encode()
+    }
+
+    var reader io.Reader
+    if body != nil {
+        payload, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(payload)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+
+    httpClient := c.HTTPClient
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 300 {
+        var failure Error
+        if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error.Code == "" {
+            return &APIError{Status: resp.StatusCode, Code: "unexpected_response", Message: resp.Status}
+        }
+        return &failure.Error
+    }
+
+    if out == nil {
+        return nil
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
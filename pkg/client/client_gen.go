@@ -0,0 +1,500 @@
+// Code generated by apigen from the OpenAPI document of The Identity Store API 1.0.0. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"time"
+)
+
+type APIError struct {
+	// The HTTP status of the response.
+	Status int `json:"status"`
+	// A stable code to switch on, such as not_found or invalid_product.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// The individual problems of a rejected request.
+	Details []string `json:"details,omitempty"`
+}
+
+type Cart struct {
+	Items []OrderItem `json:"items"`
+	Total float64     `json:"total"`
+}
+
+type CartData struct {
+	Data Cart `json:"data"`
+}
+
+type CartItemInput struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Quantity  *int      `json:"quantity,omitempty"`
+}
+
+type CartQuantityInput struct {
+	Quantity int `json:"quantity"`
+}
+
+type Checkout struct {
+	Order   Order   `json:"order"`
+	Payment Payment `json:"payment"`
+	// Where to send the customer when the payment requires action.
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+type CheckoutData struct {
+	Data Checkout `json:"data"`
+}
+
+type CheckoutInput struct {
+	CardNumber string `json:"card_number,omitempty"`
+	// Required for guests.
+	Email string `json:"email,omitempty"`
+}
+
+type Error struct {
+	Error APIError `json:"error"`
+}
+
+type Order struct {
+	ID uuid.UUID `json:"id"`
+	// Empty for guest orders.
+	UserID        string        `json:"user_id"`
+	Email         string        `json:"email"`
+	Status        OrderStatus   `json:"status"`
+	PaymentStatus PaymentStatus `json:"payment_status"`
+	Date          time.Time     `json:"date"`
+	// Null in lists.
+	Items    []OrderItem `json:"items"`
+	Subtotal float64     `json:"subtotal"`
+	Refunded float64     `json:"refunded"`
+	Total    float64     `json:"total"`
+	// The order timeline, left out in lists.
+	Events []OrderEvent `json:"events,omitempty"`
+}
+
+type OrderData struct {
+	Data Order `json:"data"`
+}
+
+type OrderEvent struct {
+	ID      uuid.UUID `json:"id"`
+	OrderID uuid.UUID `json:"order_id"`
+	Type    string    `json:"type"`
+	// Empty when the order was placed.
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OrderItem struct {
+	// The zero UUID for cart items.
+	OrderID          uuid.UUID `json:"order_id"`
+	ProductID        uuid.UUID `json:"product_id"`
+	Quantity         int       `json:"quantity"`
+	RefundedQuantity int       `json:"refunded_quantity"`
+	Cost             float64   `json:"cost"`
+	// The product's name at checkout; left out for cart items.
+	Name    string   `json:"name,omitempty"`
+	Product *Product `json:"product,omitempty"`
+	// The unit price at checkout; left out for cart items.
+	UnitPrice *float64 `json:"unit_price,omitempty"`
+}
+
+type OrderList struct {
+	Data       []Order    `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+type OrderStatus string
+
+const (
+	OrderStatusOrdered   OrderStatus = "ordered"
+	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancel    OrderStatus = "cancel"
+)
+
+type OrderStatusData struct {
+	Data OrderStatusInfo `json:"data"`
+}
+
+type OrderStatusInfo struct {
+	Status        OrderStatus   `json:"status"`
+	PaymentStatus PaymentStatus `json:"payment_status"`
+	// The statuses the caller may move the order to; empty without the
+	// permission to update orders.
+	NextStatuses []OrderStatus `json:"next_statuses"`
+	Events       []OrderEvent  `json:"events"`
+}
+
+type OrderStatusInput struct {
+	Status OrderStatus `json:"status"`
+	Note   string      `json:"note,omitempty"`
+}
+
+type Pagination struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+	Size       int `json:"size"`
+	TotalItems int `json:"total_items"`
+	// 0 on the first page.
+	PreviousPage int `json:"previous_page"`
+	// Past total_pages on the last page.
+	NextPage int `json:"next_page"`
+}
+
+type Payment struct {
+	ID          uuid.UUID `json:"id"`
+	OrderID     uuid.UUID `json:"order_id"`
+	Provider    string    `json:"provider"`
+	ProviderRef string    `json:"provider_ref"`
+	// In the minor unit of the currency.
+	Amount    int           `json:"amount"`
+	Currency  string        `json:"currency"`
+	Status    PaymentStatus `json:"status"`
+	LastError string        `json:"last_error"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type PaymentStatus string
+
+const (
+	PaymentStatusUnpaid            PaymentStatus = "unpaid"
+	PaymentStatusRequiresAction    PaymentStatus = "requires_action"
+	PaymentStatusProcessing        PaymentStatus = "processing"
+	PaymentStatusAuthorized        PaymentStatus = "authorized"
+	PaymentStatusPaid              PaymentStatus = "paid"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusVoided            PaymentStatus = "voided"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+)
+
+type Product struct {
+	ID uuid.UUID `json:"id"`
+	// Empty when the product has none.
+	SKU         string  `json:"sku"`
+	Name        string  `json:"name"`
+	Price       float64 `json:"price"`
+	Description string  `json:"description"`
+	// File name of the image under /static/uploads, if any.
+	Image string `json:"image"`
+	// Null when the stock is not tracked.
+	Stock        *int      `json:"stock"`
+	CreatedDate  time.Time `json:"created_date"`
+	ModifiedDate time.Time `json:"modified_date"`
+}
+
+type ProductData struct {
+	Data Product `json:"data"`
+}
+
+type ProductInput struct {
+	Name        string  `json:"name"`
+	Price       float64 `json:"price"`
+	Description string  `json:"description,omitempty"`
+	SKU         string  `json:"sku,omitempty"`
+	// Leave out or null not to track the stock.
+	Stock *int `json:"stock,omitempty"`
+}
+
+type ProductList struct {
+	Data       []Product  `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+type Role string
+
+const (
+	RoleCustomer    Role = "customer"
+	RoleOwner       Role = "owner"
+	RoleStaff       Role = "staff"
+	RoleReadOnly    Role = "read-only"
+	RoleFulfillment Role = "fulfillment"
+)
+
+type Session struct {
+	// Send as a bearer token on later requests.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Null for guests.
+	User *User `json:"user"`
+}
+
+type SessionData struct {
+	Data Session `json:"data"`
+}
+
+type SessionInput struct {
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type User struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name"`
+	Role        Role      `json:"role"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+// ListAllOrdersParams are the query parameters of ListAllOrders.
+type ListAllOrdersParams struct {
+	// The page to return, from 1.
+	Page int
+	// How many items a page holds.
+	Size int
+	// Only orders placed on or after this day.
+	From string
+	// Only orders placed on or before this day.
+	To     string
+	Status OrderStatus
+	// Part of the customer's email address, or their user ID.
+	Customer string
+}
+
+// ListAllOrders calls GET /admin/orders: list all orders.
+//
+// Every order, newest first, without their items. Requires the permission to
+// view orders.
+func (c *Client) ListAllOrders(ctx context.Context, params *ListAllOrdersParams) ([]Order, *Pagination, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Page != 0 {
+			query.Set("page", fmt.Sprint(params.Page))
+		}
+		if params.Size != 0 {
+			query.Set("size", fmt.Sprint(params.Size))
+		}
+		if params.From != "" {
+			query.Set("from", fmt.Sprint(params.From))
+		}
+		if params.To != "" {
+			query.Set("to", fmt.Sprint(params.To))
+		}
+		if params.Status != "" {
+			query.Set("status", fmt.Sprint(params.Status))
+		}
+		if params.Customer != "" {
+			query.Set("customer", fmt.Sprint(params.Customer))
+		}
+	}
+	var out OrderList
+	if err := c.do(ctx, "GET", "/admin/orders", query, nil, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Data, &out.Pagination, nil
+}
+
+// GetCart calls GET /cart: get the cart.
+func (c *Client) GetCart(ctx context.Context) (*Cart, error) {
+	var out CartData
+	if err := c.do(ctx, "GET", "/cart", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// AddCartItem calls POST /cart/items: add a product to the cart.
+//
+// Adds to the quantity already in the cart, one unless a quantity is given.
+func (c *Client) AddCartItem(ctx context.Context, body *CartItemInput) (*Cart, error) {
+	var out CartData
+	if err := c.do(ctx, "POST", "/cart/items", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// RemoveCartItem calls DELETE /cart/items/{id}: remove a product from the
+// cart.
+func (c *Client) RemoveCartItem(ctx context.Context, id uuid.UUID) (*Cart, error) {
+	var out CartData
+	if err := c.do(ctx, "DELETE", "/cart/items/"+url.PathEscape(id.String()), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// UpdateCartItem calls PUT /cart/items/{id}: set the quantity of a product in
+// the cart.
+//
+// A quantity of 0 removes the product.
+func (c *Client) UpdateCartItem(ctx context.Context, id uuid.UUID, body *CartQuantityInput) (*Cart, error) {
+	var out CartData
+	if err := c.do(ctx, "PUT", "/cart/items/"+url.PathEscape(id.String()), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// ListOrdersParams are the query parameters of ListOrders.
+type ListOrdersParams struct {
+	// The page to return, from 1.
+	Page int
+	// How many items a page holds.
+	Size int
+}
+
+// ListOrders calls GET /orders: list your orders.
+//
+// The signed-in customer's own orders, newest first, without their items.
+func (c *Client) ListOrders(ctx context.Context, params *ListOrdersParams) ([]Order, *Pagination, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Page != 0 {
+			query.Set("page", fmt.Sprint(params.Page))
+		}
+		if params.Size != 0 {
+			query.Set("size", fmt.Sprint(params.Size))
+		}
+	}
+	var out OrderList
+	if err := c.do(ctx, "GET", "/orders", query, nil, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Data, &out.Pagination, nil
+}
+
+// Checkout calls POST /orders: check out.
+//
+// Places an order for the cart and takes payment. The cart is emptied unless
+// the payment failed. Guests must give an email address; signed-in customers
+// order under their own. When the payment requires action, such as 3-D Secure,
+// send the customer to `redirect_url`.
+func (c *Client) Checkout(ctx context.Context, body *CheckoutInput) (*Checkout, error) {
+	var out CheckoutData
+	if err := c.do(ctx, "POST", "/orders", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// GetOrder calls GET /orders/{id}: get an order.
+//
+// Customers see their own orders; staff who may view orders see any.
+func (c *Client) GetOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+	var out OrderData
+	if err := c.do(ctx, "GET", "/orders/"+url.PathEscape(id.String()), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// GetOrderStatus calls GET /orders/{id}/status: get the status of an order.
+func (c *Client) GetOrderStatus(ctx context.Context, id uuid.UUID) (*OrderStatusInfo, error) {
+	var out OrderStatusData
+	if err := c.do(ctx, "GET", "/orders/"+url.PathEscape(id.String())+"/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// UpdateOrderStatus calls PUT /orders/{id}/status: move an order to a new
+// status.
+//
+// Only the statuses in `next_statuses` are allowed. Cancelling an order voids
+// any payment not yet captured. Requires the permission to update orders.
+func (c *Client) UpdateOrderStatus(ctx context.Context, id uuid.UUID, body *OrderStatusInput) (*OrderStatusInfo, error) {
+	var out OrderStatusData
+	if err := c.do(ctx, "PUT", "/orders/"+url.PathEscape(id.String())+"/status", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// ListProductsParams are the query parameters of ListProducts.
+type ListProductsParams struct {
+	// The page to return, from 1.
+	Page int
+	// How many items a page holds.
+	Size int
+}
+
+// ListProducts calls GET /products: list products.
+func (c *Client) ListProducts(ctx context.Context, params *ListProductsParams) ([]Product, *Pagination, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Page != 0 {
+			query.Set("page", fmt.Sprint(params.Page))
+		}
+		if params.Size != 0 {
+			query.Set("size", fmt.Sprint(params.Size))
+		}
+	}
+	var out ProductList
+	if err := c.do(ctx, "GET", "/products", query, nil, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Data, &out.Pagination, nil
+}
+
+// CreateProduct calls POST /products: create a product.
+//
+// Requires the permission to edit products.
+func (c *Client) CreateProduct(ctx context.Context, body *ProductInput) (*Product, error) {
+	var out ProductData
+	if err := c.do(ctx, "POST", "/products", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// DeleteProduct calls DELETE /products/{id}: delete a product.
+//
+// Requires the permission to edit products.
+func (c *Client) DeleteProduct(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, "DELETE", "/products/"+url.PathEscape(id.String()), nil, nil, nil)
+}
+
+// GetProduct calls GET /products/{id}: get a product.
+func (c *Client) GetProduct(ctx context.Context, id uuid.UUID) (*Product, error) {
+	var out ProductData
+	if err := c.do(ctx, "GET", "/products/"+url.PathEscape(id.String()), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// UpdateProduct calls PUT /products/{id}: update a product.
+//
+// Replaces the fields of a product. Its image is kept. Requires the permission
+// to edit products.
+func (c *Client) UpdateProduct(ctx context.Context, id uuid.UUID, body *ProductInput) (*Product, error) {
+	var out ProductData
+	if err := c.do(ctx, "PUT", "/products/"+url.PathEscape(id.String()), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// DeleteSession calls DELETE /session: sign out.
+//
+// Ends the session and empties its cart.
+func (c *Client) DeleteSession(ctx context.Context) error {
+	return c.do(ctx, "DELETE", "/session", nil, nil, nil)
+}
+
+// CreateSession calls POST /session: start a session.
+//
+// Signs in with an email and password, or starts a guest session when the body
+// is empty.
+func (c *Client) CreateSession(ctx context.Context, body *SessionInput) (*Session, error) {
+	var payload any
+	if body != nil {
+		payload = body
+	}
+	var out SessionData
+	if err := c.do(ctx, "POST", "/session", nil, payload, &out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+    "context"
+    "database/sql"
+    "embed"
+    "fmt"
+    "io/fs"
+    "sort"
+    "strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrate applies every migration in migrations/ that has not been recorded
+// in the schema_migrations table yet, in file name order.
+func Migrate(db *sql.DB) error {
+    _, err := db.Exec(`create table if not exists schema_migrations (
+        version    varchar(255) primary key,
+        applied_at datetime not null default current_timestamp
+    )`)
+    if err != nil {
+        return err
+    }
+
+    pending, err := Pending(context.Background(), db)
+    if err != nil {
+        return err
+    }
+
+    for _, name := range pending {
+        content, err := migrationFiles.ReadFile("migrations/" + name)
+        if err != nil {
+            return err
+        }
+
+        for _, statement := range splitStatements(string(content)) {
+            if _, err := db.Exec(statement); err != nil {
+                return fmt.Errorf("migration %s: %w", name, err)
+            }
+        }
+
+        if _, err := db.Exec("insert into schema_migrations(version) values (?)", name); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Pending returns the names of the migrations that have not been applied.
+func Pending(ctx context.Context, db *sql.DB) ([]string, error) {
+    names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(names)
+
+    applied := map[string]bool{}
+    rows, err := db.QueryContext(ctx, "select version from schema_migrations")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var version string
+        if err := rows.Scan(&version); err != nil {
+            return nil, err
+        }
+        applied[version] = true
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    var pending []string
+    for _, name := range names {
+        name = strings.TrimPrefix(name, "migrations/")
+        if !applied[name] {
+            pending = append(pending, name)
+        }
+    }
+
+    return pending, nil
+}
+
+func splitStatements(content string) []string {
+    var statements []string
+    for _, statement := range strings.Split(content, ";\n") {
+        statement = strings.TrimSpace(statement)
+        statement = strings.TrimSuffix(statement, ";")
+        if statement != "" {
+            statements = append(statements, statement)
+        }
+    }
+    return statements
+}
@@ -0,0 +1,25 @@
+create table if not exists products (
+    id            char(36) primary key,
+    name          varchar(255) not null,
+    price         decimal(10, 2) not null,
+    description   text not null,
+    image         varchar(255) not null default '',
+    created_date  datetime not null default current_timestamp,
+    modified_date datetime not null default current_timestamp
+);
+
+create table if not exists orders (
+    id      char(36) primary key,
+    user_id varchar(255) not null,
+    status  varchar(20) not null,
+    date    datetime not null default current_timestamp
+);
+
+create table if not exists order_items (
+    order_id   char(36) not null,
+    product_id char(36) not null,
+    quantity   int not null,
+    cost       decimal(10, 2) not null,
+    primary key (order_id, product_id),
+    foreign key (order_id) references orders (id) on delete cascade
+);
@@ -0,0 +1,16 @@
+create table users (
+    id            char(36) primary key,
+    email         varchar(255) not null unique,
+    name          varchar(255) not null,
+    password_hash varchar(255) not null,
+    created_date  datetime not null default current_timestamp
+);
+
+create table sessions (
+    id         varchar(64) primary key,
+    user_id    char(36) null,
+    expires_at datetime not null,
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+alter table orders add column email varchar(255) not null default '';
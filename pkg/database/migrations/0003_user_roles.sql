@@ -0,0 +1 @@
+alter table users add column role varchar(20) not null default 'customer';
@@ -0,0 +1,3 @@
+delete from sessions;
+
+alter table sessions add column csrf_token varchar(64) not null;
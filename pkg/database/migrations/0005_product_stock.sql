@@ -0,0 +1,3 @@
+-- Stock is null for products whose stock is not tracked, which includes
+-- every product created before stock tracking; they stay purchasable.
+alter table products add column stock int null;
@@ -0,0 +1,12 @@
+create table order_events (
+    id          char(36) primary key,
+    order_id    char(36) not null,
+    event_type  varchar(30) not null,
+    from_status varchar(20) not null default '',
+    to_status   varchar(20) not null default '',
+    actor       varchar(255) not null,
+    note        text not null,
+    created_at  datetime(3) not null default current_timestamp(3),
+    index (order_id, created_at),
+    foreign key (order_id) references orders (id) on delete cascade
+);
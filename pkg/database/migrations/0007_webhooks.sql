@@ -0,0 +1,26 @@
+create table webhook_subscriptions (
+    id           char(36) primary key,
+    url          varchar(2048) not null,
+    events       varchar(1024) not null,
+    secret       varchar(255) not null,
+    active       boolean not null default true,
+    created_date datetime not null default current_timestamp
+);
+
+create table webhook_deliveries (
+    id              char(36) primary key,
+    subscription_id char(36) not null,
+    event_id        char(36) not null,
+    event_type      varchar(64) not null,
+    payload         mediumtext not null,
+    status          varchar(20) not null,
+    attempts        int not null default 0,
+    next_attempt_at datetime(3) not null,
+    response_status int not null default 0,
+    last_error      text not null,
+    created_at      datetime(3) not null default current_timestamp(3),
+    delivered_at    datetime(3) null,
+    index (status, next_attempt_at),
+    index (subscription_id, created_at),
+    foreign key (subscription_id) references webhook_subscriptions (id) on delete cascade
+);
@@ -0,0 +1,13 @@
+create table outbox (
+    id           char(36) primary key,
+    topic        varchar(64) not null,
+    dedup_key    varchar(191) not null,
+    payload      mediumtext not null,
+    attempts     int not null default 0,
+    last_error   text not null,
+    available_at datetime(3) not null,
+    created_at   datetime(3) not null default current_timestamp(3),
+    processed_at datetime(3) null,
+    unique (dedup_key),
+    index (processed_at, available_at)
+);
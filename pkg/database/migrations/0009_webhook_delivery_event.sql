@@ -0,0 +1 @@
+create index webhook_deliveries_event on webhook_deliveries (subscription_id, event_id);
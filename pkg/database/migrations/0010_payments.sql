@@ -0,0 +1,18 @@
+alter table orders add column payment_status varchar(20) not null default 'unpaid';
+
+create table payments (
+    id           char(36) primary key,
+    order_id     char(36) not null,
+    provider     varchar(32) not null,
+    provider_ref varchar(255) not null default '',
+    amount       bigint not null,
+    currency     char(3) not null,
+    status       varchar(20) not null,
+    redirect_url varchar(2048) not null default '',
+    last_error   text not null,
+    created_at   datetime(3) not null default current_timestamp(3),
+    updated_at   datetime(3) not null default current_timestamp(3),
+    index (order_id, created_at),
+    index (provider, provider_ref),
+    foreign key (order_id) references orders (id) on delete cascade
+);
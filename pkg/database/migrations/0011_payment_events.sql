@@ -0,0 +1,16 @@
+create table payment_events (
+    id           char(36) primary key,
+    provider     varchar(32) not null,
+    event_id     varchar(255) not null,
+    event_type   varchar(64) not null,
+    provider_ref varchar(255) not null default '',
+    payment_id   char(36) null,
+    payload      mediumtext not null,
+    status       varchar(20) not null,
+    error        text not null,
+    received_at  datetime(3) not null default current_timestamp(3),
+    processed_at datetime(3) null,
+    unique (provider, event_id),
+    index (received_at),
+    index (payment_id)
+);
@@ -0,0 +1,37 @@
+alter table order_items
+    add column refunded_quantity  int not null default 0,
+    add column restocked_quantity int not null default 0;
+
+-- Cancelled orders have already put their items back in stock.
+update order_items oi
+    inner join orders o on o.id = oi.order_id
+set oi.restocked_quantity = oi.quantity
+where o.status = 'cancel';
+
+alter table orders add column refunded_amount bigint not null default 0;
+
+create table refunds (
+    id           char(36) primary key,
+    order_id     char(36) not null,
+    payment_id   char(36) not null,
+    amount       bigint not null,
+    reason       text not null,
+    restock      boolean not null,
+    status       varchar(20) not null,
+    provider_ref varchar(255) not null default '',
+    error        text not null,
+    actor        varchar(255) not null,
+    created_at   datetime(3) not null default current_timestamp(3),
+    index (order_id, created_at),
+    foreign key (order_id) references orders (id) on delete cascade,
+    foreign key (payment_id) references payments (id)
+);
+
+create table refund_items (
+    refund_id  char(36) not null,
+    product_id char(36) not null,
+    quantity   int not null,
+    amount     bigint not null,
+    primary key (refund_id, product_id),
+    foreign key (refund_id) references refunds (id) on delete cascade
+);
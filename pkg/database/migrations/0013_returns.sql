@@ -0,0 +1,24 @@
+create table returns (
+    id         char(36) primary key,
+    rma        varchar(20) unique,
+    order_id   char(36) not null,
+    status     varchar(20) not null,
+    resolution varchar(20) not null,
+    reason     text not null,
+    note       text not null,
+    refund_id  char(36),
+    created_at datetime(3) not null default current_timestamp(3),
+    updated_at datetime(3) not null default current_timestamp(3),
+    index (status, created_at),
+    index (order_id),
+    foreign key (order_id) references orders (id) on delete cascade,
+    foreign key (refund_id) references refunds (id)
+);
+
+create table return_items (
+    return_id  char(36) not null,
+    product_id char(36) not null,
+    quantity   int not null,
+    primary key (return_id, product_id),
+    foreign key (return_id) references returns (id) on delete cascade
+);
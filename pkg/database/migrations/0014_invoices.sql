@@ -0,0 +1,27 @@
+-- Invoice and credit note numbers each run without gaps, so they are taken
+-- from a locked counter rather than an auto_increment column.
+create table invoice_counters (
+    kind        varchar(20) primary key,
+    last_number int not null
+);
+
+insert into invoice_counters(kind, last_number)
+values ('invoice', 0),
+       ('credit_note', 0);
+
+-- Issued documents are never updated: the document column is the snapshot of
+-- what was issued and pdf the file that was handed out.
+create table invoices (
+    id        char(36) primary key,
+    kind      varchar(20) not null,
+    number    varchar(20) not null unique,
+    source    varchar(64) not null unique,
+    order_id  char(36) not null,
+    refund_id char(36),
+    document  json not null,
+    pdf       mediumblob not null,
+    issued_at datetime(3) not null default current_timestamp(3),
+    index (order_id, issued_at),
+    foreign key (order_id) references orders (id),
+    foreign key (refund_id) references refunds (id)
+);
@@ -0,0 +1,2 @@
+-- Optional stock keeping unit, used to match rows when importing products.
+alter table products add column sku varchar(64) null unique after id;
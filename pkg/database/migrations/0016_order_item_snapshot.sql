@@ -0,0 +1,14 @@
+-- Order lines keep the product's name and unit price as they were at
+-- checkout, so that invoices stay right once the product is edited or
+-- deleted.
+alter table order_items
+    add column product_name varchar(255) not null default '',
+    add column unit_price   decimal(10, 2) not null default 0;
+
+update order_items oi
+    inner join products p on p.id = oi.product_id
+set oi.product_name = p.name;
+
+update order_items
+set unit_price = round(cost / quantity, 2)
+where quantity > 0;
@@ -0,0 +1,4 @@
+-- An exchanged return links the order that ships the replacement goods.
+alter table returns
+    add column replacement_order_id char(36),
+    add foreign key (replacement_order_id) references orders (id);
@@ -0,0 +1,13 @@
+-- Each subscriber that handled a message is recorded, so that a message
+-- retried because of one subscriber is not handed again to the others.
+create table outbox_deliveries (
+    message_id   char(36) not null,
+    subscriber   varchar(64) not null,
+    delivered_at datetime(3) not null default current_timestamp(3),
+    primary key (message_id, subscriber),
+    foreign key (message_id) references outbox (id) on delete cascade
+);
+
+-- A message that keeps failing is given up on and kept as dead, with its
+-- last error, until someone looks into it.
+alter table outbox add column dead_at datetime(3) null;
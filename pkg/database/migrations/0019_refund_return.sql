@@ -0,0 +1,5 @@
+-- A refund issued for a return links it, so that the return can be closed
+-- when a refund left pending is settled later.
+alter table refunds
+    add column return_id char(36),
+    add foreign key (return_id) references returns (id);
@@ -0,0 +1,54 @@
+package export
+
+import (
+    "encoding/csv"
+    "io"
+)
+
+type csvWriter struct {
+    w *csv.Writer
+}
+
+func NewCSV(w io.Writer) Writer {
+    return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Write(row []any) error {
+    record := make([]string, len(row))
+    for i, cell := range row {
+        record[i] = text(cell)
+        if s, ok := cell.(string); ok {
+            record[i] = defuse(s)
+        }
+    }
+    return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+    c.w.Flush()
+    return c.w.Error()
+}
+
+// defuse stops spreadsheet programs from running text that looks like a
+// formula, such as a customer email of "=HYPERLINK(...)", by quoting it.
+func defuse(s string) string {
+    if s == "" {
+        return s
+    }
+    switch s[0] {
+    case '=', '+', '-', '@', '\t', '\r':
+        return "'" + s
+    }
+    return s
+}
+
+// Restore undoes defuse, for reading back a file that NewCSV wrote.
+func Restore(s string) string {
+    if len(s) > 1 && s[0] == '\'' {
+        switch s[1] {
+        case '=', '+', '-', '@', '\t', '\r':
+            return s[1:]
+        }
+    }
+    return s
+}
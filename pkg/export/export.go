@@ -0,0 +1,52 @@
+// Package export writes tables of data for spreadsheets, as CSV or as Excel
+// workbooks, one row at a time so that large exports can be streamed.
+package export
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+)
+
+// Writer writes a table row by row. Cells may be strings, integers, floats
+// or times; anything else is written as its fmt.Sprint text. Close must be
+// called once the last row is written.
+type Writer interface {
+    Write(row []any) error
+    Close() error
+}
+
+// Format is a file format an export can be written in.
+type Format string
+
+const (
+    CSV  Format = "csv"
+    XLSX Format = "xlsx"
+)
+
+func (f Format) ContentType() string {
+    if f == XLSX {
+        return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+    }
+    return "text/csv; charset=utf-8"
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// text returns the plain text of a cell.
+func text(cell any) string {
+    switch v := cell.(type) {
+    case string:
+        return v
+    case int:
+        return strconv.Itoa(v)
+    case int64:
+        return strconv.FormatInt(v, 10)
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    case time.Time:
+        return v.Format(timeLayout)
+    default:
+        return fmt.Sprint(v)
+    }
+}
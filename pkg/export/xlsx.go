@@ -0,0 +1,118 @@
+package export
+
+import (
+    "archive/zip"
+    "bufio"
+    "encoding/xml"
+    "io"
+    "strconv"
+    "time"
+)
+
+// The parts of a workbook with a single sheet, apart from the sheet itself.
+// Style 1 shows a date and time.
+var xlsxParts = []struct{ name, body string }{
+    {"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+    {"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+    {"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+    {"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+</styleSheet>`},
+}
+
+type xlsxWriter struct {
+    zip   *zip.Writer
+    sheet *bufio.Writer
+}
+
+// NewXLSX starts an Excel workbook with one sheet called name. Rows are
+// written straight into the compressed file, so the workbook is never held
+// in memory.
+func NewXLSX(w io.Writer, name string) (Writer, error) {
+    z := zip.NewWriter(w)
+
+    for _, part := range xlsxParts {
+        f, err := z.Create(part.name)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := io.WriteString(f, part.body); err != nil {
+            return nil, err
+        }
+    }
+
+    f, err := z.Create("xl/workbook.xml")
+    if err != nil {
+        return nil, err
+    }
+    io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+    xml.EscapeText(f, []byte(name))
+    if _, err := io.WriteString(f, `" sheetId="1" r:id="rId1"/></sheets></workbook>`); err != nil {
+        return nil, err
+    }
+
+    f, err = z.Create("xl/worksheets/sheet1.xml")
+    if err != nil {
+        return nil, err
+    }
+    x := &xlsxWriter{zip: z, sheet: bufio.NewWriter(f)}
+    x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+    return x, nil
+}
+
+func (x *xlsxWriter) Write(row []any) error {
+    x.sheet.WriteString("<row>")
+    for _, cell := range row {
+        switch v := cell.(type) {
+        case int, int64, float64:
+            x.sheet.WriteString(`<c><v>` + text(v) + `</v></c>`)
+        case time.Time:
+            x.sheet.WriteString(`<c s="1"><v>` + strconv.FormatFloat(serial(v), 'f', -1, 64) + `</v></c>`)
+        default:
+            x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+            xml.EscapeText(x.sheet, []byte(text(v)))
+            x.sheet.WriteString(`</t></is></c>`)
+        }
+    }
+    _, err := x.sheet.WriteString("</row>")
+    return err
+}
+
+func (x *xlsxWriter) Close() error {
+    x.sheet.WriteString("</sheetData></worksheet>")
+    if err := x.sheet.Flush(); err != nil {
+        return err
+    }
+    return x.zip.Close()
+}
+
+// serial converts t to an Excel date: days since the end of 1899, with the
+// time of day as the fraction. The wall clock time is kept as it is, since
+// spreadsheets have no time zones.
+func serial(t time.Time) float64 {
+    wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+    return wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+}
@@ -0,0 +1,108 @@
+// Package fulfilment prepares the paperwork the warehouse needs to ship
+// orders: packing slips to put in each parcel and pick lists that total what
+// to take off the shelves.
+package fulfilment
+
+import (
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "sort"
+    "strings"
+)
+
+// ToShip is how many units of an order line still have to be sent; refunded
+// units are not.
+func ToShip(item OrderItem) int {
+    return item.Quantity - item.RefundedQuantity
+}
+
+// Reference is the short order number printed for the warehouse.
+func Reference(order *Order) string {
+    return strings.ToUpper(order.ID.String()[:8])
+}
+
+// Slip is the packing slip of one order: what goes in its parcel.
+type Slip struct {
+    Order     *Order
+    Reference string
+    Lines     []SlipLine
+}
+
+type SlipLine struct {
+    Name     string
+    Quantity int
+}
+
+// NewSlips makes a packing slip for each of orders. Lines with nothing left
+// to ship are left off.
+func NewSlips(orders []Order) []Slip {
+    slips := make([]Slip, 0, len(orders))
+
+    for i := range orders {
+        slip := Slip{Order: &orders[i], Reference: Reference(&orders[i])}
+        for _, item := range orders[i].Items {
+            if quantity := ToShip(item); quantity > 0 {
+                slip.Lines = append(slip.Lines, SlipLine{Name: productName(item), Quantity: quantity})
+            }
+        }
+        slips = append(slips, slip)
+    }
+
+    return slips
+}
+
+// PickLine is one product to pick and the orders that need it.
+type PickLine struct {
+    ProductID uuid.UUID
+    Name      string
+    Quantity  int
+    Orders    []string
+}
+
+type PickList struct {
+    Lines  []PickLine
+    Orders int
+    Units  int
+}
+
+// NewPickList adds up what has to be picked for orders, per product, sorted
+// by product name.
+func NewPickList(orders []Order) *PickList {
+    list := &PickList{Orders: len(orders)}
+    lines := make(map[uuid.UUID]*PickLine)
+
+    for i := range orders {
+        for _, item := range orders[i].Items {
+            quantity := ToShip(item)
+            if quantity <= 0 {
+                continue
+            }
+
+            line, ok := lines[item.ProductID]
+            if !ok {
+                line = &PickLine{ProductID: item.ProductID, Name: productName(item)}
+                lines[item.ProductID] = line
+            }
+
+            line.Quantity += quantity
+            line.Orders = append(line.Orders, Reference(&orders[i]))
+            list.Units += quantity
+        }
+    }
+
+    for _, line := range lines {
+        list.Lines = append(list.Lines, *line)
+    }
+    sort.Slice(list.Lines, func(i, j int) bool {
+        return list.Lines[i].Name < list.Lines[j].Name
+    })
+
+    return list
+}
+
+func productName(item OrderItem) string {
+    if item.Product == nil {
+        return item.ProductID.String()
+    }
+    return item.Product.Name
+}
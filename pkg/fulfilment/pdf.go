@@ -0,0 +1,99 @@
+package fulfilment
+
+import (
+    "fmt"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/pdf"
+    "strings"
+    "time"
+)
+
+const (
+    margin     = 50.0
+    pageBottom = pdf.PageHeight - 60
+    lineHeight = 18.0
+    right      = pdf.PageWidth - margin
+)
+
+// PackingSlipsPDF renders each slip on its own page, without prices.
+func PackingSlipsPDF(slips []Slip) ([]byte, error) {
+    doc := pdf.NewDocument()
+
+    for i, slip := range slips {
+        if i > 0 {
+            doc.AddPage()
+        }
+
+        doc.Text(margin, 70, 22, true, "PACKING SLIP")
+        doc.TextRight(right, 60, 12, true, "Order "+slip.Reference)
+        doc.TextRight(right, 76, 9, false, "Placed "+slip.Order.Date.Format("January 2, 2006"))
+
+        doc.Text(margin, 115, 9, true, "Ship to")
+        doc.Text(margin, 130, 11, false, slip.Order.Email)
+        doc.Text(margin, 144, 8, false, slip.Order.ID.String())
+
+        y := 190.0
+        slipHeader(doc, y)
+        y += lineHeight + 2
+
+        for _, line := range slip.Lines {
+            if y > pageBottom {
+                doc.AddPage()
+                y = 70
+                slipHeader(doc, y)
+                y += lineHeight + 2
+            }
+
+            doc.Rect(margin, y-9, 10, 10)
+            doc.Text(margin+24, y, 10, false, pdf.Fit(line.Name, right-margin-80, 10))
+            doc.TextRight(right, y, 10, true, fmt.Sprint(line.Quantity))
+            y += lineHeight
+        }
+
+        doc.Text(margin, pdf.PageHeight-40, 8, false, "Thank you for your order. Questions? Reply to your order confirmation email.")
+    }
+
+    return doc.Bytes()
+}
+
+// PickListPDF renders list with a tick box per product.
+func PickListPDF(list *PickList, generated time.Time) ([]byte, error) {
+    doc := pdf.NewDocument()
+
+    doc.Text(margin, 70, 22, true, "PICK LIST")
+    doc.TextRight(right, 60, 9, false, "Generated "+generated.Format("2006-01-02 15:04"))
+    doc.TextRight(right, 74, 9, false, fmt.Sprintf("%d orders, %d units", list.Orders, list.Units))
+
+    y := 115.0
+    pickHeader(doc, y)
+    y += lineHeight + 2
+
+    for _, line := range list.Lines {
+        if y > pageBottom {
+            doc.AddPage()
+            y = 70
+            pickHeader(doc, y)
+            y += lineHeight + 2
+        }
+
+        doc.Rect(margin, y-9, 10, 10)
+        doc.Text(margin+24, y, 10, false, pdf.Fit(line.Name, 250-margin, 10))
+        doc.TextRight(330, y, 10, true, fmt.Sprint(line.Quantity))
+        doc.Text(350, y, 8, false, pdf.Fit(strings.Join(line.Orders, ", "), right-350, 8))
+        y += lineHeight
+    }
+
+    return doc.Bytes()
+}
+
+func slipHeader(doc *pdf.Document, y float64) {
+    doc.Text(margin+24, y, 9, true, "Item")
+    doc.TextRight(right, y, 9, true, "Qty")
+    doc.Line(margin, y+5, right, y+5)
+}
+
+func pickHeader(doc *pdf.Document, y float64) {
+    doc.Text(margin+24, y, 9, true, "Product")
+    doc.TextRight(330, y, 9, true, "Qty")
+    doc.Text(350, y, 9, true, "Orders")
+    doc.Line(margin, y+5, right, y+5)
+}
@@ -0,0 +1,661 @@
+package graphql
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "strings"
+)
+
+const defaultMaxDepth = 15
+
+// Request is a GraphQL request as clients post it.
+type Request struct {
+    Query         string         `json:"query"`
+    OperationName string         `json:"operationName"`
+    Variables     map[string]any `json:"variables"`
+}
+
+// Response is the result of a request. Data is left out when the request
+// failed before it could be executed, and is null or partial when fields
+// failed while it was.
+type Response struct {
+    Data   *Result  `json:"data,omitempty"`
+    Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error, with where in the document and in the response
+// it happened.
+type Error struct {
+    Message   string     `json:"message"`
+    Locations []Location `json:"locations,omitempty"`
+    Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+// Result is a response object, which keeps its fields in the order they
+// were selected.
+type Result struct {
+    keys   []string
+    values map[string]any
+}
+
+func newResult() *Result {
+    return &Result{values: make(map[string]any)}
+}
+
+func (r *Result) set(key string, value any) {
+    if _, ok := r.values[key]; !ok {
+        r.keys = append(r.keys, key)
+    }
+    r.values[key] = value
+}
+
+// Get returns the value of a field of the result.
+func (r *Result) Get(key string) any {
+    return r.values[key]
+}
+
+func (r *Result) MarshalJSON() ([]byte, error) {
+    var b bytes.Buffer
+    b.WriteByte('{')
+    for i, key := range r.keys {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        name, _ := json.Marshal(key)
+        b.Write(name)
+        b.WriteByte(':')
+        value, err := json.Marshal(r.values[key])
+        if err != nil {
+            return nil, err
+        }
+        b.Write(value)
+    }
+    b.WriteByte('}')
+    return b.Bytes(), nil
+}
+
+// Execute runs a query or mutation.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+    return s.execute(ctx, req, true)
+}
+
+// execute runs a request, refusing mutations unless allowMutation is set,
+// as for requests made with GET.
+func (s *Schema) execute(ctx context.Context, req Request, allowMutation bool) *Response {
+    doc, err := Parse(req.Query)
+    if err != nil {
+        syntaxErr := err.(*SyntaxError)
+        return &Response{Errors: []*Error{{Message: syntaxErr.Error(), Locations: []Location{syntaxErr.Location}}}}
+    }
+
+    operation, err := selectOperation(doc, req.OperationName)
+    if err != nil {
+        return &Response{Errors: []*Error{{Message: err.Error()}}}
+    }
+    if operation.Type == "mutation" && !allowMutation {
+        return &Response{Errors: []*Error{{Message: "mutations must be sent with POST", Locations: []Location{operation.Location}}}}
+    }
+
+    e := &executor{schema: s, doc: doc, operation: operation, ctx: ctx}
+    e.validate()
+    if len(e.errors) > 0 {
+        return &Response{Errors: e.errors}
+    }
+
+    e.coerceVariables(req.Variables)
+    if len(e.errors) > 0 {
+        return &Response{Errors: e.errors}
+    }
+
+    data := newResult()
+    root := s.Query
+    if operation.Type == "mutation" {
+        // Mutations run one at a time, in order, each with everything
+        // below it.
+        for _, group := range e.collectFields(s.Mutation, operation.SelectionSet) {
+            e.run([]*objectTask{{typ: s.Mutation, selections: group.selections(), out: data}})
+        }
+    } else {
+        e.run([]*objectTask{{typ: root, selections: operation.SelectionSet, out: data}})
+    }
+
+    return &Response{Data: data, Errors: e.errors}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+    if name == "" {
+        if len(doc.Operations) > 1 {
+            return nil, fmt.Errorf("the document has several operations; give an operationName")
+        }
+        return doc.Operations[0], nil
+    }
+
+    for _, operation := range doc.Operations {
+        if operation.Name == name {
+            return operation, nil
+        }
+    }
+    return nil, fmt.Errorf("there is no operation named %s", name)
+}
+
+type executor struct {
+    schema    *Schema
+    doc       *Document
+    operation *Operation
+    ctx       context.Context
+    variables map[string]any
+    errors    []*Error
+}
+
+func (e *executor) fail(message string, location *Location, path []any) {
+    err := &Error{Message: message, Path: path}
+    if location != nil {
+        err.Locations = []Location{*location}
+    }
+    e.errors = append(e.errors, err)
+}
+
+// objectTask is an object of the response waiting for its fields.
+type objectTask struct {
+    typ        *Object
+    source     any
+    selections []Selection
+    out        *Result
+    path       []any
+}
+
+// fieldTask is a field of an object being resolved.
+type fieldTask struct {
+    object *objectTask
+    group  *fieldGroup
+    def    *FieldDef
+    value  any
+    err    error
+    path   []any
+}
+
+// run resolves the objects breadth first: each round resolves every field
+// of every object, calls the thunks they returned, and then moves on to the
+// objects below them.
+func (e *executor) run(tasks []*objectTask) {
+    for len(tasks) > 0 {
+        var fields []*fieldTask
+        for _, task := range tasks {
+            for _, group := range e.collectFields(task.typ, task.selections) {
+                path := appendPath(task.path, group.key)
+                if group.name() == "__typename" {
+                    task.out.set(group.key, task.typ.Name)
+                    continue
+                }
+
+                field := &fieldTask{object: task, group: group, def: task.typ.Field(group.name()), path: path}
+                task.out.set(group.key, nil)
+
+                args, err := e.coerceArguments(field.def, group.fields[0].Arguments)
+                if err != nil {
+                    field.err = err
+                } else if field.def.Resolve != nil {
+                    field.value, field.err = field.def.Resolve(ResolveParams{Context: e.ctx, Source: task.source, Args: args})
+                } else {
+                    field.value, field.err = defaultResolve(task.source, field.def.Name)
+                }
+                fields = append(fields, field)
+            }
+        }
+
+        for _, field := range fields {
+            for field.err == nil {
+                thunk, ok := field.value.(Thunk)
+                if !ok {
+                    break
+                }
+                field.value, field.err = thunk()
+            }
+        }
+
+        var next []*objectTask
+        for _, field := range fields {
+            if field.err != nil {
+                e.fail(field.err.Error(), &field.group.fields[0].Location, field.path)
+                continue
+            }
+
+            value, children, err := e.complete(field.def.Type, field.value, field.group.selectionSet(), field.path)
+            if err != nil {
+                e.fail(err.Error(), &field.group.fields[0].Location, field.path)
+                continue
+            }
+            field.object.out.set(field.group.key, value)
+            next = append(next, children...)
+        }
+        tasks = next
+    }
+}
+
+// complete turns a resolved value into its response form. Objects come
+// back empty, with a task to fill them in the next round.
+func (e *executor) complete(t Type, value any, selections []Selection, path []any) (any, []*objectTask, error) {
+    if nonNull, ok := t.(*NonNull); ok {
+        if isNull(value, nonNull.Of) {
+            return nil, nil, fmt.Errorf("%s cannot be null", pathString(path))
+        }
+        return e.complete(nonNull.Of, value, selections, path)
+    }
+    if isNull(value, t) {
+        return nil, nil, nil
+    }
+
+    switch t := t.(type) {
+    case *List:
+        v := reflect.ValueOf(value)
+        if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+            return nil, nil, fmt.Errorf("%s should be a list", pathString(path))
+        }
+
+        list := make([]any, v.Len())
+        var tasks []*objectTask
+        for i := range list {
+            item, children, err := e.complete(t.Of, v.Index(i).Interface(), selections, appendPath(path, i))
+            if err != nil {
+                return nil, nil, err
+            }
+            list[i] = item
+            tasks = append(tasks, children...)
+        }
+        return list, tasks, nil
+
+    case *Scalar:
+        // Nullable values are often pointers, such as a *int for an Int.
+        if v := reflect.ValueOf(value); v.Kind() == reflect.Pointer {
+            value = v.Elem().Interface()
+        }
+        serialized, err := t.Serialize(value)
+        if err != nil {
+            return nil, nil, fmt.Errorf("%s: %w", pathString(path), err)
+        }
+        return serialized, nil, nil
+
+    case *Enum:
+        name, err := toString(value)
+        if err != nil || !contains(t.Values, name.(string)) {
+            return nil, nil, fmt.Errorf("%s: %v is not a %s", pathString(path), value, t.Name)
+        }
+        return name, nil, nil
+
+    case *Object:
+        out := newResult()
+        return out, []*objectTask{{typ: t, source: value, selections: selections, out: out, path: path}}, nil
+    }
+
+    return nil, nil, fmt.Errorf("%s has an unknown type", pathString(path))
+}
+
+// isNull reports whether a resolved value is null. A nil slice is an empty
+// list rather than null, since that is how Go spells one.
+func isNull(value any, t Type) bool {
+    if value == nil {
+        return true
+    }
+
+    v := reflect.ValueOf(value)
+    switch v.Kind() {
+    case reflect.Pointer, reflect.Map, reflect.Interface, reflect.Func:
+        return v.IsNil()
+    case reflect.Slice:
+        _, isList := t.(*List)
+        return v.IsNil() && !isList
+    }
+    return false
+}
+
+// fieldGroup is the fields of a selection set that share a response key,
+// which are resolved once.
+type fieldGroup struct {
+    key    string
+    fields []*Field
+}
+
+func (g *fieldGroup) name() string {
+    return g.fields[0].Name
+}
+
+func (g *fieldGroup) selectionSet() []Selection {
+    var selections []Selection
+    for _, field := range g.fields {
+        selections = append(selections, field.SelectionSet...)
+    }
+    return selections
+}
+
+func (g *fieldGroup) selections() []Selection {
+    selections := make([]Selection, len(g.fields))
+    for i, field := range g.fields {
+        selections[i] = field
+    }
+    return selections
+}
+
+// collectFields flattens the fragments of a selection set on an object
+// type and groups its fields by response key, leaving out those skipped
+// by directives.
+func (e *executor) collectFields(t *Object, selections []Selection) []*fieldGroup {
+    var groups []*fieldGroup
+    byKey := make(map[string]*fieldGroup)
+
+    var collect func(selections []Selection, visited map[string]bool)
+    collect = func(selections []Selection, visited map[string]bool) {
+        for _, selection := range selections {
+            switch selection := selection.(type) {
+            case *Field:
+                if !e.included(selection.Directives) {
+                    continue
+                }
+                key := selection.ResponseKey()
+                group, ok := byKey[key]
+                if !ok {
+                    group = &fieldGroup{key: key}
+                    byKey[key] = group
+                    groups = append(groups, group)
+                }
+                group.fields = append(group.fields, selection)
+
+            case *FragmentSpread:
+                fragment := e.doc.Fragments[selection.Name]
+                if !e.included(selection.Directives) || visited[selection.Name] || fragment == nil || fragment.TypeCondition != t.Name {
+                    continue
+                }
+                visited[selection.Name] = true
+                collect(fragment.SelectionSet, visited)
+
+            case *InlineFragment:
+                if !e.included(selection.Directives) || (selection.TypeCondition != "" && selection.TypeCondition != t.Name) {
+                    continue
+                }
+                collect(selection.SelectionSet, visited)
+            }
+        }
+    }
+    collect(selections, make(map[string]bool))
+
+    return groups
+}
+
+// included applies @skip(if:) and @include(if:).
+func (e *executor) included(directives []*Directive) bool {
+    for _, directive := range directives {
+        if directive.Name != "skip" && directive.Name != "include" {
+            continue
+        }
+        for _, argument := range directive.Arguments {
+            if argument.Name != "if" {
+                continue
+            }
+            value, _ := e.coerce(&NonNull{Of: Boolean}, argument.Value)
+            if condition, _ := value.(bool); condition == (directive.Name == "skip") {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+func (e *executor) coerceArguments(def *FieldDef, arguments []*Argument) (map[string]any, error) {
+    args := make(map[string]any, len(def.Args))
+    for _, arg := range def.Args {
+        var literal *Argument
+        for _, argument := range arguments {
+            if argument.Name == arg.Name {
+                literal = argument
+            }
+        }
+
+        if literal == nil {
+            if arg.Default != nil {
+                args[arg.Name] = arg.Default
+            } else if _, ok := arg.Type.(*NonNull); ok {
+                return nil, fmt.Errorf("argument %s of %s is required", arg.Name, def.Name)
+            }
+            continue
+        }
+
+        // A variable that was not given falls back to the argument's
+        // default.
+        if variable, ok := literal.Value.(*Variable); ok && arg.Default != nil {
+            if _, given := e.variables[variable.Name]; !given {
+                args[arg.Name] = arg.Default
+                continue
+            }
+        }
+
+        value, err := e.coerce(arg.Type, literal.Value)
+        if err != nil {
+            return nil, fmt.Errorf("argument %s of %s: %w", arg.Name, def.Name, err)
+        }
+        args[arg.Name] = value
+    }
+    return args, nil
+}
+
+// coerce turns a literal from the document, or a value from the request's
+// variables, into the Go value of an input type.
+func (e *executor) coerce(t Type, value any) (any, error) {
+    if variable, ok := value.(*Variable); ok {
+        // Variables were coerced to their own type already, which
+        // validation checked fits here.
+        value = e.variables[variable.Name]
+        if _, ok := t.(*NonNull); ok && value == nil {
+            return nil, fmt.Errorf("variable $%s cannot be null", variable.Name)
+        }
+        if _, ok := t.(*List); ok && value != nil && reflect.ValueOf(value).Kind() != reflect.Slice {
+            return []any{value}, nil
+        }
+        return value, nil
+    }
+
+    switch t := t.(type) {
+    case *NonNull:
+        if value == nil {
+            return nil, fmt.Errorf("expected a %s, got null", t.Of)
+        }
+        return e.coerce(t.Of, value)
+    }
+    if value == nil {
+        return nil, nil
+    }
+
+    switch t := t.(type) {
+    case *List:
+        items, ok := value.([]Value)
+        if !ok {
+            items = []Value{value}
+        }
+        list := make([]any, len(items))
+        for i, item := range items {
+            coerced, err := e.coerce(t.Of, item)
+            if err != nil {
+                return nil, err
+            }
+            list[i] = coerced
+        }
+        return list, nil
+
+    case *Scalar:
+        if _, ok := value.(EnumValue); ok {
+            return nil, fmt.Errorf("expected a %s, got %v", t.Name, value)
+        }
+        return t.Parse(value)
+
+    case *Enum:
+        var name string
+        switch v := value.(type) {
+        case EnumValue:
+            name = string(v)
+        case string:
+            name = v
+        }
+        if !contains(t.Values, name) {
+            return nil, fmt.Errorf("expected a %s, got %v", t.Name, value)
+        }
+        return name, nil
+    }
+
+    return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceVariables checks the variables of the request against the
+// operation's definitions.
+func (e *executor) coerceVariables(values map[string]any) {
+    e.variables = make(map[string]any)
+    types := e.schema.inputTypes()
+
+    for _, definition := range e.operation.Variables {
+        t := resolveTypeRef(definition.Type, types)
+
+        value, given := values[definition.Name]
+        if !given && definition.Default != nil {
+            value, given = definition.Default, true
+        }
+        if !given {
+            if definition.Type.NonNull {
+                e.fail("variable $"+definition.Name+" of type "+definition.Type.String()+" is required", &e.operation.Location, nil)
+            }
+            continue
+        }
+
+        coerced, err := e.coerce(t, fromJSON(value))
+        if err != nil {
+            e.fail("variable $"+definition.Name+": "+err.Error(), &e.operation.Location, nil)
+            continue
+        }
+        e.variables[definition.Name] = coerced
+    }
+}
+
+// fromJSON turns decoded JSON into document values, so that variables are
+// coerced like literals: arrays become lists and whole numbers Ints.
+func fromJSON(value any) any {
+    switch v := value.(type) {
+    case []any:
+        list := make([]Value, len(v))
+        for i, item := range v {
+            list[i] = fromJSON(item)
+        }
+        return list
+    case float64:
+        if v == float64(int64(v)) {
+            return int64(v)
+        }
+    case json.Number:
+        if n, err := v.Int64(); err == nil {
+            return n
+        }
+        if f, err := v.Float64(); err == nil {
+            return f
+        }
+    }
+    return value
+}
+
+func resolveTypeRef(ref *TypeRef, types map[string]Type) Type {
+    var t Type
+    if ref.Elem != nil {
+        t = &List{Of: resolveTypeRef(ref.Elem, types)}
+    } else {
+        t = types[ref.Name]
+    }
+    if ref.NonNull {
+        t = &NonNull{Of: t}
+    }
+    return t
+}
+
+// inputTypes returns the types variables may have: the built-in scalars
+// and the scalars and enums of the schema's arguments.
+func (s *Schema) inputTypes() map[string]Type {
+    types := map[string]Type{"Int": Int, "Float": Float, "String": String, "Boolean": Boolean, "ID": ID}
+    for _, object := range s.objects() {
+        for _, field := range object.Fields {
+            for _, arg := range field.Args {
+                switch t := namedType(arg.Type).(type) {
+                case *Scalar:
+                    types[t.Name] = t
+                case *Enum:
+                    types[t.Name] = t
+                }
+            }
+        }
+    }
+    return types
+}
+
+// objects returns every object type reachable from the roots.
+func (s *Schema) objects() map[string]*Object {
+    objects := make(map[string]*Object)
+    var walk func(t Type)
+    walk = func(t Type) {
+        object, ok := namedType(t).(*Object)
+        if !ok || objects[object.Name] != nil {
+            return
+        }
+        objects[object.Name] = object
+        for _, field := range object.Fields {
+            walk(field.Type)
+        }
+    }
+    walk(s.Query)
+    if s.Mutation != nil {
+        walk(s.Mutation)
+    }
+    return objects
+}
+
+func namedType(t Type) Type {
+    for {
+        switch wrapper := t.(type) {
+        case *List:
+            t = wrapper.Of
+        case *NonNull:
+            t = wrapper.Of
+        default:
+            return t
+        }
+    }
+}
+
+func appendPath(path []any, element any) []any {
+    next := make([]any, len(path), len(path)+1)
+    copy(next, path)
+    return append(next, element)
+}
+
+func pathString(path []any) string {
+    var b strings.Builder
+    for i, element := range path {
+        switch element := element.(type) {
+        case int:
+            fmt.Fprintf(&b, "[%d]", element)
+        default:
+            if i > 0 {
+                b.WriteByte('.')
+            }
+            fmt.Fprint(&b, element)
+        }
+    }
+    return b.String()
+}
+
+func contains(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
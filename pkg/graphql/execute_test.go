@@ -0,0 +1,396 @@
+package graphql
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+type testBook struct {
+    ID     string
+    Title  string
+    Genre  string
+    Author *testAuthor
+}
+
+type testAuthor struct {
+    Name string
+}
+
+var testBooks = []*testBook{
+    {ID: "1", Title: "The Hobbit", Genre: "FANTASY", Author: &testAuthor{Name: "Tolkien"}},
+    {ID: "2", Title: "Dune", Genre: "SCIENCE_FICTION", Author: &testAuthor{Name: "Herbert"}},
+    {ID: "3", Title: "Anonymous", Genre: "FANTASY"},
+}
+
+// newTestSchema returns a schema of books. Its addBook mutation appends
+// to added.
+func newTestSchema(added *[]string) *Schema {
+    nonNull := func(t Type) Type { return &NonNull{Of: t} }
+
+    genre := &Enum{Name: "Genre", Values: []string{"FANTASY", "SCIENCE_FICTION"}}
+    author := &Object{Name: "Author", Fields: []*FieldDef{
+        {Name: "name", Type: nonNull(String)},
+    }}
+    book := &Object{Name: "Book", Fields: []*FieldDef{
+        {Name: "id", Type: nonNull(ID)},
+        {Name: "title", Type: nonNull(String)},
+        {Name: "genre", Type: nonNull(genre)},
+        {Name: "author", Type: author},
+        {Name: "rating", Type: nonNull(Int), Resolve: func(p ResolveParams) (any, error) {
+            return nil, errors.New("ratings are down")
+        }},
+    }}
+
+    query := &Object{Name: "Query", Fields: []*FieldDef{
+        {
+            Name: "book",
+            Type: book,
+            Args: []*ArgDef{{Name: "id", Type: nonNull(ID)}},
+            Resolve: func(p ResolveParams) (any, error) {
+                for _, b := range testBooks {
+                    if b.ID == p.Args["id"] {
+                        return b, nil
+                    }
+                }
+                return nil, nil
+            },
+        },
+        {
+            Name: "books",
+            Type: nonNull(&List{Of: nonNull(book)}),
+            Args: []*ArgDef{
+                {Name: "genres", Type: &List{Of: nonNull(genre)}},
+                {Name: "first", Type: Int, Default: 10},
+            },
+            Resolve: func(p ResolveParams) (any, error) {
+                genres, _ := p.Args["genres"].([]any)
+                var books []*testBook
+                for _, b := range testBooks {
+                    for _, g := range genres {
+                        if g == b.Genre {
+                            books = append(books, b)
+                        }
+                    }
+                    if genres == nil {
+                        books = append(books, b)
+                    }
+                }
+                if first := p.Args["first"].(int); len(books) > first {
+                    books = books[:first]
+                }
+                return books, nil
+            },
+        },
+        {
+            Name: "echo",
+            Type: nonNull(String),
+            Args: []*ArgDef{{Name: "text", Type: nonNull(String)}},
+            Resolve: func(p ResolveParams) (any, error) {
+                return p.Args["text"], nil
+            },
+        },
+    }}
+
+    mutation := &Object{Name: "Mutation", Fields: []*FieldDef{
+        {
+            Name: "addBook",
+            Type: nonNull(book),
+            Args: []*ArgDef{{Name: "title", Type: nonNull(String)}},
+            Resolve: func(p ResolveParams) (any, error) {
+                *added = append(*added, p.Args["title"].(string))
+                return &testBook{ID: "new", Title: p.Args["title"].(string), Genre: "FANTASY"}, nil
+            },
+        },
+    }}
+
+    return &Schema{Query: query, Mutation: mutation, MaxDepth: 3}
+}
+
+func marshal(t *testing.T, v any) string {
+    t.Helper()
+
+    data, err := json.Marshal(v)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func TestExecute(t *testing.T) {
+    tests := []struct {
+        name          string
+        query         string
+        operationName string
+        variables     string
+        want          string
+    }{
+        {
+            name:  "fields in the order selected",
+            query: `{ book(id: 1) { title id author { name } } }`,
+            want:  `{"data":{"book":{"title":"The Hobbit","id":"1","author":{"name":"Tolkien"}}}}`,
+        },
+        {
+            name:  "aliases and __typename",
+            query: `{ a: book(id: "1") { __typename title } b: book(id: "2") { title } none: book(id: "9") { title } }`,
+            want:  `{"data":{"a":{"__typename":"Book","title":"The Hobbit"},"b":{"title":"Dune"},"none":null}}`,
+        },
+        {
+            name:  "enum and list arguments",
+            query: `{ books(genres: FANTASY, first: 1) { title genre } }`,
+            want:  `{"data":{"books":[{"title":"The Hobbit","genre":"FANTASY"}]}}`,
+        },
+        {
+            name:  "null objects",
+            query: `{ books(genres: [FANTASY]) { author { name } } }`,
+            want:  `{"data":{"books":[{"author":{"name":"Tolkien"}},{"author":null}]}}`,
+        },
+        {
+            name:      "variables",
+            query:     `query ($id: ID!, $genres: [Genre!], $first: Int) { book(id: $id) { title } books(genres: $genres, first: $first) { id } }`,
+            variables: `{"id": 2, "genres": ["SCIENCE_FICTION", "FANTASY"], "first": 2}`,
+            want:      `{"data":{"book":{"title":"Dune"},"books":[{"id":"1"},{"id":"2"}]}}`,
+        },
+        {
+            name:      "a single value for a list variable",
+            query:     `query ($genres: [Genre!]) { books(genres: $genres) { id } }`,
+            variables: `{"genres": "SCIENCE_FICTION"}`,
+            want:      `{"data":{"books":[{"id":"2"}]}}`,
+        },
+        {
+            name:  "variable defaults",
+            query: `query ($text: String = "hello", $first: Int) { echo(text: $text) books(first: $first) { id } }`,
+            want:  `{"data":{"echo":"hello","books":[{"id":"1"},{"id":"2"},{"id":"3"}]}}`,
+        },
+        {
+            name:      "directives",
+            query:     `query ($more: Boolean!) { book(id: 1) { title id @include(if: $more) genre @skip(if: $more) } }`,
+            variables: `{"more": false}`,
+            want:      `{"data":{"book":{"title":"The Hobbit","genre":"FANTASY"}}}`,
+        },
+        {
+            name: "fragments",
+            query: `
+                { book(id: 2) { ...Names ... on Book { genre } ... @skip(if: true) { id } } }
+                fragment Names on Book { title ...Authors }
+                fragment Authors on Book { author { name } title }`,
+            want: `{"data":{"book":{"title":"Dune","author":{"name":"Herbert"},"genre":"SCIENCE_FICTION"}}}`,
+        },
+        {
+            name:          "operation name",
+            query:         `query A { echo(text: "a") } query B { echo(text: "b") }`,
+            operationName: "B",
+            want:          `{"data":{"echo":"b"}}`,
+        },
+        {
+            name:  "field error",
+            query: `{ echo(text: "still here") book(id: 1) { title rating } }`,
+            want:  `{"data":{"echo":"still here","book":{"title":"The Hobbit","rating":null}},"errors":[{"message":"ratings are down","locations":[{"line":1,"column":48}],"path":["book","rating"]}]}`,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := Request{Query: tt.query, OperationName: tt.operationName}
+            if tt.variables != "" {
+                decoder := json.NewDecoder(strings.NewReader(tt.variables))
+                decoder.UseNumber()
+                if err := decoder.Decode(&req.Variables); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            response := newTestSchema(new([]string)).Execute(context.Background(), req)
+            if got := marshal(t, response); got != tt.want {
+                t.Errorf("response = %s\nwant %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestExecuteErrors(t *testing.T) {
+    tests := []struct {
+        name      string
+        query     string
+        variables map[string]any
+        want      []string
+    }{
+        {"syntax", `{ book(id: 1) { title }`, nil, []string{"syntax error at 1:24: unexpected end of document"}},
+        {"several operations", `query A { echo(text: "a") } query B { echo(text: "b") }`, nil, []string{"the document has several operations; give an operationName"}},
+        {"unknown field", `{ book(id: 1) { title isbn } }`, nil, []string{"Book has no field isbn"}},
+        {"unknown argument", `{ echo(text: "a", loud: true) }`, nil, []string{"Query.echo has no argument loud"}},
+        {"missing argument", `{ echo }`, nil, []string{"Query.echo needs argument text"}},
+        {"missing selection", `{ book(id: 1) }`, nil, []string{"Query.book of type Book needs a selection of its fields"}},
+        {"selection on a scalar", `{ echo(text: "a") { length } }`, nil, []string{"Query.echo of type String! has no fields to select"}},
+        {"nested too deeply", `{ book(id: 1) { author { name } } }`, nil, []string{"the query is nested more than 2 levels deep"}},
+        {"unknown directive", `{ echo(text: "a") @deprecated }`, nil, []string{"unknown directive @deprecated"}},
+        {"directive without if", `{ echo(text: "a") @skip }`, nil, []string{"@skip takes one argument, if"}},
+        {"unknown fragment", `{ book(id: 1) { ...Missing } }`, nil, []string{"there is no fragment named Missing"}},
+        {"fragment on the wrong type", `{ book(id: 1) { ...Name } } fragment Name on Author { name }`, nil, []string{"fragment Name on Author cannot be spread on Book"}},
+        {"inline fragment on the wrong type", `{ book(id: 1) { ... on Author { name } } }`, nil, []string{"a fragment on Author cannot be spread on Book"}},
+        {"fragment cycle", `{ book(id: 1) { ...A } } fragment A on Book { title ...B } fragment B on Book { ...A }`, nil, []string{"fragment A spreads itself"}},
+        {"undefined variable", `{ echo(text: $text) }`, nil, []string{"variable $text is not defined"}},
+        {"variable of the wrong type", `query ($text: Int) { echo(text: $text) }`, map[string]any{"text": 1}, []string{"variable $text of type Int cannot be used as a String!"}},
+        {"variable of an unknown type", `query ($text: Text) { echo(text: "a") }`, nil, []string{"variable $text has unknown type Text"}},
+        {"variable defined twice", `query ($text: String, $text: String) { echo(text: $text) }`, nil, []string{"variable $text is defined twice"}},
+        {"required variable missing", `query ($text: String!) { echo(text: $text) }`, nil, []string{"variable $text of type String! is required"}},
+        {"variable value of the wrong type", `query ($id: ID!) { book(id: $id) { title } }`, map[string]any{"id": true}, []string{"variable $id: expected an ID, got true"}},
+        {"enum variable not in the enum", `query ($genres: [Genre!]) { books(genres: $genres) { id } }`, map[string]any{"genres": []any{"ROMANCE"}}, []string{"variable $genres: expected a Genre, got ROMANCE"}},
+        {"null variable where one is required", `query ($text: String) { echo(text: $text) }`, map[string]any{"text": nil}, []string{"argument text of echo: variable $text cannot be null"}},
+        {"literal of the wrong type", `{ echo(text: 12) }`, nil, []string{"argument text of echo: expected a string, got 12"}},
+        {"mutation in a query", `{ addBook(title: "Emma") { id } }`, nil, []string{"Query has no field addBook"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var added []string
+            schema := newTestSchema(&added)
+            schema.MaxDepth = 2
+            response := schema.Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables})
+
+            var got []string
+            for _, err := range response.Errors {
+                got = append(got, err.Message)
+            }
+            if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+                t.Errorf("errors = %q, want %q", got, tt.want)
+            }
+            if len(added) > 0 {
+                t.Errorf("a failed request added %v", added)
+            }
+        })
+    }
+}
+
+func TestMutations(t *testing.T) {
+    var added []string
+    response := newTestSchema(&added).Execute(context.Background(), Request{
+        Query:     `mutation ($second: String!) { first: addBook(title: "Emma") { title } second: addBook(title: $second) { id } }`,
+        Variables: map[string]any{"second": "Persuasion"},
+    })
+
+    want := `{"data":{"first":{"title":"Emma"},"second":{"id":"new"}}}`
+    if got := marshal(t, response); got != want {
+        t.Errorf("response = %s\nwant %s", got, want)
+    }
+    if strings.Join(added, ", ") != "Emma, Persuasion" {
+        t.Errorf("added %v, want Emma and then Persuasion", added)
+    }
+
+    response = (&Schema{Query: newTestSchema(&added).Query}).Execute(context.Background(), Request{Query: `mutation { addBook(title: "Emma") { id } }`})
+    if got := marshal(t, response); !strings.Contains(got, "the schema has no mutations") {
+        t.Errorf("mutation on a schema without them = %s", got)
+    }
+}
+
+func TestServeHTTP(t *testing.T) {
+    const mutation = `mutation { addBook(title: "Emma") { title } }`
+
+    tests := []struct {
+        name   string
+        method string
+        query  url.Values
+        body   string
+        status int
+        want   string
+        added  int
+    }{
+        {
+            name:   "query with GET",
+            method: "GET",
+            query:  url.Values{"query": {`query ($id: ID!) { book(id: $id) { title } }`}, "variables": {`{"id":"1"}`}},
+            status: 200,
+            want:   `{"data":{"book":{"title":"The Hobbit"}}}`,
+        },
+        {
+            name:   "query with POST",
+            method: "POST",
+            body:   `{"query":"query B($first: Int) { books(first: $first) { id } }","operationName":"B","variables":{"first":1}}`,
+            status: 200,
+            want:   `{"data":{"books":[{"id":"1"}]}}`,
+        },
+        {
+            name:   "mutation with POST",
+            method: "POST",
+            body:   marshalRequest(Request{Query: mutation}),
+            status: 200,
+            want:   `{"data":{"addBook":{"title":"Emma"}}}`,
+            added:  1,
+        },
+        {
+            // A link or image must not be able to change anything.
+            name:   "mutation with GET",
+            method: "GET",
+            query:  url.Values{"query": {mutation}},
+            status: 200,
+            want:   `{"errors":[{"message":"mutations must be sent with POST","locations":[{"line":1,"column":1}]}]}`,
+        },
+        {
+            name:   "errors in the query",
+            method: "POST",
+            body:   `{"query":"{ book(id: 1) { isbn } }"}`,
+            status: 200,
+            want:   `{"errors":[{"message":"Book has no field isbn","locations":[{"line":1,"column":17}]}]}`,
+        },
+        {
+            name:   "variables that are not an object",
+            method: "GET",
+            query:  url.Values{"query": {`{ echo(text: "a") }`}, "variables": {`[1]`}},
+            status: 400,
+            want:   `{"errors":[{"message":"variables is not a JSON object"}]}`,
+        },
+        {
+            name:   "body that is not a request",
+            method: "POST",
+            body:   `{"query":`,
+            status: 400,
+            want:   `{"errors":[{"message":"the body is not a GraphQL request: unexpected EOF"}]}`,
+        },
+        {
+            name:   "no query",
+            method: "POST",
+            body:   `{}`,
+            status: 400,
+            want:   `{"errors":[{"message":"query is required"}]}`,
+        },
+        {
+            name:   "other methods",
+            method: "PUT",
+            body:   marshalRequest(Request{Query: mutation}),
+            status: 405,
+            want:   `{"errors":[{"message":"use GET or POST"}]}`,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var added []string
+            req := httptest.NewRequest(tt.method, "/graphql?"+tt.query.Encode(), strings.NewReader(tt.body))
+            rec := httptest.NewRecorder()
+            newTestSchema(&added).ServeHTTP(rec, req)
+
+            if rec.Code != tt.status {
+                t.Errorf("status = %d, want %d", rec.Code, tt.status)
+            }
+            if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+                t.Errorf("body = %s\nwant %s", got, tt.want)
+            }
+            if len(added) != tt.added {
+                t.Errorf("added %v, want %d books", added, tt.added)
+            }
+            if rec.Code == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET, POST" {
+                t.Errorf("Allow = %q", rec.Header().Get("Allow"))
+            }
+        })
+    }
+}
+
+func marshalRequest(req Request) string {
+    data, _ := json.Marshal(req)
+    return string(data)
+}
@@ -0,0 +1,57 @@
+package graphql
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+)
+
+const maxRequestSize = 1 << 20
+
+// ServeHTTP answers GraphQL over HTTP: POST with a JSON Request body, or
+// GET with query, operationName and variables parameters for queries
+// only. Errors from the query come back in the response with status 200;
+// only requests that are not GraphQL at all get a 400.
+func (s *Schema) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var req Request
+    switch r.Method {
+    case http.MethodGet:
+        query := r.URL.Query()
+        req.Query = query.Get("query")
+        req.OperationName = query.Get("operationName")
+        if variables := query.Get("variables"); variables != "" {
+            decoder := json.NewDecoder(strings.NewReader(variables))
+            decoder.UseNumber()
+            if err := decoder.Decode(&req.Variables); err != nil {
+                writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "variables is not a JSON object"}}})
+                return
+            }
+        }
+
+    case http.MethodPost:
+        decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+        decoder.UseNumber()
+        if err := decoder.Decode(&req); err != nil {
+            writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "the body is not a GraphQL request: " + err.Error()}}})
+            return
+        }
+
+    default:
+        w.Header().Set("Allow", "GET, POST")
+        writeResponse(w, http.StatusMethodNotAllowed, &Response{Errors: []*Error{{Message: "use GET or POST"}}})
+        return
+    }
+
+    if req.Query == "" {
+        writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "query is required"}}})
+        return
+    }
+
+    writeResponse(w, http.StatusOK, s.execute(r.Context(), req, r.Method == http.MethodPost))
+}
+
+func writeResponse(w http.ResponseWriter, status int, response *Response) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,64 @@
+package graphql
+
+import (
+    "sync"
+)
+
+// Loader batches loads by key. Each Load returns a Thunk; the first thunk
+// to be called fetches every key asked for so far in one go, and the rest
+// read from that. Results are cached, so a Loader should live for one
+// request only.
+type Loader[K comparable, V any] struct {
+    fetch   func(keys []K) (map[K]V, error)
+    mu      sync.Mutex
+    pending []K
+    cache   map[K]V
+    errors  map[K]error
+}
+
+// NewLoader returns a Loader that fetches with fetch. Keys missing from
+// the map fetch returns load as the zero value.
+func NewLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *Loader[K, V] {
+    return &Loader[K, V]{fetch: fetch, cache: make(map[K]V), errors: make(map[K]error)}
+}
+
+// Load queues key for the next batch.
+func (l *Loader[K, V]) Load(key K) Thunk {
+    l.mu.Lock()
+    if _, ok := l.cache[key]; !ok && l.errors[key] == nil && !containsKey(l.pending, key) {
+        l.pending = append(l.pending, key)
+    }
+    l.mu.Unlock()
+
+    return func() (any, error) {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+
+        if containsKey(l.pending, key) {
+            keys := l.pending
+            l.pending = nil
+            values, err := l.fetch(keys)
+            for _, k := range keys {
+                if err != nil {
+                    l.errors[k] = err
+                } else {
+                    l.cache[k] = values[k]
+                }
+            }
+        }
+
+        if err := l.errors[key]; err != nil {
+            return nil, err
+        }
+        return l.cache[key], nil
+    }
+}
+
+func containsKey[K comparable](keys []K, key K) bool {
+    for _, k := range keys {
+        if k == key {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,644 @@
+package graphql
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// Document is a parsed GraphQL request document.
+type Document struct {
+    Operations []*Operation
+    Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+    Type         string // query or mutation
+    Name         string
+    Variables    []*VariableDefinition
+    SelectionSet []Selection
+    Location     Location
+}
+
+type VariableDefinition struct {
+    Name    string
+    Type    *TypeRef
+    Default Value
+}
+
+// TypeRef is a type as written in a variable definition, such as [ID!]!.
+type TypeRef struct {
+    Name    string
+    Elem    *TypeRef
+    NonNull bool
+}
+
+func (t *TypeRef) String() string {
+    s := t.Name
+    if t.Elem != nil {
+        s = "[" + t.Elem.String() + "]"
+    }
+    if t.NonNull {
+        s += "!"
+    }
+    return s
+}
+
+type Fragment struct {
+    Name          string
+    TypeCondition string
+    SelectionSet  []Selection
+    Location      Location
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment.
+type Selection interface {
+    selection()
+}
+
+type Field struct {
+    Alias        string
+    Name         string
+    Arguments    []*Argument
+    Directives   []*Directive
+    SelectionSet []Selection
+    Location     Location
+}
+
+// ResponseKey is the name of the field in the response.
+func (f *Field) ResponseKey() string {
+    if f.Alias != "" {
+        return f.Alias
+    }
+    return f.Name
+}
+
+type FragmentSpread struct {
+    Name       string
+    Directives []*Directive
+    Location   Location
+}
+
+type InlineFragment struct {
+    TypeCondition string
+    Directives    []*Directive
+    SelectionSet  []Selection
+    Location      Location
+}
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+type Argument struct {
+    Name  string
+    Value Value
+}
+
+type Directive struct {
+    Name      string
+    Arguments []*Argument
+    Location  Location
+}
+
+// Value is a literal in a document. Variables are *Variable, enum values
+// are EnumValue, lists []Value and objects map[string]Value; the rest are
+// the Go string, int64, float64 and bool, and nil for null.
+type Value any
+
+type Variable struct {
+    Name string
+}
+
+type EnumValue string
+
+type Location struct {
+    Line   int `json:"line"`
+    Column int `json:"column"`
+}
+
+// SyntaxError is a document that cannot be parsed.
+type SyntaxError struct {
+    Message  string
+    Location Location
+}
+
+func (e *SyntaxError) Error() string {
+    return fmt.Sprintf("syntax error at %d:%d: %s", e.Location.Line, e.Location.Column, e.Message)
+}
+
+// Parse parses a GraphQL request document: operations and fragments, but
+// not type system definitions.
+func Parse(source string) (doc *Document, err error) {
+    p := &parser{lexer: lexer{source: source, line: 1, lineStart: 0}}
+    defer func() {
+        if r := recover(); r != nil {
+            syntaxErr, ok := r.(*SyntaxError)
+            if !ok {
+                panic(r)
+            }
+            doc, err = nil, syntaxErr
+        }
+    }()
+
+    p.next()
+    doc = &Document{Fragments: make(map[string]*Fragment)}
+    for p.token.kind != tokenEOF {
+        switch {
+        case p.token.kind == tokenPunct && p.token.value == "{":
+            doc.Operations = append(doc.Operations, &Operation{Type: "query", Location: p.token.location, SelectionSet: p.selectionSet()})
+        case p.token.kind == tokenName && (p.token.value == "query" || p.token.value == "mutation"):
+            doc.Operations = append(doc.Operations, p.operation())
+        case p.token.kind == tokenName && p.token.value == "fragment":
+            fragment := p.fragment()
+            if _, ok := doc.Fragments[fragment.Name]; ok {
+                p.fail(fragment.Location, "fragment "+fragment.Name+" is defined twice")
+            }
+            doc.Fragments[fragment.Name] = fragment
+        default:
+            p.unexpected()
+        }
+    }
+    if len(doc.Operations) == 0 {
+        p.fail(p.token.location, "the document has no operation")
+    }
+
+    return doc, nil
+}
+
+type parser struct {
+    lexer lexer
+    token token
+}
+
+func (p *parser) next() {
+    p.token = p.lexer.next()
+}
+
+func (p *parser) fail(location Location, message string) {
+    panic(&SyntaxError{Message: message, Location: location})
+}
+
+func (p *parser) unexpected() {
+    if p.token.kind == tokenEOF {
+        p.fail(p.token.location, "unexpected end of document")
+    }
+    p.fail(p.token.location, "unexpected "+strconv.Quote(p.token.value))
+}
+
+func (p *parser) peek(punct string) bool {
+    return p.token.kind == tokenPunct && p.token.value == punct
+}
+
+func (p *parser) skip(punct string) bool {
+    if p.peek(punct) {
+        p.next()
+        return true
+    }
+    return false
+}
+
+func (p *parser) expect(punct string) {
+    if !p.skip(punct) {
+        p.unexpected()
+    }
+}
+
+func (p *parser) name() string {
+    if p.token.kind != tokenName {
+        p.unexpected()
+    }
+    name := p.token.value
+    p.next()
+    return name
+}
+
+func (p *parser) keyword(keyword string) {
+    if p.token.kind != tokenName || p.token.value != keyword {
+        p.unexpected()
+    }
+    p.next()
+}
+
+func (p *parser) operation() *Operation {
+    operation := &Operation{Type: p.token.value, Location: p.token.location}
+    p.next()
+
+    if p.token.kind == tokenName {
+        operation.Name = p.name()
+    }
+    if p.skip("(") {
+        for !p.skip(")") {
+            operation.Variables = append(operation.Variables, p.variableDefinition())
+        }
+    }
+    p.directives()
+    operation.SelectionSet = p.selectionSet()
+
+    return operation
+}
+
+func (p *parser) variableDefinition() *VariableDefinition {
+    p.expect("$")
+    definition := &VariableDefinition{Name: p.name()}
+    p.expect(":")
+    definition.Type = p.typeRef()
+    if p.skip("=") {
+        definition.Default = p.value(true)
+    }
+    p.directives()
+    return definition
+}
+
+func (p *parser) typeRef() *TypeRef {
+    var t *TypeRef
+    if p.skip("[") {
+        t = &TypeRef{Elem: p.typeRef()}
+        p.expect("]")
+    } else {
+        t = &TypeRef{Name: p.name()}
+    }
+    t.NonNull = p.skip("!")
+    return t
+}
+
+func (p *parser) fragment() *Fragment {
+    fragment := &Fragment{Location: p.token.location}
+    p.next()
+
+    fragment.Name = p.name()
+    if fragment.Name == "on" {
+        p.fail(fragment.Location, "a fragment cannot be named on")
+    }
+    p.keyword("on")
+    fragment.TypeCondition = p.name()
+    p.directives()
+    fragment.SelectionSet = p.selectionSet()
+
+    return fragment
+}
+
+func (p *parser) selectionSet() []Selection {
+    location := p.token.location
+    p.expect("{")
+    var selections []Selection
+    for !p.skip("}") {
+        selections = append(selections, p.selection())
+    }
+    if len(selections) == 0 {
+        p.fail(location, "empty selection set")
+    }
+    return selections
+}
+
+func (p *parser) selection() Selection {
+    location := p.token.location
+    if p.skip("...") {
+        if p.token.kind == tokenName && p.token.value != "on" {
+            return &FragmentSpread{Name: p.name(), Directives: p.directives(), Location: location}
+        }
+
+        fragment := &InlineFragment{Location: location}
+        if p.token.kind == tokenName {
+            p.keyword("on")
+            fragment.TypeCondition = p.name()
+        }
+        fragment.Directives = p.directives()
+        fragment.SelectionSet = p.selectionSet()
+        return fragment
+    }
+
+    field := &Field{Location: location, Name: p.name()}
+    if p.skip(":") {
+        field.Alias = field.Name
+        field.Name = p.name()
+    }
+    field.Arguments = p.arguments(false)
+    field.Directives = p.directives()
+    if p.peek("{") {
+        field.SelectionSet = p.selectionSet()
+    }
+    return field
+}
+
+func (p *parser) arguments(constant bool) []*Argument {
+    if !p.skip("(") {
+        return nil
+    }
+
+    var arguments []*Argument
+    for !p.skip(")") {
+        argument := &Argument{Name: p.name()}
+        p.expect(":")
+        argument.Value = p.value(constant)
+        arguments = append(arguments, argument)
+    }
+    return arguments
+}
+
+func (p *parser) directives() []*Directive {
+    var directives []*Directive
+    for p.peek("@") {
+        location := p.token.location
+        p.next()
+        directives = append(directives, &Directive{Name: p.name(), Arguments: p.arguments(false), Location: location})
+    }
+    return directives
+}
+
+// value parses a literal; constant ones, such as variable defaults, may not
+// refer to variables.
+func (p *parser) value(constant bool) Value {
+    token := p.token
+    switch token.kind {
+    case tokenInt:
+        p.next()
+        n, err := strconv.ParseInt(token.value, 10, 64)
+        if err != nil {
+            p.fail(token.location, "invalid integer "+token.value)
+        }
+        return n
+    case tokenFloat:
+        p.next()
+        f, err := strconv.ParseFloat(token.value, 64)
+        if err != nil {
+            p.fail(token.location, "invalid number "+token.value)
+        }
+        return f
+    case tokenString:
+        p.next()
+        return token.value
+    case tokenName:
+        p.next()
+        switch token.value {
+        case "true":
+            return true
+        case "false":
+            return false
+        case "null":
+            return nil
+        }
+        return EnumValue(token.value)
+    }
+
+    switch {
+    case p.skip("$"):
+        if constant {
+            p.fail(token.location, "variables are not allowed here")
+        }
+        return &Variable{Name: p.name()}
+    case p.skip("["):
+        list := []Value{}
+        for !p.skip("]") {
+            list = append(list, p.value(constant))
+        }
+        return list
+    case p.skip("{"):
+        object := map[string]Value{}
+        for !p.skip("}") {
+            name := p.name()
+            p.expect(":")
+            object[name] = p.value(constant)
+        }
+        return object
+    }
+
+    p.unexpected()
+    return nil
+}
+
+type tokenKind int
+
+const (
+    tokenEOF tokenKind = iota
+    tokenPunct
+    tokenName
+    tokenInt
+    tokenFloat
+    tokenString
+)
+
+type token struct {
+    kind     tokenKind
+    value    string
+    location Location
+}
+
+type lexer struct {
+    source    string
+    pos       int
+    line      int
+    lineStart int
+}
+
+func (l *lexer) fail(message string) {
+    panic(&SyntaxError{Message: message, Location: l.location()})
+}
+
+func (l *lexer) location() Location {
+    return Location{Line: l.line, Column: l.pos - l.lineStart + 1}
+}
+
+func (l *lexer) next() token {
+    l.skipIgnored()
+    location := l.location()
+    if l.pos >= len(l.source) {
+        return token{kind: tokenEOF, location: location}
+    }
+
+    c := l.source[l.pos]
+    switch {
+    case strings.HasPrefix(l.source[l.pos:], "..."):
+        l.pos += 3
+        return token{kind: tokenPunct, value: "...", location: location}
+    case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+        l.pos++
+        return token{kind: tokenPunct, value: string(c), location: location}
+    case c == '_' || isLetter(c):
+        start := l.pos
+        for l.pos < len(l.source) && (l.source[l.pos] == '_' || isLetter(l.source[l.pos]) || isDigit(l.source[l.pos])) {
+            l.pos++
+        }
+        return token{kind: tokenName, value: l.source[start:l.pos], location: location}
+    case c == '-' || isDigit(c):
+        return l.number(location)
+    case c == '"':
+        if strings.HasPrefix(l.source[l.pos:], `"""`) {
+            return token{kind: tokenString, value: l.blockString(), location: location}
+        }
+        return token{kind: tokenString, value: l.string(), location: location}
+    }
+
+    l.fail("unexpected character " + strconv.QuoteRune(rune(c)))
+    return token{}
+}
+
+// skipIgnored skips white space, line terminators, commas and comments.
+func (l *lexer) skipIgnored() {
+    for l.pos < len(l.source) {
+        switch c := l.source[l.pos]; c {
+        case ' ', '\t', ',', '\r':
+            l.pos++
+        case '\n':
+            l.pos++
+            l.line++
+            l.lineStart = l.pos
+        case '#':
+            for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+                l.pos++
+            }
+        default:
+            if strings.HasPrefix(l.source[l.pos:], "\ufeff") {
+                l.pos += len("\ufeff")
+                continue
+            }
+            return
+        }
+    }
+}
+
+func (l *lexer) number(location Location) token {
+    start := l.pos
+    if l.source[l.pos] == '-' {
+        l.pos++
+    }
+    digits := func() {
+        begin := l.pos
+        for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+            l.pos++
+        }
+        if l.pos == begin {
+            l.fail("expected a digit")
+        }
+    }
+
+    digits()
+    kind := tokenInt
+    if l.pos < len(l.source) && l.source[l.pos] == '.' {
+        kind = tokenFloat
+        l.pos++
+        digits()
+    }
+    if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+        kind = tokenFloat
+        l.pos++
+        if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+            l.pos++
+        }
+        digits()
+    }
+
+    return token{kind: kind, value: l.source[start:l.pos], location: location}
+}
+
+func (l *lexer) string() string {
+    l.pos++
+    var b strings.Builder
+    for {
+        if l.pos >= len(l.source) || l.source[l.pos] == '\n' {
+            l.fail("unterminated string")
+        }
+
+        c := l.source[l.pos]
+        switch {
+        case c == '"':
+            l.pos++
+            return b.String()
+        case c == '\\':
+            if l.pos+1 >= len(l.source) {
+                l.fail("unterminated string")
+            }
+            escape := l.source[l.pos+1]
+            l.pos += 2
+            switch escape {
+            case '"', '\\', '/':
+                b.WriteByte(escape)
+            case 'b':
+                b.WriteByte('\b')
+            case 'f':
+                b.WriteByte('\f')
+            case 'n':
+                b.WriteByte('\n')
+            case 'r':
+                b.WriteByte('\r')
+            case 't':
+                b.WriteByte('\t')
+            case 'u':
+                if l.pos+4 > len(l.source) {
+                    l.fail("invalid unicode escape")
+                }
+                code, err := strconv.ParseUint(l.source[l.pos:l.pos+4], 16, 32)
+                if err != nil {
+                    l.fail("invalid unicode escape")
+                }
+                b.WriteRune(rune(code))
+                l.pos += 4
+            default:
+                l.fail("invalid escape \\" + string(escape))
+            }
+        default:
+            r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+            b.WriteRune(r)
+            l.pos += size
+        }
+    }
+}
+
+// blockString reads a """block string""", removing the common indentation
+// of its lines and the blank lines around it.
+func (l *lexer) blockString() string {
+    l.pos += 3
+    end := strings.Index(l.source[l.pos:], `"""`)
+    for end > 0 && l.source[l.pos+end-1] == '\\' {
+        next := strings.Index(l.source[l.pos+end+3:], `"""`)
+        if next == -1 {
+            end = -1
+            break
+        }
+        end += 3 + next
+    }
+    if end == -1 {
+        l.fail("unterminated block string")
+    }
+
+    raw := strings.ReplaceAll(l.source[l.pos:l.pos+end], `\"""`, `"""`)
+    for _, c := range l.source[l.pos : l.pos+end] {
+        if c == '\n' {
+            l.line++
+        }
+    }
+    l.pos += end + 3
+    if i := strings.LastIndexByte(l.source[:l.pos], '\n'); i >= 0 {
+        l.lineStart = i + 1
+    }
+
+    lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+    indent := -1
+    for _, line := range lines[1:] {
+        trimmed := strings.TrimLeft(line, " \t")
+        if trimmed != "" && (indent == -1 || len(line)-len(trimmed) < indent) {
+            indent = len(line) - len(trimmed)
+        }
+    }
+    for i := 1; i < len(lines) && indent > 0; i++ {
+        if len(lines[i]) >= indent {
+            lines[i] = lines[i][indent:]
+        }
+    }
+    for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+        lines = lines[1:]
+    }
+    for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+        lines = lines[:len(lines)-1]
+    }
+    return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+    return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
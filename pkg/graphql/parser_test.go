@@ -0,0 +1,184 @@
+package graphql
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        name   string
+        source string
+        check  func(t *testing.T, doc *Document)
+    }{
+        {
+            name:   "shorthand query",
+            source: `{ book(id: "1") { title } }`,
+            check: func(t *testing.T, doc *Document) {
+                operation := doc.Operations[0]
+                if operation.Type != "query" || operation.Name != "" {
+                    t.Errorf("operation = %s %q, want an anonymous query", operation.Type, operation.Name)
+                }
+                field := operation.SelectionSet[0].(*Field)
+                if field.Name != "book" || field.Arguments[0].Value != "1" || field.SelectionSet[0].(*Field).Name != "title" {
+                    t.Errorf("field = %+v", field)
+                }
+            },
+        },
+        {
+            name:   "variables and defaults",
+            source: `query Books($genre: Genre = FANTASY, $ids: [ID!]!, $first: Int) { books(genre: $genre, first: $first) { id } }`,
+            check: func(t *testing.T, doc *Document) {
+                operation := doc.Operations[0]
+                var types []string
+                for _, definition := range operation.Variables {
+                    types = append(types, definition.Name+": "+definition.Type.String())
+                }
+                if want := []string{"genre: Genre", "ids: [ID!]!", "first: Int"}; !reflect.DeepEqual(types, want) {
+                    t.Errorf("variables = %v, want %v", types, want)
+                }
+                if operation.Variables[0].Default != EnumValue("FANTASY") {
+                    t.Errorf("default = %#v, want the enum value FANTASY", operation.Variables[0].Default)
+                }
+                if variable := operation.SelectionSet[0].(*Field).Arguments[0].Value; !reflect.DeepEqual(variable, &Variable{Name: "genre"}) {
+                    t.Errorf("argument = %#v, want $genre", variable)
+                }
+            },
+        },
+        {
+            name:   "aliases and directives",
+            source: `{ first: book(id: 1) @include(if: $all) { title } second: book(id: 2) @skip(if: true) { title } }`,
+            check: func(t *testing.T, doc *Document) {
+                first := doc.Operations[0].SelectionSet[0].(*Field)
+                second := doc.Operations[0].SelectionSet[1].(*Field)
+                if first.ResponseKey() != "first" || first.Name != "book" || second.ResponseKey() != "second" {
+                    t.Errorf("fields = %+v, %+v", first, second)
+                }
+                if first.Directives[0].Name != "include" || second.Directives[0].Name != "skip" || second.Directives[0].Arguments[0].Value != true {
+                    t.Errorf("directives = %+v, %+v", first.Directives[0], second.Directives[0])
+                }
+            },
+        },
+        {
+            name: "fragments",
+            source: `
+                query { book(id: 1) { ...BookFields ... on Book { genre } ... @include(if: true) { id } } }
+                fragment BookFields on Book { title }`,
+            check: func(t *testing.T, doc *Document) {
+                selections := doc.Operations[0].SelectionSet[0].(*Field).SelectionSet
+                if spread := selections[0].(*FragmentSpread); spread.Name != "BookFields" {
+                    t.Errorf("spread = %+v", spread)
+                }
+                if inline := selections[1].(*InlineFragment); inline.TypeCondition != "Book" {
+                    t.Errorf("inline fragment = %+v", inline)
+                }
+                if inline := selections[2].(*InlineFragment); inline.TypeCondition != "" || len(inline.Directives) != 1 {
+                    t.Errorf("inline fragment = %+v", inline)
+                }
+                if fragment := doc.Fragments["BookFields"]; fragment == nil || fragment.TypeCondition != "Book" {
+                    t.Errorf("fragment = %+v", fragment)
+                }
+            },
+        },
+        {
+            name:   "literals",
+            source: `{ f(a: -12, b: 1.5e3, c: "tab\there é", d: [1 [true null]], e: {x: RED}) }`,
+            check: func(t *testing.T, doc *Document) {
+                values := map[string]Value{}
+                for _, argument := range doc.Operations[0].SelectionSet[0].(*Field).Arguments {
+                    values[argument.Name] = argument.Value
+                }
+                want := map[string]Value{
+                    "a": int64(-12),
+                    "b": 1500.0,
+                    "c": "tab\there é",
+                    "d": []Value{int64(1), []Value{true, nil}},
+                    "e": map[string]Value{"x": EnumValue("RED")},
+                }
+                if !reflect.DeepEqual(values, want) {
+                    t.Errorf("values = %#v, want %#v", values, want)
+                }
+            },
+        },
+        {
+            name: "block string",
+            source: `{ f(text: """
+                    Dear reader,
+                      \"""indented\"""
+                """) }`,
+            check: func(t *testing.T, doc *Document) {
+                text := doc.Operations[0].SelectionSet[0].(*Field).Arguments[0].Value
+                if want := "Dear reader,\n  \"\"\"indented\"\"\""; text != want {
+                    t.Errorf("text = %q, want %q", text, want)
+                }
+            },
+        },
+        {
+            name:   "comments and commas",
+            source: "\ufeff# the books\n{ a, b # and more\n, c }",
+            check: func(t *testing.T, doc *Document) {
+                if n := len(doc.Operations[0].SelectionSet); n != 3 {
+                    t.Errorf("%d selections, want 3", n)
+                }
+            },
+        },
+        {
+            name:   "locations",
+            source: "query {\n  book(id: 1) {\n    title\n  }\n}",
+            check: func(t *testing.T, doc *Document) {
+                book := doc.Operations[0].SelectionSet[0].(*Field)
+                title := book.SelectionSet[0].(*Field)
+                if book.Location != (Location{2, 3}) || title.Location != (Location{3, 5}) {
+                    t.Errorf("locations = %v, %v, want 2:3 and 3:5", book.Location, title.Location)
+                }
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            doc, err := Parse(tt.source)
+            if err != nil {
+                t.Fatal(err)
+            }
+            tt.check(t, doc)
+        })
+    }
+}
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        source   string
+        message  string
+        location Location
+    }{
+        {"", "the document has no operation", Location{1, 1}},
+        {"fragment F on Book { id }", "the document has no operation", Location{1, 26}},
+        {"{ book { title }", "unexpected end of document", Location{1, 17}},
+        {"{ book { } }", "empty selection set", Location{1, 8}},
+        {"{ book(id: ) }", `unexpected ")"`, Location{1, 12}},
+        {"subscription { book }", `unexpected "subscription"`, Location{1, 1}},
+        {"{ a }\nfragment F on A { a }\nfragment F on A { b }", "fragment F is defined twice", Location{3, 1}},
+        {"fragment on on A { a }", "a fragment cannot be named on", Location{1, 1}},
+        {"query ($id: ID = $other) { a }", "variables are not allowed here", Location{1, 18}},
+        {`{ f(text: "no end) }`, "unterminated string", Location{1, 21}},
+        {`{ f(text: "\q") }`, `invalid escape \q`, Location{1, 14}},
+        {`{ f(n: 1.) }`, "expected a digit", Location{1, 10}},
+        {"{ f(n: 99999999999999999999) }", "invalid integer 99999999999999999999", Location{1, 8}},
+        {"{ a %}", `unexpected character '%'`, Location{1, 5}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.source, func(t *testing.T) {
+            doc, err := Parse(tt.source)
+            var syntaxErr *SyntaxError
+            if !errors.As(err, &syntaxErr) {
+                t.Fatalf("Parse = %v, %v, want a syntax error", doc, err)
+            }
+            if syntaxErr.Message != tt.message || syntaxErr.Location != tt.location {
+                t.Errorf("error = %q at %v, want %q at %v", syntaxErr.Message, syntaxErr.Location, tt.message, tt.location)
+            }
+        })
+    }
+}
@@ -0,0 +1,380 @@
+// Package graphql is a small GraphQL server: a parser for request
+// documents, a schema built from Go values, and an executor. It supports
+// queries and mutations with variables, fragments, aliases and the
+// @include and @skip directives, but not subscriptions, interfaces, unions
+// or introspection beyond __typename; the schema is published as SDL
+// instead.
+//
+// Fields are resolved breadth first: every field at one depth of the
+// response is resolved before any field below it. Resolvers may return a
+// Thunk, such as one from a Loader, to defer loading until its siblings
+// have asked for theirs, so that lists load in one batch per level.
+package graphql
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Type is a *Scalar, *Enum, *Object, *List or *NonNull.
+type Type interface {
+    String() string
+}
+
+type Scalar struct {
+    Name        string
+    Description string
+    // Serialize turns a resolved value into its JSON form.
+    Serialize func(value any) (any, error)
+    // Parse turns a literal or variable value into the Go value resolvers
+    // receive as an argument.
+    Parse func(value any) (any, error)
+}
+
+type Enum struct {
+    Name        string
+    Description string
+    Values      []string
+}
+
+type Object struct {
+    Name        string
+    Description string
+    Fields      []*FieldDef
+}
+
+type List struct {
+    Of Type
+}
+
+type NonNull struct {
+    Of Type
+}
+
+func (t *Scalar) String() string  { return t.Name }
+func (t *Enum) String() string    { return t.Name }
+func (t *Object) String() string  { return t.Name }
+func (t *List) String() string    { return "[" + t.Of.String() + "]" }
+func (t *NonNull) String() string { return t.Of.String() + "!" }
+
+// FieldDef is a field of an object type. Without Resolve the field reads
+// the struct field or map key of the same name, ignoring case, from its
+// source.
+type FieldDef struct {
+    Name        string
+    Description string
+    Type        Type
+    Args        []*ArgDef
+    Resolve     ResolveFunc
+}
+
+type ArgDef struct {
+    Name        string
+    Description string
+    Type        Type
+    Default     any
+}
+
+// ResolveFunc returns the value of a field of Source. It may return a
+// Thunk to be called once the other fields at the same depth have been
+// resolved.
+type ResolveFunc func(p ResolveParams) (any, error)
+
+type ResolveParams struct {
+    Context context.Context
+    Source  any
+    Args    map[string]any
+}
+
+// Thunk is a value that is not loaded yet.
+type Thunk func() (any, error)
+
+// Field looks up a field of the object by name.
+func (o *Object) Field(name string) *FieldDef {
+    for _, field := range o.Fields {
+        if field.Name == name {
+            return field
+        }
+    }
+    return nil
+}
+
+// Schema is the entry point of a GraphQL API: the root query type and,
+// optionally, the root mutation type.
+type Schema struct {
+    Query    *Object
+    Mutation *Object
+    // MaxDepth limits how deeply selections may nest; 0 means 15.
+    MaxDepth int
+}
+
+// Built-in scalars.
+var (
+    Int = &Scalar{
+        Name: "Int",
+        Serialize: func(value any) (any, error) {
+            return toInt(value)
+        },
+        Parse: func(value any) (any, error) {
+            return toInt(value)
+        },
+    }
+    Float = &Scalar{
+        Name: "Float",
+        Serialize: func(value any) (any, error) {
+            return toFloat(value)
+        },
+        Parse: func(value any) (any, error) {
+            return toFloat(value)
+        },
+    }
+    String = &Scalar{
+        Name: "String",
+        Serialize: func(value any) (any, error) {
+            return toString(value)
+        },
+        Parse: func(value any) (any, error) {
+            if s, ok := value.(string); ok {
+                return s, nil
+            }
+            return nil, fmt.Errorf("expected a string, got %v", value)
+        },
+    }
+    Boolean = &Scalar{
+        Name: "Boolean",
+        Serialize: func(value any) (any, error) {
+            if b, ok := value.(bool); ok {
+                return b, nil
+            }
+            return nil, fmt.Errorf("%v is not a boolean", value)
+        },
+        Parse: func(value any) (any, error) {
+            if b, ok := value.(bool); ok {
+                return b, nil
+            }
+            return nil, fmt.Errorf("expected a boolean, got %v", value)
+        },
+    }
+    // ID serializes anything with a String method, such as a uuid.UUID,
+    // as its string.
+    ID = &Scalar{
+        Name: "ID",
+        Serialize: func(value any) (any, error) {
+            return toString(value)
+        },
+        Parse: func(value any) (any, error) {
+            switch v := value.(type) {
+            case string:
+                return v, nil
+            case int64, json.Number:
+                return fmt.Sprint(v), nil
+            }
+            return nil, fmt.Errorf("expected an ID, got %v", value)
+        },
+    }
+    // Time is a time.Time as an RFC 3339 string.
+    Time = &Scalar{
+        Name:        "Time",
+        Description: "A date and time as an RFC 3339 string.",
+        Serialize: func(value any) (any, error) {
+            if t, ok := value.(time.Time); ok {
+                return t.Format(time.RFC3339), nil
+            }
+            return nil, fmt.Errorf("%v is not a time", value)
+        },
+        Parse: func(value any) (any, error) {
+            if s, ok := value.(string); ok {
+                return time.Parse(time.RFC3339, s)
+            }
+            return nil, fmt.Errorf("expected a time, got %v", value)
+        },
+    }
+)
+
+func toInt(value any) (any, error) {
+    switch v := value.(type) {
+    case int:
+        return v, nil
+    case int64:
+        if v >= math.MinInt32 && v <= math.MaxInt32 {
+            return int(v), nil
+        }
+    case float64:
+        if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+            return int(v), nil
+        }
+    case json.Number:
+        if n, err := v.Int64(); err == nil {
+            return toInt(n)
+        }
+    }
+    return nil, fmt.Errorf("%v is not a 32-bit integer", value)
+}
+
+func toFloat(value any) (any, error) {
+    switch v := value.(type) {
+    case float64:
+        return v, nil
+    case float32:
+        return float64(v), nil
+    case int:
+        return float64(v), nil
+    case int64:
+        return float64(v), nil
+    case json.Number:
+        return v.Float64()
+    }
+    return nil, fmt.Errorf("%v is not a number", value)
+}
+
+func toString(value any) (any, error) {
+    switch v := value.(type) {
+    case string:
+        return v, nil
+    case fmt.Stringer:
+        return v.String(), nil
+    }
+    if rv := reflect.ValueOf(value); rv.Kind() == reflect.String {
+        return rv.String(), nil
+    }
+    return nil, fmt.Errorf("%v is not a string", value)
+}
+
+// defaultResolve reads the struct field or map key named like the GraphQL
+// field, ignoring case, so that orderId finds OrderID.
+func defaultResolve(source any, name string) (any, error) {
+    v := reflect.ValueOf(source)
+    for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+        if v.IsNil() {
+            return nil, nil
+        }
+        v = v.Elem()
+    }
+
+    switch v.Kind() {
+    case reflect.Struct:
+        field := v.FieldByNameFunc(func(fieldName string) bool {
+            return strings.EqualFold(fieldName, name)
+        })
+        if field.IsValid() {
+            return field.Interface(), nil
+        }
+    case reflect.Map:
+        if value := v.MapIndex(reflect.ValueOf(name)); value.IsValid() {
+            return value.Interface(), nil
+        }
+        return nil, nil
+    }
+
+    return nil, fmt.Errorf("%s has no field %s", v.Type(), name)
+}
+
+// SDL returns the schema in the GraphQL schema definition language, for
+// clients to generate code and check their queries against.
+func (s *Schema) SDL() string {
+    types := make(map[string]Type)
+    var collect func(t Type)
+    collect = func(t Type) {
+        switch t := t.(type) {
+        case *List:
+            collect(t.Of)
+        case *NonNull:
+            collect(t.Of)
+        case *Object:
+            if _, ok := types[t.Name]; ok {
+                return
+            }
+            types[t.Name] = t
+            for _, field := range t.Fields {
+                collect(field.Type)
+                for _, arg := range field.Args {
+                    collect(arg.Type)
+                }
+            }
+        case *Enum:
+            types[t.Name] = t
+        case *Scalar:
+            switch t.Name {
+            case "Int", "Float", "String", "Boolean", "ID":
+            default:
+                types[t.Name] = t
+            }
+        }
+    }
+    collect(s.Query)
+    if s.Mutation != nil {
+        collect(s.Mutation)
+    }
+
+    var b strings.Builder
+    b.WriteString("schema {\n  query: " + s.Query.Name + "\n")
+    if s.Mutation != nil {
+        b.WriteString("  mutation: " + s.Mutation.Name + "\n")
+    }
+    b.WriteString("}\n")
+
+    names := make([]string, 0, len(types))
+    for name := range types {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        b.WriteString("\n")
+        switch t := types[name].(type) {
+        case *Scalar:
+            writeDescription(&b, "", t.Description)
+            b.WriteString("scalar " + t.Name + "\n")
+        case *Enum:
+            writeDescription(&b, "", t.Description)
+            b.WriteString("enum " + t.Name + " {\n")
+            for _, value := range t.Values {
+                b.WriteString("  " + value + "\n")
+            }
+            b.WriteString("}\n")
+        case *Object:
+            writeDescription(&b, "", t.Description)
+            b.WriteString("type " + t.Name + " {\n")
+            for _, field := range t.Fields {
+                writeDescription(&b, "  ", field.Description)
+                b.WriteString("  " + field.Name)
+                if len(field.Args) > 0 {
+                    args := make([]string, len(field.Args))
+                    for i, arg := range field.Args {
+                        args[i] = arg.Name + ": " + arg.Type.String()
+                        if arg.Default != nil {
+                            value, _ := json.Marshal(arg.Default)
+                            args[i] += " = " + string(value)
+                        }
+                    }
+                    b.WriteString("(" + strings.Join(args, ", ") + ")")
+                }
+                b.WriteString(": " + field.Type.String() + "\n")
+            }
+            b.WriteString("}\n")
+        }
+    }
+
+    return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+    if description == "" {
+        return
+    }
+    if !strings.Contains(description, "\n") && !strings.Contains(description, `"`) {
+        b.WriteString(indent + `"` + description + `"` + "\n")
+        return
+    }
+    b.WriteString(indent + `"""` + "\n")
+    for _, line := range strings.Split(description, "\n") {
+        b.WriteString(indent + strings.ReplaceAll(line, `"""`, `\"""`) + "\n")
+    }
+    b.WriteString(indent + `"""` + "\n")
+}
@@ -0,0 +1,188 @@
+package graphql
+
+import (
+    "fmt"
+)
+
+// validate checks the operation against the schema before anything is
+// resolved, so that a bad query fails as a whole rather than field by
+// field.
+func (e *executor) validate() {
+    root := e.schema.Query
+    if e.operation.Type == "mutation" {
+        root = e.schema.Mutation
+        if root == nil {
+            e.fail("the schema has no mutations", &e.operation.Location, nil)
+            return
+        }
+    }
+
+    types := e.schema.inputTypes()
+    defined := make(map[string]*VariableDefinition)
+    for _, definition := range e.operation.Variables {
+        if _, ok := defined[definition.Name]; ok {
+            e.fail("variable $"+definition.Name+" is defined twice", &e.operation.Location, nil)
+        }
+        defined[definition.Name] = definition
+        if _, ok := types[typeRefName(definition.Type)]; !ok {
+            e.fail("variable $"+definition.Name+" has unknown type "+definition.Type.String(), &e.operation.Location, nil)
+        }
+    }
+
+    v := &validator{executor: e, defined: defined, maxDepth: e.schema.MaxDepth}
+    if v.maxDepth == 0 {
+        v.maxDepth = defaultMaxDepth
+    }
+    v.selectionSet(root, e.operation.SelectionSet, 1, nil)
+}
+
+type validator struct {
+    *executor
+    defined  map[string]*VariableDefinition
+    maxDepth int
+}
+
+// selectionSet checks the selections on an object type; spreading names
+// the fragments being expanded, to catch cycles.
+func (v *validator) selectionSet(t *Object, selections []Selection, depth int, spreading []string) {
+    for _, selection := range selections {
+        switch selection := selection.(type) {
+        case *Field:
+            v.directives(selection.Directives)
+            v.field(t, selection, depth, spreading)
+
+        case *FragmentSpread:
+            v.directives(selection.Directives)
+            fragment, ok := v.doc.Fragments[selection.Name]
+            if !ok {
+                v.fail("there is no fragment named "+selection.Name, &selection.Location, nil)
+                continue
+            }
+            if contains(spreading, selection.Name) {
+                v.fail("fragment "+selection.Name+" spreads itself", &selection.Location, nil)
+                continue
+            }
+            if fragment.TypeCondition != t.Name {
+                v.fail(fmt.Sprintf("fragment %s on %s cannot be spread on %s", fragment.Name, fragment.TypeCondition, t.Name), &selection.Location, nil)
+                continue
+            }
+            v.selectionSet(t, fragment.SelectionSet, depth, append(spreading[:len(spreading):len(spreading)], selection.Name))
+
+        case *InlineFragment:
+            v.directives(selection.Directives)
+            if selection.TypeCondition != "" && selection.TypeCondition != t.Name {
+                v.fail(fmt.Sprintf("a fragment on %s cannot be spread on %s", selection.TypeCondition, t.Name), &selection.Location, nil)
+                continue
+            }
+            v.selectionSet(t, selection.SelectionSet, depth, spreading)
+        }
+    }
+}
+
+func (v *validator) field(t *Object, field *Field, depth int, spreading []string) {
+    if depth > v.maxDepth {
+        v.fail(fmt.Sprintf("the query is nested more than %d levels deep", v.maxDepth), &field.Location, nil)
+        return
+    }
+
+    if field.Name == "__typename" {
+        if len(field.Arguments) > 0 || len(field.SelectionSet) > 0 {
+            v.fail("__typename takes no arguments or selections", &field.Location, nil)
+        }
+        return
+    }
+
+    def := t.Field(field.Name)
+    if def == nil {
+        v.fail(t.Name+" has no field "+field.Name, &field.Location, nil)
+        return
+    }
+
+    for _, argument := range field.Arguments {
+        arg := def.arg(argument.Name)
+        if arg == nil {
+            v.fail(fmt.Sprintf("%s.%s has no argument %s", t.Name, def.Name, argument.Name), &field.Location, nil)
+            continue
+        }
+        v.value(arg.Type, argument.Value, &field.Location)
+    }
+    for _, arg := range def.Args {
+        if _, ok := arg.Type.(*NonNull); !ok || arg.Default != nil {
+            continue
+        }
+        found := false
+        for _, argument := range field.Arguments {
+            found = found || argument.Name == arg.Name
+        }
+        if !found {
+            v.fail(fmt.Sprintf("%s.%s needs argument %s", t.Name, def.Name, arg.Name), &field.Location, nil)
+        }
+    }
+
+    object, isObject := namedType(def.Type).(*Object)
+    switch {
+    case isObject && len(field.SelectionSet) == 0:
+        v.fail(fmt.Sprintf("%s.%s of type %s needs a selection of its fields", t.Name, def.Name, object.Name), &field.Location, nil)
+    case !isObject && len(field.SelectionSet) > 0:
+        v.fail(fmt.Sprintf("%s.%s of type %s has no fields to select", t.Name, def.Name, def.Type), &field.Location, nil)
+    case isObject:
+        v.selectionSet(object, field.SelectionSet, depth+1, spreading)
+    }
+}
+
+func (v *validator) directives(directives []*Directive) {
+    for _, directive := range directives {
+        if directive.Name != "skip" && directive.Name != "include" {
+            v.fail("unknown directive @"+directive.Name, &directive.Location, nil)
+            continue
+        }
+        if len(directive.Arguments) != 1 || directive.Arguments[0].Name != "if" {
+            v.fail("@"+directive.Name+" takes one argument, if", &directive.Location, nil)
+            continue
+        }
+        v.value(&NonNull{Of: Boolean}, directive.Arguments[0].Value, &directive.Location)
+    }
+}
+
+// value checks that the variables a literal uses are defined with the
+// type it needs; the literals themselves are checked as they are coerced.
+func (v *validator) value(t Type, value Value, location *Location) {
+    switch value := value.(type) {
+    case *Variable:
+        definition, ok := v.defined[value.Name]
+        if !ok {
+            v.fail("variable $"+value.Name+" is not defined", location, nil)
+            return
+        }
+        if typeRefName(definition.Type) != namedType(t).String() {
+            v.fail(fmt.Sprintf("variable $%s of type %s cannot be used as a %s", value.Name, definition.Type, t), location, nil)
+        }
+    case []Value:
+        of := t
+        if nonNull, ok := of.(*NonNull); ok {
+            of = nonNull.Of
+        }
+        if list, ok := of.(*List); ok {
+            of = list.Of
+        }
+        for _, item := range value {
+            v.value(of, item, location)
+        }
+    }
+}
+
+func (d *FieldDef) arg(name string) *ArgDef {
+    for _, arg := range d.Args {
+        if arg.Name == name {
+            return arg
+        }
+    }
+    return nil
+}
+
+func typeRefName(t *TypeRef) string {
+    for t.Elem != nil {
+        t = t.Elem
+    }
+    return t.Name
+}
@@ -0,0 +1,124 @@
+package grpcserver
+
+import (
+    "context"
+    "crypto/x509"
+    "database/sql"
+    "errors"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/orderpb"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+    "strings"
+)
+
+// methodPermissions is what a staff member calling with a bearer token
+// needs for each method. Other methods, such as reflection, need
+// ViewOrders.
+var methodPermissions = map[string]auth.Permission{
+    orderpb.OrderService_ListOrders_FullMethodName:        auth.ViewOrders,
+    orderpb.OrderService_GetOrder_FullMethodName:          auth.ViewOrders,
+    orderpb.OrderService_WatchOrders_FullMethodName:       auth.ViewOrders,
+    orderpb.OrderService_UpdateOrderStatus_FullMethodName: auth.UpdateOrders,
+}
+
+type userContextKey struct{}
+
+// authenticate lets a call through if the client presented a certificate
+// the server verified, or sends the ID of a session, as from POST
+// /api/v1/sessions, whose user has the permission the method needs. The
+// user is added to the returned context.
+func (s *OrderServer) authenticate(ctx context.Context, method string) (context.Context, error) {
+    if clientCertificate(ctx) != nil {
+        return ctx, nil
+    }
+
+    token, ok := bearerToken(ctx)
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "a client certificate or bearer token is required")
+    }
+
+    session, err := s.repo.Session.GetById(ctx, token)
+    if errors.Is(err, sql.ErrNoRows) || err == nil && !session.UserID.Valid {
+        return nil, status.Error(codes.Unauthenticated, "the token is invalid or has expired")
+    }
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    user, err := s.repo.User.GetById(ctx, session.UserID.UUID)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, status.Error(codes.Unauthenticated, "the token is invalid or has expired")
+    }
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    permission, ok := methodPermissions[method]
+    if !ok {
+        permission = auth.ViewOrders
+    }
+    if !auth.Can(user.Role, permission) {
+        return nil, status.Error(codes.PermissionDenied, "the "+string(user.Role)+" role may not call this method")
+    }
+
+    return context.WithValue(ctx, userContextKey{}, user), nil
+}
+
+func (s *OrderServer) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+    ctx, err := s.authenticate(ctx, info.FullMethod)
+    if err != nil {
+        return nil, err
+    }
+    return handler(ctx, req)
+}
+
+func (s *OrderServer) authStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+    ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+    if err != nil {
+        return err
+    }
+    return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream carries the context with the caller's user.
+type authenticatedStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+    return s.ctx
+}
+
+// bearerToken returns the token of the call's "authorization: Bearer"
+// metadata.
+func bearerToken(ctx context.Context) (string, bool) {
+    for _, value := range metadata.ValueFromIncomingContext(ctx, "authorization") {
+        if token, ok := strings.CutPrefix(value, "Bearer "); ok && strings.TrimSpace(token) != "" {
+            return strings.TrimSpace(token), true
+        }
+    }
+    return "", false
+}
+
+// clientCertificate returns the certificate the client authenticated
+// with, or nil without mutual TLS.
+func clientCertificate(ctx context.Context) *x509.Certificate {
+    if p, ok := peer.FromContext(ctx); ok {
+        if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+            return info.State.VerifiedChains[0][0]
+        }
+    }
+    return nil
+}
+
+func currentUser(ctx context.Context) *User {
+    user, _ := ctx.Value(userContextKey{}).(*User)
+    return user
+}
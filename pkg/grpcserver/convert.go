@@ -0,0 +1,96 @@
+package grpcserver
+
+import (
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/orderpb"
+    "google.golang.org/protobuf/types/known/timestamppb"
+    "math"
+)
+
+var orderStatuses = map[OrderStatus]orderpb.OrderStatus{
+    Ordered:   orderpb.OrderStatus_ORDER_STATUS_ORDERED,
+    Pending:   orderpb.OrderStatus_ORDER_STATUS_PENDING,
+    Shipped:   orderpb.OrderStatus_ORDER_STATUS_SHIPPED,
+    Delivered: orderpb.OrderStatus_ORDER_STATUS_DELIVERED,
+    Cancel:    orderpb.OrderStatus_ORDER_STATUS_CANCELLED,
+}
+
+var paymentStatuses = map[PaymentStatus]orderpb.PaymentStatus{
+    PaymentUnpaid:         orderpb.PaymentStatus_PAYMENT_STATUS_UNPAID,
+    PaymentRequiresAction: orderpb.PaymentStatus_PAYMENT_STATUS_REQUIRES_ACTION,
+    PaymentProcessing:     orderpb.PaymentStatus_PAYMENT_STATUS_PROCESSING,
+    PaymentAuthorized:     orderpb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED,
+    PaymentPaid:           orderpb.PaymentStatus_PAYMENT_STATUS_PAID,
+    PaymentFailed:         orderpb.PaymentStatus_PAYMENT_STATUS_FAILED,
+    PaymentVoided:         orderpb.PaymentStatus_PAYMENT_STATUS_VOIDED,
+    PaymentPartlyRefunded: orderpb.PaymentStatus_PAYMENT_STATUS_PARTIALLY_REFUNDED,
+    PaymentRefunded:       orderpb.PaymentStatus_PAYMENT_STATUS_REFUNDED,
+}
+
+var orderEventTypes = map[OrderEventType]orderpb.OrderEventType{
+    OrderPlaced:        orderpb.OrderEventType_ORDER_EVENT_TYPE_PLACED,
+    OrderStatusChanged: orderpb.OrderEventType_ORDER_EVENT_TYPE_STATUS_CHANGED,
+    OrderRefunded:      orderpb.OrderEventType_ORDER_EVENT_TYPE_REFUNDED,
+    OrderReturnUpdated: orderpb.OrderEventType_ORDER_EVENT_TYPE_RETURN,
+}
+
+// orderStatus returns the order status of s, or "" if there is none.
+func orderStatus(s orderpb.OrderStatus) OrderStatus {
+    for status, value := range orderStatuses {
+        if value == s {
+            return status
+        }
+    }
+    return ""
+}
+
+func toOrder(order *Order) *orderpb.Order {
+    message := &orderpb.Order{
+        Id:            order.ID.String(),
+        UserId:        order.UserID,
+        Email:         order.Email,
+        Status:        orderStatuses[order.Status],
+        PaymentStatus: paymentStatuses[order.PaymentStatus],
+        Date:          timestamppb.New(order.Date),
+        SubtotalCents: cents(order.Subtotal),
+        RefundedCents: cents(order.Refunded),
+        TotalCents:    cents(order.Total),
+    }
+
+    for _, item := range order.Items {
+        itemMessage := &orderpb.OrderItem{
+            ProductId:        item.ProductID.String(),
+            Quantity:         int32(item.Quantity),
+            RefundedQuantity: int32(item.RefundedQuantity),
+            CostCents:        cents(item.Cost),
+        }
+        if item.Product != nil {
+            itemMessage.Sku = item.Product.SKU
+            itemMessage.Name = item.Product.Name
+        }
+        message.Items = append(message.Items, itemMessage)
+    }
+
+    for i := range order.Events {
+        message.Events = append(message.Events, toOrderEvent(&order.Events[i]))
+    }
+
+    return message
+}
+
+func toOrderEvent(event *OrderEvent) *orderpb.OrderEvent {
+    return &orderpb.OrderEvent{
+        Id:         event.ID.String(),
+        OrderId:    event.OrderID.String(),
+        Type:       orderEventTypes[event.Type],
+        FromStatus: orderStatuses[event.FromStatus],
+        ToStatus:   orderStatuses[event.ToStatus],
+        Actor:      event.Actor,
+        Note:       event.Note,
+        CreatedAt:  timestamppb.New(event.CreatedAt),
+    }
+}
+
+func cents(amount float64) int64 {
+    return int64(math.Round(amount * 100))
+}
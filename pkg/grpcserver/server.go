@@ -0,0 +1,269 @@
+// Package grpcserver serves the order service of pkg/orderpb to internal
+// services, such as fulfilment, on a port of its own.
+package grpcserver
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/orderpb"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "log/slog"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    defaultPageSize = 10
+    maxPageSize     = 100
+    watchBatchSize  = 100
+)
+
+// OrderServer implements orderpb.OrderServiceServer on the repository.
+type OrderServer struct {
+    orderpb.UnimplementedOrderServiceServer
+
+    repo *repository.Repository
+    // Cancelled is called after an order is cancelled, to void its
+    // payments.
+    Cancelled func(ctx context.Context, orderID uuid.UUID)
+    // PollInterval is how often WatchOrders looks for new events.
+    PollInterval time.Duration
+
+    closing   chan struct{}
+    closeOnce sync.Once
+}
+
+func NewOrderServer(repo *repository.Repository) *OrderServer {
+    return &OrderServer{
+        repo:         repo,
+        PollInterval: time.Second,
+        closing:      make(chan struct{}),
+    }
+}
+
+// Close ends the WatchOrders streams with UNAVAILABLE, so that the server
+// can stop gracefully and clients resume elsewhere.
+func (s *OrderServer) Close() {
+    s.closeOnce.Do(func() {
+        close(s.closing)
+    })
+}
+
+func (s *OrderServer) ListOrders(ctx context.Context, req *orderpb.ListOrdersRequest) (*orderpb.ListOrdersResponse, error) {
+    size := int(req.PageSize)
+    if size <= 0 {
+        size = defaultPageSize
+    }
+    size = min(size, maxPageSize)
+
+    page := 1
+    if req.PageToken != "" {
+        var err error
+        page, err = strconv.Atoi(req.PageToken)
+        if err != nil || page < 1 {
+            return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+        }
+    }
+
+    filter := repository.OrderFilter{Customer: req.Customer}
+    if req.Status != orderpb.OrderStatus_ORDER_STATUS_UNSPECIFIED {
+        filter.Status = orderStatus(req.Status)
+        if filter.Status == "" {
+            return nil, status.Error(codes.InvalidArgument, "unknown status")
+        }
+    }
+    if req.From != nil {
+        filter.From = req.From.AsTime()
+    }
+    if req.To != nil {
+        filter.To = req.To.AsTime()
+    }
+
+    orders, err := s.repo.Order.Find(ctx, filter, page, size)
+    if err != nil {
+        return nil, internalError(err)
+    }
+    count, err := s.repo.Order.Count(ctx, filter)
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    // Items are loaded for the whole page at once, rather than an order at
+    // a time.
+    ids := make([]uuid.UUID, len(orders))
+    for i := range orders {
+        ids[i] = orders[i].ID
+    }
+    items, err := s.repo.Order.FindItems(ctx, ids)
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    var productIDs []uuid.UUID
+    for _, orderItems := range items {
+        for _, item := range orderItems {
+            productIDs = append(productIDs, item.ProductID)
+        }
+    }
+    products, err := s.repo.Product.GetByIds(ctx, productIDs)
+    if err != nil {
+        return nil, internalError(err)
+    }
+    byID := make(map[uuid.UUID]*Product, len(products))
+    for i := range products {
+        byID[products[i].ID] = &products[i]
+    }
+
+    response := &orderpb.ListOrdersResponse{TotalSize: int32(count)}
+    for i := range orders {
+        for _, item := range items[orders[i].ID] {
+            item.Product = byID[item.ProductID]
+            orders[i].Items = append(orders[i].Items, item)
+        }
+        response.Orders = append(response.Orders, toOrder(&orders[i]))
+    }
+    if page*size < count {
+        response.NextPageToken = strconv.Itoa(page + 1)
+    }
+
+    return response, nil
+}
+
+func (s *OrderServer) GetOrder(ctx context.Context, req *orderpb.GetOrderRequest) (*orderpb.Order, error) {
+    id, err := uuid.Parse(req.Id)
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, "invalid order ID")
+    }
+
+    order, err := s.repo.Order.GetOrderWithProduct(ctx, id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, status.Error(codes.NotFound, "order not found")
+    }
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    return toOrder(order), nil
+}
+
+// UpdateOrderStatus records the change with the caller as its actor: the
+// common name of the client certificate, or the email of the token's user.
+func (s *OrderServer) UpdateOrderStatus(ctx context.Context, req *orderpb.UpdateOrderStatusRequest) (*orderpb.UpdateOrderStatusResponse, error) {
+    id, err := uuid.Parse(req.Id)
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, "invalid order ID")
+    }
+    to := orderStatus(req.Status)
+    if to == "" {
+        return nil, status.Error(codes.InvalidArgument, "unknown status")
+    }
+
+    event, err := s.repo.Order.UpdateStatus(ctx, id, to, actor(ctx), req.Note)
+    var transitionErr *TransitionError
+    if errors.As(err, &transitionErr) {
+        return nil, status.Error(codes.FailedPrecondition, transitionErr.Error())
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, status.Error(codes.NotFound, "order not found")
+    }
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    if event != nil && event.ToStatus == Cancel && s.Cancelled != nil {
+        s.Cancelled(ctx, id)
+    }
+
+    order, err := s.repo.Order.GetOrderWithProduct(ctx, id)
+    if err != nil {
+        return nil, internalError(err)
+    }
+
+    response := &orderpb.UpdateOrderStatusResponse{Order: toOrder(order)}
+    if event != nil {
+        response.Event = toOrderEvent(event)
+    }
+    return response, nil
+}
+
+// WatchOrders polls the order timelines for new events until the client
+// goes away.
+func (s *OrderServer) WatchOrders(req *orderpb.WatchOrdersRequest, stream grpc.ServerStreamingServer[orderpb.WatchOrdersResponse]) error {
+    var after uuid.UUID
+    if req.AfterEventId != "" {
+        var err error
+        after, err = uuid.Parse(req.AfterEventId)
+        if err != nil {
+            return status.Error(codes.InvalidArgument, "invalid after_event_id")
+        }
+    } else {
+        // A new UUIDv7 sorts after every event recorded so far.
+        after = uuid.Must(uuid.NewV7())
+    }
+
+    ctx := stream.Context()
+    ticker := time.NewTicker(s.PollInterval)
+    defer ticker.Stop()
+
+    for {
+        events, err := s.repo.Order.FindEventsAfter(ctx, after, watchBatchSize)
+        if err != nil {
+            return internalError(err)
+        }
+
+        for i := range events {
+            order, err := s.repo.Order.GetOrderWithProduct(ctx, events[i].OrderID)
+            if errors.Is(err, sql.ErrNoRows) {
+                // Deleted since; its events go with it.
+                after = events[i].ID
+                continue
+            }
+            if err != nil {
+                return internalError(err)
+            }
+            order.Events = nil
+
+            err = stream.Send(&orderpb.WatchOrdersResponse{Event: toOrderEvent(&events[i]), Order: toOrder(order)})
+            if err != nil {
+                return err
+            }
+            after = events[i].ID
+        }
+
+        if len(events) == watchBatchSize {
+            continue
+        }
+
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-s.closing:
+            return status.Error(codes.Unavailable, "the server is shutting down")
+        case <-ticker.C:
+        }
+    }
+}
+
+// actor names the client for the order timeline and the log.
+func actor(ctx context.Context) string {
+    if certificate := clientCertificate(ctx); certificate != nil && certificate.Subject.CommonName != "" {
+        return certificate.Subject.CommonName
+    }
+    if user := currentUser(ctx); user != nil {
+        return user.Email
+    }
+    return "grpc"
+}
+
+// internalError logs err and hides it from clients.
+func internalError(err error) error {
+    slog.Error("grpc: internal error", "error", err)
+    return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "net/mail"
+    "strings"
+)
+
+type AccountForm struct {
+    Name     string
+    Email    string
+    Messages []string
+}
+
+func (h *Handler) RegisterView(w http.ResponseWriter, r *http.Request) {
+    err := h.render(w, r, "register", &AccountForm{})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+    form := &AccountForm{
+        Name:  strings.TrimSpace(r.FormValue("name")),
+        Email: strings.TrimSpace(r.FormValue("email")),
+    }
+    password := r.FormValue("password")
+
+    if form.Name == "" {
+        form.Messages = append(form.Messages, "Name is required.")
+    }
+
+    if address, err := mail.ParseAddress(form.Email); err != nil {
+        form.Messages = append(form.Messages, "A valid email address is required.")
+    } else {
+        form.Email = address.Address
+    }
+
+    if len(password) < auth.MinPasswordLength {
+        form.Messages = append(form.Messages, "Password must be at least 8 characters.")
+    } else if password != r.FormValue("confirm_password") {
+        form.Messages = append(form.Messages, "Passwords do not match.")
+    }
+
+    if len(form.Messages) == 0 {
+        if _, err := h.Repo.User.GetByEmail(r.Context(), form.Email); err == nil {
+            form.Messages = append(form.Messages, "An account with this email already exists.")
+        } else if !errors.Is(err, sql.ErrNoRows) {
+            serverError(w, r, err)
+            return
+        }
+    }
+
+    if len(form.Messages) > 0 {
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        err := h.render(w, r, "register", form)
+        if err != nil {
+            serverError(w, r, err)
+        }
+        return
+    }
+
+    hash, err := auth.HashPassword(password)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    user, err := h.Repo.User.Register(r.Context(), &User{
+        Email:        form.Email,
+        Name:         form.Name,
+        PasswordHash: hash,
+    })
+    if errors.Is(err, ErrEmailTaken) {
+        // Someone registered the address since the check above.
+        form.Messages = append(form.Messages, "An account with this email already exists.")
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        if err := h.render(w, r, "register", form); err != nil {
+            serverError(w, r, err)
+        }
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if _, err := h.signIn(w, r, user); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) LoginView(w http.ResponseWriter, r *http.Request) {
+    err := h.render(w, r, "login", &AccountForm{})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+    form := &AccountForm{
+        Email: strings.TrimSpace(r.FormValue("email")),
+    }
+
+    user, err := h.Repo.User.GetByEmail(r.Context(), form.Email)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        serverError(w, r, err)
+        return
+    }
+
+    if user == nil || !auth.CheckPassword(user.PasswordHash, r.FormValue("password")) {
+        form.Messages = append(form.Messages, "Invalid email or password.")
+
+        w.WriteHeader(http.StatusUnauthorized)
+        err := h.render(w, r, "login", form)
+        if err != nil {
+            serverError(w, r, err)
+        }
+        return
+    }
+
+    if _, err := h.signIn(w, r, user); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+    if err := h.signOut(w, r); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *Handler) AccountOrdersPage(w http.ResponseWriter, r *http.Request) {
+    user := currentUser(r)
+    if user == nil {
+        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        return
+    }
+
+    orders, err := h.Repo.Order.FindByUser(r.Context(), user.ID.String())
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    err = h.render(w, r, "accountOrders", orders)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) AccountOrderPage(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.accountOrder(w, r)
+    if !ok {
+        return
+    }
+
+    h.renderAccountOrder(w, r, order, "")
+}
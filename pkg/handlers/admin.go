@@ -0,0 +1,216 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "net/mail"
+    "slices"
+    "strings"
+)
+
+// RequireAdmin only lets signed-in users with an admin role through. Others
+// are sent to the admin login page; HTMX requests are redirected with the
+// HX-Redirect header so the whole page navigates instead of a fragment.
+func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        user := currentUser(r)
+        if user == nil || !user.Role.IsAdmin() {
+            if r.Header.Get("HX-Request") == "true" {
+                w.Header().Set("HX-Redirect", "/admin/login")
+                w.WriteHeader(http.StatusUnauthorized)
+                return
+            }
+            http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// RequirePermission returns a middleware that rejects users whose role does
+// not grant permission. It expects RequireAdmin to have run first.
+func (h *Handler) RequirePermission(permission auth.Permission) mux.MiddlewareFunc {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user := currentUser(r)
+            if user == nil || !auth.Can(user.Role, permission) {
+                http.Error(w, "You do not have permission to perform this action", http.StatusForbidden)
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+func (h *Handler) AdminLoginView(w http.ResponseWriter, r *http.Request) {
+    if user := currentUser(r); user != nil && user.Role.IsAdmin() {
+        http.Redirect(w, r, "/manage-orders", http.StatusSeeOther)
+        return
+    }
+
+    err := h.render(w, r, "adminLogin", &AccountForm{})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
+    form := &AccountForm{
+        Email: strings.TrimSpace(r.FormValue("email")),
+    }
+
+    user, err := h.Repo.User.GetByEmail(r.Context(), form.Email)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        serverError(w, r, err)
+        return
+    }
+
+    if user == nil || !user.Role.IsAdmin() || !auth.CheckPassword(user.PasswordHash, r.FormValue("password")) {
+        form.Messages = append(form.Messages, "Invalid email or password.")
+
+        w.WriteHeader(http.StatusUnauthorized)
+        err := h.render(w, r, "adminLogin", form)
+        if err != nil {
+            serverError(w, r, err)
+        }
+        return
+    }
+
+    if _, err := h.signIn(w, r, user); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/manage-orders", http.StatusSeeOther)
+}
+
+func (h *Handler) AdminLogout(w http.ResponseWriter, r *http.Request) {
+    if err := h.signOut(w, r); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+type StaffPageData struct {
+    Users    []User
+    Roles    []Role
+    Form     AccountForm
+    Messages []string
+}
+
+func (h *Handler) StaffPage(w http.ResponseWriter, r *http.Request) {
+    h.renderStaff(w, r, "staff", nil, AccountForm{})
+}
+
+func (h *Handler) CreateStaff(w http.ResponseWriter, r *http.Request) {
+    form := AccountForm{
+        Name:  strings.TrimSpace(r.FormValue("name")),
+        Email: strings.TrimSpace(r.FormValue("email")),
+    }
+    role := Role(r.FormValue("role"))
+    password := r.FormValue("password")
+
+    if form.Name == "" {
+        form.Messages = append(form.Messages, "Name is required.")
+    }
+
+    if _, err := mail.ParseAddress(form.Email); err != nil {
+        form.Messages = append(form.Messages, "A valid email address is required.")
+    }
+
+    if len(password) < auth.MinPasswordLength {
+        form.Messages = append(form.Messages, "Password must be at least 8 characters.")
+    }
+
+    if !role.IsAdmin() {
+        form.Messages = append(form.Messages, "Invalid role.")
+    }
+
+    if len(form.Messages) == 0 {
+        if _, err := h.Repo.User.GetByEmail(r.Context(), form.Email); err == nil {
+            form.Messages = append(form.Messages, "An account with this email already exists.")
+        }
+    }
+
+    if len(form.Messages) > 0 {
+        h.renderStaff(w, r, "staffTable", nil, form)
+        return
+    }
+
+    hash, err := auth.HashPassword(password)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    _, err = h.Repo.User.Create(r.Context(), &User{
+        Email:        form.Email,
+        Name:         form.Name,
+        PasswordHash: hash,
+        Role:         role,
+    })
+    if err != nil {
+        form.Messages = append(form.Messages, "Failed to create user: "+err.Error())
+        h.renderStaff(w, r, "staffTable", nil, form)
+        return
+    }
+
+    h.renderStaff(w, r, "staffTable", []string{form.Email + " added."}, AccountForm{})
+}
+
+func (h *Handler) UpdateStaffRole(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := uuid.Parse(vars["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    role := Role(r.FormValue("role"))
+    if role != Customer && !role.IsAdmin() {
+        http.Error(w, "Invalid role", http.StatusBadRequest)
+        return
+    }
+
+    if id == currentUser(r).ID {
+        h.renderStaff(w, r, "staffTable", []string{"You cannot change your own role."}, AccountForm{})
+        return
+    }
+
+    err = h.Repo.User.UpdateRole(r.Context(), id, role)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderStaff(w, r, "staffTable", []string{"Role updated."}, AccountForm{})
+}
+
+func (h *Handler) renderStaff(w http.ResponseWriter, r *http.Request, name string, messages []string, form AccountForm) {
+    users, err := h.Repo.User.FindStaff(r.Context())
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := StaffPageData{
+        Users:    users,
+        Roles:    slices.Clone(AdminRoles),
+        Form:     form,
+        Messages: messages,
+    }
+
+    err = h.render(w, r, name, data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "io"
+    "mime"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// APIPrefix is where the JSON API is served. Breaking changes get a new
+// version next to it.
+const APIPrefix = "/api/v1"
+
+// maxAPIBody caps the size of a JSON request body.
+const maxAPIBody = 1 << 20
+
+// APIError is the body of every API error response, wrapped as
+// {"error": ...}. Code is stable for clients to switch on; Details lists
+// the individual problems of a rejected request, such as each invalid field.
+type APIError struct {
+    Status  int      `json:"status"`
+    Code    string   `json:"code"`
+    Message string   `json:"message"`
+    Details []string `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+    return e.Message
+}
+
+type apiErrorBody struct {
+    Error APIError `json:"error"`
+}
+
+type apiDataBody struct {
+    Data any `json:"data"`
+}
+
+type apiListBody struct {
+    Data       any        `json:"data"`
+    Pagination Pagination `json:"pagination"`
+}
+
+func isAPIRequest(r *http.Request) bool {
+    return r.URL.Path == APIPrefix || strings.HasPrefix(r.URL.Path, APIPrefix+"/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIData(w http.ResponseWriter, status int, data any) {
+    writeJSON(w, status, apiDataBody{Data: data})
+}
+
+func writeAPIList(w http.ResponseWriter, data any, pagination Pagination) {
+    writeJSON(w, http.StatusOK, apiListBody{Data: data, Pagination: pagination})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details ...string) {
+    writeJSON(w, status, apiErrorBody{Error: APIError{Status: status, Code: code, Message: message, Details: details}})
+}
+
+// writeAPIServerError logs err with the request and fails it with a 500.
+// The error itself is not shown to clients.
+func writeAPIServerError(w http.ResponseWriter, r *http.Request, err error) {
+    logging.FromContext(r.Context()).Error("request failed", "error", err)
+    writeAPIError(w, http.StatusInternalServerError, "internal_error", "Internal server error.")
+}
+
+// decodeJSON reads the JSON request body into v, rejecting unknown fields so
+// that typos do not pass silently. It writes the error response itself and
+// reports whether decoding succeeded.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+    decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+    decoder.DisallowUnknownFields()
+
+    err := decoder.Decode(v)
+    if errors.Is(err, io.EOF) {
+        writeAPIError(w, http.StatusBadRequest, "invalid_body", "The request body is empty.")
+        return false
+    }
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_body", "The request body is not valid JSON: "+err.Error())
+        return false
+    }
+
+    return true
+}
+
+// APIContent negotiates the content type of API requests: responses are
+// always JSON, so clients must accept it, and request bodies must be JSON.
+func (h *Handler) APIContent(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !acceptsJSON(r.Header.Get("Accept")) {
+            writeAPIError(w, http.StatusNotAcceptable, "not_acceptable", "The API only responds with application/json.")
+            return
+        }
+
+        if r.ContentLength != 0 && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+            mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+            if err != nil || mediaType != "application/json" {
+                writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "Request bodies must be application/json.")
+                return
+            }
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// acceptsJSON reports whether an Accept header allows a JSON response. No
+// header accepts anything.
+func acceptsJSON(accept string) bool {
+    if strings.TrimSpace(accept) == "" {
+        return true
+    }
+
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err != nil || params["q"] == "0" || params["q"] == "0.0" {
+            continue
+        }
+        switch mediaType {
+        case "application/json", "application/*", "*/*":
+            return true
+        }
+    }
+
+    return false
+}
+
+// APIRequireUser rejects API requests from visitors who are not signed in.
+func (h *Handler) APIRequireUser(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if currentUser(r) == nil {
+            writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Sign in first: POST "+APIPrefix+"/session.")
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// APIRequirePermission is RequirePermission for the API. It expects
+// APIRequireUser to have run first.
+func (h *Handler) APIRequirePermission(permission auth.Permission) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            user := currentUser(r)
+            if user == nil || !auth.Can(user.Role, permission) {
+                writeAPIError(w, http.StatusForbidden, "forbidden", "You do not have permission to perform this action.")
+                return
+            }
+
+            next.ServeHTTP(w, r)
+        })
+    }
+}
+
+func (h *Handler) APINotFound(w http.ResponseWriter, r *http.Request) {
+    writeAPIError(w, http.StatusNotFound, "not_found", "There is no "+r.Method+" "+r.URL.Path+".")
+}
+
+func (h *Handler) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+    writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed on "+r.URL.Path+".")
+}
+
+type sessionRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+}
+
+// SessionResponse hands an API client its session. Token goes in an
+// "Authorization: Bearer" header on later requests; User is null for
+// guests.
+type SessionResponse struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expires_at"`
+    User      *User     `json:"user"`
+}
+
+// APICreateSession starts an API session. With an email and password it
+// signs the user in; with an empty body it returns a guest session, which
+// is enough to fill a cart and check out.
+func (h *Handler) APICreateSession(w http.ResponseWriter, r *http.Request) {
+    var req sessionRequest
+    if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+        return
+    }
+
+    if req.Email == "" && req.Password == "" {
+        session, err := h.ensureSession(w, r)
+        if err != nil {
+            writeAPIServerError(w, r, err)
+            return
+        }
+        writeAPIData(w, http.StatusCreated, SessionResponse{Token: session.ID, ExpiresAt: session.ExpiresAt, User: currentUser(r)})
+        return
+    }
+
+    user, err := h.Repo.User.GetByEmail(r.Context(), strings.TrimSpace(req.Email))
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if user == nil || !auth.CheckPassword(user.PasswordHash, req.Password) {
+        writeAPIError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid email or password.")
+        return
+    }
+
+    session, err := h.signIn(w, r, user)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    writeAPIData(w, http.StatusCreated, SessionResponse{Token: session.ID, ExpiresAt: session.ExpiresAt, User: user})
+}
+
+// APIDeleteSession signs out, ending the session and its cart.
+func (h *Handler) APIDeleteSession(w http.ResponseWriter, r *http.Request) {
+    if err := h.signOut(w, r); err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "strconv"
+)
+
+// maxCartQuantity caps how many units of one product a cart line may hold.
+const maxCartQuantity = 1000
+
+type CartResponse struct {
+    Items []OrderItem `json:"items"`
+    Total float64     `json:"total"`
+}
+
+type cartItemRequest struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Quantity  *int      `json:"quantity"`
+}
+
+func (h *Handler) APIGetCart(w http.ResponseWriter, r *http.Request) {
+    h.writeCart(w, h.cartItems(r))
+}
+
+// APIAddCartItem adds quantity units of a product to the cart, one if no
+// quantity is given.
+func (h *Handler) APIAddCartItem(w http.ResponseWriter, r *http.Request) {
+    var req cartItemRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    quantity := 1
+    if req.Quantity != nil {
+        quantity = *req.Quantity
+    }
+    if quantity < 1 {
+        writeAPIError(w, http.StatusUnprocessableEntity, "invalid_quantity", "The quantity must be at least 1.")
+        return
+    }
+
+    cartItems := h.cartItems(r)
+    if index := getCartItem(cartItems, req.ProductID); index != -1 {
+        quantity += cartItems[index].Quantity
+    }
+
+    h.setCartQuantity(w, r, req.ProductID, quantity)
+}
+
+// APIUpdateCartItem sets how many units of a product are in the cart;
+// zero removes it.
+func (h *Handler) APIUpdateCartItem(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid product ID.")
+        return
+    }
+
+    var req cartItemRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+    if req.Quantity == nil || *req.Quantity < 0 {
+        writeAPIError(w, http.StatusUnprocessableEntity, "invalid_quantity", "Give a quantity of 0 or more.")
+        return
+    }
+
+    if getCartItem(h.cartItems(r), id) == -1 {
+        writeAPIError(w, http.StatusNotFound, "not_found", "The product is not in the cart.")
+        return
+    }
+
+    h.setCartQuantity(w, r, id, *req.Quantity)
+}
+
+func (h *Handler) APIRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid product ID.")
+        return
+    }
+
+    h.setCartQuantity(w, r, id, 0)
+}
+
+// setCartQuantity puts quantity units of the product in the cart, at its
+// current price, and answers with the cart.
+func (h *Handler) setCartQuantity(w http.ResponseWriter, r *http.Request, productID uuid.UUID, quantity int) {
+    cartItems, err := h.updateCart(w, r, productID, quantity)
+    var apiErr *APIError
+    if errors.As(err, &apiErr) {
+        writeAPIError(w, apiErr.Status, apiErr.Code, apiErr.Message)
+        return
+    }
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    h.writeCart(w, cartItems)
+}
+
+// updateCart puts quantity units of the product in the session's cart,
+// starting a session if there is none, and returns the cart. Problems with
+// the request come back as an *APIError.
+func (h *Handler) updateCart(w http.ResponseWriter, r *http.Request, productID uuid.UUID, quantity int) ([]OrderItem, error) {
+    if quantity > maxCartQuantity {
+        return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: "invalid_quantity", Message: "A cart can hold at most " + strconv.Itoa(maxCartQuantity) + " of a product."}
+    }
+
+    session, err := h.ensureSession(w, r)
+    if err != nil {
+        return nil, err
+    }
+    cartItems := h.Carts.Items(session.ID)
+    index := getCartItem(cartItems, productID)
+
+    switch {
+    case quantity == 0 && index != -1:
+        cartItems = append(cartItems[:index], cartItems[index+1:]...)
+    case quantity > 0:
+        product, err := h.Repo.Product.GetById(r.Context(), productID)
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, &APIError{Status: http.StatusNotFound, Code: "not_found", Message: "Product not found."}
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        item := OrderItem{
+            ProductID: productID,
+            Product:   product,
+            Quantity:  quantity,
+            Cost:      product.Price * float64(quantity),
+        }
+        if index == -1 {
+            metrics.CartAdditions.Add(float64(quantity))
+            cartItems = append(cartItems, item)
+        } else {
+            if added := quantity - cartItems[index].Quantity; added > 0 {
+                metrics.CartAdditions.Add(float64(added))
+            }
+            cartItems[index] = item
+        }
+    }
+
+    h.Carts.Set(session.ID, cartItems)
+    return cartItems, nil
+}
+
+func (h *Handler) writeCart(w http.ResponseWriter, cartItems []OrderItem) {
+    if cartItems == nil {
+        cartItems = []OrderItem{}
+    }
+    writeAPIData(w, http.StatusOK, CartResponse{Items: cartItems, Total: getCartTotal(cartItems)})
+}
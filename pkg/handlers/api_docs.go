@@ -0,0 +1,21 @@
+package handlers
+
+import (
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/openapi"
+    "net/http"
+)
+
+// OpenAPISpec serves the OpenAPI document of the JSON API.
+func (h *Handler) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.Write(openapi.Spec)
+}
+
+// APIDocs is the reference documentation of the JSON API, rendered in the
+// browser from the OpenAPI document.
+func (h *Handler) APIDocs(w http.ResponseWriter, r *http.Request) {
+    err := h.render(w, r, "apiDocs", nil)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "net/http"
+    "strings"
+)
+
+type checkoutRequest struct {
+    Email      string `json:"email"`
+    CardNumber string `json:"card_number"`
+}
+
+// CheckoutResponse is the order placed by an API checkout. When the payment
+// requires action, such as 3-D Secure, the customer must be sent to
+// RedirectURL to finish it.
+type CheckoutResponse struct {
+    Order       *Order   `json:"order"`
+    Payment     *Payment `json:"payment"`
+    RedirectURL string   `json:"redirect_url,omitempty"`
+}
+
+// OrderStatusResponse is where an order is in its lifecycle. NextStatuses
+// lists the statuses staff may move it to.
+type OrderStatusResponse struct {
+    Status        OrderStatus   `json:"status"`
+    PaymentStatus PaymentStatus `json:"payment_status"`
+    NextStatuses  []OrderStatus `json:"next_statuses"`
+    Events        []OrderEvent  `json:"events"`
+}
+
+type orderStatusRequest struct {
+    Status OrderStatus `json:"status"`
+    Note   string      `json:"note"`
+}
+
+// APICheckout places an order for the session's cart, like the checkout
+// form. The cart is emptied unless the payment failed, in which case it is
+// kept so the customer can try again.
+func (h *Handler) APICheckout(w http.ResponseWriter, r *http.Request) {
+    var req checkoutRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    response, err := h.checkout(r, req.Email, req.CardNumber)
+    var apiErr *APIError
+    if errors.As(err, &apiErr) {
+        writeAPIError(w, apiErr.Status, apiErr.Code, apiErr.Message)
+        return
+    }
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Location", APIPrefix+"/orders/"+response.Order.ID.String())
+    writeAPIData(w, http.StatusCreated, response)
+}
+
+// checkout places an order for the session's cart and empties the cart,
+// unless the payment failed. Checkout problems come back as an *APIError.
+func (h *Handler) checkout(r *http.Request, email, cardNumber string) (*CheckoutResponse, error) {
+    attempt, err := h.placeOrder(r, email, cardNumber)
+    var checkoutErr *CheckoutError
+    if errors.As(err, &checkoutErr) {
+        code := "checkout_failed"
+        switch checkoutErr.Status {
+        case http.StatusConflict:
+            code = "out_of_stock"
+        case http.StatusInternalServerError:
+            code = "internal_error"
+        }
+        return nil, &APIError{Status: checkoutErr.Status, Code: code, Message: checkoutErr.Message}
+    }
+
+    if attempt.Status == PaymentFailed {
+        return nil, &APIError{Status: http.StatusPaymentRequired, Code: "payment_failed", Message: "The payment failed: " + attempt.LastError}
+    }
+    h.Carts.Clear(currentSession(r).ID)
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), attempt.OrderID)
+    if err != nil {
+        return nil, err
+    }
+
+    response := &CheckoutResponse{Order: order, Payment: attempt}
+    if attempt.Status == PaymentRequiresAction {
+        response.RedirectURL = attempt.RedirectURL
+    }
+    return response, nil
+}
+
+// APIListOrders lists the signed-in customer's own orders, newest first.
+func (h *Handler) APIListOrders(w http.ResponseWriter, r *http.Request) {
+    page, size := apiPageParams(r)
+
+    filter := repository.OrderFilter{UserID: uuid.NullUUID{UUID: currentUser(r).ID, Valid: true}}
+
+    orders, err := h.Repo.Order.Find(r.Context(), filter, page, size)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    count, err := h.Repo.Order.Count(r.Context(), filter)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if orders == nil {
+        orders = []Order{}
+    }
+    writeAPIList(w, orders, newPagination(page, size, count))
+}
+
+// APIListAllOrders lists every order for staff, with the filters of the
+// admin order table: from, to, status and customer.
+func (h *Handler) APIListAllOrders(w http.ResponseWriter, r *http.Request) {
+    page, size := apiPageParams(r)
+    filter := orderFilter(r)
+
+    orders, err := h.Repo.Order.Find(r.Context(), filter, page, size)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    count, err := h.Repo.Order.Count(r.Context(), filter)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if orders == nil {
+        orders = []Order{}
+    }
+    writeAPIList(w, orders, newPagination(page, size, count))
+}
+
+func (h *Handler) APIGetOrder(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.apiOrder(w, r)
+    if !ok {
+        return
+    }
+
+    writeAPIData(w, http.StatusOK, order)
+}
+
+func (h *Handler) APIGetOrderStatus(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.apiOrder(w, r)
+    if !ok {
+        return
+    }
+
+    h.writeOrderStatus(w, r, order)
+}
+
+// APIUpdateOrderStatus moves an order to a new status, like the order
+// detail form. Cancelling it voids any payment not yet captured.
+func (h *Handler) APIUpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid order ID.")
+        return
+    }
+
+    var req orderStatusRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    event, err := h.Repo.Order.UpdateStatus(r.Context(), id, req.Status, currentUser(r).Email, strings.TrimSpace(req.Note))
+    var transitionErr *TransitionError
+    if errors.As(err, &transitionErr) {
+        writeAPIError(w, http.StatusConflict, "invalid_transition", transitionErr.Error())
+        return
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        writeAPIError(w, http.StatusNotFound, "not_found", "Order not found.")
+        return
+    }
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if event != nil && event.ToStatus == Cancel {
+        h.VoidPayments(r.Context(), id)
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), id)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    h.writeOrderStatus(w, r, order)
+}
+
+func (h *Handler) writeOrderStatus(w http.ResponseWriter, r *http.Request, order *Order) {
+    response := OrderStatusResponse{
+        Status:        order.Status,
+        PaymentStatus: order.PaymentStatus,
+        NextStatuses:  []OrderStatus{},
+        Events:        order.Events,
+    }
+    if user := currentUser(r); auth.Can(user.Role, auth.UpdateOrders) {
+        response.NextStatuses = order.Status.NextStatuses()
+    }
+    if response.Events == nil {
+        response.Events = []OrderEvent{}
+    }
+
+    writeAPIData(w, http.StatusOK, response)
+}
+
+// apiOrder loads the order named by the id route variable with its items
+// and timeline. Customers only see their own orders; staff who may view
+// orders see any.
+func (h *Handler) apiOrder(w http.ResponseWriter, r *http.Request) (*Order, bool) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid order ID.")
+        return nil, false
+    }
+
+    user := currentUser(r)
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && order.UserID != user.ID.String() && !auth.Can(user.Role, auth.ViewOrders)) {
+        writeAPIError(w, http.StatusNotFound, "not_found", "Order not found.")
+        return nil, false
+    }
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return nil, false
+    }
+
+    return order, true
+}
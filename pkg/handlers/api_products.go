@@ -0,0 +1,160 @@
+package handlers
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/catalog"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "os"
+    "path/filepath"
+)
+
+// productRequest is the body of a product create or update. Numbers are
+// kept as written so they go through the same checks as the product form.
+type productRequest struct {
+    Name        string      `json:"name"`
+    SKU         string      `json:"sku"`
+    Price       json.Number `json:"price"`
+    Description string      `json:"description"`
+    Stock       json.Number `json:"stock"`
+}
+
+func (req *productRequest) parse() (*Product, []string) {
+    return catalog.ParseProduct(req.Name, req.SKU, req.Price.String(), req.Description, req.Stock.String())
+}
+
+func (h *Handler) APIListProducts(w http.ResponseWriter, r *http.Request) {
+    page, size := apiPageParams(r)
+
+    products, err := h.Repo.Product.Find(r.Context(), "", page, size)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    count, err := h.Repo.Product.Count(r.Context())
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if products == nil {
+        products = []Product{}
+    }
+    writeAPIList(w, products, newPagination(page, size, count))
+}
+
+func (h *Handler) APIGetProduct(w http.ResponseWriter, r *http.Request) {
+    product, ok := h.apiProduct(w, r)
+    if !ok {
+        return
+    }
+
+    writeAPIData(w, http.StatusOK, product)
+}
+
+func (h *Handler) APICreateProduct(w http.ResponseWriter, r *http.Request) {
+    var req productRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    product, errorMessages := req.parse()
+    if len(errorMessages) > 0 {
+        writeAPIError(w, http.StatusUnprocessableEntity, "invalid_product", "The product is not valid.", errorMessages...)
+        return
+    }
+
+    id, err := uuid.NewV7()
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+    product.ID = id
+
+    product, err = h.Repo.Product.Create(r.Context(), product)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Location", APIPrefix+"/products/"+product.ID.String())
+    writeAPIData(w, http.StatusCreated, product)
+}
+
+// APIUpdateProduct replaces the fields of a product. Its image is kept.
+func (h *Handler) APIUpdateProduct(w http.ResponseWriter, r *http.Request) {
+    current, ok := h.apiProduct(w, r)
+    if !ok {
+        return
+    }
+
+    var req productRequest
+    if !decodeJSON(w, r, &req) {
+        return
+    }
+
+    product, errorMessages := req.parse()
+    if len(errorMessages) > 0 {
+        writeAPIError(w, http.StatusUnprocessableEntity, "invalid_product", "The product is not valid.", errorMessages...)
+        return
+    }
+    product.Image = current.Image
+    product.CreatedDate = current.CreatedDate
+
+    err := h.Repo.Product.Update(r.Context(), current.ID, product)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    writeAPIData(w, http.StatusOK, product)
+}
+
+func (h *Handler) APIDeleteProduct(w http.ResponseWriter, r *http.Request) {
+    product, ok := h.apiProduct(w, r)
+    if !ok {
+        return
+    }
+
+    err := h.Repo.Product.Delete(r.Context(), product.ID)
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return
+    }
+
+    if product.Image != "" {
+        if err := os.Remove(filepath.Join(h.ImageStoragePath, product.Image)); err != nil {
+            logging.FromContext(r.Context()).Warn("delete product image", "error", err)
+        }
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// apiProduct loads the product named by the id route variable, writing the
+// error response if there is none.
+func (h *Handler) apiProduct(w http.ResponseWriter, r *http.Request) (*Product, bool) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        writeAPIError(w, http.StatusBadRequest, "invalid_id", "Invalid product ID.")
+        return nil, false
+    }
+
+    product, err := h.Repo.Product.GetById(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) {
+        writeAPIError(w, http.StatusNotFound, "not_found", "Product not found.")
+        return nil, false
+    }
+    if err != nil {
+        writeAPIServerError(w, r, err)
+        return nil, false
+    }
+
+    return product, true
+}
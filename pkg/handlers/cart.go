@@ -0,0 +1,67 @@
+package handlers
+
+import (
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "slices"
+    "sync"
+)
+
+// CartStore keeps the shopping cart of every session in memory, keyed by
+// session ID.
+type CartStore struct {
+    mu    sync.Mutex
+    carts map[string][]OrderItem
+}
+
+func NewCartStore() *CartStore {
+    return &CartStore{
+        carts: make(map[string][]OrderItem),
+    }
+}
+
+// Items returns a copy of the cart so callers can modify it freely and
+// store the result back with Set.
+func (s *CartStore) Items(sessionID string) []OrderItem {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return slices.Clone(s.carts[sessionID])
+}
+
+func (s *CartStore) Set(sessionID string, items []OrderItem) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if len(items) == 0 {
+        delete(s.carts, sessionID)
+        return
+    }
+    s.carts[sessionID] = items
+}
+
+func (s *CartStore) Clear(sessionID string) {
+    s.Set(sessionID, nil)
+}
+
+// Move hands the cart of one session over to another, e.g. when a guest
+// signs in and gets a new session ID.
+func (s *CartStore) Move(from, to string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if items, ok := s.carts[from]; ok {
+        s.carts[to] = items
+        delete(s.carts, from)
+    }
+}
+
+// cartItems returns a copy of the visitor's cart, which is empty until
+// they have a session.
+func (h *Handler) cartItems(r *http.Request) []OrderItem {
+    session := currentSession(r)
+    if session == nil {
+        return nil
+    }
+    return h.Carts.Items(session.ID)
+}
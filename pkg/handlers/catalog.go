@@ -0,0 +1,219 @@
+package handlers
+
+import (
+    "archive/zip"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/catalog"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/export"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// maxImportSize caps an import upload, the CSV file and image archive
+// together.
+const maxImportSize = 200 << 20
+
+// importTimeout is how long an import upload may take to arrive.
+const importTimeout = 10 * time.Minute
+
+type ProductImportData struct {
+    // ImagePath is the server folder images can be taken from, if any.
+    ImagePath string
+    Plan      *catalog.Plan
+    DryRun    bool
+    Applied   bool
+    Message   string
+}
+
+func (h *Handler) ProductImportView(w http.ResponseWriter, r *http.Request) {
+    err := h.render(w, r, "productImport", ProductImportData{ImagePath: h.ImportPath})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+// ImportProducts imports the uploaded CSV file of products. With dry_run it
+// only shows what would change. Otherwise it applies the whole file in one
+// transaction, or nothing at all if any row is wrong. Images named in the
+// file come from the uploaded zip archive, or without one from the
+// server's import folder.
+func (h *Handler) ImportProducts(w http.ResponseWriter, r *http.Request) {
+    data := ProductImportData{ImagePath: h.ImportPath}
+
+    liftReadDeadline(w, r, importTimeout)
+    liftWriteDeadline(w, r)
+    r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+    if err := r.ParseMultipartForm(32 << 20); err != nil {
+        data.Message = "The upload could not be read: " + err.Error()
+        h.renderProductImport(w, r, data)
+        return
+    }
+    data.DryRun = r.FormValue("dry_run") != ""
+
+    file, _, err := r.FormFile("file")
+    if err != nil {
+        data.Message = "Choose a CSV file to import."
+        h.renderProductImport(w, r, data)
+        return
+    }
+    defer file.Close()
+
+    var images catalog.Images
+    if h.ImportPath != "" {
+        images = catalog.Dir(h.ImportPath)
+    }
+    archive, header, err := r.FormFile("images")
+    if err == nil {
+        defer archive.Close()
+
+        zipReader, err := zip.NewReader(archive, header.Size)
+        if err != nil {
+            data.Message = "The image archive is not a zip file: " + err.Error()
+            h.renderProductImport(w, r, data)
+            return
+        }
+        images = catalog.NewZip(zipReader)
+    } else if !errors.Is(err, http.ErrMissingFile) {
+        data.Message = "The image archive could not be read: " + err.Error()
+        h.renderProductImport(w, r, data)
+        return
+    }
+
+    existing, err := h.Repo.Product.Find(r.Context(), "", 1, -1)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data.Plan, err = catalog.Read(file, existing, images)
+    if err != nil {
+        data.Message = "The file could not be imported: " + err.Error()
+        h.renderProductImport(w, r, data)
+        return
+    }
+
+    if data.DryRun || !data.Plan.Valid() {
+        h.renderProductImport(w, r, data)
+        return
+    }
+
+    err = h.applyImport(r, data.Plan, images)
+    if err != nil {
+        data.Message = "Nothing was imported: " + err.Error()
+        h.renderProductImport(w, r, data)
+        return
+    }
+
+    data.Applied = true
+    h.renderProductImport(w, r, data)
+}
+
+// applyImport stores the new images of plan and then writes its products.
+// If the products cannot be written the images are removed again.
+func (h *Handler) applyImport(r *http.Request, plan *catalog.Plan, images catalog.Images) error {
+    var creates, updates []*Product
+    var saved []string
+
+    cleanUp := func() {
+        for _, filename := range saved {
+            os.Remove(filepath.Join(h.ImageStoragePath, filename))
+        }
+    }
+
+    for i := range plan.Rows {
+        row := &plan.Rows[i]
+
+        if row.Image != "" {
+            src, err := images.Open(row.Image)
+            if err != nil {
+                cleanUp()
+                return err
+            }
+            row.Product.Image, err = h.saveImage(src, row.Image)
+            src.Close()
+            if err != nil {
+                cleanUp()
+                return err
+            }
+            saved = append(saved, row.Product.Image)
+        }
+
+        if row.Action == catalog.Create {
+            if row.Product.ID == uuid.Nil {
+                id, err := uuid.NewV7()
+                if err != nil {
+                    cleanUp()
+                    return err
+                }
+                row.Product.ID = id
+            }
+            creates = append(creates, &row.Product)
+        } else {
+            updates = append(updates, &row.Product)
+        }
+    }
+
+    err := h.Repo.Product.Import(r.Context(), creates, updates)
+    if err != nil {
+        cleanUp()
+        return err
+    }
+
+    return nil
+}
+
+func (h *Handler) renderProductImport(w http.ResponseWriter, r *http.Request, data ProductImportData) {
+    err := h.render(w, r, "productImportResult", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+// ExportProducts downloads the whole catalogue as a CSV file that can be
+// edited and imported again.
+func (h *Handler) ExportProducts(w http.ResponseWriter, r *http.Request) {
+    filename := "products-" + time.Now().Format("20060102-1504") + ".csv"
+    w.Header().Set("Content-Type", export.CSV.ContentType())
+    w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+    liftWriteDeadline(w, r)
+
+    out := export.NewCSV(w)
+    err := out.Write(catalog.Header())
+    if err == nil {
+        err = h.Repo.Product.Export(r.Context(), func(product *Product) error {
+            return out.Write(catalog.Record(product))
+        })
+    }
+    if err == nil {
+        err = out.Close()
+    }
+    if err != nil {
+        logging.FromContext(r.Context()).Error("export", "error", err)
+    }
+}
+
+// saveImage stores an uploaded product image under a new name, keeping the
+// extension of its original name, and returns the new name.
+func (h *Handler) saveImage(src io.Reader, originalName string) (string, error) {
+    filename := strconv.FormatInt(time.Now().UnixNano(), 10) + filepath.Ext(originalName)
+
+    dst, err := os.OpenFile(filepath.Join(h.ImageStoragePath, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+    if err != nil {
+        return "", err
+    }
+    defer dst.Close()
+
+    if _, err := io.Copy(dst, src); err != nil {
+        os.Remove(dst.Name())
+        return "", err
+    }
+
+    return filename, nil
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "net/http"
+    "net/mail"
+    "time"
+)
+
+// paymentTimeout bounds every call to the payment provider made while the
+// customer waits.
+const paymentTimeout = 10 * time.Second
+
+type CheckoutResult struct {
+    Order   *Order
+    Payment *Payment
+    Message string
+}
+
+// Checkout places an order for the cart and authorizes its payment. The
+// customer ends up on the order complete page, on the provider's page for a
+// required action such as 3-D Secure, or back at a failure page with their
+// cart intact.
+func (h *Handler) Checkout(w http.ResponseWriter, r *http.Request) {
+    if len(h.cartItems(r)) == 0 {
+        http.Redirect(w, r, "/", http.StatusSeeOther)
+        return
+    }
+
+    attempt, err := h.placeOrder(r, r.FormValue("email"), r.FormValue("card_number"))
+    var checkoutErr *CheckoutError
+    if errors.As(err, &checkoutErr) {
+        http.Error(w, checkoutErr.Message, checkoutErr.Status)
+        return
+    }
+
+    if attempt.Status == PaymentRequiresAction {
+        http.Redirect(w, r, attempt.RedirectURL, http.StatusSeeOther)
+        return
+    }
+
+    h.finishCheckout(w, r, attempt)
+}
+
+// CheckoutError is why an order could not be placed, with the HTTP status
+// to answer with.
+type CheckoutError struct {
+    Status  int
+    Message string
+}
+
+func (e *CheckoutError) Error() string {
+    return e.Message
+}
+
+// placeOrder places an order for the session's cart and authorizes its
+// payment with cardNumber. Guests give an email address; signed-in users
+// order under their own. It returns the payment attempt, whose status says
+// what happens next, or a *CheckoutError.
+func (h *Handler) placeOrder(r *http.Request, email, cardNumber string) (*Payment, error) {
+    cartItems := h.cartItems(r)
+    if len(cartItems) == 0 {
+        return nil, checkoutFailed("empty_cart", &CheckoutError{http.StatusBadRequest, "The cart is empty"})
+    }
+
+    order := &Order{
+        Items: cartItems,
+    }
+
+    if user := currentUser(r); user != nil {
+        order.UserID = user.ID.String()
+        order.Email = user.Email
+    } else {
+        // Guest checkout only needs an email address to reach the customer.
+        address, err := mail.ParseAddress(email)
+        if err != nil {
+            return nil, checkoutFailed("invalid_email", &CheckoutError{http.StatusBadRequest, "A valid email address is required to place an order"})
+        }
+        order.Email = address.Address
+    }
+
+    err := h.Repo.Order.PlaceOrderWithItems(r.Context(), order)
+    var outOfStock *OutOfStockError
+    if errors.As(err, &outOfStock) {
+        return nil, checkoutFailed("out_of_stock", &CheckoutError{http.StatusConflict, "Sorry, " + outOfStock.Error() + ". Please update your cart."})
+    }
+    if err != nil {
+        logging.FromContext(r.Context()).Error("checkout: place order", "error", err)
+        return nil, checkoutFailed("internal_error", &CheckoutError{http.StatusInternalServerError, "Failed to place order"})
+    }
+    metrics.OrdersPlaced.Inc()
+
+    attempt := &Payment{
+        OrderID:  order.ID,
+        Provider: h.Payments.Name(),
+        Amount:   payment.Cents(order.Total),
+        Currency: payment.Currency,
+        Status:   PaymentProcessing,
+    }
+    if err := h.Repo.Payment.Create(r.Context(), attempt); err != nil {
+        logging.FromContext(r.Context()).Error("checkout: create payment", "error", err)
+        return nil, checkoutFailed("internal_error", &CheckoutError{http.StatusInternalServerError, "Failed to start payment"})
+    }
+
+    ctx, cancel := context.WithTimeout(r.Context(), paymentTimeout)
+    defer cancel()
+
+    intent, err := h.Payments.Authorize(ctx, payment.AuthorizeRequest{
+        IdempotencyKey: attempt.ID.String(),
+        Amount:         attempt.Amount,
+        Currency:       attempt.Currency,
+        Description:    "Order " + order.ID.String(),
+        Email:          order.Email,
+        CardNumber:     cardNumber,
+        ReturnURL:      absoluteURL(r, "/checkout/return/"+attempt.ID.String()),
+    })
+    if err := h.settlePayment(ctx, attempt, intent, err); err != nil {
+        logging.FromContext(r.Context()).Error("checkout: settle payment", "error", err)
+        return nil, checkoutFailed("internal_error", &CheckoutError{http.StatusInternalServerError, "Something went wrong, please try again"})
+    }
+
+    return attempt, nil
+}
+
+// checkoutFailed counts a checkout that failed for reason and returns err.
+func checkoutFailed(reason string, err *CheckoutError) error {
+    metrics.CheckoutFailures.WithLabelValues(reason).Inc()
+    return err
+}
+
+// CheckoutReturn is where the provider sends the customer back after a
+// required action.
+func (h *Handler) CheckoutReturn(w http.ResponseWriter, r *http.Request) {
+    attempt, ok := h.findPayment(w, r)
+    if !ok {
+        return
+    }
+
+    if attempt.Status == PaymentRequiresAction {
+        ctx, cancel := context.WithTimeout(r.Context(), paymentTimeout)
+        defer cancel()
+
+        intent, err := h.Payments.Get(ctx, attempt.ProviderRef)
+        if err := h.settlePayment(ctx, attempt, intent, err); err != nil {
+            serverError(w, r, err)
+            return
+        }
+    }
+
+    h.finishCheckout(w, r, attempt)
+}
+
+func (h *Handler) OrderCompletePage(w http.ResponseWriter, r *http.Request) {
+    attempt, ok := h.findPayment(w, r)
+    if !ok {
+        return
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), attempt.OrderID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    err = h.render(w, r, "orderComplete", CheckoutResult{Order: order, Payment: attempt})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+// finishCheckout empties the cart once the payment went through, or might
+// have, and shows the outcome. A failed payment leaves the cart alone so the
+// customer can try again.
+func (h *Handler) finishCheckout(w http.ResponseWriter, r *http.Request, attempt *Payment) {
+    if attempt.Status == PaymentFailed {
+        w.WriteHeader(http.StatusPaymentRequired)
+        err := h.render(w, r, "checkoutFailed", CheckoutResult{Payment: attempt, Message: attempt.LastError})
+        if err != nil {
+            serverError(w, r, err)
+        }
+        return
+    }
+
+    h.Carts.Clear(currentSession(r).ID)
+    http.Redirect(w, r, "/order-complete/"+attempt.ID.String(), http.StatusSeeOther)
+}
+
+func (h *Handler) findPayment(w http.ResponseWriter, r *http.Request) (*Payment, bool) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+        return nil, false
+    }
+
+    attempt, err := h.Repo.Payment.GetById(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.NotFound(w, r)
+        return nil, false
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return nil, false
+    }
+
+    return attempt, true
+}
+
+// settlePayment records what the provider said about attempt, capturing
+// authorized payments straight away. A paid order moves from Ordered to
+// Pending; a declined one is cancelled, which puts its items back in stock.
+// A timeout leaves the payment processing until the provider confirms it.
+//
+// The intent may come from a webhook, so it is fetched from the provider
+// again and must be for attempt, over its amount, before anything is
+// settled; otherwise attempt is left alone and an error returned.
+func (h *Handler) settlePayment(ctx context.Context, attempt *Payment, intent *payment.Intent, err error) error {
+    if err == nil {
+        intent, err = h.confirmIntent(ctx, attempt, intent.Ref)
+        if err != nil {
+            return err
+        }
+    }
+
+    if err != nil {
+        attempt.LastError = err.Error()
+        if payment.IsTimeout(err) {
+            attempt.Status = PaymentProcessing
+            return h.Repo.Payment.Update(ctx, attempt, "", "")
+        }
+        attempt.Status = PaymentFailed
+        metrics.CheckoutFailures.WithLabelValues("payment_error").Inc()
+        return h.Repo.Payment.Update(ctx, attempt, Cancel, "Payment failed")
+    }
+
+    attempt.ProviderRef = intent.Ref
+    attempt.RedirectURL = intent.RedirectURL
+    attempt.LastError = intent.DeclineReason
+
+    if intent.Status == payment.IntentAuthorized {
+        captured, err := h.Payments.Capture(ctx, intent.Ref, intent.Amount)
+        if errors.Is(err, payment.ErrInvalidState) {
+            // Someone else, e.g. the customer's return and the provider's
+            // webhook racing each other, got there first.
+            captured, err = h.Payments.Get(ctx, intent.Ref)
+        }
+        if err != nil {
+            // The money is reserved; cancelling the order voids it.
+            logging.FromContext(ctx).Error("payment: capture", "ref", intent.Ref, "error", err)
+            attempt.Status = PaymentAuthorized
+            attempt.LastError = err.Error()
+            return h.Repo.Payment.Update(ctx, attempt, "", "")
+        }
+        intent = captured
+    }
+
+    switch intent.Status {
+    case payment.IntentRequiresAction:
+        attempt.Status = PaymentRequiresAction
+        return h.Repo.Payment.Update(ctx, attempt, "", "")
+    case payment.IntentCaptured:
+        attempt.Status = PaymentPaid
+        if err := h.Repo.Payment.Update(ctx, attempt, Pending, "Payment received"); err != nil {
+            return err
+        }
+        metrics.Revenue.Add(float64(attempt.Amount) / 100)
+        return nil
+    case payment.IntentVoided:
+        attempt.Status = PaymentVoided
+        return h.Repo.Payment.Update(ctx, attempt, Cancel, "Payment voided")
+    default:
+        attempt.Status = PaymentFailed
+        metrics.CheckoutFailures.WithLabelValues("payment_declined").Inc()
+        return h.Repo.Payment.Update(ctx, attempt, Cancel, "Payment declined: "+intent.DeclineReason)
+    }
+}
+
+// confirmIntent fetches the intent ref from the provider and checks that it
+// is the one authorized for attempt.
+func (h *Handler) confirmIntent(ctx context.Context, attempt *Payment, ref string) (*payment.Intent, error) {
+    intent, err := h.Payments.Get(ctx, ref)
+    if err != nil {
+        return nil, fmt.Errorf("confirm intent %s: %w", ref, err)
+    }
+
+    if intent.Reference != attempt.ID.String() {
+        return nil, fmt.Errorf("intent %s belongs to payment %q, not %s", ref, intent.Reference, attempt.ID)
+    }
+    if intent.Amount != attempt.Amount || intent.Currency != attempt.Currency {
+        return nil, fmt.Errorf("intent %s is for %d %s, not %d %s", ref, intent.Amount, intent.Currency, attempt.Amount, attempt.Currency)
+    }
+    return intent, nil
+}
+
+// VoidPayments releases money reserved for a cancelled order that was never
+// captured.
+func (h *Handler) VoidPayments(ctx context.Context, orderID uuid.UUID) {
+    logger := logging.FromContext(ctx)
+    payments, err := h.Repo.Payment.FindByOrder(ctx, orderID)
+    if err != nil {
+        logger.Error("payment: find payments", "order_id", orderID, "error", err)
+        return
+    }
+
+    for _, attempt := range payments {
+        if attempt.Status != PaymentAuthorized && attempt.Status != PaymentRequiresAction {
+            continue
+        }
+
+        intent, err := h.Payments.Void(ctx, attempt.ProviderRef)
+        if err != nil {
+            logger.Error("payment: void", "ref", attempt.ProviderRef, "error", err)
+            continue
+        }
+
+        if err := h.settlePayment(ctx, &attempt, intent, nil); err != nil {
+            logger.Error("payment: record void", "ref", attempt.ProviderRef, "error", err)
+        }
+    }
+}
+
+// absoluteURL turns path into a URL on this site for use by other sites.
+func absoluteURL(r *http.Request, path string) string {
+    scheme := "http"
+    if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+        scheme = "https"
+    }
+    return scheme + "://" + r.Host + path
+}
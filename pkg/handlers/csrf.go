@@ -0,0 +1,94 @@
+package handlers
+
+import (
+    "crypto/subtle"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/graphql"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/health"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    "net/http"
+    "slices"
+)
+
+const (
+    csrfHeaderName = "X-CSRF-Token"
+    csrfFormField  = "csrf_token"
+)
+
+// serverEndpoints are called by other servers rather than browsers, such as
+// the payment provider, Prometheus or the orchestrator's probes. They
+// authenticate with their own signatures or tokens, if at all, so they get
+// no session and no CSRF check.
+var serverEndpoints = []string{
+    PaymentWebhookPath,
+    metrics.Path,
+    health.LivenessPath,
+    health.ReadinessPath,
+}
+
+func isServerEndpoint(r *http.Request) bool {
+    return slices.Contains(serverEndpoints, r.URL.Path)
+}
+
+// VerifyCSRF rejects state-changing requests that do not carry the CSRF
+// token of the current session. HTMX sends it in the X-CSRF-Token header
+// (see hx-headers on <body>); plain HTML forms send it as a hidden field.
+// Requests authenticated with a bearer token need none: browsers never send
+// one on their own, so it cannot be forged from another site.
+func (h *Handler) VerifyCSRF(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        if isServerEndpoint(r) || usesBearerToken(r) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        expected := csrfToken(r)
+        if expected == "" {
+            h.csrfFailure(w, r)
+            return
+        }
+
+        token := r.Header.Get(csrfHeaderName)
+        if token == "" {
+            token = r.PostFormValue(csrfFormField)
+        }
+
+        if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+            h.csrfFailure(w, r)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (h *Handler) csrfFailure(w http.ResponseWriter, r *http.Request) {
+    if isAPIRequest(r) {
+        writeAPIError(w, http.StatusForbidden, "csrf_failed", "Send the session's CSRF token in the "+csrfHeaderName+" header, or authenticate with a bearer token.")
+        return
+    }
+    if r.URL.Path == GraphQLPath {
+        writeJSON(w, http.StatusForbidden, &graphql.Response{Errors: []*graphql.Error{{Message: "send the session's CSRF token in the " + csrfHeaderName + " header, or authenticate with a bearer token"}}})
+        return
+    }
+
+    if r.Header.Get("HX-Request") == "true" {
+        // Show the error on top of the page rather than in whatever element
+        // the request was targeting.
+        w.Header().Set("HX-Retarget", "body")
+        w.Header().Set("HX-Reswap", "afterbegin")
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.WriteHeader(http.StatusForbidden)
+
+    err := h.render(w, r, "csrfError", nil)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
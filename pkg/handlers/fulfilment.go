@@ -0,0 +1,119 @@
+package handlers
+
+import (
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/fulfilment"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// fulfilmentBatchLimit caps how many orders are printed at once.
+const fulfilmentBatchLimit = 200
+
+type FulfilmentData struct {
+    Slips     []fulfilment.Slip
+    Skipped   []Order
+    PickList  *fulfilment.PickList
+    Generated time.Time
+}
+
+// PackingSlips prints a packing slip for each selected order, as HTML or,
+// with format=pdf, as a PDF.
+func (h *Handler) PackingSlips(w http.ResponseWriter, r *http.Request) {
+    data, err := h.fulfilmentData(r)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if r.URL.Query().Get("format") == "pdf" && len(data.Slips) > 0 {
+        doc, err := fulfilment.PackingSlipsPDF(data.Slips)
+        if err != nil {
+            serverError(w, r, err)
+            return
+        }
+        servePDF(w, "inline", "packing-slips-"+data.Generated.Format("20060102-1504")+".pdf", doc)
+        return
+    }
+
+    err = h.render(w, r, "packingSlips", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+// PickList prints the products to pick for the selected orders, as HTML or,
+// with format=pdf, as a PDF.
+func (h *Handler) PickList(w http.ResponseWriter, r *http.Request) {
+    data, err := h.fulfilmentData(r)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if r.URL.Query().Get("format") == "pdf" && len(data.Slips) > 0 {
+        doc, err := fulfilment.PickListPDF(data.PickList, data.Generated)
+        if err != nil {
+            serverError(w, r, err)
+            return
+        }
+        servePDF(w, "inline", "pick-list-"+data.Generated.Format("20060102-1504")+".pdf", doc)
+        return
+    }
+
+    err = h.render(w, r, "pickList", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+// fulfilmentData loads the orders picked in the order table, given as
+// "order" IDs, or with all=pending every order waiting to ship. Only orders
+// waiting to ship are printed; any others are reported as skipped.
+func (h *Handler) fulfilmentData(r *http.Request) (*FulfilmentData, error) {
+    var ids []uuid.UUID
+    var err error
+
+    if r.URL.Query().Get("all") == string(Pending) {
+        ids, err = h.Repo.Order.FindIDsByStatus(r.Context(), Pending, fulfilmentBatchLimit)
+        if err != nil {
+            return nil, err
+        }
+    } else {
+        for _, value := range r.URL.Query()["order"] {
+            if id, err := uuid.Parse(value); err == nil && len(ids) < fulfilmentBatchLimit {
+                ids = append(ids, id)
+            }
+        }
+    }
+
+    orders, err := h.Repo.Order.GetOrdersWithProduct(r.Context(), ids)
+    if err != nil {
+        return nil, err
+    }
+
+    var pending []Order
+    data := &FulfilmentData{Generated: time.Now()}
+    for _, order := range orders {
+        if order.Status == Pending {
+            pending = append(pending, order)
+        } else {
+            data.Skipped = append(data.Skipped, order)
+        }
+    }
+    data.Slips = fulfilment.NewSlips(pending)
+    data.PickList = fulfilment.NewPickList(pending)
+
+    return data, nil
+}
+
+// servePDF sends doc, shown in the browser when disposition is "inline" and
+// downloaded when it is "attachment".
+func servePDF(w http.ResponseWriter, disposition, filename string, doc []byte) {
+    w.Header().Set("Content-Type", "application/pdf")
+    w.Header().Set("Content-Disposition", disposition+`; filename="`+filename+`"`)
+    w.Header().Set("Content-Length", strconv.Itoa(len(doc)))
+    w.Write(doc)
+}
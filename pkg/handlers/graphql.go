@@ -0,0 +1,404 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/graphql"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "net/http"
+)
+
+// graphQLContext is what the resolvers of one GraphQL request share: the
+// HTTP request and response, for the session, and the loaders that batch lookups across
+// the fields of the response.
+type graphQLContext struct {
+    w          http.ResponseWriter
+    r          *http.Request
+    products   *graphql.Loader[uuid.UUID, *Product]
+    orderItems *graphql.Loader[uuid.UUID, []OrderItem]
+}
+
+type graphQLContextKey struct{}
+
+// GraphQLPath serves the storefront GraphQL API.
+const GraphQLPath = "/graphql"
+
+// GraphQL serves the storefront GraphQL API: the catalogue, the session's
+// cart and the customer's orders, with mutations for the cart and checkout.
+// Clients authenticate like the JSON API, with a session cookie and CSRF
+// token or a bearer token.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+    gc := &graphQLContext{
+        w: w,
+        r: r,
+        products: graphql.NewLoader(func(ids []uuid.UUID) (map[uuid.UUID]*Product, error) {
+            products, err := h.Repo.Product.GetByIds(r.Context(), ids)
+            if err != nil {
+                return nil, err
+            }
+            byID := make(map[uuid.UUID]*Product, len(products))
+            for i := range products {
+                byID[products[i].ID] = &products[i]
+            }
+            return byID, nil
+        }),
+        orderItems: graphql.NewLoader(func(ids []uuid.UUID) (map[uuid.UUID][]OrderItem, error) {
+            return h.Repo.Order.FindItems(r.Context(), ids)
+        }),
+    }
+
+    h.Graph.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), graphQLContextKey{}, gc)))
+}
+
+// GraphQLSchema serves the schema in SDL, for client code generators.
+func (h *Handler) GraphQLSchema(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Write([]byte(h.Graph.SDL()))
+}
+
+func graphQLRequest(p graphql.ResolveParams) *graphQLContext {
+    return p.Context.Value(graphQLContextKey{}).(*graphQLContext)
+}
+
+// graphQLError turns the errors of the shared API helpers into messages;
+// server errors are logged instead of shown to clients.
+func graphQLError(ctx context.Context, err error) error {
+    var apiErr *APIError
+    if errors.As(err, &apiErr) && apiErr.Status != http.StatusInternalServerError {
+        return errors.New(apiErr.Message)
+    }
+    logging.FromContext(ctx).Error("graphql: resolve", "error", err)
+    return errors.New("internal error")
+}
+
+func newGraphQLSchema(h *Handler) *graphql.Schema {
+    nonNull := func(t graphql.Type) graphql.Type { return &graphql.NonNull{Of: t} }
+    listOf := func(t graphql.Type) graphql.Type { return &graphql.NonNull{Of: &graphql.List{Of: nonNull(t)}} }
+
+    orderStatus := &graphql.Enum{Name: "OrderStatus"}
+    for _, status := range OrderStatuses {
+        orderStatus.Values = append(orderStatus.Values, string(status))
+    }
+    paymentStatus := &graphql.Enum{Name: "PaymentStatus", Values: []string{
+        string(PaymentUnpaid), string(PaymentRequiresAction), string(PaymentProcessing), string(PaymentAuthorized),
+        string(PaymentPaid), string(PaymentFailed), string(PaymentVoided), string(PaymentPartlyRefunded), string(PaymentRefunded),
+    }}
+
+    product := &graphql.Object{Name: "Product", Fields: []*graphql.FieldDef{
+        {Name: "id", Type: nonNull(graphql.ID)},
+        {Name: "sku", Type: nonNull(graphql.String)},
+        {Name: "name", Type: nonNull(graphql.String)},
+        {Name: "price", Type: nonNull(graphql.Float)},
+        {Name: "description", Type: nonNull(graphql.String)},
+        {Name: "image", Type: nonNull(graphql.String), Description: "The image's path under /static/uploads."},
+        {Name: "stock", Type: graphql.Int, Description: "Null when the stock is not tracked."},
+        {Name: "createdDate", Type: nonNull(graphql.Time)},
+        {Name: "modifiedDate", Type: nonNull(graphql.Time)},
+    }}
+
+    orderItem := &graphql.Object{Name: "OrderItem", Fields: []*graphql.FieldDef{
+        {Name: "productId", Type: nonNull(graphql.ID)},
+        {Name: "name", Type: nonNull(graphql.String), Description: "The product's name at checkout; empty for cart items."},
+        {Name: "unitPrice", Type: nonNull(graphql.Float), Description: "The unit price at checkout; 0 for cart items."},
+        {Name: "quantity", Type: nonNull(graphql.Int)},
+        {Name: "refundedQuantity", Type: nonNull(graphql.Int)},
+        {Name: "cost", Type: nonNull(graphql.Float)},
+        {
+            Name:        "product",
+            Description: "The product as it is now; null if it has been deleted.",
+            Type:        product,
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                item := p.Source.(OrderItem)
+                if item.Product != nil {
+                    return item.Product, nil
+                }
+                return graphQLRequest(p).products.Load(item.ProductID), nil
+            },
+        },
+    }}
+
+    order := &graphql.Object{Name: "Order", Fields: []*graphql.FieldDef{
+        {Name: "id", Type: nonNull(graphql.ID)},
+        {Name: "email", Type: nonNull(graphql.String)},
+        {Name: "status", Type: nonNull(orderStatus)},
+        {Name: "paymentStatus", Type: nonNull(paymentStatus)},
+        {Name: "date", Type: nonNull(graphql.Time)},
+        {Name: "subtotal", Type: nonNull(graphql.Float)},
+        {Name: "refunded", Type: nonNull(graphql.Float)},
+        {Name: "total", Type: nonNull(graphql.Float)},
+        {
+            Name: "items",
+            Type: listOf(orderItem),
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                order := p.Source.(*Order)
+                if order.Items != nil {
+                    return order.Items, nil
+                }
+                return graphQLRequest(p).orderItems.Load(order.ID), nil
+            },
+        },
+    }}
+
+    cart := &graphql.Object{Name: "Cart", Fields: []*graphql.FieldDef{
+        {Name: "items", Type: listOf(orderItem)},
+        {Name: "total", Type: nonNull(graphql.Float)},
+    }}
+
+    pagination := &graphql.Object{Name: "Pagination", Fields: []*graphql.FieldDef{
+        {Name: "page", Type: nonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (any, error) {
+            return p.Source.(Pagination).CurrentPage, nil
+        }},
+        {Name: "size", Type: nonNull(graphql.Int)},
+        {Name: "totalPages", Type: nonNull(graphql.Int)},
+        {Name: "totalItems", Type: nonNull(graphql.Int)},
+    }}
+
+    productPage := &graphql.Object{Name: "ProductPage", Fields: []*graphql.FieldDef{
+        {Name: "items", Type: listOf(product)},
+        {Name: "pagination", Type: nonNull(pagination)},
+    }}
+
+    orderPage := &graphql.Object{Name: "OrderPage", Fields: []*graphql.FieldDef{
+        {Name: "items", Type: listOf(order)},
+        {Name: "pagination", Type: nonNull(pagination)},
+    }}
+
+    payment := &graphql.Object{Name: "Payment", Fields: []*graphql.FieldDef{
+        {Name: "id", Type: nonNull(graphql.ID)},
+        {Name: "provider", Type: nonNull(graphql.String)},
+        {Name: "amount", Type: nonNull(graphql.Int), Description: "The amount in cents."},
+        {Name: "currency", Type: nonNull(graphql.String)},
+        {Name: "status", Type: nonNull(paymentStatus)},
+        {Name: "lastError", Type: nonNull(graphql.String)},
+    }}
+
+    checkoutResult := &graphql.Object{Name: "CheckoutResult", Fields: []*graphql.FieldDef{
+        {Name: "order", Type: nonNull(order)},
+        {Name: "payment", Type: nonNull(payment)},
+        {Name: "redirectUrl", Type: graphql.String, Description: "Where to send the customer to finish a payment that requires action.", Resolve: func(p graphql.ResolveParams) (any, error) {
+            if url := p.Source.(*CheckoutResponse).RedirectURL; url != "" {
+                return url, nil
+            }
+            return nil, nil
+        }},
+    }}
+
+    user := &graphql.Object{Name: "User", Fields: []*graphql.FieldDef{
+        {Name: "id", Type: nonNull(graphql.ID)},
+        {Name: "email", Type: nonNull(graphql.String)},
+        {Name: "name", Type: nonNull(graphql.String)},
+    }}
+
+    pageArgs := []*graphql.ArgDef{
+        {Name: "page", Type: graphql.Int, Default: 1},
+        {Name: "size", Type: graphql.Int, Default: defaultPageSize},
+    }
+
+    query := &graphql.Object{Name: "Query", Fields: []*graphql.FieldDef{
+        {
+            Name:        "products",
+            Description: "A page of the catalogue, oldest products first.",
+            Type:        nonNull(productPage),
+            Args:        pageArgs,
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                page, size := graphQLPage(p)
+                products, err := h.Repo.Product.Find(p.Context, "", page, size)
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+                count, err := h.Repo.Product.Count(p.Context)
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+                return map[string]any{"items": products, "pagination": newPagination(page, size, count)}, nil
+            },
+        },
+        {
+            Name: "product",
+            Type: product,
+            Args: []*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}},
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                id, err := uuid.Parse(p.Args["id"].(string))
+                if err != nil {
+                    return nil, nil
+                }
+                return graphQLRequest(p).products.Load(id), nil
+            },
+        },
+        {
+            Name:        "cart",
+            Description: "The session's cart.",
+            Type:        nonNull(cart),
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                return graphQLCart(h.cartItems(graphQLRequest(p).r)), nil
+            },
+        },
+        {
+            Name:        "orders",
+            Description: "A page of the signed-in customer's orders, newest first.",
+            Type:        nonNull(orderPage),
+            Args:        pageArgs,
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                user := currentUser(graphQLRequest(p).r)
+                if user == nil {
+                    return nil, errors.New("sign in first")
+                }
+
+                page, size := graphQLPage(p)
+                filter := repository.OrderFilter{UserID: uuid.NullUUID{UUID: user.ID, Valid: true}}
+
+                orders, err := h.Repo.Order.Find(p.Context, filter, page, size)
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+
+                count, err := h.Repo.Order.Count(p.Context, filter)
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+
+                items := make([]*Order, len(orders))
+                for i := range orders {
+                    items[i] = &orders[i]
+                }
+                return map[string]any{"items": items, "pagination": newPagination(page, size, count)}, nil
+            },
+        },
+        {
+            Name:        "order",
+            Description: "One of the signed-in customer's orders, or any order for staff who may view orders.",
+            Type:        order,
+            Args:        []*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}},
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                user := currentUser(graphQLRequest(p).r)
+                if user == nil {
+                    return nil, errors.New("sign in first")
+                }
+                id, err := uuid.Parse(p.Args["id"].(string))
+                if err != nil {
+                    return nil, nil
+                }
+
+                order, err := h.Repo.Order.GetOrderWithProduct(p.Context, id)
+                if errors.Is(err, sql.ErrNoRows) || (err == nil && order.UserID != user.ID.String() && !auth.Can(user.Role, auth.ViewOrders)) {
+                    return nil, nil
+                }
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+                return order, nil
+            },
+        },
+        {
+            Name:        "me",
+            Description: "The signed-in user; null for guests.",
+            Type:        user,
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                return currentUser(graphQLRequest(p).r), nil
+            },
+        },
+    }}
+
+    cartMutation := func(p graphql.ResolveParams, quantity func(current int) int) (any, error) {
+        gc := graphQLRequest(p)
+        r := gc.r
+        productID, err := uuid.Parse(p.Args["productId"].(string))
+        if err != nil {
+            return nil, errors.New("invalid product ID")
+        }
+
+        current := 0
+        cartItems := h.cartItems(r)
+        if index := getCartItem(cartItems, productID); index != -1 {
+            current = cartItems[index].Quantity
+        }
+
+        cartItems, err = h.updateCart(gc.w, r, productID, quantity(current))
+        if err != nil {
+            return nil, graphQLError(p.Context, err)
+        }
+        return graphQLCart(cartItems), nil
+    }
+
+    mutation := &graphql.Object{Name: "Mutation", Fields: []*graphql.FieldDef{
+        {
+            Name:        "addToCart",
+            Description: "Adds quantity units of a product to the cart.",
+            Type:        nonNull(cart),
+            Args: []*graphql.ArgDef{
+                {Name: "productId", Type: nonNull(graphql.ID)},
+                {Name: "quantity", Type: graphql.Int, Default: 1},
+            },
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                quantity := p.Args["quantity"].(int)
+                if quantity < 1 {
+                    return nil, errors.New("the quantity must be at least 1")
+                }
+                return cartMutation(p, func(current int) int { return current + quantity })
+            },
+        },
+        {
+            Name:        "updateCartItem",
+            Description: "Sets how many units of a product are in the cart; 0 removes it.",
+            Type:        nonNull(cart),
+            Args: []*graphql.ArgDef{
+                {Name: "productId", Type: nonNull(graphql.ID)},
+                {Name: "quantity", Type: nonNull(graphql.Int)},
+            },
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                quantity := p.Args["quantity"].(int)
+                if quantity < 0 {
+                    return nil, errors.New("give a quantity of 0 or more")
+                }
+                return cartMutation(p, func(int) int { return quantity })
+            },
+        },
+        {
+            Name: "removeFromCart",
+            Type: nonNull(cart),
+            Args: []*graphql.ArgDef{{Name: "productId", Type: nonNull(graphql.ID)}},
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                return cartMutation(p, func(int) int { return 0 })
+            },
+        },
+        {
+            Name:        "checkout",
+            Description: "Places an order for the cart, like the checkout form. The cart is kept if the payment fails.",
+            Type:        nonNull(checkoutResult),
+            Args: []*graphql.ArgDef{
+                {Name: "email", Type: nonNull(graphql.String)},
+                {Name: "cardNumber", Type: nonNull(graphql.String)},
+            },
+            Resolve: func(p graphql.ResolveParams) (any, error) {
+                response, err := h.checkout(graphQLRequest(p).r, p.Args["email"].(string), p.Args["cardNumber"].(string))
+                if err != nil {
+                    return nil, graphQLError(p.Context, err)
+                }
+                return response, nil
+            },
+        },
+    }}
+
+    return &graphql.Schema{Query: query, Mutation: mutation}
+}
+
+func graphQLPage(p graphql.ResolveParams) (int, int) {
+    page, _ := p.Args["page"].(int)
+    size, _ := p.Args["size"].(int)
+    if page < 1 {
+        page = 1
+    }
+    if size <= 0 {
+        size = defaultPageSize
+    }
+    return page, min(size, maxPageSize)
+}
+
+func graphQLCart(cartItems []OrderItem) CartResponse {
+    return CartResponse{Items: cartItems, Total: getCartTotal(cartItems)}
+}
@@ -6,20 +6,27 @@ import (
     "github.com/bxcodec/faker/v3"
     "github.com/google/uuid"
     "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/catalog"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/graphql"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/invoice"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
     . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
     "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/tracing"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/webhook"
     "golang.org/x/text/cases"
     "golang.org/x/text/language"
     "html/template"
-    "io"
-    "log"
-    "math"
     "math/rand"
     "net/http"
     "os"
     "path/filepath"
     "slices"
-    "strconv"
+    "strings"
+    "sync"
     "time"
 )
 
@@ -27,14 +34,37 @@ type Handler struct {
     Repo             *repository.Repository
     Tmpl             *template.Template
     ImageStoragePath string
+    // ImportPath is a server folder product imports may take images from.
+    ImportPath       string
+    Carts            *CartStore
+    Webhooks         *webhook.Dispatcher
+    Payments         payment.PaymentProvider
+    Invoices         *invoice.Invoicer
+    // Graph is the storefront GraphQL schema served at /graphql.
+    Graph            *graphql.Schema
+
+    templates templateSets
 }
 
-func NewHandler(repo *repository.Repository, tmpl *template.Template, fp string) *Handler {
-    return &Handler{
+func NewHandler(repo *repository.Repository, tmpl *template.Template, fp string, webhooks *webhook.Dispatcher, payments payment.PaymentProvider, invoices *invoice.Invoicer) *Handler {
+    h := &Handler{
         Repo:             repo,
         Tmpl:             tmpl,
         ImageStoragePath: fp,
+        Carts:            NewCartStore(),
+        Webhooks:         webhooks,
+        Payments:         payments,
+        Invoices:         invoices,
     }
+    h.Graph = newGraphQLSchema(h)
+    return h
+}
+
+// serverError logs err with the request and fails it with a 500, without
+// telling the client what went wrong.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+    logging.FromContext(r.Context()).Error("request failed", "error", err)
+    http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 /*** Admin actions ***/
@@ -58,15 +88,17 @@ func (h *Handler) SeedProduct(w http.ResponseWriter, r *http.Request) {
             return
         }
 
+        stock := rnd.Intn(100)
         product := Product{
             ID:          id,
             Name:        productName,
             Price:       float64(rnd.Intn(10000)) / 100,
             Description: faker.Sentence(),
             Image:       "placeholder.jpg",
+            Stock:       &stock,
         }
 
-        _, err = h.Repo.Product.Create(&product)
+        _, err = h.Repo.Product.Create(r.Context(), &product)
         if err != nil {
             http.Error(w, fmt.Sprintf("Error creating product %s: %v", productName, err), http.StatusInternalServerError)
             return
@@ -78,75 +110,49 @@ func (h *Handler) SeedProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ProductsPage(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "products", nil)
+    err := h.render(w, r, "products", nil)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
 
 func (h *Handler) AllProductsView(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "allProducts", nil)
+    err := h.render(w, r, "allProducts", nil)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
 
 func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
-    pageStr := r.URL.Query().Get("page")
-    sizeStr := r.URL.Query().Get("size")
-
-    page, err := strconv.Atoi(pageStr)
-    if err != nil || page < 1 {
-        page = 1
-    }
+    page, size := pageParams(r)
 
-    size, err := strconv.Atoi(sizeStr)
-    if err != nil || size <= 0 {
-        size = 10
-    }
-
-    products, err := h.Repo.Product.Find("", page, size)
+    products, err := h.Repo.Product.Find(r.Context(), "", page, size)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    count, err := h.Repo.Product.Count()
+    count, err := h.Repo.Product.Count(r.Context())
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    totalPages := int(math.Ceil(float64(count) / float64(size)))
-    previousPage := page - 1
-    nextPage := page + 1
-    pageRange := makeRange(1, totalPages)
-
     data := struct {
-        Products     []Product
-        CurrentPage  int
-        TotalPages   int
-        Size         int
-        PreviousPage int
-        NextPage     int
-        PageRange    []int
+        Products []Product
+        Pagination
     }{
-        Products:     products,
-        CurrentPage:  page,
-        TotalPages:   totalPages,
-        Size:         size,
-        PreviousPage: previousPage,
-        NextPage:     nextPage,
-        PageRange:    pageRange,
+        Products:   products,
+        Pagination: newPagination(page, size, count),
     }
 
     //time.Sleep(3 * time.Second)
 
-    err = h.Tmpl.ExecuteTemplate(w, "productRows", data)
+    err = h.render(w, r, "productRows", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -159,23 +165,23 @@ func (h *Handler) ProductView(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    product, err := h.Repo.Product.GetById(id)
+    product, err := h.Repo.Product.GetById(r.Context(), id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "viewProduct", product)
+    err = h.render(w, r, "viewProduct", product)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
 
 func (h *Handler) CreateProductView(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "createProduct", nil)
+    err := h.render(w, r, "createProduct", nil)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -186,29 +192,13 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
     if err := r.ParseMultipartForm(10 << 20); err != nil {
         http.Error(w, "Failed to parse form", http.StatusInternalServerError)
-        log.Println(err)
+        logging.FromContext(r.Context()).Warn("parse form", "error", err)
         return
     }
 
-    nameValue := r.FormValue("name")
-    priceValue := r.FormValue("price")
-    descriptionValue := r.FormValue("description")
-
-    if nameValue == "" {
-        errorMessages = append(errorMessages, "Product name is required.")
-    }
-
-    if priceValue == "" {
-        errorMessages = append(errorMessages, "Product price is required.")
-    }
-
-    priceParsed, err := strconv.ParseFloat(priceValue, 64)
-    if err != nil {
-        errorMessages = append(errorMessages, "Invalid price value.")
-    }
-
+    product, errorMessages := catalog.ParseProduct(r.FormValue("name"), r.FormValue("sku"), r.FormValue("price"), r.FormValue("description"), r.FormValue("stock"))
     if len(errorMessages) > 0 {
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     }
 
@@ -218,27 +208,15 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
     file, handler, err := r.FormFile("image")
     if err != nil && !errors.Is(err, http.ErrMissingFile) {
         errorMessages = append(errorMessages, "Error retrieving the image")
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     } else if !errors.Is(err, http.ErrMissingFile) {
         defer file.Close()
 
-        nano := strconv.FormatInt(time.Now().UnixNano(), 10)
-        filename = nano + filepath.Ext(handler.Filename)
-
-        filePath := filepath.Join(h.ImageStoragePath, filename)
-
-        dst, err := os.Create(filePath)
+        filename, err = h.saveImage(file, handler.Filename)
         if err != nil {
             errorMessages = append(errorMessages, "Error saving new file: "+err.Error())
-            sendMessage(w, h.Tmpl, errorMessages, nil)
-            return
-        }
-        defer dst.Close()
-
-        if _, err := io.Copy(dst, file); err != nil {
-            errorMessages = append(errorMessages, "Error saving new file: "+err.Error())
-            sendMessage(w, h.Tmpl, errorMessages, nil)
+            h.sendMessage(w, r, errorMessages, nil)
             return
         }
     }
@@ -246,26 +224,21 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
     id, err := uuid.NewV7()
     if err != nil {
         errorMessages = append(errorMessages, "Error get new id: "+err.Error())
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     }
 
-    product := &Product{
-        ID:          id,
-        Name:        nameValue,
-        Price:       priceParsed,
-        Description: descriptionValue,
-        Image:       filename,
-    }
+    product.ID = id
+    product.Image = filename
 
-    product, err = h.Repo.Product.Create(product)
+    product, err = h.Repo.Product.Create(r.Context(), product)
     if err != nil {
         errorMessages = append(errorMessages, "Failed to create product: "+err.Error())
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     }
 
-    sendMessage(w, h.Tmpl, nil, product)
+    h.sendMessage(w, r, nil, product)
 }
 
 func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
@@ -276,13 +249,13 @@ func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    product, err := h.Repo.Product.GetById(id)
+    product, err := h.Repo.Product.GetById(r.Context(), id)
     if err != nil {
         http.Error(w, "Product not found", http.StatusInternalServerError)
         return
     }
 
-    err = h.Repo.Product.Delete(id)
+    err = h.Repo.Product.Delete(r.Context(), id)
     if err != nil {
         http.Error(w, "Error deleting product", http.StatusInternalServerError)
         return
@@ -297,9 +270,9 @@ func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "allProducts", nil)
+    err = h.render(w, r, "allProducts", nil)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -312,15 +285,15 @@ func (h *Handler) EditProductView(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    product, err := h.Repo.Product.GetById(id)
+    product, err := h.Repo.Product.GetById(r.Context(), id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "editProduct", product)
+    err = h.render(w, r, "editProduct", product)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -338,90 +311,66 @@ func (h *Handler) EditProduct(w http.ResponseWriter, r *http.Request) {
 
     if err := r.ParseMultipartForm(10 << 20); err != nil {
         http.Error(w, "Failed to parse form", http.StatusInternalServerError)
-        log.Println(err)
+        logging.FromContext(r.Context()).Warn("parse form", "error", err)
         return
     }
 
-    nameValue := r.FormValue("name")
-    priceValue := r.FormValue("price")
-    descriptionValue := r.FormValue("description")
-
-    if nameValue == "" {
-        errorMessages = append(errorMessages, "Product name is required.")
-    }
-
-    if priceValue == "" {
-        errorMessages = append(errorMessages, "Product price is required.")
-    }
-
-    priceParsed, err := strconv.ParseFloat(priceValue, 64)
-    if err != nil {
-        errorMessages = append(errorMessages, "Invalid price value.")
-    }
-
+    product, errorMessages := catalog.ParseProduct(r.FormValue("name"), r.FormValue("sku"), r.FormValue("price"), r.FormValue("description"), r.FormValue("stock"))
     if len(errorMessages) > 0 {
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     }
 
-    product := &Product{
-        Name:        nameValue,
-        Price:       priceParsed,
-        Description: descriptionValue,
-    }
-
-    err = h.Repo.Product.Update(id, product)
+    err = h.Repo.Product.Update(r.Context(), id, product)
     if err != nil {
         errorMessages = append(errorMessages, "Failed to update product: "+err.Error())
-        sendMessage(w, h.Tmpl, errorMessages, nil)
+        h.sendMessage(w, r, errorMessages, nil)
         return
     }
 
-    sendMessage(w, h.Tmpl, nil, product)
+    h.sendMessage(w, r, nil, product)
 }
 
 /*** End Admin actions ***/
 
 /*** User actions ***/
-var (
-    currentCartOrderId uuid.UUID
-    cartItems          []OrderItem
-)
 
 func (h *Handler) ShoppingHomePage(w http.ResponseWriter, r *http.Request) {
     data := struct {
         OrderItems []OrderItem
     }{
-        OrderItems: cartItems,
+        OrderItems: h.cartItems(r),
     }
 
-    err := h.Tmpl.ExecuteTemplate(w, "homepage", data)
+    err := h.render(w, r, "homepage", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
 func (h *Handler) ShoppingItemView(w http.ResponseWriter, r *http.Request) {
     time.Sleep(1 * time.Second)
 
-    products, _ := h.Repo.Product.Find("", 1, -1)
-    err := h.Tmpl.ExecuteTemplate(w, "shoppingItems", products)
+    products, _ := h.Repo.Product.Find(r.Context(), "", 1, -1)
+    err := h.render(w, r, "shoppingItems", products)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
 func (h *Handler) CartItemView(w http.ResponseWriter, r *http.Request) {
+    cartItems := h.cartItems(r)
+
     data := &Cart{
         Items:     cartItems,
         Message:   "",
         AlertType: "",
-        TotalCost: getCartTotal(),
+        TotalCost: getCartTotal(cartItems),
     }
 
-    err := h.Tmpl.ExecuteTemplate(w, "cartItems", data)
+    err := h.render(w, r, "cartItems", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
@@ -433,13 +382,17 @@ func (h *Handler) AddCartItem(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if currentCartOrderId == uuid.Nil {
-        currentCartOrderId, _ = uuid.NewV7()
+    session, err := h.ensureSession(w, r)
+    if err != nil {
+        serverError(w, r, err)
+        return
     }
 
     cartMessage := ""
     cartAlert := ""
 
+    cartItems := h.Carts.Items(session.ID)
+
     productExists := false
     for index, item := range cartItems {
         if item.ProductID == id {
@@ -454,14 +407,13 @@ func (h *Handler) AddCartItem(w http.ResponseWriter, r *http.Request) {
     }
 
     if !productExists {
-        product, err := h.Repo.Product.GetById(id)
+        product, err := h.Repo.Product.GetById(r.Context(), id)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusInternalServerError)
+            serverError(w, r, err)
             return
         }
 
         cartItems = append(cartItems, OrderItem{
-            OrderID:   currentCartOrderId,
             ProductID: id,
             Product:   product,
             Quantity:  1,
@@ -472,23 +424,26 @@ func (h *Handler) AddCartItem(w http.ResponseWriter, r *http.Request) {
         cartAlert = "success"
     }
 
+    h.Carts.Set(session.ID, cartItems)
+    metrics.CartAdditions.Inc()
+
     data := &Cart{
         Items:     cartItems,
         Message:   cartMessage,
         AlertType: cartAlert,
-        TotalCost: getCartTotal(),
+        TotalCost: getCartTotal(cartItems),
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "cartItems", data)
+    err = h.render(w, r, "cartItems", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
 func (h *Handler) ShoppingCartView(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "shoppingCart", cartItems)
+    err := h.render(w, r, "shoppingCart", h.cartItems(r))
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
@@ -500,11 +455,14 @@ func (h *Handler) ShoppingCartUpdate(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    index, item := getCartItem(id)
+    cartItems := h.cartItems(r)
+
+    index := getCartItem(cartItems, id)
     if index == -1 {
         http.Error(w, "Product not found in order", http.StatusBadRequest)
         return
     }
+    item := &cartItems[index]
 
     refreshCart := false
 
@@ -513,6 +471,7 @@ func (h *Handler) ShoppingCartUpdate(w http.ResponseWriter, r *http.Request) {
     case "add":
         item.Quantity++
         item.Cost = item.Product.Price * float64(item.Quantity)
+        metrics.CartAdditions.Inc()
         break
     case "subtract":
         if item.Quantity > 1 {
@@ -531,60 +490,37 @@ func (h *Handler) ShoppingCartUpdate(w http.ResponseWriter, r *http.Request) {
         break
     }
 
+    h.Carts.Set(currentSession(r).ID, cartItems)
+
     data := &Cart{
         Items:       cartItems,
-        TotalCost:   getCartTotal(),
+        TotalCost:   getCartTotal(cartItems),
         AlertType:   "info",
         RefreshCart: refreshCart,
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "updateShoppingCart", data)
+    err = h.render(w, r, "updateShoppingCart", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
-func (h *Handler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
-    if len(cartItems) == 0 {
-        http.Redirect(w, r, "/", http.StatusSeeOther)
-        return
-    }
-
-    err := h.Repo.Order.PlaceOrderWithItems(cartItems)
-    if err != nil {
-        http.Error(w, "Failed to place order", http.StatusInternalServerError)
-        return
-    }
-
-    orderItems := cartItems
-    orderTotal := getCartTotal()
-
-    // Empty the cart
-    cartItems = []OrderItem{}
-    currentCartOrderId = uuid.Nil
-
-    data := &Cart{
-        Items:     orderItems,
-        TotalCost: orderTotal,
-    }
-
-    err = h.Tmpl.ExecuteTemplate(w, "orderComplete", data)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-    }
+// OrderTableData fills in the filters above the order table.
+type OrderTableData struct {
+    Statuses []OrderStatus
 }
 
 func (h *Handler) ManageOrdersPage(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "orders", nil)
+    err := h.render(w, r, "orders", OrderTableData{Statuses: OrderStatuses})
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
 func (h *Handler) OrderTableView(w http.ResponseWriter, r *http.Request) {
-    err := h.Tmpl.ExecuteTemplate(w, "orderTable", nil)
+    err := h.render(w, r, "orderTable", OrderTableData{Statuses: OrderStatuses})
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -592,57 +528,32 @@ func (h *Handler) OrderTableView(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) OrderTableRowsView(w http.ResponseWriter, r *http.Request) {
     time.Sleep(1 * time.Second)
 
-    pageStr := r.URL.Query().Get("page")
-    sizeStr := r.URL.Query().Get("size")
-
-    page, err := strconv.Atoi(pageStr)
-    if err != nil || page < 1 {
-        page = 1
-    }
-
-    size, err := strconv.Atoi(sizeStr)
-    if err != nil || size <= 0 {
-        size = 10
-    }
+    page, size := pageParams(r)
 
-    orders, err := h.Repo.Order.Find(page, size)
+    filter := orderFilter(r)
+    orders, err := h.Repo.Order.Find(r.Context(), filter, page, size)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    count, err := h.Repo.Order.Count()
+    count, err := h.Repo.Order.Count(r.Context(), filter)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    totalPages := int(math.Ceil(float64(count) / float64(size)))
-    previousPage := page - 1
-    nextPage := page + 1
-    pageRange := makeRange(1, totalPages)
-
     data := struct {
-        Orders       []Order
-        CurrentPage  int
-        TotalPages   int
-        Size         int
-        PreviousPage int
-        NextPage     int
-        PageRange    []int
+        Orders []Order
+        Pagination
     }{
-        Orders:       orders,
-        CurrentPage:  page,
-        TotalPages:   totalPages,
-        Size:         size,
-        PreviousPage: previousPage,
-        NextPage:     nextPage,
-        PageRange:    pageRange,
+        Orders:     orders,
+        Pagination: newPagination(page, size, count),
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "orderTableRows", data)
+    err = h.render(w, r, "orderTableRows", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -657,23 +568,55 @@ func (h *Handler) OrderDetailView(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    order, err := h.Repo.Order.GetOrderWithProduct(id)
+    h.renderOrderDetail(w, r, id, "")
+}
+
+func (h *Handler) renderOrderDetail(w http.ResponseWriter, r *http.Request, id uuid.UUID, message string) {
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    payments, err := h.Repo.Payment.FindByOrder(r.Context(), id)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
+        return
+    }
+
+    refunds, err := h.Repo.Refund.FindByOrder(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    invoices, err := h.Repo.Invoice.FindByOrder(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
         return
     }
 
     data := struct {
         Order         *Order
+        Payments      []Payment
+        Refunds       []Refund
+        Invoices      []Invoice
+        CanRefund     bool
         StatusOptions []OrderStatus
+        Message       string
     }{
         Order:         order,
-        StatusOptions: []OrderStatus{Ordered, Pending, Shipped, Delivered, Cancel},
+        Payments:      payments,
+        Refunds:       refunds,
+        Invoices:      invoices,
+        CanRefund:     (order.PaymentStatus == PaymentPaid || order.PaymentStatus == PaymentPartlyRefunded) && order.Total > 0,
+        StatusOptions: order.Status.NextStatuses(),
+        Message:       message,
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "orderDetail", data)
+    err = h.render(w, r, "orderDetail", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -687,16 +630,26 @@ func (h *Handler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
     }
 
     status := r.FormValue("order_status")
+    note := strings.TrimSpace(r.FormValue("note"))
 
-    err = h.Repo.Order.UpdateStatus(id, OrderStatus(status))
+    event, err := h.Repo.Order.UpdateStatus(r.Context(), id, OrderStatus(status), currentUser(r).Email, note)
+    var transitionErr *TransitionError
+    if errors.As(err, &transitionErr) {
+        h.renderOrderDetail(w, r, id, transitionErr.Error())
+        return
+    }
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 
-    err = h.Tmpl.ExecuteTemplate(w, "orderTable", nil)
+    if event != nil && event.ToStatus == Cancel {
+        h.VoidPayments(r.Context(), id)
+    }
+
+    err = h.render(w, r, "orderTable", OrderTableData{Statuses: OrderStatuses})
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
         return
     }
 }
@@ -704,12 +657,82 @@ func (h *Handler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 /*** End User actions ***/
 
 /*** utils ***/
-func makeRange(min int, max int) []int {
-    rangeArray := make([]int, max-min+1)
-    for i := range rangeArray {
-        rangeArray[i] = min + i
+
+// TemplateFuncs returns the functions available to templates. Each
+// template set render uses binds them to the request it is rendering; the
+// versions returned here only exist so the templates can be parsed.
+func TemplateFuncs() template.FuncMap {
+    return template.FuncMap{
+        "currentUser":     func() *User { return nil },
+        "can":             func(permission string) bool { return false },
+        "csrfToken":       func() string { return "" },
+        "paymentProvider": func() string { return "" },
+        "cents": func(amount int64) string {
+            return fmt.Sprintf("%.2f", float64(amount)/100)
+        },
     }
-    return rangeArray
+}
+
+// templateSet is a copy of h.Tmpl whose functions answer for r, the
+// request it is rendering. A set renders one request at a time.
+type templateSet struct {
+    tmpl *template.Template
+    r    *http.Request
+}
+
+// templateSets keeps the sets that are not rendering, so that a new one
+// is only made, by cloning h.Tmpl, when more requests render at once than
+// ever before.
+type templateSets struct {
+    mu   sync.Mutex
+    free []*templateSet
+}
+
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, data any) (err error) {
+    _, span := tracing.Tracer().Start(r.Context(), "template "+name)
+    defer func() { tracing.End(span, err) }()
+
+    set, err := h.templateSet()
+    if err != nil {
+        return err
+    }
+    set.r = r
+    defer func() {
+        set.r = nil
+        h.templates.mu.Lock()
+        h.templates.free = append(h.templates.free, set)
+        h.templates.mu.Unlock()
+    }()
+
+    return set.tmpl.ExecuteTemplate(w, name, data)
+}
+
+func (h *Handler) templateSet() (*templateSet, error) {
+    h.templates.mu.Lock()
+    if n := len(h.templates.free); n > 0 {
+        set := h.templates.free[n-1]
+        h.templates.free = h.templates.free[:n-1]
+        h.templates.mu.Unlock()
+        return set, nil
+    }
+    h.templates.mu.Unlock()
+
+    tmpl, err := h.Tmpl.Clone()
+    if err != nil {
+        return nil, err
+    }
+
+    set := &templateSet{tmpl: tmpl}
+    tmpl.Funcs(template.FuncMap{
+        "currentUser": func() *User { return currentUser(set.r) },
+        "can": func(permission string) bool {
+            user := currentUser(set.r)
+            return user != nil && auth.Can(user.Role, auth.Permission(permission))
+        },
+        "csrfToken":       func() string { return csrfToken(set.r) },
+        "paymentProvider": func() string { return h.Payments.Name() },
+    })
+    return set, nil
 }
 
 type ProductMessage struct {
@@ -717,11 +740,11 @@ type ProductMessage struct {
     Product  *Product
 }
 
-func sendMessage(w http.ResponseWriter, tmpl *template.Template, messages []string, product *Product) {
+func (h *Handler) sendMessage(w http.ResponseWriter, r *http.Request, messages []string, product *Product) {
     data := ProductMessage{Messages: messages, Product: product}
-    err := tmpl.ExecuteTemplate(w, "viewMessages", data)
+    err := h.render(w, r, "viewMessages", data)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        serverError(w, r, err)
     }
 }
 
@@ -733,7 +756,7 @@ type Cart struct {
     RefreshCart bool
 }
 
-func getCartTotal() float64 {
+func getCartTotal(cartItems []OrderItem) float64 {
     totalCost := 0.0
     for _, item := range cartItems {
         totalCost += item.Cost
@@ -741,9 +764,26 @@ func getCartTotal() float64 {
     return totalCost
 }
 
-func getCartItem(id uuid.UUID) (int, *OrderItem) {
-    index := slices.IndexFunc(cartItems, func(item OrderItem) bool {
+func getCartItem(cartItems []OrderItem, id uuid.UUID) int {
+    return slices.IndexFunc(cartItems, func(item OrderItem) bool {
         return item.ProductID == id
     })
-    return index, &cartItems[index]
+}
+
+// liftWriteDeadline lets a long response, such as a streamed download, run
+// past the server's write timeout.
+func liftWriteDeadline(w http.ResponseWriter, r *http.Request) {
+    err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+    if err != nil && !errors.Is(err, http.ErrNotSupported) {
+        logging.FromContext(r.Context()).Warn("write deadline", "error", err)
+    }
+}
+
+// liftReadDeadline gives a large upload until d from now to arrive, past
+// the server's read timeout.
+func liftReadDeadline(w http.ResponseWriter, r *http.Request, d time.Duration) {
+    err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(d))
+    if err != nil && !errors.Is(err, http.ErrNotSupported) {
+        logging.FromContext(r.Context()).Warn("read deadline", "error", err)
+    }
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/invoice"
+    "net/http"
+)
+
+// OrderInvoice downloads the invoice of a paid order, issuing it the first
+// time it is asked for.
+func (h *Handler) OrderInvoice(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    h.serveOrderInvoice(w, r, id)
+}
+
+// InvoiceDownload downloads an issued invoice or credit note.
+func (h *Handler) InvoiceDownload(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    doc, err := h.Repo.Invoice.Get(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    servePDF(w, "attachment", doc.Filename(), doc.PDF)
+}
+
+func (h *Handler) AccountOrderInvoice(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.accountOrder(w, r)
+    if !ok {
+        return
+    }
+
+    h.serveOrderInvoice(w, r, order.ID)
+}
+
+// AccountInvoiceDownload downloads one of the customer's own invoices or
+// credit notes.
+func (h *Handler) AccountInvoiceDownload(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.accountOrder(w, r)
+    if !ok {
+        return
+    }
+
+    id, err := uuid.Parse(mux.Vars(r)["invoiceID"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    doc, err := h.Repo.Invoice.Get(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && doc.OrderID != order.ID) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    servePDF(w, "attachment", doc.Filename(), doc.PDF)
+}
+
+func (h *Handler) serveOrderInvoice(w http.ResponseWriter, r *http.Request, orderID uuid.UUID) {
+    doc, err := h.Invoices.ForOrder(r.Context(), orderID)
+    if errors.Is(err, invoice.ErrNotInvoiced) {
+        http.Error(w, "This order has not been paid, so it has no invoice yet.", http.StatusNotFound)
+        return
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    servePDF(w, "attachment", doc.Filename(), doc.PDF)
+}
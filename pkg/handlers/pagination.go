@@ -0,0 +1,70 @@
+package handlers
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+)
+
+const (
+    defaultPageSize = 10
+    // maxPageSize caps the pages of the public APIs.
+    maxPageSize     = 100
+)
+
+// Pagination describes one page of a list, for the page links under the
+// admin tables and the metadata of API lists.
+type Pagination struct {
+    CurrentPage  int   `json:"page"`
+    TotalPages   int   `json:"total_pages"`
+    Size         int   `json:"size"`
+    TotalItems   int   `json:"total_items"`
+    PreviousPage int   `json:"previous_page"`
+    NextPage     int   `json:"next_page"`
+    PageRange    []int `json:"-"`
+}
+
+// pageParams reads the page and size query parameters, falling back to the
+// first page of defaultPageSize items.
+func pageParams(r *http.Request) (int, int) {
+    page, err := strconv.Atoi(r.URL.Query().Get("page"))
+    if err != nil || page < 1 {
+        page = 1
+    }
+
+    size, err := strconv.Atoi(r.URL.Query().Get("size"))
+    if err != nil || size <= 0 {
+        size = defaultPageSize
+    }
+
+    return page, size
+}
+
+// apiPageParams reads the page and size query parameters of an API list
+// like pageParams, with the size capped at maxPageSize.
+func apiPageParams(r *http.Request) (int, int) {
+    page, size := pageParams(r)
+    return page, min(size, maxPageSize)
+}
+
+func newPagination(page, size, count int) Pagination {
+    totalPages := int(math.Ceil(float64(count) / float64(size)))
+
+    return Pagination{
+        CurrentPage:  page,
+        TotalPages:   totalPages,
+        Size:         size,
+        TotalItems:   count,
+        PreviousPage: page - 1,
+        NextPage:     page + 1,
+        PageRange:    makeRange(1, totalPages),
+    }
+}
+
+func makeRange(min int, max int) []int {
+    rangeArray := make([]int, max-min+1)
+    for i := range rangeArray {
+        rangeArray[i] = min + i
+    }
+    return rangeArray
+}
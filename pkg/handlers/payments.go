@@ -0,0 +1,211 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "io"
+    "net/http"
+    "time"
+)
+
+const (
+    // PaymentWebhookPath receives webhooks from the payment provider.
+    PaymentWebhookPath = "/payments/webhook"
+
+    maxPaymentWebhookBody = 1 << 20
+    paymentEventsLimit    = 100
+)
+
+type PaymentEventsPageData struct {
+    Events   []PaymentEvent
+    Messages []string
+}
+
+// PaymentWebhook receives signed events from the payment provider. Every
+// verified event is stored as received and deduplicated by its ID, so a
+// provider retrying or replaying an event is acknowledged without applying
+// it twice. Failures answer 500 so that the provider retries.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPaymentWebhookBody))
+    if err != nil {
+        http.Error(w, "Failed to read body", http.StatusBadRequest)
+        return
+    }
+
+    event, err := h.Payments.ParseWebhook(r.Header, body)
+    if err != nil {
+        logging.FromContext(r.Context()).Warn("payment webhook: invalid", "error", err)
+        http.Error(w, "Invalid webhook", http.StatusBadRequest)
+        return
+    }
+
+    stored := &PaymentEvent{
+        Provider:    h.Payments.Name(),
+        EventID:     event.ID,
+        EventType:   event.Type,
+        ProviderRef: event.Intent.Ref,
+        Payload:     string(body),
+    }
+    created, err := h.Repo.Payment.CreateEvent(r.Context(), stored)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if !created && stored.Status != PaymentEventFailed {
+        w.WriteHeader(http.StatusOK)
+        return
+    }
+
+    if err := h.processPaymentEvent(r.Context(), stored, event); err != nil {
+        http.Error(w, "Failed to process event", http.StatusInternalServerError)
+        return
+    }
+
+    w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) PaymentEventsPage(w http.ResponseWriter, r *http.Request) {
+    h.renderPaymentEvents(w, r, "paymentEvents", nil)
+}
+
+// ReprocessPaymentEvent applies a stored event again, e.g. after fixing
+// whatever made it fail. Its signature was checked when it arrived.
+func (h *Handler) ReprocessPaymentEvent(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, "Invalid event ID", http.StatusBadRequest)
+        return
+    }
+
+    stored, err := h.Repo.Payment.GetEvent(r.Context(), id)
+    if err != nil {
+        http.Error(w, "Event not found", http.StatusNotFound)
+        return
+    }
+
+    var event payment.WebhookEvent
+    if err := json.Unmarshal([]byte(stored.Payload), &event); err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    message := "Event " + stored.EventID + " reprocessed."
+    if err := h.processPaymentEvent(r.Context(), stored, &event); err != nil {
+        message = "Event " + stored.EventID + " failed again: " + err.Error()
+    }
+
+    h.renderPaymentEvents(w, r, "paymentEventTable", []string{message})
+}
+
+// processPaymentEvent maps a provider event onto the payment it is about,
+// and through it onto the order, then records the outcome on the stored
+// event. Events for payments that already reached a final state are ignored,
+// which keeps late or repeated events from undoing anything.
+func (h *Handler) processPaymentEvent(ctx context.Context, stored *PaymentEvent, event *payment.WebhookEvent) error {
+    stored.Status = PaymentEventReceived
+    stored.Error = ""
+
+    err := h.applyPaymentEvent(ctx, stored, event)
+
+    stored.ProcessedAt = sql.NullTime{Time: time.Now(), Valid: true}
+    if err != nil {
+        stored.Status = PaymentEventFailed
+        stored.Error = err.Error()
+    } else if stored.Status != PaymentEventIgnored {
+        stored.Status = PaymentEventProcessed
+    }
+
+    if err := h.Repo.Payment.RecordEventOutcome(ctx, stored); err != nil {
+        logging.FromContext(ctx).Error("payment webhook: record outcome", "event_id", stored.EventID, "error", err)
+    }
+
+    return err
+}
+
+func (h *Handler) applyPaymentEvent(ctx context.Context, stored *PaymentEvent, event *payment.WebhookEvent) error {
+    attempt, err := h.paymentForIntent(ctx, &event.Intent)
+    if errors.Is(err, sql.ErrNoRows) {
+        stored.Status = PaymentEventIgnored
+        stored.Error = "No payment matches this intent."
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    stored.PaymentID = uuid.NullUUID{UUID: attempt.ID, Valid: true}
+
+    // Refunds are settled against captured payments, so these come before
+    // the check for a final state.
+    switch event.Type {
+    case payment.EventIntentRefunded, payment.EventIntentRefundFailed:
+        failBefore := time.Time{}
+        if event.Type == payment.EventIntentRefundFailed {
+            failBefore = time.Now()
+        }
+        settled, err := h.reconcileRefunds(ctx, attempt, &event.Intent, failBefore)
+        if err == nil && settled == 0 {
+            stored.Status = PaymentEventIgnored
+            stored.Error = "No pending refund for this payment."
+        }
+        return err
+    }
+
+    switch attempt.Status {
+    case PaymentPaid, PaymentFailed, PaymentVoided, PaymentPartlyRefunded, PaymentRefunded:
+        stored.Status = PaymentEventIgnored
+        stored.Error = "Payment is already " + string(attempt.Status) + "."
+        return nil
+    }
+
+    switch event.Type {
+    case payment.EventIntentAuthorized, payment.EventIntentCaptured, payment.EventIntentDeclined, payment.EventIntentVoided:
+    default:
+        stored.Status = PaymentEventIgnored
+        stored.Error = "Unhandled event type."
+        return nil
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, paymentTimeout)
+    defer cancel()
+
+    return h.settlePayment(ctx, attempt, &event.Intent, nil)
+}
+
+// paymentForIntent finds our payment for a provider intent, by the
+// idempotency key it was authorized with or else by the provider's reference.
+// The key still works when the authorization timed out and the reference was
+// never recorded.
+func (h *Handler) paymentForIntent(ctx context.Context, intent *payment.Intent) (*Payment, error) {
+    if id, err := uuid.Parse(intent.Reference); err == nil {
+        attempt, err := h.Repo.Payment.GetById(ctx, id)
+        if err == nil && attempt.Provider == h.Payments.Name() {
+            return attempt, nil
+        }
+        if err != nil && !errors.Is(err, sql.ErrNoRows) {
+            return nil, err
+        }
+    }
+
+    return h.Repo.Payment.GetByProviderRef(ctx, h.Payments.Name(), intent.Ref)
+}
+
+func (h *Handler) renderPaymentEvents(w http.ResponseWriter, r *http.Request, name string, messages []string) {
+    events, err := h.Repo.Payment.FindEvents(r.Context(), paymentEventsLimit)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    err = h.render(w, r, name, PaymentEventsPageData{Events: events, Messages: messages})
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
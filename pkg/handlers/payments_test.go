@@ -0,0 +1,413 @@
+package handlers
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "encoding/json"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/signature"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// fixtureSecret is the secret the fixtures in pkg/payment/testdata are
+// signed with.
+const fixtureSecret = "whsec_fixture"
+
+// downDatabase is a database that is never reachable. It counts the
+// connections asked of it, which tells whether a request got as far as
+// storing anything.
+type downDatabase struct {
+    connects atomic.Int32
+}
+
+func (d *downDatabase) Connect(ctx context.Context) (driver.Conn, error) {
+    d.connects.Add(1)
+    return nil, errors.New("database is down")
+}
+
+func (d *downDatabase) Driver() driver.Driver {
+    return nil
+}
+
+// memPayments keeps payments and their events in memory, the way
+// *repository.PaymentRepository keeps them in the database. Instead of
+// moving orders it records the status changes asked for.
+type memPayments struct {
+    mu       sync.Mutex
+    payments map[uuid.UUID]Payment
+    events   []PaymentEvent
+    orders   map[uuid.UUID][]orderChange
+}
+
+type orderChange struct {
+    Status OrderStatus
+    Note   string
+}
+
+func newMemPayments() *memPayments {
+    return &memPayments{
+        payments: make(map[uuid.UUID]Payment),
+        orders:   make(map[uuid.UUID][]orderChange),
+    }
+}
+
+func (s *memPayments) Create(ctx context.Context, payment *Payment) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if payment.ID == uuid.Nil {
+        payment.ID = uuid.New()
+    }
+    payment.CreatedAt = time.Now()
+    payment.UpdatedAt = payment.CreatedAt
+    s.payments[payment.ID] = *payment
+    return nil
+}
+
+func (s *memPayments) GetById(ctx context.Context, id uuid.UUID) (*Payment, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    payment, ok := s.payments[id]
+    if !ok {
+        return nil, sql.ErrNoRows
+    }
+    return &payment, nil
+}
+
+func (s *memPayments) GetByProviderRef(ctx context.Context, provider, ref string) (*Payment, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, payment := range s.payments {
+        if payment.Provider == provider && payment.ProviderRef == ref {
+            return &payment, nil
+        }
+    }
+    return nil, sql.ErrNoRows
+}
+
+func (s *memPayments) FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Payment, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var payments []Payment
+    for _, payment := range s.payments {
+        if payment.OrderID == orderID {
+            payments = append(payments, payment)
+        }
+    }
+    return payments, nil
+}
+
+func (s *memPayments) Update(ctx context.Context, payment *Payment, orderStatus OrderStatus, note string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.payments[payment.ID]; !ok {
+        return sql.ErrNoRows
+    }
+    payment.UpdatedAt = time.Now()
+    s.payments[payment.ID] = *payment
+    if orderStatus != "" {
+        s.orders[payment.OrderID] = append(s.orders[payment.OrderID], orderChange{orderStatus, note})
+    }
+    return nil
+}
+
+func (s *memPayments) CreateEvent(ctx context.Context, event *PaymentEvent) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, stored := range s.events {
+        if stored.Provider == event.Provider && stored.EventID == event.EventID {
+            *event = stored
+            return false, nil
+        }
+    }
+
+    event.ID = uuid.New()
+    event.Status = PaymentEventReceived
+    event.ReceivedAt = time.Now()
+    s.events = append(s.events, *event)
+    return true, nil
+}
+
+func (s *memPayments) GetEvent(ctx context.Context, id uuid.UUID) (*PaymentEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, event := range s.events {
+        if event.ID == id {
+            return &event, nil
+        }
+    }
+    return nil, sql.ErrNoRows
+}
+
+func (s *memPayments) FindEvents(ctx context.Context, limit int) ([]PaymentEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    events := append([]PaymentEvent(nil), s.events...)
+    if len(events) > limit {
+        events = events[:limit]
+    }
+    return events, nil
+}
+
+func (s *memPayments) RecordEventOutcome(ctx context.Context, event *PaymentEvent) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i, stored := range s.events {
+        if stored.ID == event.ID {
+            s.events[i] = *event
+            return nil
+        }
+    }
+    return sql.ErrNoRows
+}
+
+// orderChanges returns the status changes asked of an order so far.
+func (s *memPayments) orderChanges(orderID uuid.UUID) []orderChange {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]orderChange(nil), s.orders[orderID]...)
+}
+
+func newPaymentWebhookHandler(t *testing.T) (*Handler, *downDatabase) {
+    t.Helper()
+
+    database := &downDatabase{}
+    db := sql.OpenDB(database)
+    t.Cleanup(func() { db.Close() })
+
+    return &Handler{
+        Repo:     repository.NewRepository(db),
+        Payments: payment.NewFakeProvider("", fixtureSecret),
+    }, database
+}
+
+// newPaymentStoreHandler returns a handler whose payments are kept in
+// memory. Everything else still goes to a database that is down.
+func newPaymentStoreHandler(t *testing.T) (*Handler, *memPayments) {
+    t.Helper()
+
+    h, _ := newPaymentWebhookHandler(t)
+    store := newMemPayments()
+    h.Repo.Payment = store
+    return h, store
+}
+
+// authorizedFixture stores the payment a webhook fixture is about, as
+// checkout leaves it while the provider decides, and authorizes it with the
+// fake provider using card. It returns the payment and the fixture, signed
+// now, with the intent reference the fake provider gave the payment.
+func authorizedFixture(t *testing.T, h *Handler, store *memPayments, name, card string) (*Payment, []byte, string) {
+    t.Helper()
+
+    fixture, _ := paymentFixture(t, name)
+    var event payment.WebhookEvent
+    if err := json.Unmarshal(fixture, &event); err != nil {
+        t.Fatal(err)
+    }
+
+    attempt := &Payment{
+        ID:       uuid.MustParse(event.Intent.Reference),
+        OrderID:  uuid.New(),
+        Provider: h.Payments.Name(),
+        Amount:   event.Intent.Amount,
+        Currency: event.Intent.Currency,
+        Status:   PaymentProcessing,
+    }
+    if err := store.Create(context.Background(), attempt); err != nil {
+        t.Fatal(err)
+    }
+
+    intent, err := h.Payments.Authorize(context.Background(), payment.AuthorizeRequest{
+        IdempotencyKey: attempt.ID.String(),
+        Amount:         attempt.Amount,
+        Currency:       attempt.Currency,
+        CardNumber:     card,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if event.Type == payment.EventIntentCaptured {
+        if intent, err = h.Payments.Capture(context.Background(), intent.Ref, intent.Amount); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    event.Intent.Ref = intent.Ref
+    body, err := json.Marshal(event)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return attempt, body, signature.Header(fixtureSecret, time.Now(), body)
+}
+
+// paymentFixture returns the body of a webhook fixture and the signature
+// header it was recorded with.
+func paymentFixture(t *testing.T, name string) ([]byte, string) {
+    t.Helper()
+
+    body, err := os.ReadFile("../payment/testdata/" + name + ".json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    header, err := os.ReadFile("../payment/testdata/" + name + ".sig")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return body, strings.TrimSpace(string(header))
+}
+
+func postPaymentWebhook(h *Handler, body []byte, header string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodPost, PaymentWebhookPath, bytes.NewReader(body))
+    if header != "" {
+        req.Header.Set(payment.SignatureHeader, header)
+    }
+    rec := httptest.NewRecorder()
+    h.PaymentWebhook(rec, req)
+    return rec
+}
+
+func TestPaymentWebhookRejectsForgeries(t *testing.T) {
+    captured, recorded := paymentFixture(t, "intent_captured")
+    tampered, _ := paymentFixture(t, "forged_tampered")
+    now := time.Now()
+
+    tests := []struct {
+        name   string
+        body   []byte
+        header string
+    }{
+        {"unsigned", captured, ""},
+        {"tampered body", tampered, signature.Header(fixtureSecret, now, captured)},
+        {"guessed secret", captured, signature.Header("whsec_guessed", now, captured)},
+        {"old default secret", captured, signature.Header("whsec_fake", now, captured)},
+        // The fixture as the provider sent it long ago, replayed.
+        {"stale replay", captured, recorded},
+        {"signed in the future", captured, signature.Header(fixtureSecret, now.Add(time.Hour), captured)},
+        {"garbage header", captured, "t=now,v1=nothing"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h, database := newPaymentWebhookHandler(t)
+
+            rec := postPaymentWebhook(h, tt.body, tt.header)
+            if rec.Code != http.StatusBadRequest {
+                t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+            }
+            if n := database.connects.Load(); n != 0 {
+                t.Errorf("a forged webhook reached the database %d times", n)
+            }
+        })
+    }
+}
+
+func TestPaymentWebhookRejectsOversizedBody(t *testing.T) {
+    h, database := newPaymentWebhookHandler(t)
+
+    body := bytes.Repeat([]byte(" "), maxPaymentWebhookBody+1)
+    rec := postPaymentWebhook(h, body, signature.Header(fixtureSecret, time.Now(), body))
+    if rec.Code != http.StatusBadRequest {
+        t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+    }
+    if database.connects.Load() != 0 {
+        t.Error("an oversized webhook reached the database")
+    }
+}
+
+func TestPaymentWebhookAcceptsSignedFixtures(t *testing.T) {
+    for _, name := range []string{"intent_captured", "intent_declined", "intent_refunded", "intent_refund_failed"} {
+        t.Run(name, func(t *testing.T) {
+            h, database := newPaymentWebhookHandler(t)
+            body, _ := paymentFixture(t, name)
+
+            // The provider signs each delivery when it sends it.
+            rec := postPaymentWebhook(h, body, signature.Header(fixtureSecret, time.Now(), body))
+
+            if database.connects.Load() == 0 {
+                t.Fatalf("a signed webhook was not stored; status %d: %s", rec.Code, rec.Body)
+            }
+            // The event could not be stored, so the provider is asked to
+            // retry without learning why.
+            if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "database") {
+                t.Errorf("status = %d, body %q, want a bare 500", rec.Code, rec.Body)
+            }
+        })
+    }
+}
+
+func TestPaymentWebhookSettlesPayment(t *testing.T) {
+    tests := []struct {
+        fixture string
+        card    string
+        status  PaymentStatus
+        order   orderChange
+    }{
+        {"intent_captured", payment.CardSuccess, PaymentPaid, orderChange{Pending, "Payment received"}},
+        {"intent_declined", payment.CardDeclined, PaymentFailed, orderChange{Cancel, "Payment declined: Your card was declined."}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.fixture, func(t *testing.T) {
+            h, store := newPaymentStoreHandler(t)
+            attempt, body, header := authorizedFixture(t, h, store, tt.fixture, tt.card)
+
+            if rec := postPaymentWebhook(h, body, header); rec.Code != http.StatusOK {
+                t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+            }
+
+            settled, _ := store.GetById(context.Background(), attempt.ID)
+            if settled.Status != tt.status || settled.ProviderRef == "" {
+                t.Errorf("payment = %+v, want %s with the provider's reference", settled, tt.status)
+            }
+            if changes := store.orderChanges(attempt.OrderID); len(changes) != 1 || changes[0] != tt.order {
+                t.Errorf("order changes = %+v, want %+v", changes, tt.order)
+            }
+            if events, _ := store.FindEvents(context.Background(), 10); len(events) != 1 || events[0].Status != PaymentEventProcessed || events[0].PaymentID.UUID != attempt.ID {
+                t.Errorf("events = %+v, want one processed for the payment", events)
+            }
+        })
+    }
+}
+
+func TestPaymentWebhookProcessesReplayOnce(t *testing.T) {
+    h, store := newPaymentStoreHandler(t)
+    attempt, body, _ := authorizedFixture(t, h, store, "intent_captured", payment.CardSuccess)
+
+    // The provider retries with the same event, signed again.
+    for i := 0; i < 2; i++ {
+        rec := postPaymentWebhook(h, body, signature.Header(fixtureSecret, time.Now(), body))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("delivery %d: status = %d: %s", i+1, rec.Code, rec.Body)
+        }
+    }
+
+    // Processing it again would have marked it ignored, the payment being
+    // paid by then.
+    if events, _ := store.FindEvents(context.Background(), 10); len(events) != 1 || events[0].Status != PaymentEventProcessed {
+        t.Errorf("events = %+v, want the replay deduplicated", events)
+    }
+    if changes := store.orderChanges(attempt.OrderID); len(changes) != 1 {
+        t.Errorf("order changes = %+v, want the payment settled once", changes)
+    }
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    refundReconcileInterval = 5 * time.Minute
+    refundReconcileBatch    = 50
+    // refundGiveUp is how long a pending refund the provider has no record
+    // of waits before it fails.
+    refundGiveUp            = 24 * time.Hour
+)
+
+// RefundOrder refunds order lines, an extra amount, or everything that is
+// left, through the provider that took the payment. The refund is reserved
+// before the provider is called so it can never exceed what was paid.
+func (h *Handler) RefundOrder(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    attempt, err := h.capturedPayment(r, id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+    if attempt == nil {
+        h.renderOrderDetail(w, r, id, "This order has no captured payment to refund.")
+        return
+    }
+
+    refund := &Refund{
+        OrderID:   id,
+        PaymentID: attempt.ID,
+        Reason:    strings.TrimSpace(r.FormValue("reason")),
+        Restock:   r.FormValue("restock") != "",
+        Actor:     currentUser(r).Email,
+    }
+    full := r.FormValue("full") != ""
+
+    if refund.Reason == "" {
+        h.renderOrderDetail(w, r, id, "Please give a reason for the refund.")
+        return
+    }
+
+    for _, item := range order.Items {
+        quantity := item.RefundableQuantity()
+        if !full {
+            value := strings.TrimSpace(r.FormValue("quantity_" + item.ProductID.String()))
+            if value == "" {
+                continue
+            }
+            quantity, err = strconv.Atoi(value)
+            if err != nil || quantity < 0 {
+                h.renderOrderDetail(w, r, id, "Invalid quantity to refund.")
+                return
+            }
+        }
+        if quantity == 0 {
+            continue
+        }
+
+        amount := lineRefundAmount(item, quantity)
+        refund.Items = append(refund.Items, RefundItem{ProductID: item.ProductID, Quantity: quantity, Amount: amount})
+        refund.Amount += amount
+    }
+
+    if full {
+        refund.Amount = attempt.Amount - payment.Cents(order.Refunded)
+    } else if value := strings.TrimSpace(r.FormValue("amount")); value != "" {
+        amount, err := strconv.ParseFloat(value, 64)
+        if err != nil || amount < 0 {
+            h.renderOrderDetail(w, r, id, "Invalid amount to refund.")
+            return
+        }
+        refund.Amount += payment.Cents(amount)
+    }
+
+    message, err := h.issueRefund(r.Context(), refund, attempt)
+    var refundErr *RefundError
+    if errors.As(err, &refundErr) {
+        h.renderOrderDetail(w, r, id, refundErr.Error())
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderOrderDetail(w, r, id, message)
+}
+
+// issueRefund reserves refund and asks the provider to pay it back from
+// attempt. It returns a message for staff describing the outcome, or a
+// *RefundError if the refund was refused before reaching the provider.
+func (h *Handler) issueRefund(ctx context.Context, refund *Refund, attempt *Payment) (string, error) {
+    if err := h.Repo.Refund.Create(ctx, refund); err != nil {
+        return "", err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, paymentTimeout)
+    defer cancel()
+
+    intent, err := h.Payments.Refund(ctx, attempt.ProviderRef, refund.Amount)
+    switch {
+    case payment.IsTimeout(err):
+        // The provider may still have refunded it, so the refund stays
+        // pending and keeps its amount reserved.
+        return "The payment provider did not answer; the refund is pending.", nil
+    case err != nil:
+        refund.Error = err.Error()
+        if err := h.Repo.Refund.Fail(ctx, refund); err != nil {
+            return "", err
+        }
+        return "The payment provider refused the refund: " + err.Error(), nil
+    }
+
+    refund.ProviderRef = intent.Ref
+    if err := h.Repo.Refund.Complete(ctx, refund); err != nil {
+        return "", err
+    }
+
+    return fmt.Sprintf("Refunded $%.2f.", float64(refund.Amount)/100), nil
+}
+
+// ReconcileRefunds settles, every refundReconcileInterval until ctx is
+// cancelled, the refunds left pending because the provider did not answer:
+// those the provider has paid complete, and those it still has no record of
+// after refundGiveUp fail, which makes their amount refundable again.
+func (h *Handler) ReconcileRefunds(ctx context.Context) {
+    ticker := time.NewTicker(refundReconcileInterval)
+    defer ticker.Stop()
+
+    for {
+        if err := h.reconcilePendingRefunds(ctx); err != nil && ctx.Err() == nil {
+            slog.Error("refund: reconcile pending", "error", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (h *Handler) reconcilePendingRefunds(ctx context.Context) error {
+    // Refunds younger than paymentTimeout may still be waiting on the
+    // provider in issueRefund.
+    refunds, err := h.Repo.Refund.FindPending(ctx, time.Now().Add(-paymentTimeout), refundReconcileBatch)
+    if err != nil {
+        return err
+    }
+
+    reconciled := make(map[uuid.UUID]bool)
+    for _, refund := range refunds {
+        if ctx.Err() != nil || reconciled[refund.PaymentID] {
+            continue
+        }
+        reconciled[refund.PaymentID] = true
+
+        attempt, err := h.Repo.Payment.GetById(ctx, refund.PaymentID)
+        if err != nil {
+            return err
+        }
+
+        intent, err := h.getIntent(ctx, attempt.ProviderRef)
+        if err != nil {
+            slog.Warn("refund: get intent", "payment_id", attempt.ID, "error", err)
+            continue
+        }
+
+        settled, err := h.reconcileRefunds(ctx, attempt, intent, time.Now().Add(-refundGiveUp))
+        if err != nil {
+            return err
+        }
+        if settled > 0 {
+            slog.Info("refund: reconciled pending", "payment_id", attempt.ID, "count", settled)
+        }
+    }
+
+    return nil
+}
+
+func (h *Handler) getIntent(ctx context.Context, ref string) (*payment.Intent, error) {
+    ctx, cancel := context.WithTimeout(ctx, paymentTimeout)
+    defer cancel()
+    return h.Payments.Get(ctx, ref)
+}
+
+// reconcileRefunds settles the pending refunds of attempt against intent,
+// whose Refunded is what the provider has paid back in total. Oldest first,
+// each pending refund that fits in what the provider paid beyond the
+// refunds that already succeeded completes. Those that do not fit fail if
+// they were created before failBefore, and stay pending otherwise. The
+// return a completed refund was issued for is marked refunded. It returns
+// how many refunds were settled.
+func (h *Handler) reconcileRefunds(ctx context.Context, attempt *Payment, intent *payment.Intent, failBefore time.Time) (int, error) {
+    pending, err := h.Repo.Refund.FindPendingByPayment(ctx, attempt.ID)
+    if err != nil || len(pending) == 0 {
+        return 0, err
+    }
+
+    refunded, err := h.Repo.Refund.SucceededAmount(ctx, attempt.ID)
+    if err != nil {
+        return 0, err
+    }
+
+    settled := 0
+    for i := range pending {
+        refund := &pending[i]
+
+        if refunded+refund.Amount <= intent.Refunded {
+            refund.ProviderRef = intent.Ref
+            if err := h.Repo.Refund.Complete(ctx, refund); err != nil {
+                return settled, err
+            }
+            refunded += refund.Amount
+            settled++
+
+            if refund.ReturnID.Valid {
+                _, err := h.Repo.Return.MarkRefunded(ctx, refund.ReturnID.UUID, refund.ID, refund.Actor, "")
+                var transitionErr *ReturnTransitionError
+                if err != nil && !errors.As(err, &transitionErr) {
+                    return settled, err
+                }
+            }
+            continue
+        }
+
+        if refund.CreatedAt.Before(failBefore) {
+            refund.Error = "The payment provider did not refund it."
+            if err := h.Repo.Refund.Fail(ctx, refund); err != nil {
+                return settled, err
+            }
+            settled++
+        }
+    }
+
+    return settled, nil
+}
+
+// capturedPayment returns the order's payment that refunds go back to, or
+// nil if none was captured.
+func (h *Handler) capturedPayment(r *http.Request, orderID uuid.UUID) (*Payment, error) {
+    payments, err := h.Repo.Payment.FindByOrder(r.Context(), orderID)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := len(payments) - 1; i >= 0; i-- {
+        if payments[i].Status.IsCaptured() {
+            return &payments[i], nil
+        }
+    }
+
+    return nil, nil
+}
+
+// lineRefundAmount is the share of the line's cost for quantity units, in
+// cents.
+func lineRefundAmount(item OrderItem, quantity int) int64 {
+    if item.Quantity == 0 {
+        return 0
+    }
+    return payment.Cents(item.Cost) * int64(quantity) / int64(item.Quantity)
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+const returnsLimit = 100
+
+type ReturnsPageData struct {
+    Returns  []Return
+    Statuses []ReturnStatus
+    Status   ReturnStatus
+    Message  string
+}
+
+type ReturnDetailData struct {
+    Return        *Return
+    Order         *Order
+    StatusOptions []ReturnStatus
+    Message       string
+}
+
+// AccountOrderPageData is the customer's view of an order, with its returns,
+// how much of each line can still be sent back, and its invoices.
+type AccountOrderPageData struct {
+    Order      *Order
+    Returns    []Return
+    Returnable map[uuid.UUID]int
+    CanReturn  bool
+    Invoices   []Invoice
+    Message    string
+}
+
+// RequestReturn lets a customer ask to send back items of a delivered order.
+func (h *Handler) RequestReturn(w http.ResponseWriter, r *http.Request) {
+    order, ok := h.accountOrder(w, r)
+    if !ok {
+        return
+    }
+
+    ret := &Return{
+        OrderID:    order.ID,
+        Resolution: ReturnResolution(r.FormValue("resolution")),
+        Reason:     strings.TrimSpace(r.FormValue("reason")),
+    }
+
+    for _, item := range order.Items {
+        value := strings.TrimSpace(r.FormValue("quantity_" + item.ProductID.String()))
+        if value == "" || value == "0" {
+            continue
+        }
+
+        quantity, err := strconv.Atoi(value)
+        if err != nil {
+            w.WriteHeader(http.StatusUnprocessableEntity)
+            h.renderAccountOrder(w, r, order, "Invalid quantity to return.")
+            return
+        }
+        ret.Items = append(ret.Items, ReturnItem{ProductID: item.ProductID, Quantity: quantity})
+    }
+
+    err := h.Repo.Return.Create(r.Context(), ret)
+    var returnErr *ReturnError
+    if errors.As(err, &returnErr) {
+        w.WriteHeader(http.StatusUnprocessableEntity)
+        h.renderAccountOrder(w, r, order, returnErr.Error())
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    http.Redirect(w, r, "/account/orders/"+order.ID.String(), http.StatusSeeOther)
+}
+
+// accountOrder loads the order in the URL if it belongs to the signed-in
+// customer. Otherwise it answers the request itself and returns false.
+func (h *Handler) accountOrder(w http.ResponseWriter, r *http.Request) (*Order, bool) {
+    user := currentUser(r)
+    if user == nil {
+        http.Redirect(w, r, "/login", http.StatusSeeOther)
+        return nil, false
+    }
+
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return nil, false
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && order.UserID != user.ID.String()) {
+        http.NotFound(w, r)
+        return nil, false
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return nil, false
+    }
+
+    return order, true
+}
+
+func (h *Handler) renderAccountOrder(w http.ResponseWriter, r *http.Request, order *Order, message string) {
+    returns, err := h.Repo.Return.FindByOrder(r.Context(), order.ID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    returnable, err := h.Repo.Return.Returnable(r.Context(), order.ID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    invoices, err := h.Repo.Invoice.FindByOrder(r.Context(), order.ID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := AccountOrderPageData{
+        Order:      order,
+        Returns:    returns,
+        Returnable: returnable,
+        Invoices:   invoices,
+        Message:    message,
+    }
+    if order.Status == Delivered {
+        for _, quantity := range returnable {
+            if quantity > 0 {
+                data.CanReturn = true
+            }
+        }
+    }
+
+    err = h.render(w, r, "accountOrder", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) ReturnsPage(w http.ResponseWriter, r *http.Request) {
+    h.renderReturns(w, r, "returns", "")
+}
+
+func (h *Handler) ReturnTableView(w http.ResponseWriter, r *http.Request) {
+    h.renderReturns(w, r, "returnTable", "")
+}
+
+func (h *Handler) ReturnDetailView(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    h.renderReturnDetail(w, r, id, "")
+}
+
+// UpdateReturnStatus moves a return along its workflow. Refunding one pays
+// the customer back for the returned lines through the order's payment, and
+// needs the refund permission on top of the one to update orders.
+func (h *Handler) UpdateReturnStatus(w http.ResponseWriter, r *http.Request) {
+    id, err := uuid.Parse(mux.Vars(r)["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    status := ReturnStatus(r.FormValue("return_status"))
+    note := strings.TrimSpace(r.FormValue("note"))
+    user := currentUser(r)
+
+    if status == ReturnRefunded {
+        if !auth.Can(user.Role, auth.RefundOrders) {
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            return
+        }
+        h.refundReturn(w, r, id, note, r.FormValue("restock") != "")
+        return
+    }
+
+    message := "Return " + string(status) + "."
+    _, err = h.Repo.Return.UpdateStatus(r.Context(), id, status, user.Email, note)
+    var transitionErr *ReturnTransitionError
+    var outOfStock *OutOfStockError
+    if errors.As(err, &transitionErr) {
+        message = transitionErr.Error()
+    } else if errors.As(err, &outOfStock) {
+        message = "The replacement could not be placed: " + outOfStock.Error() + "."
+    } else if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderReturnDetail(w, r, id, message)
+}
+
+// refundReturn refunds the returned lines at the price they were bought for
+// and closes the return once the provider has paid. A refund the provider
+// has not confirmed leaves the return received, until ReconcileRefunds
+// settles it.
+func (h *Handler) refundReturn(w http.ResponseWriter, r *http.Request, id uuid.UUID, note string, restock bool) {
+    ret, err := h.Repo.Return.Get(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if !ret.Status.CanTransitionTo(ReturnRefunded) {
+        h.renderReturnDetail(w, r, id, (&ReturnTransitionError{From: ret.Status, To: ReturnRefunded}).Error())
+        return
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), ret.OrderID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    attempt, err := h.capturedPayment(r, order.ID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+    if attempt == nil {
+        h.renderReturnDetail(w, r, id, "This order has no captured payment to refund.")
+        return
+    }
+
+    refund := &Refund{
+        OrderID:   order.ID,
+        ReturnID:  uuid.NullUUID{UUID: ret.ID, Valid: true},
+        PaymentID: attempt.ID,
+        Reason:    "Return " + ret.RMA + ": " + ret.Reason,
+        Restock:   restock,
+        Actor:     currentUser(r).Email,
+    }
+    for _, item := range ret.Items {
+        for _, line := range order.Items {
+            if line.ProductID == item.ProductID {
+                amount := lineRefundAmount(line, item.Quantity)
+                refund.Items = append(refund.Items, RefundItem{ProductID: item.ProductID, Quantity: item.Quantity, Amount: amount})
+                refund.Amount += amount
+            }
+        }
+    }
+
+    message, err := h.issueRefund(r.Context(), refund, attempt)
+    var refundErr *RefundError
+    if errors.As(err, &refundErr) {
+        h.renderReturnDetail(w, r, id, refundErr.Error())
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    if refund.Status == RefundSucceeded {
+        if _, err := h.Repo.Return.MarkRefunded(r.Context(), id, refund.ID, refund.Actor, note); err != nil {
+            serverError(w, r, err)
+            return
+        }
+    }
+
+    h.renderReturnDetail(w, r, id, message)
+}
+
+func (h *Handler) renderReturns(w http.ResponseWriter, r *http.Request, name, message string) {
+    status := ReturnStatus(r.URL.Query().Get("status"))
+    if !status.IsValid() {
+        status = ""
+    }
+
+    returns, err := h.Repo.Return.Find(r.Context(), status, returnsLimit)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := ReturnsPageData{
+        Returns:  returns,
+        Statuses: ReturnStatuses,
+        Status:   status,
+        Message:  message,
+    }
+
+    err = h.render(w, r, name, data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) renderReturnDetail(w http.ResponseWriter, r *http.Request, id uuid.UUID, message string) {
+    ret, err := h.Repo.Return.Get(r.Context(), id)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.NotFound(w, r)
+        return
+    }
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    order, err := h.Repo.Order.GetOrderWithProduct(r.Context(), ret.OrderID)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := ReturnDetailData{
+        Return:        ret,
+        Order:         order,
+        StatusOptions: ret.Status.NextStatuses(),
+        Message:       message,
+    }
+
+    err = h.render(w, r, "returnDetail", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+    "context"
+    "database/sql"
+    "encoding/base64"
+    "errors"
+    "github.com/google/uuid"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    sessionCookieName      = "session_id"
+    csrfCookieName         = "csrf_token"
+    sessionLifetime        = 30 * 24 * time.Hour
+    sessionCleanupInterval = time.Hour
+)
+
+// Session IDs and CSRF tokens are tokenBytes random bytes, tokenLength
+// characters once encoded.
+const tokenBytes = 32
+
+var tokenLength = base64.RawURLEncoding.EncodedLen(tokenBytes)
+
+type contextKey string
+
+const (
+    sessionContextKey contextKey = "session"
+    userContextKey    contextKey = "user"
+    bearerContextKey  contextKey = "bearer"
+    csrfContextKey    contextKey = "csrf"
+)
+
+// LoadSession attaches the visitor's session, and the signed-in user if
+// any, to the request context. API clients may send the session ID as a
+// bearer token instead of the cookie, which must then be valid. Visitors
+// without a session get none until they need one, such as for a cart (see
+// ensureSession); until then their CSRF token lives in a cookie of its own.
+func (h *Handler) LoadSession(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.URL.Path, "/static") || isServerEndpoint(r) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        var session *Session
+        var err error
+        ctx := r.Context()
+        logger := logging.FromContext(ctx)
+        if token, ok := bearerToken(r); ok {
+            session, err = h.Repo.Session.GetById(ctx, token)
+            if err != nil && !errors.Is(err, sql.ErrNoRows) {
+                writeAPIServerError(w, r, err)
+                return
+            }
+            if session == nil {
+                writeAPIError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid or has expired.")
+                return
+            }
+            ctx = context.WithValue(ctx, bearerContextKey, true)
+        } else if cookie, err := r.Cookie(sessionCookieName); err == nil {
+            session, err = h.Repo.Session.GetById(ctx, cookie.Value)
+            if err != nil && !errors.Is(err, sql.ErrNoRows) {
+                logger.Error("session: lookup", "error", err)
+            }
+        }
+
+        ctx = context.WithValue(ctx, sessionContextKey, &sessionSlot{session: session})
+        if session == nil {
+            token, err := guestCSRFToken(w, r)
+            if err != nil {
+                serverError(w, r, err)
+                return
+            }
+            ctx = context.WithValue(ctx, csrfContextKey, token)
+            next.ServeHTTP(w, r.WithContext(ctx))
+            return
+        }
+
+        if session.UserID.Valid {
+            user, err := h.Repo.User.GetById(ctx, session.UserID.UUID)
+            if err == nil {
+                ctx = context.WithValue(ctx, userContextKey, user)
+                ctx = logging.WithLogger(ctx, logger.With("user_id", user.ID))
+            } else if !errors.Is(err, sql.ErrNoRows) {
+                logger.Error("session: load user", "error", err)
+            }
+        }
+
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+// guestCSRFToken returns the CSRF token of a visitor without a session,
+// from their cookie or, on their first visit, a new one.
+func guestCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+    if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == tokenLength {
+        return cookie.Value, nil
+    }
+
+    token, err := auth.RandomToken(tokenBytes)
+    if err != nil {
+        return "", err
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     csrfCookieName,
+        Value:    token,
+        Path:     "/",
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
+    })
+
+    return token, nil
+}
+
+// sessionSlot holds the session of a request, so that a session started
+// part way through is seen by the rest of the request.
+type sessionSlot struct {
+    session *Session
+}
+
+// ensureSession returns the visitor's session, creating a guest session
+// the first time one is needed. The guest keeps the CSRF token the pages
+// they have open were rendered with.
+func (h *Handler) ensureSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+    if session := currentSession(r); session != nil {
+        return session, nil
+    }
+
+    session, err := h.newSession(w, r, uuid.NullUUID{}, csrfToken(r))
+    if err != nil {
+        return nil, err
+    }
+    if slot, ok := r.Context().Value(sessionContextKey).(*sessionSlot); ok {
+        slot.session = session
+    }
+    return session, nil
+}
+
+// newSession stores a new session and sets its cookie. An empty csrfToken
+// gets the session a new one.
+func (h *Handler) newSession(w http.ResponseWriter, r *http.Request, userID uuid.NullUUID, csrfToken string) (*Session, error) {
+    id, err := auth.RandomToken(tokenBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    if csrfToken == "" {
+        csrfToken, err = auth.RandomToken(tokenBytes)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    session := &Session{
+        ID:        id,
+        UserID:    userID,
+        CSRFToken: csrfToken,
+        ExpiresAt: time.Now().Add(sessionLifetime),
+    }
+
+    err = h.Repo.Session.Create(r.Context(), session)
+    if err != nil {
+        return nil, err
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    session.ID,
+        Path:     "/",
+        Expires:  session.ExpiresAt,
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
+    })
+
+    return session, nil
+}
+
+// ExpireSessions deletes expired sessions every sessionCleanupInterval
+// until ctx is cancelled.
+func (h *Handler) ExpireSessions(ctx context.Context) {
+    ticker := time.NewTicker(sessionCleanupInterval)
+    defer ticker.Stop()
+
+    for {
+        deleted, err := h.Repo.Session.DeleteExpired(ctx)
+        if err != nil && ctx.Err() == nil {
+            slog.Error("session: delete expired", "error", err)
+        } else if deleted > 0 {
+            slog.Info("session: deleted expired", "count", deleted)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// signIn replaces the current session with a new one owned by user and
+// returns it. The session ID is rotated to prevent session fixation; the
+// cart carries over.
+func (h *Handler) signIn(w http.ResponseWriter, r *http.Request, user *User) (*Session, error) {
+    session, err := h.newSession(w, r, uuid.NullUUID{UUID: user.ID, Valid: true}, "")
+    if err != nil {
+        return nil, err
+    }
+
+    if previous := currentSession(r); previous != nil {
+        h.Carts.Move(previous.ID, session.ID)
+        _ = h.Repo.Session.Delete(r.Context(), previous.ID)
+    }
+
+    return session, nil
+}
+
+func (h *Handler) signOut(w http.ResponseWriter, r *http.Request) error {
+    if session := currentSession(r); session != nil {
+        h.Carts.Clear(session.ID)
+        if err := h.Repo.Session.Delete(r.Context(), session.ID); err != nil {
+            return err
+        }
+    }
+
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookieName,
+        Value:    "",
+        Path:     "/",
+        MaxAge:   -1,
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
+    })
+
+    return nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    token = strings.TrimSpace(token)
+    return token, ok && token != ""
+}
+
+// usesBearerToken reports whether the request was authenticated with a
+// bearer token rather than the session cookie.
+func usesBearerToken(r *http.Request) bool {
+    bearer, _ := r.Context().Value(bearerContextKey).(bool)
+    return bearer
+}
+
+// csrfToken returns the token state-changing requests must carry: the
+// session's, or the guest token of a visitor without a session.
+func csrfToken(r *http.Request) string {
+    if session := currentSession(r); session != nil {
+        return session.CSRFToken
+    }
+    token, _ := r.Context().Value(csrfContextKey).(string)
+    return token
+}
+
+// currentSession returns the visitor's session, or nil if they have none
+// yet.
+func currentSession(r *http.Request) *Session {
+    if slot, ok := r.Context().Value(sessionContextKey).(*sessionSlot); ok {
+        return slot.session
+    }
+    return nil
+}
+
+func currentUser(r *http.Request) *User {
+    user, _ := r.Context().Value(userContextKey).(*User)
+    return user
+}
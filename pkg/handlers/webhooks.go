@@ -0,0 +1,188 @@
+package handlers
+
+import (
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/auth"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "net/http"
+    "net/url"
+    "slices"
+    "strings"
+)
+
+type WebhooksPageData struct {
+    Subscriptions []WebhookSubscription
+    Events        []string
+    Messages      []string
+}
+
+func (h *Handler) WebhooksPage(w http.ResponseWriter, r *http.Request) {
+    h.renderWebhooks(w, r, "webhooks", nil)
+}
+
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+    var errorMessages []string
+
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Failed to parse form", http.StatusBadRequest)
+        return
+    }
+
+    urlValue := strings.TrimSpace(r.FormValue("url"))
+    secretValue := strings.TrimSpace(r.FormValue("secret"))
+    events := r.Form["events"]
+
+    parsedURL, err := url.Parse(urlValue)
+    if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+        errorMessages = append(errorMessages, "A valid http(s) URL is required.")
+    }
+
+    for _, event := range events {
+        if !slices.Contains(WebhookEvents, event) {
+            errorMessages = append(errorMessages, "Unknown event "+event+".")
+        }
+    }
+
+    if len(errorMessages) > 0 {
+        h.renderWebhooks(w, r, "webhookTable", errorMessages)
+        return
+    }
+
+    if secretValue == "" {
+        secretValue, err = auth.RandomToken(24)
+        if err != nil {
+            serverError(w, r, err)
+            return
+        }
+    }
+
+    _, err = h.Repo.Webhook.CreateSubscription(r.Context(), &WebhookSubscription{
+        URL:    urlValue,
+        Events: events,
+        Secret: secretValue,
+        Active: true,
+    })
+    if err != nil {
+        h.renderWebhooks(w, r, "webhookTable", []string{"Failed to create webhook: " + err.Error()})
+        return
+    }
+
+    h.renderWebhooks(w, r, "webhookTable", []string{"Webhook for " + urlValue + " added."})
+}
+
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := uuid.Parse(vars["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    err = h.Repo.Webhook.SetSubscriptionActive(r.Context(), id, r.FormValue("active") == "true")
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderWebhooks(w, r, "webhookTable", nil)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := uuid.Parse(vars["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    err = h.Repo.Webhook.DeleteSubscription(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderWebhooks(w, r, "webhookTable", []string{"Webhook deleted."})
+}
+
+func (h *Handler) WebhookDeliveriesView(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := uuid.Parse(vars["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    h.renderDeliveries(w, r, id, "")
+}
+
+func (h *Handler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    id, err := uuid.Parse(vars["id"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    delivery, err := h.Repo.Webhook.GetDelivery(r.Context(), id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    err = h.Webhooks.Replay(r.Context(), id)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    h.renderDeliveries(w, r, delivery.SubscriptionID, "Event "+delivery.EventID.String()+" queued for redelivery.")
+}
+
+func (h *Handler) renderWebhooks(w http.ResponseWriter, r *http.Request, name string, messages []string) {
+    subscriptions, err := h.Repo.Webhook.FindSubscriptions(r.Context(), false)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := WebhooksPageData{
+        Subscriptions: subscriptions,
+        Events:        WebhookEvents,
+        Messages:      messages,
+    }
+
+    err = h.render(w, r, name, data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
+
+func (h *Handler) renderDeliveries(w http.ResponseWriter, r *http.Request, subscriptionID uuid.UUID, message string) {
+    subscription, err := h.Repo.Webhook.GetSubscription(r.Context(), subscriptionID)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    deliveries, err := h.Repo.Webhook.FindDeliveries(r.Context(), subscriptionID, 50)
+    if err != nil {
+        serverError(w, r, err)
+        return
+    }
+
+    data := struct {
+        Subscription *WebhookSubscription
+        Deliveries   []WebhookDelivery
+        Message      string
+    }{
+        Subscription: subscription,
+        Deliveries:   deliveries,
+        Message:      message,
+    }
+
+    err = h.render(w, r, "webhookDeliveries", data)
+    if err != nil {
+        serverError(w, r, err)
+    }
+}
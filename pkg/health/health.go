@@ -0,0 +1,225 @@
+// Package health serves the probes the orchestrator uses: liveness, which
+// only says the process is up, and readiness, which checks the database, the
+// schema, upload storage and the background workers.
+package health
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/database"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    LivenessPath  = "/healthz"
+    ReadinessPath = "/readyz"
+)
+
+// checkTimeout bounds every check, so that a dependency that hangs fails
+// the probe instead of stalling it.
+const checkTimeout = 2 * time.Second
+
+// CheckFunc returns an error when the dependency it checks is unusable.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+    name string
+    run  CheckFunc
+}
+
+// Checker runs the readiness checks. Checks are added at startup, before
+// the server starts.
+type Checker struct {
+    checks       []check
+    shuttingDown atomic.Bool
+}
+
+func NewChecker() *Checker {
+    return &Checker{}
+}
+
+// Add registers a readiness check under name.
+func (c *Checker) Add(name string, run CheckFunc) {
+    c.checks = append(c.checks, check{name: name, run: run})
+}
+
+// Heartbeat registers a check that fails once the returned function has not
+// been called for maxAge, for a background worker to call as it works. The
+// worker counts as alive when it is registered, to give it time to start.
+func (c *Checker) Heartbeat(name string, maxAge time.Duration) func() {
+    var last atomic.Int64
+    last.Store(time.Now().UnixNano())
+
+    c.Add(name, func(context.Context) error {
+        since := time.Since(time.Unix(0, last.Load()))
+        if since > maxAge {
+            return fmt.Errorf("no heartbeat for %s", since.Round(time.Second))
+        }
+        return nil
+    })
+
+    return func() {
+        last.Store(time.Now().UnixNano())
+    }
+}
+
+// ShutDown makes readiness fail from now on, so that the orchestrator stops
+// sending traffic while requests in flight finish.
+func (c *Checker) ShutDown() {
+    c.shuttingDown.Store(true)
+}
+
+// Status is one check's part of the readiness report. The probe is public,
+// so why a check failed is only logged.
+type Status struct {
+    Status     string  `json:"status"`
+    DurationMS float64 `json:"duration_ms"`
+    err        error
+}
+
+// Report is the readiness response body.
+type Report struct {
+    Status string            `json:"status"`
+    Checks map[string]Status `json:"checks,omitempty"`
+}
+
+// Ready runs every check at once and reports whether all of them passed.
+// While shutting down it reports not ready without running them.
+func (c *Checker) Ready(ctx context.Context) (Report, bool) {
+    if c.shuttingDown.Load() {
+        return Report{Status: "shutting_down"}, false
+    }
+
+    report := Report{Status: "ready", Checks: map[string]Status{}}
+    ready := true
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for _, check := range c.checks {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            status := runCheck(ctx, check.run)
+
+            mu.Lock()
+            defer mu.Unlock()
+            report.Checks[check.name] = status
+            if status.err != nil {
+                ready = false
+            }
+        }()
+    }
+    wg.Wait()
+
+    if !ready {
+        report.Status = "not_ready"
+    }
+    return report, ready
+}
+
+// runCheck gives up on a check at checkTimeout even if it ignores ctx.
+func runCheck(ctx context.Context, run CheckFunc) Status {
+    ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+    defer cancel()
+
+    start := time.Now()
+    result := make(chan error, 1)
+    go func() {
+        result <- run(ctx)
+    }()
+
+    var err error
+    select {
+    case err = <-result:
+    case <-ctx.Done():
+        err = fmt.Errorf("no answer within %s", checkTimeout)
+    }
+
+    status := Status{
+        Status:     "ok",
+        DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+    }
+    if err != nil {
+        status.Status = "fail"
+        status.err = err
+    }
+    return status
+}
+
+// Live answers the liveness probe. It checks no dependencies: an outage of
+// the database should take the server out of rotation, not restart it.
+func Live(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, Report{Status: "ok"})
+}
+
+// ServeReady answers the readiness probe with the report of every check,
+// and 503 Service Unavailable unless all of them passed. The errors of
+// failed checks are logged.
+func (c *Checker) ServeReady(w http.ResponseWriter, r *http.Request) {
+    report, ready := c.Ready(r.Context())
+    status := http.StatusOK
+    if !ready {
+        status = http.StatusServiceUnavailable
+        logger := logging.FromContext(r.Context())
+        for name, check := range report.Checks {
+            if check.err != nil {
+                logger.Warn("health: check failed", "check", name, "error", check.err)
+            }
+        }
+    }
+    writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, report Report) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(report)
+}
+
+// Database checks that db answers a ping.
+func Database(db *sql.DB) CheckFunc {
+    return func(ctx context.Context) error {
+        return db.PingContext(ctx)
+    }
+}
+
+// Migrations checks that every migration has been applied to db.
+func Migrations(db *sql.DB) CheckFunc {
+    return func(ctx context.Context) error {
+        pending, err := database.Pending(ctx, db)
+        if err != nil {
+            return err
+        }
+        if len(pending) > 0 {
+            return fmt.Errorf("%d pending: %s", len(pending), strings.Join(pending, ", "))
+        }
+        return nil
+    }
+}
+
+// Writable checks that files can be created in dir, by writing one and
+// removing it again.
+func Writable(dir string) CheckFunc {
+    return func(context.Context) error {
+        file, err := os.CreateTemp(dir, ".health-*")
+        if err != nil {
+            return err
+        }
+        defer os.Remove(file.Name())
+
+        if _, err := file.WriteString("ok"); err != nil {
+            file.Close()
+            return err
+        }
+        return file.Close()
+    }
+}
@@ -0,0 +1,204 @@
+// Package invoice issues invoices for paid orders and credit notes for their
+// refunds, and renders them as PDF.
+package invoice
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/payment"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "math"
+)
+
+// ErrNotInvoiced is returned for orders that are not paid and refunds that
+// did not go through, which get no document.
+var ErrNotInvoiced = errors.New("invoice: nothing to invoice yet")
+
+// Invoicer issues each document once, the first time it is asked for, and
+// returns the stored one after that. It also runs on the outbox relay so
+// documents are issued as soon as an order is paid or refunded.
+type Invoicer struct {
+    repo    *repository.Repository
+    seller  Party
+    taxRate float64
+}
+
+// NewInvoicer returns an Invoicer that names seller on its documents. Prices
+// in the shop include tax at taxRate percent.
+func NewInvoicer(repo *repository.Repository, seller Party, taxRate float64) *Invoicer {
+    return &Invoicer{
+        repo:    repo,
+        seller:  seller,
+        taxRate: taxRate,
+    }
+}
+
+// HandleOutbox issues the invoice of a paid order and the credit note of a
+// refund.
+func (i *Invoicer) HandleOutbox(ctx context.Context, message OutboxMessage) error {
+    var err error
+
+    switch message.Topic {
+    case EventOrderPaid:
+        var paid Payment
+        if err := json.Unmarshal([]byte(message.Payload), &paid); err != nil {
+            return err
+        }
+        _, err = i.ForOrder(ctx, paid.OrderID)
+
+    case EventOrderRefunded:
+        var refund Refund
+        if err := json.Unmarshal([]byte(message.Payload), &refund); err != nil {
+            return err
+        }
+        _, err = i.ForRefund(ctx, refund.ID)
+    }
+
+    if errors.Is(err, ErrNotInvoiced) {
+        return nil
+    }
+    return err
+}
+
+// ForOrder returns the invoice of an order, issuing it if the order is paid
+// and has none yet.
+func (i *Invoicer) ForOrder(ctx context.Context, orderID uuid.UUID) (*Invoice, error) {
+    source := "order:" + orderID.String()
+
+    invoice, err := i.repo.Invoice.GetBySource(ctx, source)
+    if !errors.Is(err, sql.ErrNoRows) {
+        return invoice, err
+    }
+
+    order, err := i.repo.Order.GetOrderWithProduct(ctx, orderID)
+    if err != nil {
+        return nil, err
+    }
+
+    if !order.PaymentStatus.IsCaptured() {
+        return nil, ErrNotInvoiced
+    }
+
+    invoice, err = i.newDocument(ctx, KindInvoice, order)
+    if err != nil {
+        return nil, err
+    }
+
+    invoiceLines(invoice, order)
+
+    return i.repo.Invoice.Issue(ctx, invoice, source, Render)
+}
+
+// ForRefund returns the credit note of a refund. A succeeded refund without
+// one gets it issued, along with the invoice it credits if that is missing.
+func (i *Invoicer) ForRefund(ctx context.Context, refundID uuid.UUID) (*Invoice, error) {
+    source := "refund:" + refundID.String()
+
+    creditNote, err := i.repo.Invoice.GetBySource(ctx, source)
+    if !errors.Is(err, sql.ErrNoRows) {
+        return creditNote, err
+    }
+
+    refund, err := i.repo.Refund.Get(ctx, refundID)
+    if err != nil {
+        return nil, err
+    }
+    if refund.Status != RefundSucceeded {
+        return nil, ErrNotInvoiced
+    }
+
+    invoice, err := i.ForOrder(ctx, refund.OrderID)
+    if err != nil {
+        return nil, err
+    }
+
+    order, err := i.repo.Order.GetOrderWithProduct(ctx, refund.OrderID)
+    if err != nil {
+        return nil, err
+    }
+
+    creditNote, err = i.newDocument(ctx, KindCreditNote, order)
+    if err != nil {
+        return nil, err
+    }
+    creditNote.RefundID = uuid.NullUUID{UUID: refund.ID, Valid: true}
+    creditNote.Credits = invoice.Number
+    creditLines(creditNote, order, refund)
+
+    return i.repo.Invoice.Issue(ctx, creditNote, source, Render)
+}
+
+func (i *Invoicer) newDocument(ctx context.Context, kind InvoiceKind, order *Order) (*Invoice, error) {
+    buyer := Party{Name: order.Email, Email: order.Email}
+    if id, err := uuid.Parse(order.UserID); err == nil {
+        user, err := i.repo.User.GetById(ctx, id)
+        if err != nil && !errors.Is(err, sql.ErrNoRows) {
+            return nil, err
+        }
+        if user != nil {
+            buyer.Name = user.Name
+        }
+    }
+
+    return &Invoice{
+        Kind:     kind,
+        OrderID:  order.ID,
+        Seller:   i.seller,
+        Buyer:    buyer,
+        TaxRate:  i.taxRate,
+        Currency: payment.Currency,
+    }, nil
+}
+
+// invoiceLines adds a line for each item of order.
+func invoiceLines(invoice *Invoice, order *Order) {
+    for _, item := range order.Items {
+        total := payment.Cents(item.Cost)
+        addLine(invoice, item.Name, item.Quantity, total)
+    }
+}
+
+// creditLines adds a line for each item refund gave money back for, and one
+// for whatever part of the refund is not for an item.
+func creditLines(creditNote *Invoice, order *Order, refund *Refund) {
+    remaining := refund.Amount
+    for _, item := range refund.Items {
+        name := item.ProductID.String()
+        for _, line := range order.Items {
+            if line.ProductID == item.ProductID {
+                name = line.Name
+            }
+        }
+        addLine(creditNote, name, item.Quantity, item.Amount)
+        remaining -= item.Amount
+    }
+    if remaining > 0 {
+        addLine(creditNote, "Refund: "+refund.Reason, 1, remaining)
+    }
+}
+
+// addLine adds a line whose tax-inclusive total is given in cents, and splits
+// it into net and tax.
+func addLine(invoice *Invoice, description string, quantity int, total int64) {
+    net := int64(math.Round(float64(total) * 100 / (100 + invoice.TaxRate)))
+
+    line := InvoiceLine{
+        Description: description,
+        Quantity:    quantity,
+        Net:         net,
+        Tax:         total - net,
+        Total:       total,
+    }
+    if quantity > 0 {
+        line.UnitPrice = total / int64(quantity)
+    }
+
+    invoice.Lines = append(invoice.Lines, line)
+    invoice.Net += line.Net
+    invoice.Tax += line.Tax
+    invoice.Total += line.Total
+}
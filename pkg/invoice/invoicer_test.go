@@ -0,0 +1,115 @@
+package invoice
+
+import (
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "reflect"
+    "testing"
+)
+
+func TestAddLineRounding(t *testing.T) {
+    type line struct {
+        quantity int
+        total    int64
+    }
+    tests := []struct {
+        name    string
+        taxRate float64
+        lines   []line
+        // net is each line's net; tax follows as the rest of its total.
+        net     []int64
+        unit    []int64
+    }{
+        {"even split", 20, []line{{1, 1200}}, []int64{1000}, []int64{1200}},
+        // Rounding the total instead would give 13 net and 2 tax.
+        {"rounded per line", 20, []line{{1, 5}, {1, 5}, {1, 5}}, []int64{4, 4, 4}, []int64{5, 5, 5}},
+        {"fractional rate", 8.25, []line{{1, 4599}, {2, 1999}}, []int64{4248, 1847}, []int64{4599, 999}},
+        {"net rounds up", 20, []line{{1, 7}}, []int64{6}, []int64{7}},
+        {"no tax", 0, []line{{3, 1000}}, []int64{1000}, []int64{333}},
+        {"free line", 20, []line{{1, 0}, {1, 1200}}, []int64{0, 1000}, []int64{0, 1200}},
+        {"no quantity", 20, []line{{0, 600}}, []int64{500}, []int64{0}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            invoice := &Invoice{TaxRate: tt.taxRate}
+            var net, tax, total int64
+            for i, l := range tt.lines {
+                addLine(invoice, "Item", l.quantity, l.total)
+
+                got := invoice.Lines[i]
+                if got.Net != tt.net[i] || got.Tax != l.total-tt.net[i] || got.Total != l.total || got.UnitPrice != tt.unit[i] {
+                    t.Errorf("line %d = %+v, want net %d, tax %d, unit price %d", i, got, tt.net[i], l.total-tt.net[i], tt.unit[i])
+                }
+                net += got.Net
+                tax += got.Tax
+                total += got.Total
+            }
+
+            // The totals add up the rounded lines, so the document agrees
+            // with itself to the cent.
+            if invoice.Net != net || invoice.Tax != tax || invoice.Total != total || net+tax != total {
+                t.Errorf("totals = %d + %d = %d, want %d + %d = %d", invoice.Net, invoice.Tax, invoice.Total, net, tax, total)
+            }
+        })
+    }
+}
+
+func TestCreditLines(t *testing.T) {
+    hat, scarf, gloves := uuid.New(), uuid.New(), uuid.New()
+    order := &Order{Items: []OrderItem{
+        {ProductID: hat, Name: "Hat", Quantity: 2, Cost: 45.98},
+        {ProductID: scarf, Name: "Scarf", Quantity: 1, Cost: 19.99},
+        {ProductID: gloves, Name: "Gloves", Quantity: 1, Cost: 12.50},
+    }}
+
+    invoice := &Invoice{TaxRate: 8.25}
+    invoiceLines(invoice, order)
+    hatLine, scarfLine, glovesLine := invoice.Lines[0], invoice.Lines[1], invoice.Lines[2]
+
+    tests := []struct {
+        name   string
+        refund *Refund
+        want   []InvoiceLine
+    }{
+        {
+            name:   "one line",
+            refund: &Refund{Amount: 1999, Items: []RefundItem{{ProductID: scarf, Quantity: 1, Amount: 1999}}},
+            want:   []InvoiceLine{scarfLine},
+        },
+        {
+            name:   "two lines",
+            refund: &Refund{Amount: 5848, Items: []RefundItem{{ProductID: hat, Quantity: 2, Amount: 4598}, {ProductID: gloves, Quantity: 1, Amount: 1250}}},
+            want:   []InvoiceLine{hatLine, glovesLine},
+        },
+        {
+            name:   "part of a line",
+            refund: &Refund{Amount: 2299, Items: []RefundItem{{ProductID: hat, Quantity: 1, Amount: 2299}}},
+            want:   []InvoiceLine{{Description: "Hat", Quantity: 1, UnitPrice: 2299, Net: 2124, Tax: 175, Total: 2299}},
+        },
+        {
+            name:   "line and goodwill",
+            refund: &Refund{Amount: 2499, Reason: "Late delivery", Items: []RefundItem{{ProductID: scarf, Quantity: 1, Amount: 1999}}},
+            want:   []InvoiceLine{scarfLine, {Description: "Refund: Late delivery", Quantity: 1, UnitPrice: 500, Net: 462, Tax: 38, Total: 500}},
+        },
+        {
+            name:   "no items",
+            refund: &Refund{Amount: 1000, Reason: "Goodwill"},
+            want:   []InvoiceLine{{Description: "Refund: Goodwill", Quantity: 1, UnitPrice: 1000, Net: 924, Tax: 76, Total: 1000}},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            creditNote := &Invoice{Kind: KindCreditNote, TaxRate: invoice.TaxRate}
+            creditLines(creditNote, order, tt.refund)
+
+            if !reflect.DeepEqual(creditNote.Lines, tt.want) {
+                t.Errorf("lines = %+v, want %+v", creditNote.Lines, tt.want)
+            }
+            if creditNote.Total != tt.refund.Amount || creditNote.Net+creditNote.Tax != creditNote.Total {
+                t.Errorf("credited %d + %d = %d, want %d", creditNote.Net, creditNote.Tax, creditNote.Total, tt.refund.Amount)
+            }
+        })
+    }
+}
@@ -0,0 +1,140 @@
+package invoice
+
+import (
+    "fmt"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/pdf"
+    "strings"
+)
+
+const (
+    margin     = 50.0
+    pageBottom = pdf.PageHeight - 80
+    lineHeight = 16.0
+)
+
+// Right edges of the amount columns of the line table.
+var columns = []struct {
+    title string
+    right float64
+}{
+    {"Qty", 320},
+    {"Unit price", 385},
+    {"Net", 445},
+    {"Tax", 495},
+    {"Total", pdf.PageWidth - margin},
+}
+
+// Render lays out an invoice or credit note as a PDF.
+func Render(invoice *Invoice) ([]byte, error) {
+    doc := pdf.NewDocument()
+
+    title := "INVOICE"
+    if invoice.Kind == KindCreditNote {
+        title = "CREDIT NOTE"
+    }
+
+    doc.Text(margin, 70, 22, true, title)
+    right := pdf.PageWidth - margin
+    doc.TextRight(right, 60, 10, true, invoice.Number)
+    doc.TextRight(right, 74, 9, false, "Issued "+invoice.IssuedAt.Format("January 2, 2006"))
+    doc.TextRight(right, 88, 9, false, "Order "+invoice.OrderID.String())
+    if invoice.Credits != "" {
+        doc.TextRight(right, 102, 9, false, "Credits invoice "+invoice.Credits)
+    }
+
+    party(doc, margin, 130, "From", invoice.Seller)
+    party(doc, 320, 130, "Bill to", invoice.Buyer)
+
+    y := 230.0
+    tableHeader(doc, y)
+    y += lineHeight + 4
+
+    for _, line := range invoice.Lines {
+        if y > pageBottom {
+            doc.AddPage()
+            y = 70
+            tableHeader(doc, y)
+            y += lineHeight + 4
+        }
+
+        doc.Text(margin, y, 9, false, pdf.Fit(line.Description, 320-60-margin, 9))
+        values := []string{fmt.Sprint(line.Quantity), money(line.UnitPrice), money(line.Net), money(line.Tax), money(line.Total)}
+        for i, value := range values {
+            doc.TextRight(columns[i].right, y, 9, false, value)
+        }
+        y += lineHeight
+    }
+
+    if y > pageBottom-60 {
+        doc.AddPage()
+        y = 70
+    }
+
+    doc.Line(margin, y-10, right, y-10)
+    y += 6
+    totals := []struct {
+        label string
+        value int64
+    }{
+        {"Net", invoice.Net},
+        {fmt.Sprintf("Tax (%s%%)", rate(invoice.TaxRate)), invoice.Tax},
+    }
+    for _, total := range totals {
+        doc.TextRight(445, y, 10, false, total.label)
+        doc.TextRight(right, y, 10, false, money(total.value))
+        y += lineHeight
+    }
+
+    label := "Total " + invoice.Currency
+    if invoice.Kind == KindCreditNote {
+        label = "Credited " + invoice.Currency
+    }
+    doc.TextRight(445, y+2, 11, true, label)
+    doc.TextRight(right, y+2, 11, true, money(invoice.Total))
+
+    footer := fmt.Sprintf("Prices include %s%% tax.", rate(invoice.TaxRate))
+    if invoice.Kind == KindInvoice {
+        footer += " Paid in full, thank you for your order."
+    }
+    doc.Text(margin, pdf.PageHeight-40, 8, false, footer)
+
+    return doc.Bytes()
+}
+
+func party(doc *pdf.Document, x, y float64, heading string, p Party) {
+    doc.Text(x, y, 9, true, heading)
+    y += 14
+
+    lines := []string{p.Name}
+    lines = append(lines, strings.Split(p.Address, "\n")...)
+    if p.Email != "" && p.Email != p.Name {
+        lines = append(lines, p.Email)
+    }
+    if p.TaxID != "" {
+        lines = append(lines, "Tax ID "+p.TaxID)
+    }
+
+    for _, line := range lines {
+        if line = strings.TrimSpace(line); line != "" {
+            doc.Text(x, y, 10, false, pdf.Fit(line, 230, 10))
+            y += 13
+        }
+    }
+}
+
+func tableHeader(doc *pdf.Document, y float64) {
+    doc.Text(margin, y, 9, true, "Description")
+    for _, column := range columns {
+        doc.TextRight(column.right, y, 9, true, column.title)
+    }
+    doc.Line(margin, y+5, pdf.PageWidth-margin, y+5)
+}
+
+func money(cents int64) string {
+    return fmt.Sprintf("%.2f", float64(cents)/100)
+}
+
+func rate(percent float64) string {
+    return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", percent), "0"), ".")
+}
@@ -0,0 +1,120 @@
+package invoice
+
+import (
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "io"
+    "regexp"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// pageTexts returns the strings drawn on each page of a rendered PDF.
+func pageTexts(t *testing.T, file []byte) [][]string {
+    t.Helper()
+
+    var pages [][]string
+    for _, match := range regexp.MustCompile(`/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(file, -1) {
+        length, _ := strconv.Atoi(string(file[match[2]:match[3]]))
+        r, err := zlib.NewReader(bytes.NewReader(file[match[1] : match[1]+length]))
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, err := io.ReadAll(r)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        var texts []string
+        for _, text := range regexp.MustCompile(`\((.*)\) Tj`).FindAllSubmatch(content, -1) {
+            texts = append(texts, string(text[1]))
+        }
+        pages = append(pages, texts)
+    }
+    return pages
+}
+
+func TestRender(t *testing.T) {
+    document := func(kind InvoiceKind, lines int) *Invoice {
+        invoice := &Invoice{
+            Kind:     kind,
+            Number:   kind.Prefix() + "000042",
+            OrderID:  uuid.MustParse("0192a3b4-c5d6-7e8f-9a0b-1c2d3e4f5a6b"),
+            Seller:   Party{Name: "The Identity Store", Address: "1 Main Street\nSpringfield", TaxID: "US123"},
+            Buyer:    Party{Name: "Jane Doe", Email: "jane@example.com"},
+            TaxRate:  8.25,
+            Currency: "USD",
+            IssuedAt: time.Date(2025, 10, 9, 8, 53, 0, 0, time.UTC),
+        }
+        for i := 0; i < lines; i++ {
+            addLine(invoice, fmt.Sprintf("Hat (size %d)", i+1), 2, 4598)
+        }
+        return invoice
+    }
+
+    creditNote := document(KindCreditNote, 1)
+    creditNote.Credits = "INV-000041"
+
+    tests := []struct {
+        name     string
+        invoice  *Invoice
+        pages    int
+        want     []string
+        unwanted []string
+    }{
+        {
+            name:     "invoice",
+            invoice:  document(KindInvoice, 2),
+            pages:    1,
+            want:     []string{"INVOICE", "INV-000042", "Issued October 9, 2025", "Tax ID US123", `Hat \(size 1\)`, "22.99", "42.48", "3.50", "84.96", "7.00", "Tax \\(8.25%\\)", "Total USD", "91.96", "Paid in full"},
+            unwanted: []string{"Credits invoice"},
+        },
+        {
+            name:     "credit note",
+            invoice:  creditNote,
+            pages:    1,
+            want:     []string{"CREDIT NOTE", "CN-000042", "Credits invoice INV-000041", "Credited USD", "45.98"},
+            unwanted: []string{"Paid in full"},
+        },
+        {
+            name:    "many lines",
+            invoice: document(KindInvoice, 60),
+            pages:   2,
+            want:    []string{`Hat \(size 60\)`, "2758.80"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            file, err := Render(tt.invoice)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            pages := pageTexts(t, file)
+            if len(pages) != tt.pages {
+                t.Fatalf("%d pages, want %d", len(pages), tt.pages)
+            }
+            var all []string
+            for _, page := range pages {
+                all = append(all, page...)
+            }
+            text := strings.Join(all, "\n")
+            for _, want := range tt.want {
+                if !strings.Contains(text, want) {
+                    t.Errorf("missing %q in\n%s", want, text)
+                }
+            }
+            for _, unwanted := range tt.unwanted {
+                if strings.Contains(text, unwanted) {
+                    t.Errorf("unexpected %q in\n%s", unwanted, text)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,37 @@
+// Package logging sets up structured JSON logging and carries a logger,
+// tagged with the request ID, from the HTTP middleware down to the handlers
+// and repositories through the request context.
+package logging
+
+import (
+    "context"
+    "io"
+    "log/slog"
+)
+
+type contextKey int
+
+const (
+    loggerContextKey contextKey = iota
+    entryContextKey
+)
+
+// New returns a logger writing JSON lines to w, leaving out records below
+// level.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+    return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// WithLogger returns a copy of ctx that carries logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+    return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger ctx carries, or the default logger outside
+// a request.
+func FromContext(ctx context.Context) *slog.Logger {
+    if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+        return logger
+    }
+    return slog.Default()
+}
@@ -0,0 +1,137 @@
+package logging
+
+import (
+    "context"
+    "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "go.opentelemetry.io/otel/trace"
+    "log/slog"
+    "net/http"
+    "time"
+)
+
+// RequestIDHeader carries the request ID. An ID sent by a proxy in front of
+// the server is kept, so that its logs and ours can be matched up; the ID
+// is echoed in the response either way.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// entry collects what the access log needs from inside the router.
+type entry struct {
+    route string
+}
+
+// Middleware gives every request an ID and a logger tagged with it, and
+// with the trace ID when the request is traced, and logs the request once
+// it completes with its status, size, duration and route template. It wraps
+// the whole router, so that requests no route matches are logged too; Route
+// fills in the template from inside.
+func Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+
+        id := r.Header.Get(RequestIDHeader)
+        if !validRequestID(id) {
+            id = uuid.NewString()
+        }
+        w.Header().Set(RequestIDHeader, id)
+
+        logger := slog.Default().With("request_id", id)
+        if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+            logger = logger.With("trace_id", span.TraceID().String())
+        }
+        e := &entry{}
+        ctx := context.WithValue(WithLogger(r.Context(), logger), entryContextKey, e)
+        recorder := &responseRecorder{ResponseWriter: w}
+
+        defer func() {
+            // A panic is logged as the 500 the client gets, then left to
+            // net/http to report.
+            p := recover()
+            status := recorder.status
+            if p != nil {
+                status = http.StatusInternalServerError
+            } else if status == 0 {
+                status = http.StatusOK
+            }
+
+            level := slog.LevelInfo
+            if status >= http.StatusInternalServerError {
+                level = slog.LevelError
+            }
+            logger.LogAttrs(ctx, level, "request",
+                slog.String("method", r.Method),
+                slog.String("path", r.URL.Path),
+                slog.String("route", e.route),
+                slog.Int("status", status),
+                slog.Int64("bytes", recorder.bytes),
+                slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+                slog.String("remote_addr", r.RemoteAddr),
+            )
+
+            if p != nil {
+                panic(p)
+            }
+        }()
+
+        next.ServeHTTP(recorder, r.WithContext(ctx))
+    })
+}
+
+// Route records the template of the matched route, such as /product/{id},
+// for the access log. It is router middleware and so only runs for
+// requests a route matched.
+func Route(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        e, ok := r.Context().Value(entryContextKey).(*entry)
+        if route := mux.CurrentRoute(r); ok && route != nil {
+            if template, err := route.GetPathTemplate(); err == nil {
+                e.route = template
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func validRequestID(id string) bool {
+    if id == "" || len(id) > maxRequestIDLength {
+        return false
+    }
+    for _, c := range id {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// responseRecorder notes the status and size of a response. It unwraps for
+// http.ResponseController, so handlers can still flush and set deadlines.
+type responseRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+    if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    n, err := w.ResponseWriter.Write(b)
+    w.bytes += int64(n)
+    return n, err
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
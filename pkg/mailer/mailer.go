@@ -0,0 +1,187 @@
+package mailer
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log/slog"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type Message struct {
+    To      string
+    Subject string
+    Text    string
+    HTML    string
+}
+
+type Mailer interface {
+    Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP server. Username and Password are
+// optional, which is what local sinks such as MailHog or smtp4dev expect.
+type SMTPMailer struct {
+    Addr     string
+    From     string
+    Username string
+    Password string
+
+    // rootCAs verifies the server's certificate after STARTTLS; nil means
+    // the system pool.
+    rootCAs *x509.CertPool
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+    body, err := m.build(msg)
+    if err != nil {
+        return err
+    }
+
+    host, _, err := net.SplitHostPort(m.Addr)
+    if err != nil {
+        return err
+    }
+
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+    if err != nil {
+        return err
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+
+    client, err := smtp.NewClient(conn, host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer client.Close()
+
+    if ok, _ := client.Extension("STARTTLS"); ok {
+        if err := client.StartTLS(&tls.Config{ServerName: host, RootCAs: m.rootCAs}); err != nil {
+            return err
+        }
+    }
+
+    if m.Username != "" {
+        if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+            return err
+        }
+    }
+
+    from, err := mail.ParseAddress(m.From)
+    if err != nil {
+        return err
+    }
+
+    if err := client.Mail(from.Address); err != nil {
+        return err
+    }
+    if err := client.Rcpt(msg.To); err != nil {
+        return err
+    }
+
+    w, err := client.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(body); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+
+    return client.Quit()
+}
+
+// build renders msg as a multipart/alternative MIME message with a plain
+// text and an HTML part.
+func (m *SMTPMailer) build(msg Message) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := multipart.NewWriter(&buf)
+
+    headers := [][2]string{
+        {"From", m.From},
+        {"To", msg.To},
+        {"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+        {"Date", time.Now().Format(time.RFC1123Z)},
+        {"Message-ID", "<" + strconv.FormatInt(time.Now().UnixNano(), 36) + "@" + hostname(m.From) + ">"},
+        {"MIME-Version", "1.0"},
+        {"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+    }
+
+    for _, header := range headers {
+        fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+    }
+    buf.WriteString("\r\n")
+
+    parts := []struct {
+        contentType string
+        content     string
+    }{
+        {"text/plain; charset=utf-8", msg.Text},
+        {"text/html; charset=utf-8", msg.HTML},
+    }
+
+    for _, part := range parts {
+        if part.content == "" {
+            continue
+        }
+
+        w, err := writer.CreatePart(textproto.MIMEHeader{
+            "Content-Type":              {part.contentType},
+            "Content-Transfer-Encoding": {"quoted-printable"},
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        qp := quotedprintable.NewWriter(w)
+        if _, err := qp.Write([]byte(part.content)); err != nil {
+            return nil, err
+        }
+        if err := qp.Close(); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+// hostname returns the domain of address, used to build Message-IDs.
+func hostname(address string) string {
+    if parsed, err := mail.ParseAddress(address); err == nil {
+        address = parsed.Address
+    }
+    if i := strings.LastIndex(address, "@"); i >= 0 {
+        return address[i+1:]
+    }
+    return "localhost"
+}
+
+// LogMailer writes messages to the log instead of sending them. It is used
+// when no SMTP server is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+    slog.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "text", msg.Text)
+    return nil
+}
@@ -0,0 +1,420 @@
+package mailer
+
+import (
+    "bufio"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "io"
+    "math/big"
+    "mime"
+    "mime/multipart"
+    "net"
+    "net/mail"
+    "net/textproto"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// sunkMail is a message as the SMTP sink received it.
+type sunkMail struct {
+    From string
+    To   []string
+    Auth string
+    TLS  bool
+    Data []byte
+}
+
+// smtpSink is a minimal SMTP server that keeps what it is sent, like the
+// MailHog or smtp4dev sinks used locally. If credentials is set, it
+// advertises AUTH PLAIN and only accepts "\x00username\x00password" equal to
+// it. If tls is set, it advertises STARTTLS and upgrades the connection
+// with it. Recipients in reject are refused.
+type smtpSink struct {
+    ln          net.Listener
+    credentials string
+    reject      map[string]bool
+    tls         *tls.Config
+
+    mu   sync.Mutex
+    mail []sunkMail
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+    t.Helper()
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    sink := &smtpSink{ln: ln, reject: make(map[string]bool)}
+    t.Cleanup(func() { ln.Close() })
+
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go sink.serve(conn)
+        }
+    }()
+
+    return sink
+}
+
+// startTLS makes the sink offer STARTTLS with a self-signed certificate for
+// 127.0.0.1, and returns a pool that trusts it.
+func (s *smtpSink) startTLS(t *testing.T) *x509.CertPool {
+    t.Helper()
+
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+        BasicConstraintsValid: true,
+        IsCA:                  true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s.tls = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+    pool := x509.NewCertPool()
+    pool.AddCert(cert)
+    return pool
+}
+
+func (s *smtpSink) Addr() string {
+    return s.ln.Addr().String()
+}
+
+func (s *smtpSink) received() []sunkMail {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]sunkMail(nil), s.mail...)
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+    tp := textproto.NewConn(conn)
+    tp.PrintfLine("220 localhost ESMTP sink")
+
+    var current sunkMail
+    secure := false
+    for {
+        line, err := tp.ReadLine()
+        if err != nil {
+            return
+        }
+        verb, arg, _ := strings.Cut(line, " ")
+
+        switch strings.ToUpper(verb) {
+        case "EHLO", "HELO":
+            extensions := []string{"localhost"}
+            if s.tls != nil && !secure {
+                extensions = append(extensions, "STARTTLS")
+            }
+            if s.credentials != "" {
+                extensions = append(extensions, "AUTH PLAIN")
+            }
+            for i, extension := range extensions {
+                if i == len(extensions)-1 {
+                    tp.PrintfLine("250 %s", extension)
+                } else {
+                    tp.PrintfLine("250-%s", extension)
+                }
+            }
+        case "STARTTLS":
+            if s.tls == nil || secure {
+                tp.PrintfLine("502 command not implemented")
+                continue
+            }
+            tp.PrintfLine("220 ready to start TLS")
+            tlsConn := tls.Server(conn, s.tls)
+            if err := tlsConn.Handshake(); err != nil {
+                return
+            }
+            // The client starts over on the encrypted connection.
+            tp = textproto.NewConn(tlsConn)
+            current = sunkMail{TLS: true}
+            secure = true
+        case "AUTH":
+            mechanism, response, _ := strings.Cut(arg, " ")
+            decoded, err := base64.StdEncoding.DecodeString(response)
+            if mechanism != "PLAIN" || err != nil || string(decoded) != s.credentials {
+                tp.PrintfLine("535 5.7.8 authentication failed")
+                continue
+            }
+            current.Auth = string(decoded)
+            tp.PrintfLine("235 2.7.0 authenticated")
+        case "MAIL":
+            current.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+            tp.PrintfLine("250 ok")
+        case "RCPT":
+            to := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+            if s.reject[to] {
+                tp.PrintfLine("550 5.1.1 no such user")
+                continue
+            }
+            current.To = append(current.To, to)
+            tp.PrintfLine("250 ok")
+        case "DATA":
+            tp.PrintfLine("354 go ahead")
+            data, err := tp.ReadDotBytes()
+            if err != nil {
+                return
+            }
+            current.Data = data
+
+            s.mu.Lock()
+            s.mail = append(s.mail, current)
+            s.mu.Unlock()
+            current = sunkMail{Auth: current.Auth, TLS: current.TLS}
+            tp.PrintfLine("250 queued")
+        case "RSET", "NOOP":
+            tp.PrintfLine("250 ok")
+        case "QUIT":
+            tp.PrintfLine("221 bye")
+            return
+        default:
+            tp.PrintfLine("502 command not implemented")
+        }
+    }
+}
+
+// parsedMail is a sunk message split into its headers and decoded parts.
+type parsedMail struct {
+    Header *mail.Message
+    Parts  map[string]string
+}
+
+func parseMail(t *testing.T, data []byte) parsedMail {
+    t.Helper()
+
+    msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+    if err != nil || mediaType != "multipart/alternative" {
+        t.Fatalf("Content-Type = %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+    }
+
+    parsed := parsedMail{Header: msg, Parts: make(map[string]string)}
+    reader := multipart.NewReader(msg.Body, params["boundary"])
+    for {
+        // NextPart decodes quoted-printable parts.
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+        body, err := io.ReadAll(part)
+        if err != nil {
+            t.Fatal(err)
+        }
+        parsed.Parts[partType] = string(body)
+    }
+
+    return parsed
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+    sink := newSMTPSink(t)
+    mailer := &SMTPMailer{Addr: sink.Addr(), From: "The Identity Store <shop@example.com>"}
+
+    text := "Thanks for your order of a très long line that goes well past the seventy-six characters quoted-printable allows."
+    err := mailer.Send(context.Background(), Message{
+        To:      "jane@example.com",
+        Subject: "Your order — confirmed",
+        Text:    text,
+        HTML:    "<p>Thanks &amp; see you soon</p>",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    received := sink.received()
+    if len(received) != 1 {
+        t.Fatalf("sink got %d messages, want 1", len(received))
+    }
+    got := received[0]
+    if got.From != "shop@example.com" {
+        t.Errorf("MAIL FROM = %q, want the bare sender address", got.From)
+    }
+    if len(got.To) != 1 || got.To[0] != "jane@example.com" {
+        t.Errorf("RCPT TO = %q, want jane@example.com", got.To)
+    }
+    if got.Auth != "" {
+        t.Error("authenticated without a username")
+    }
+
+    parsed := parseMail(t, got.Data)
+    subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Header.Get("Subject"))
+    if err != nil || subject != "Your order — confirmed" {
+        t.Errorf("Subject = %q (%v), want the UTF-8 subject back", subject, err)
+    }
+    if from := parsed.Header.Header.Get("From"); from != mailer.From {
+        t.Errorf("From = %q, want %q", from, mailer.From)
+    }
+    if id := parsed.Header.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+        t.Errorf("Message-ID = %q, want one at the sender's domain", id)
+    }
+    if parsed.Parts["text/plain"] != text {
+        t.Errorf("text part = %q, want %q", parsed.Parts["text/plain"], text)
+    }
+    if parsed.Parts["text/html"] != "<p>Thanks &amp; see you soon</p>" {
+        t.Errorf("HTML part = %q", parsed.Parts["text/html"])
+    }
+    for _, line := range strings.Split(string(got.Data), "\r\n") {
+        if len(line) > 998 {
+            t.Fatalf("line of %d characters exceeds the SMTP limit", len(line))
+        }
+    }
+}
+
+func TestSMTPMailerSkipsEmptyParts(t *testing.T) {
+    sink := newSMTPSink(t)
+    mailer := &SMTPMailer{Addr: sink.Addr(), From: "shop@example.com"}
+
+    if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Plain only"}); err != nil {
+        t.Fatal(err)
+    }
+
+    parsed := parseMail(t, sink.received()[0].Data)
+    if len(parsed.Parts) != 1 || parsed.Parts["text/plain"] != "Plain only" {
+        t.Errorf("parts = %q, want only the text part", parsed.Parts)
+    }
+}
+
+func TestSMTPMailerAuth(t *testing.T) {
+    sink := newSMTPSink(t)
+    sink.credentials = "\x00mailer\x00s3cret"
+
+    mailer := &SMTPMailer{Addr: sink.Addr(), From: "shop@example.com", Username: "mailer", Password: "s3cret"}
+    if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if received := sink.received(); len(received) != 1 || received[0].Auth != sink.credentials {
+        t.Errorf("sink got %+v, want one authenticated message", received)
+    }
+
+    mailer.Password = "wrong"
+    if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Hi"}); err == nil {
+        t.Error("Send with a wrong password succeeded")
+    }
+    if received := sink.received(); len(received) != 1 {
+        t.Errorf("sink got %d messages, want the failed login to send none", len(received))
+    }
+}
+
+func TestSMTPMailerErrors(t *testing.T) {
+    sink := newSMTPSink(t)
+    sink.reject["nobody@example.com"] = true
+
+    tests := []struct {
+        name   string
+        mailer *SMTPMailer
+        to     string
+    }{
+        {"rejected recipient", &SMTPMailer{Addr: sink.Addr(), From: "shop@example.com"}, "nobody@example.com"},
+        {"invalid sender", &SMTPMailer{Addr: sink.Addr(), From: "not an address"}, "jane@example.com"},
+        {"address without port", &SMTPMailer{Addr: "127.0.0.1", From: "shop@example.com"}, "jane@example.com"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := tt.mailer.Send(context.Background(), Message{To: tt.to, Subject: "Hi", Text: "Hi"}); err == nil {
+                t.Error("Send succeeded, want an error")
+            }
+        })
+    }
+
+    if received := sink.received(); len(received) != 0 {
+        t.Errorf("sink got %d messages, want none", len(received))
+    }
+}
+
+func TestSMTPMailerHonoursContext(t *testing.T) {
+    // A server that accepts the connection but never greets.
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    go func() {
+        conn, err := ln.Accept()
+        if err == nil {
+            defer conn.Close()
+            io.Copy(io.Discard, conn)
+        }
+    }()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+
+    mailer := &SMTPMailer{Addr: ln.Addr().String(), From: "shop@example.com"}
+    done := make(chan error, 1)
+    go func() {
+        done <- mailer.Send(ctx, Message{To: "jane@example.com", Subject: "Hi", Text: "Hi"})
+    }()
+
+    select {
+    case err := <-done:
+        if err == nil {
+            t.Error("Send to a silent server succeeded")
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("Send did not give up when its context expired")
+    }
+}
+
+func TestSMTPMailerStartTLS(t *testing.T) {
+    sink := newSMTPSink(t)
+    pool := sink.startTLS(t)
+    sink.credentials = "\x00mailer\x00s3cret"
+
+    mailer := &SMTPMailer{Addr: sink.Addr(), From: "shop@example.com", Username: "mailer", Password: "s3cret", rootCAs: pool}
+    if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Hi"}); err != nil {
+        t.Fatal(err)
+    }
+    if received := sink.received(); len(received) != 1 || !received[0].TLS || received[0].Auth != sink.credentials {
+        t.Errorf("sink got %+v, want one message authenticated over TLS", received)
+    }
+
+    // A certificate that does not verify for the configured host fails
+    // rather than sending in the clear.
+    mailer.rootCAs = nil
+    if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Hi"}); err == nil {
+        t.Error("Send trusted a certificate outside the root pool")
+    }
+    if received := sink.received(); len(received) != 1 {
+        t.Errorf("sink got %d messages, want the failed handshake to send none", len(received))
+    }
+}
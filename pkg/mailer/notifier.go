@@ -0,0 +1,216 @@
+package mailer
+
+import (
+    "context"
+    "encoding/json"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "slices"
+    "time"
+)
+
+const sendTimeout = 30 * time.Second
+
+// statusEmails lists the order statuses customers are emailed about.
+var statusEmails = []OrderStatus{Shipped, Delivered, Cancel}
+
+// returnEmails lists the return statuses customers are emailed about.
+// Refunded returns get the refund email instead.
+var returnEmails = []ReturnStatus{ReturnRequested, ReturnApproved, ReturnRejected, ReturnReceived, ReturnExchanged}
+
+// Notifier turns outbox messages into transactional emails. It runs on the
+// outbox relay, which retries failed sends, so a message may be sent more
+// than once but is never silently dropped.
+type Notifier struct {
+    mailer    Mailer
+    orders    *repository.OrderRepository
+    templates *Templates
+    baseURL   string
+}
+
+// NewNotifier returns a Notifier that sends mail with mailer. baseURL is the
+// public address of the shop, used for links; it may be empty.
+func NewNotifier(mailer Mailer, orders *repository.OrderRepository, baseURL string) (*Notifier, error) {
+    templates, err := LoadTemplates()
+    if err != nil {
+        return nil, err
+    }
+
+    return &Notifier{
+        mailer:    mailer,
+        orders:    orders,
+        templates: templates,
+        baseURL:   baseURL,
+    }, nil
+}
+
+// HandleOutbox sends the email that belongs to an outbox message, if any.
+func (n *Notifier) HandleOutbox(ctx context.Context, message OutboxMessage) error {
+    switch message.Topic {
+    case EventOrderPaid:
+        var paid Payment
+        if err := json.Unmarshal([]byte(message.Payload), &paid); err != nil {
+            return err
+        }
+
+        order, err := n.orders.GetOrderWithProduct(ctx, paid.OrderID)
+        if err != nil {
+            return err
+        }
+        return n.OrderPlaced(ctx, order)
+
+    case EventOrderStatusChanged:
+        var event OrderEvent
+        if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+            return err
+        }
+        // Payment outcomes have their own emails, or were shown at checkout.
+        if event.Actor == repository.PaymentActor {
+            return nil
+        }
+
+        order, err := n.orders.GetOrderWithProduct(ctx, event.OrderID)
+        if err != nil {
+            return err
+        }
+        // The order may have moved on since; describe the change that was made.
+        order.Status = event.ToStatus
+        return n.OrderStatusChanged(ctx, order, event.Note)
+
+    case EventOrderRefunded:
+        var refund Refund
+        if err := json.Unmarshal([]byte(message.Payload), &refund); err != nil {
+            return err
+        }
+
+        order, err := n.orders.GetOrderWithProduct(ctx, refund.OrderID)
+        if err != nil {
+            return err
+        }
+        return n.Refunded(ctx, order, &refund)
+
+    case EventReturnUpdated:
+        var ret Return
+        if err := json.Unmarshal([]byte(message.Payload), &ret); err != nil {
+            return err
+        }
+
+        order, err := n.orders.GetById(ctx, ret.OrderID)
+        if err != nil {
+            return err
+        }
+        return n.ReturnStatusChanged(ctx, order, &ret)
+
+    case TopicUserRegistered:
+        var user User
+        if err := json.Unmarshal([]byte(message.Payload), &user); err != nil {
+            return err
+        }
+        return n.Welcome(ctx, &user)
+    }
+
+    return nil
+}
+
+func (n *Notifier) OrderPlaced(ctx context.Context, order *Order) error {
+    data := struct {
+        Order    *Order
+        OrderURL string
+    }{
+        Order:    order,
+        OrderURL: n.orderURL(order),
+    }
+
+    return n.send(ctx, orderConfirmationEmail, order.Email, data)
+}
+
+// OrderStatusChanged emails the customer about the order's current status if
+// it is one they care about; other statuses are ignored.
+func (n *Notifier) OrderStatusChanged(ctx context.Context, order *Order, note string) error {
+    if !slices.Contains(statusEmails, order.Status) {
+        return nil
+    }
+
+    data := struct {
+        Order    *Order
+        Note     string
+        OrderURL string
+    }{
+        Order:    order,
+        Note:     note,
+        OrderURL: n.orderURL(order),
+    }
+
+    return n.send(ctx, orderStatusEmail, order.Email, data)
+}
+
+func (n *Notifier) Refunded(ctx context.Context, order *Order, refund *Refund) error {
+    data := struct {
+        Order    *Order
+        Amount   float64
+        Reason   string
+        OrderURL string
+    }{
+        Order:    order,
+        Amount:   float64(refund.Amount) / 100,
+        Reason:   refund.Reason,
+        OrderURL: n.orderURL(order),
+    }
+
+    return n.send(ctx, refundEmail, order.Email, data)
+}
+
+// ReturnStatusChanged emails the customer about where their return stands,
+// including the RMA number once it is approved.
+func (n *Notifier) ReturnStatusChanged(ctx context.Context, order *Order, ret *Return) error {
+    if !slices.Contains(returnEmails, ret.Status) {
+        return nil
+    }
+
+    data := struct {
+        Return   *Return
+        OrderURL string
+    }{
+        Return:   ret,
+        OrderURL: n.orderURL(order),
+    }
+
+    return n.send(ctx, returnStatusEmail, order.Email, data)
+}
+
+func (n *Notifier) Welcome(ctx context.Context, user *User) error {
+    data := struct {
+        User    *User
+        BaseURL string
+    }{
+        User:    user,
+        BaseURL: n.baseURL,
+    }
+
+    return n.send(ctx, welcomeEmail, user.Email, data)
+}
+
+func (n *Notifier) send(ctx context.Context, name, to string, data any) error {
+    if to == "" {
+        return nil
+    }
+
+    msg, err := n.templates.Render(name, to, data)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+    defer cancel()
+
+    return n.mailer.Send(ctx, msg)
+}
+
+// orderURL links registered customers to their order page. Guests have no
+// account to sign in to, so they get no link.
+func (n *Notifier) orderURL(order *Order) string {
+    if n.baseURL == "" || order.UserID == "" {
+        return ""
+    }
+    return n.baseURL + "/account/orders/" + order.ID.String()
+}
@@ -0,0 +1,207 @@
+package mailer
+
+import (
+    "context"
+    "encoding/json"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "strings"
+    "testing"
+)
+
+// newTestNotifier returns a notifier that mails the sink. It has no order
+// repository, so only messages that carry what they need can be handled.
+func newTestNotifier(t *testing.T, baseURL string) (*Notifier, *smtpSink) {
+    t.Helper()
+
+    sink := newSMTPSink(t)
+    notifier, err := NewNotifier(&SMTPMailer{Addr: sink.Addr(), From: "shop@example.com"}, nil, baseURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return notifier, sink
+}
+
+func outboxMessage(t *testing.T, topic string, payload any) OutboxMessage {
+    t.Helper()
+
+    data, err := json.Marshal(payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return OutboxMessage{ID: uuid.New(), Topic: topic, Payload: string(data)}
+}
+
+func TestNotifierWelcome(t *testing.T) {
+    notifier, sink := newTestNotifier(t, "https://shop.example.com")
+
+    user := &User{ID: uuid.New(), Email: "jane@example.com", Name: "Jane"}
+    if err := notifier.HandleOutbox(context.Background(), outboxMessage(t, TopicUserRegistered, user)); err != nil {
+        t.Fatal(err)
+    }
+
+    received := sink.received()
+    if len(received) != 1 || len(received[0].To) != 1 || received[0].To[0] != "jane@example.com" {
+        t.Fatalf("sink got %+v, want one message to jane@example.com", received)
+    }
+
+    parsed := parseMail(t, received[0].Data)
+    if subject := parsed.Header.Header.Get("Subject"); subject != "Welcome to The Identity Store" {
+        t.Errorf("Subject = %q", subject)
+    }
+    for _, want := range []string{"Welcome, Jane!", "sign in with jane@example.com", "https://shop.example.com/login"} {
+        if !strings.Contains(parsed.Parts["text/plain"], want) {
+            t.Errorf("text part does not contain %q:\n%s", want, parsed.Parts["text/plain"])
+        }
+    }
+    if !strings.Contains(parsed.Parts["text/html"], "Jane") {
+        t.Errorf("HTML part does not greet the user:\n%s", parsed.Parts["text/html"])
+    }
+}
+
+func TestNotifierIgnoresMessages(t *testing.T) {
+    notifier, sink := newTestNotifier(t, "")
+    ctx := context.Background()
+
+    messages := []OutboxMessage{
+        outboxMessage(t, EventProductCreated, Product{Name: "Hat"}),
+        // Payment outcomes are not mailed as status changes, so the order
+        // is never looked up.
+        outboxMessage(t, EventOrderStatusChanged, OrderEvent{OrderID: uuid.New(), ToStatus: Cancel, Actor: repository.PaymentActor}),
+        outboxMessage(t, TopicUserRegistered, User{Name: "No email"}),
+    }
+    for _, message := range messages {
+        if err := notifier.HandleOutbox(ctx, message); err != nil {
+            t.Errorf("HandleOutbox(%s) = %v", message.Topic, err)
+        }
+    }
+
+    if err := notifier.HandleOutbox(ctx, OutboxMessage{Topic: TopicUserRegistered, Payload: "{"}); err == nil {
+        t.Error("HandleOutbox of a malformed payload succeeded, want an error so the relay retries")
+    }
+
+    if received := sink.received(); len(received) != 0 {
+        t.Errorf("sink got %d messages, want none", len(received))
+    }
+}
+
+func TestNotifierOrderStatusChanged(t *testing.T) {
+    tests := []struct {
+        status  OrderStatus
+        subject string
+    }{
+        {Ordered, ""},
+        {Shipped, "Your order is on its way"},
+        {Delivered, "Your order has been delivered"},
+        {Cancel, "Your order has been cancelled"},
+    }
+
+    for _, tt := range tests {
+        t.Run(string(tt.status), func(t *testing.T) {
+            notifier, sink := newTestNotifier(t, "https://shop.example.com")
+
+            order := &Order{ID: uuid.New(), UserID: uuid.NewString(), Email: "jane@example.com", Status: tt.status, Total: 12.5}
+            if err := notifier.OrderStatusChanged(context.Background(), order, "Tracking number 123"); err != nil {
+                t.Fatal(err)
+            }
+
+            received := sink.received()
+            if tt.subject == "" {
+                if len(received) != 0 {
+                    t.Errorf("sink got %d messages for a status customers are not mailed about", len(received))
+                }
+                return
+            }
+            if len(received) != 1 {
+                t.Fatalf("sink got %d messages, want 1", len(received))
+            }
+
+            parsed := parseMail(t, received[0].Data)
+            if subject := parsed.Header.Header.Get("Subject"); subject != tt.subject {
+                t.Errorf("Subject = %q, want %q", subject, tt.subject)
+            }
+            text := parsed.Parts["text/plain"]
+            for _, want := range []string{"Tracking number 123", "Total: $12.50", "https://shop.example.com/account/orders/" + order.ID.String()} {
+                if !strings.Contains(text, want) {
+                    t.Errorf("text part does not contain %q:\n%s", want, text)
+                }
+            }
+        })
+    }
+}
+
+func TestNotifierGuestsGetNoOrderLink(t *testing.T) {
+    notifier, sink := newTestNotifier(t, "https://shop.example.com")
+
+    order := &Order{ID: uuid.New(), Email: "guest@example.com", Status: Shipped}
+    if err := notifier.OrderStatusChanged(context.Background(), order, ""); err != nil {
+        t.Fatal(err)
+    }
+
+    parsed := parseMail(t, sink.received()[0].Data)
+    if strings.Contains(parsed.Parts["text/plain"], "/account/orders/") {
+        t.Errorf("guest email links to an account page:\n%s", parsed.Parts["text/plain"])
+    }
+}
+
+func TestNotifierRefunded(t *testing.T) {
+    notifier, sink := newTestNotifier(t, "")
+
+    order := &Order{ID: uuid.New(), Email: "jane@example.com", Refunded: 20, Total: 80}
+    refund := &Refund{OrderID: order.ID, Amount: 2000, Reason: "Damaged in transit"}
+    if err := notifier.Refunded(context.Background(), order, refund); err != nil {
+        t.Fatal(err)
+    }
+
+    parsed := parseMail(t, sink.received()[0].Data)
+    if subject := parsed.Header.Header.Get("Subject"); subject != "Your refund of $20.00 is on its way" {
+        t.Errorf("Subject = %q", subject)
+    }
+    for _, want := range []string{"Damaged in transit", "Refunded so far: $20.00", "Total: $80.00"} {
+        if !strings.Contains(parsed.Parts["text/plain"], want) {
+            t.Errorf("text part does not contain %q:\n%s", want, parsed.Parts["text/plain"])
+        }
+    }
+}
+
+func TestNotifierReturnStatusChanged(t *testing.T) {
+    tests := []struct {
+        status ReturnStatus
+        want   string
+    }{
+        {ReturnRequested, "We have received your return request"},
+        {ReturnApproved, "Your return RMA-1234ABCD has been approved"},
+        {ReturnRejected, "Your return request was not accepted"},
+        {ReturnReceived, "Your return RMA-1234ABCD has arrived"},
+        {ReturnExchanged, "Your replacement is on its way"},
+        // Refunded returns get the refund email instead.
+        {ReturnRefunded, ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(string(tt.status), func(t *testing.T) {
+            notifier, sink := newTestNotifier(t, "")
+
+            order := &Order{ID: uuid.New(), Email: "jane@example.com"}
+            ret := &Return{RMA: "RMA-1234ABCD", OrderID: order.ID, Status: tt.status, Resolution: ResolutionRefund}
+            if err := notifier.ReturnStatusChanged(context.Background(), order, ret); err != nil {
+                t.Fatal(err)
+            }
+
+            received := sink.received()
+            if tt.want == "" {
+                if len(received) != 0 {
+                    t.Errorf("sink got %d messages, want none", len(received))
+                }
+                return
+            }
+            if len(received) != 1 {
+                t.Fatalf("sink got %d messages, want 1", len(received))
+            }
+            if subject := parseMail(t, received[0].Data).Header.Header.Get("Subject"); subject != tt.want {
+                t.Errorf("Subject = %q, want %q", subject, tt.want)
+            }
+        })
+    }
+}
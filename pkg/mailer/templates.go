@@ -0,0 +1,73 @@
+package mailer
+
+import (
+    "bytes"
+    "embed"
+    htmltemplate "html/template"
+    "strings"
+    texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Names of the emails in templates/. Each has a .txt file defining the
+// "subject" and "body" templates and a .html file defining "content", which
+// is wrapped in the shared layout.
+const (
+    orderConfirmationEmail = "order_confirmation"
+    orderStatusEmail       = "order_status"
+    refundEmail            = "refund"
+    returnStatusEmail      = "return_status"
+    welcomeEmail           = "welcome"
+)
+
+type Templates struct {
+    html map[string]*htmltemplate.Template
+    text map[string]*texttemplate.Template
+}
+
+func LoadTemplates() (*Templates, error) {
+    t := &Templates{
+        html: make(map[string]*htmltemplate.Template),
+        text: make(map[string]*texttemplate.Template),
+    }
+
+    for _, name := range []string{orderConfirmationEmail, orderStatusEmail, refundEmail, returnStatusEmail, welcomeEmail} {
+        html, err := htmltemplate.ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html")
+        if err != nil {
+            return nil, err
+        }
+        t.html[name] = html
+
+        text, err := texttemplate.ParseFS(templateFiles, "templates/"+name+".txt")
+        if err != nil {
+            return nil, err
+        }
+        t.text[name] = text
+    }
+
+    return t, nil
+}
+
+// Render builds the message for the email called name, addressed to to.
+func (t *Templates) Render(name, to string, data any) (Message, error) {
+    var subject, text, html bytes.Buffer
+
+    if err := t.text[name].ExecuteTemplate(&subject, "subject", data); err != nil {
+        return Message{}, err
+    }
+    if err := t.text[name].ExecuteTemplate(&text, "body", data); err != nil {
+        return Message{}, err
+    }
+    if err := t.html[name].ExecuteTemplate(&html, "layout", data); err != nil {
+        return Message{}, err
+    }
+
+    return Message{
+        To:      to,
+        Subject: strings.TrimSpace(subject.String()),
+        Text:    text.String(),
+        HTML:    html.String(),
+    }, nil
+}
@@ -0,0 +1,144 @@
+// Package metrics defines the shop's Prometheus metrics: HTTP traffic by
+// route, the database pool and query timings, and business events such as
+// orders, cart additions and revenue.
+package metrics
+
+import (
+    "crypto/subtle"
+    "database/sql"
+    "github.com/gorilla/mux"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Path is where Handler is served.
+const Path = "/metrics"
+
+var (
+    httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "http_requests_total",
+        Help: "HTTP requests by route template, method and status code.",
+    }, []string{"route", "method", "code"})
+
+    httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "http_request_duration_seconds",
+        Help:    "How long HTTP requests took, by route template and method.",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"route", "method"})
+
+    queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "db_query_duration_seconds",
+        Help:    "How long database statements took, by the repository method that ran them.",
+        Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+    }, []string{"repository", "method"})
+)
+
+// Business events.
+var (
+    OrdersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+        Namespace: "shop",
+        Name:      "orders_placed_total",
+        Help:      "Orders placed at checkout.",
+    })
+
+    CartAdditions = promauto.NewCounter(prometheus.CounterOpts{
+        Namespace: "shop",
+        Name:      "cart_additions_total",
+        Help:      "Units of products added to carts.",
+    })
+
+    Revenue = promauto.NewCounter(prometheus.CounterOpts{
+        Namespace: "shop",
+        Name:      "revenue_total",
+        Help:      "Payments captured, in the shop's currency.",
+    })
+
+    CheckoutFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+        Namespace: "shop",
+        Name:      "checkout_failures_total",
+        Help:      "Checkouts that did not end in an order being paid for, by reason.",
+    }, []string{"reason"})
+)
+
+// RegisterDB adds gauges for the connection pool of db, from its Stats.
+func RegisterDB(db *sql.DB, name string) {
+    prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveQuery records that a statement run by method of repository took d.
+func ObserveQuery(repository, method string, d time.Duration) {
+    queryDuration.WithLabelValues(repository, method).Observe(d.Seconds())
+}
+
+// Handler serves the metrics to Prometheus. With a token, scrapes must send
+// it as a bearer token, as revenue is not for everyone to see.
+func Handler(token string) http.Handler {
+    metrics := promhttp.Handler()
+    if token == "" {
+        return metrics
+    }
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        metrics.ServeHTTP(w, r)
+    })
+}
+
+// Instrument counts and times requests by the template of their route, such
+// as /product/{id}, which keeps the number of series bounded. It is router
+// middleware and so only sees requests a route matched.
+func Instrument(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        route := ""
+        if current := mux.CurrentRoute(r); current != nil {
+            route, _ = current.GetPathTemplate()
+        }
+
+        start := time.Now()
+        recorder := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(recorder, r)
+
+        status := recorder.status
+        if status == 0 {
+            status = http.StatusOK
+        }
+        httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+        httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+    })
+}
+
+// statusRecorder notes the status of a response. It unwraps for
+// http.ResponseController, so handlers can still flush and set deadlines.
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+    if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+    "github.com/google/uuid"
+    "time"
+)
+
+type InvoiceKind string
+
+const (
+    KindInvoice    InvoiceKind = "invoice"
+    KindCreditNote InvoiceKind = "credit_note"
+)
+
+// Prefix is put in front of the sequential number of documents of the kind.
+func (k InvoiceKind) Prefix() string {
+    if k == KindCreditNote {
+        return "CN-"
+    }
+    return "INV-"
+}
+
+// Party is the seller or the buyer named on an invoice.
+type Party struct {
+    Name    string `json:"name"`
+    Email   string `json:"email,omitempty"`
+    Address string `json:"address,omitempty"`
+    TaxID   string `json:"tax_id,omitempty"`
+}
+
+// InvoiceLine amounts are in cents. Prices include tax; Net and Tax split
+// Total at the invoice's tax rate.
+type InvoiceLine struct {
+    Description string `json:"description"`
+    Quantity    int    `json:"quantity"`
+    UnitPrice   int64  `json:"unit_price"`
+    Net         int64  `json:"net"`
+    Tax         int64  `json:"tax"`
+    Total       int64  `json:"total"`
+}
+
+// Invoice is an issued invoice for a paid order, or a credit note for one of
+// its refunds. Once issued it is never changed: corrections are made with a
+// credit note. Credits is the number of the invoice a credit note reduces.
+type Invoice struct {
+    ID       uuid.UUID     `json:"id"`
+    Kind     InvoiceKind   `json:"kind"`
+    Number   string        `json:"number"`
+    OrderID  uuid.UUID     `json:"order_id"`
+    RefundID uuid.NullUUID `json:"refund_id"`
+    Credits  string        `json:"credits,omitempty"`
+    Seller   Party         `json:"seller"`
+    Buyer    Party         `json:"buyer"`
+    Lines    []InvoiceLine `json:"lines"`
+    TaxRate  float64       `json:"tax_rate"`
+    Net      int64         `json:"net"`
+    Tax      int64         `json:"tax"`
+    Total    int64         `json:"total"`
+    Currency string        `json:"currency"`
+    IssuedAt time.Time     `json:"issued_at"`
+    PDF      []byte        `json:"-"`
+}
+
+// Filename is what the document is called when it is downloaded.
+func (i *Invoice) Filename() string {
+    return i.Number + ".pdf"
+}
@@ -1,25 +1,43 @@
 package models
 
 import (
+    "fmt"
     "github.com/google/uuid"
+    "slices"
     "time"
 )
 
+// Order.Total is what the customer paid net of refunds, Subtotal - Refunded.
 type Order struct {
-    ID     uuid.UUID
-    UserID string
-    Status OrderStatus
-    Date   time.Time
-    Items  []OrderItem
-    Total  float64
+    ID            uuid.UUID     `json:"id"`
+    UserID        string        `json:"user_id"`
+    Email         string        `json:"email"`
+    Status        OrderStatus   `json:"status"`
+    PaymentStatus PaymentStatus `json:"payment_status"`
+    Date          time.Time     `json:"date"`
+    Items         []OrderItem   `json:"items"`
+    Subtotal      float64       `json:"subtotal"`
+    Refunded      float64       `json:"refunded"`
+    Total         float64       `json:"total"`
+    Events        []OrderEvent  `json:"events,omitempty"`
 }
 
 type OrderItem struct {
-    OrderID   uuid.UUID
-    ProductID uuid.UUID
-    Quantity  int
-    Product   *Product
-    Cost      float64
+    OrderID          uuid.UUID `json:"order_id"`
+    ProductID        uuid.UUID `json:"product_id"`
+    // Name and UnitPrice are the product's as at checkout; cart items have
+    // neither.
+    Name             string    `json:"name,omitempty"`
+    UnitPrice        float64   `json:"unit_price,omitempty"`
+    Quantity         int       `json:"quantity"`
+    RefundedQuantity int       `json:"refunded_quantity"`
+    Product          *Product  `json:"product,omitempty"`
+    Cost             float64   `json:"cost"`
+}
+
+// RefundableQuantity is how many units of the line have not been refunded.
+func (i OrderItem) RefundableQuantity() int {
+    return i.Quantity - i.RefundedQuantity
 }
 
 type OrderStatus string
@@ -31,3 +49,81 @@ const (
     Delivered OrderStatus = "delivered"
     Cancel    OrderStatus = "cancel"
 )
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Delivered and cancelled orders are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+    Ordered:   {Pending, Cancel},
+    Pending:   {Shipped, Cancel},
+    Shipped:   {Delivered},
+    Delivered: {},
+    Cancel:    {},
+}
+
+var OrderStatuses = []OrderStatus{
+    Ordered,
+    Pending,
+    Shipped,
+    Delivered,
+    Cancel,
+}
+
+func (s OrderStatus) IsValid() bool {
+    _, ok := orderTransitions[s]
+    return ok
+}
+
+// NextStatuses returns the statuses the order may legally move to next.
+func (s OrderStatus) NextStatuses() []OrderStatus {
+    return slices.Clone(orderTransitions[s])
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+    return slices.Contains(orderTransitions[s], next)
+}
+
+// OrderEvent is an entry on the order timeline.
+type OrderEvent struct {
+    ID         uuid.UUID      `json:"id"`
+    OrderID    uuid.UUID      `json:"order_id"`
+    Type       OrderEventType `json:"type"`
+    FromStatus OrderStatus    `json:"from_status"`
+    ToStatus   OrderStatus    `json:"to_status"`
+    Actor      string         `json:"actor"`
+    Note       string         `json:"note"`
+    CreatedAt  time.Time      `json:"created_at"`
+}
+
+type OrderEventType string
+
+const (
+    OrderPlaced        OrderEventType = "placed"
+    OrderStatusChanged OrderEventType = "status_changed"
+    OrderRefunded      OrderEventType = "refunded"
+    OrderReturnUpdated OrderEventType = "return"
+)
+
+// TransitionError is returned when an order is asked to move to a status
+// that is unknown or not reachable from its current one.
+type TransitionError struct {
+    From OrderStatus
+    To   OrderStatus
+}
+
+func (e *TransitionError) Error() string {
+    if !e.To.IsValid() {
+        return fmt.Sprintf("unknown order status %q", e.To)
+    }
+    return fmt.Sprintf("order cannot move from %s to %s", e.From, e.To)
+}
+
+// OutOfStockError is returned when an order asks for more of a product than
+// is in stock.
+type OutOfStockError struct {
+    ProductID uuid.UUID
+    Name      string
+}
+
+func (e *OutOfStockError) Error() string {
+    return fmt.Sprintf("%s is out of stock", e.Name)
+}
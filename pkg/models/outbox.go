@@ -0,0 +1,29 @@
+package models
+
+import (
+    "database/sql"
+    "github.com/google/uuid"
+    "time"
+)
+
+// Outbox topics. Besides these, every webhook event type is also an outbox
+// topic.
+const (
+    TopicUserRegistered = "user.registered"
+)
+
+// OutboxMessage is a side effect recorded in the same transaction as the
+// change that caused it, waiting to be relayed to emails, webhooks and
+// other consumers. DeadAt is set once the relay gives up on the message.
+type OutboxMessage struct {
+    ID          uuid.UUID
+    Topic       string
+    DedupKey    string
+    Payload     string
+    Attempts    int
+    LastError   string
+    AvailableAt time.Time
+    CreatedAt   time.Time
+    ProcessedAt sql.NullTime
+    DeadAt      sql.NullTime
+}
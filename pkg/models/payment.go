@@ -0,0 +1,70 @@
+package models
+
+import (
+    "database/sql"
+    "github.com/google/uuid"
+    "time"
+)
+
+// PaymentStatus tracks the money side of an order, separately from its
+// fulfilment status.
+type PaymentStatus string
+
+const (
+    PaymentUnpaid         PaymentStatus = "unpaid"
+    PaymentRequiresAction PaymentStatus = "requires_action"
+    PaymentProcessing     PaymentStatus = "processing"
+    PaymentAuthorized     PaymentStatus = "authorized"
+    PaymentPaid           PaymentStatus = "paid"
+    PaymentFailed         PaymentStatus = "failed"
+    PaymentVoided         PaymentStatus = "voided"
+    PaymentPartlyRefunded PaymentStatus = "partially_refunded"
+    PaymentRefunded       PaymentStatus = "refunded"
+)
+
+// IsCaptured reports whether the money was taken, whether or not some of it
+// has been refunded since.
+func (s PaymentStatus) IsCaptured() bool {
+    return s == PaymentPaid || s == PaymentPartlyRefunded || s == PaymentRefunded
+}
+
+// Payment is one attempt to pay for an order through a payment provider.
+// Amount is in cents.
+type Payment struct {
+    ID          uuid.UUID     `json:"id"`
+    OrderID     uuid.UUID     `json:"order_id"`
+    Provider    string        `json:"provider"`
+    ProviderRef string        `json:"provider_ref"`
+    Amount      int64         `json:"amount"`
+    Currency    string        `json:"currency"`
+    Status      PaymentStatus `json:"status"`
+    RedirectURL string        `json:"-"`
+    LastError   string        `json:"last_error"`
+    CreatedAt   time.Time     `json:"created_at"`
+    UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type PaymentEventStatus string
+
+const (
+    PaymentEventReceived  PaymentEventStatus = "received"
+    PaymentEventProcessed PaymentEventStatus = "processed"
+    PaymentEventIgnored   PaymentEventStatus = "ignored"
+    PaymentEventFailed    PaymentEventStatus = "failed"
+)
+
+// PaymentEvent is a webhook received from a payment provider, stored as it
+// arrived. EventID is the provider's ID, unique per provider.
+type PaymentEvent struct {
+    ID          uuid.UUID
+    Provider    string
+    EventID     string
+    EventType   string
+    ProviderRef string
+    PaymentID   uuid.NullUUID
+    Payload     string
+    Status      PaymentEventStatus
+    Error       string
+    ReceivedAt  time.Time
+    ProcessedAt sql.NullTime
+}
@@ -6,11 +6,20 @@ import (
 )
 
 type Product struct {
-    ID           uuid.UUID
-    Name         string
-    Price        float64
-    Description  string
-    Image        string
-    CreatedDate  time.Time
-    ModifiedDate time.Time
+    ID           uuid.UUID `json:"id"`
+    SKU          string    `json:"sku"`
+    Name         string    `json:"name"`
+    Price        float64   `json:"price"`
+    Description  string    `json:"description"`
+    Image        string    `json:"image"`
+    // Stock is how many units are left, or nil when the product's stock is
+    // not tracked and it can always be ordered.
+    Stock        *int      `json:"stock"`
+    CreatedDate  time.Time `json:"created_date"`
+    ModifiedDate time.Time `json:"modified_date"`
+}
+
+// InStock reports whether the product can be ordered.
+func (p Product) InStock() bool {
+    return p.Stock == nil || *p.Stock > 0
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+    "github.com/google/uuid"
+    "time"
+)
+
+type RefundStatus string
+
+const (
+    RefundPending   RefundStatus = "pending"
+    RefundSucceeded RefundStatus = "succeeded"
+    RefundFailed    RefundStatus = "failed"
+)
+
+// Refund returns money from a captured payment, for specific order lines,
+// an arbitrary amount, or both. Amounts are in cents. A pending refund holds
+// its lines and amount so they cannot be refunded twice while the provider
+// is being called. ReturnID is set for the refund of a return.
+type Refund struct {
+    ID          uuid.UUID     `json:"id"`
+    OrderID     uuid.UUID     `json:"order_id"`
+    ReturnID    uuid.NullUUID `json:"return_id"`
+    PaymentID   uuid.UUID     `json:"payment_id"`
+    Amount      int64         `json:"amount"`
+    Reason      string        `json:"reason"`
+    Restock     bool          `json:"restock"`
+    Status      RefundStatus  `json:"status"`
+    ProviderRef string        `json:"provider_ref"`
+    Error       string        `json:"error,omitempty"`
+    Actor       string        `json:"actor"`
+    Items       []RefundItem  `json:"items"`
+    CreatedAt   time.Time     `json:"created_at"`
+}
+
+type RefundItem struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Quantity  int       `json:"quantity"`
+    Amount    int64     `json:"amount"`
+}
+
+// RefundError explains why a refund was refused before reaching the
+// provider.
+type RefundError struct {
+    Message string
+}
+
+func (e *RefundError) Error() string {
+    return e.Message
+}
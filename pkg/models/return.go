@@ -0,0 +1,116 @@
+package models
+
+import (
+    "fmt"
+    "github.com/google/uuid"
+    "slices"
+    "time"
+)
+
+type ReturnStatus string
+
+const (
+    ReturnRequested ReturnStatus = "requested"
+    ReturnApproved  ReturnStatus = "approved"
+    ReturnRejected  ReturnStatus = "rejected"
+    ReturnReceived  ReturnStatus = "received"
+    ReturnRefunded  ReturnStatus = "refunded"
+    ReturnExchanged ReturnStatus = "exchanged"
+)
+
+// returnTransitions lists the statuses a return may move to from each status.
+// Rejected, refunded and exchanged returns are final.
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+    ReturnRequested: {ReturnApproved, ReturnRejected},
+    ReturnApproved:  {ReturnReceived, ReturnRejected},
+    ReturnReceived:  {ReturnRefunded, ReturnExchanged},
+    ReturnRejected:  {},
+    ReturnRefunded:  {},
+    ReturnExchanged: {},
+}
+
+var ReturnStatuses = []ReturnStatus{
+    ReturnRequested,
+    ReturnApproved,
+    ReturnReceived,
+    ReturnRejected,
+    ReturnRefunded,
+    ReturnExchanged,
+}
+
+func (s ReturnStatus) IsValid() bool {
+    _, ok := returnTransitions[s]
+    return ok
+}
+
+// NextStatuses returns the statuses the return may legally move to next.
+func (s ReturnStatus) NextStatuses() []ReturnStatus {
+    return slices.Clone(returnTransitions[s])
+}
+
+func (s ReturnStatus) CanTransitionTo(next ReturnStatus) bool {
+    return slices.Contains(returnTransitions[s], next)
+}
+
+// ReturnResolution is what the customer would like in exchange for the
+// goods they send back.
+type ReturnResolution string
+
+const (
+    ResolutionRefund   ReturnResolution = "refund"
+    ResolutionExchange ReturnResolution = "exchange"
+)
+
+func (r ReturnResolution) IsValid() bool {
+    return r == ResolutionRefund || r == ResolutionExchange
+}
+
+// Return is a customer's request to send order items back. Staff approve or
+// reject it; an approved return gets an RMA number for the parcel, and once
+// the goods are received it ends in a refund or an exchange. RMA is empty
+// until the return is approved, RefundID until it is refunded and
+// ReplacementOrderID until it is exchanged.
+type Return struct {
+    ID                 uuid.UUID        `json:"id"`
+    RMA                string           `json:"rma"`
+    OrderID            uuid.UUID        `json:"order_id"`
+    Email              string           `json:"email"`
+    Status             ReturnStatus     `json:"status"`
+    Resolution         ReturnResolution `json:"resolution"`
+    Reason             string           `json:"reason"`
+    Note               string           `json:"note"`
+    RefundID           uuid.NullUUID    `json:"refund_id"`
+    ReplacementOrderID uuid.NullUUID    `json:"replacement_order_id"`
+    Items              []ReturnItem     `json:"items"`
+    CreatedAt          time.Time        `json:"created_at"`
+    UpdatedAt          time.Time        `json:"updated_at"`
+}
+
+type ReturnItem struct {
+    ProductID uuid.UUID `json:"product_id"`
+    Quantity  int       `json:"quantity"`
+    Product   *Product  `json:"product,omitempty"`
+}
+
+// ReturnTransitionError is returned when a return is asked to move to a
+// status that is unknown or not reachable from its current one.
+type ReturnTransitionError struct {
+    From ReturnStatus
+    To   ReturnStatus
+}
+
+func (e *ReturnTransitionError) Error() string {
+    if !e.To.IsValid() {
+        return fmt.Sprintf("unknown return status %q", e.To)
+    }
+    return fmt.Sprintf("return cannot move from %s to %s", e.From, e.To)
+}
+
+// ReturnError explains why a return request was refused.
+type ReturnError struct {
+    Message string
+}
+
+func (e *ReturnError) Error() string {
+    return e.Message
+}
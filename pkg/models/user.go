@@ -0,0 +1,49 @@
+package models
+
+import (
+    "errors"
+    "github.com/google/uuid"
+    "slices"
+    "time"
+)
+
+type User struct {
+    ID           uuid.UUID `json:"id"`
+    Email        string    `json:"email"`
+    Name         string    `json:"name"`
+    PasswordHash string    `json:"-"`
+    Role         Role      `json:"role"`
+    CreatedDate  time.Time `json:"created_date"`
+}
+
+// ErrEmailTaken is returned when creating a user whose email address
+// another account already has.
+var ErrEmailTaken = errors.New("an account with this email already exists")
+
+type Role string
+
+const (
+    Customer    Role = "customer"
+    Owner       Role = "owner"
+    Staff       Role = "staff"
+    ReadOnly    Role = "read-only"
+    Fulfillment Role = "fulfillment"
+)
+
+// AdminRoles lists the roles that may sign in to the admin pages.
+var AdminRoles = []Role{Owner, Staff, ReadOnly, Fulfillment}
+
+func (r Role) IsAdmin() bool {
+    return slices.Contains(AdminRoles, r)
+}
+
+type Session struct {
+    ID        string
+    UserID    uuid.NullUUID
+    CSRFToken string
+    ExpiresAt time.Time
+}
+
+func (s *Session) IsGuest() bool {
+    return !s.UserID.Valid
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+    "database/sql"
+    "github.com/google/uuid"
+    "slices"
+    "time"
+)
+
+// Webhook event types sent to subscribers.
+const (
+    EventOrderPlaced        = "order.placed"
+    EventOrderStatusChanged = "order.status_changed"
+    EventOrderPaid          = "order.paid"
+    EventOrderRefunded      = "order.refunded"
+    EventReturnUpdated      = "return.updated"
+    EventProductCreated     = "product.created"
+    EventProductUpdated     = "product.updated"
+    EventProductDeleted     = "product.deleted"
+)
+
+var WebhookEvents = []string{
+    EventOrderPlaced,
+    EventOrderStatusChanged,
+    EventOrderPaid,
+    EventOrderRefunded,
+    EventReturnUpdated,
+    EventProductCreated,
+    EventProductUpdated,
+    EventProductDeleted,
+}
+
+type WebhookSubscription struct {
+    ID          uuid.UUID
+    URL         string
+    Events      []string
+    Secret      string
+    Active      bool
+    CreatedDate time.Time
+}
+
+// Wants reports whether the subscription should receive eventType. An empty
+// filter subscribes to every event.
+func (s *WebhookSubscription) Wants(eventType string) bool {
+    return len(s.Events) == 0 || slices.Contains(s.Events, eventType)
+}
+
+type DeliveryStatus string
+
+const (
+    DeliveryPending   DeliveryStatus = "pending"
+    DeliverySucceeded DeliveryStatus = "succeeded"
+    DeliveryFailed    DeliveryStatus = "failed"
+)
+
+type WebhookDelivery struct {
+    ID             uuid.UUID
+    SubscriptionID uuid.UUID
+    EventID        uuid.UUID
+    EventType      string
+    Payload        string
+    Status         DeliveryStatus
+    Attempts       int
+    NextAttemptAt  time.Time
+    ResponseStatus int
+    LastError      string
+    CreatedAt      time.Time
+    DeliveredAt    sql.NullTime
+}
@@ -0,0 +1,66 @@
+package openapi
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "net/http"
+)
+
+// Validator returns middleware that checks every request it sees, and the
+// response to it, against the document, calling report with each
+// *ValidationError. Requests and responses go through untouched either
+// way; it is meant for development and staging, to catch the API drifting
+// from its contract.
+func (d *Document) Validator(report func(*http.Request, error)) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if _, err := d.Find(r.Method, r.URL.Path); err != nil {
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            body, err := io.ReadAll(r.Body)
+            r.Body.Close()
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            r.Body = io.NopCloser(bytes.NewReader(body))
+
+            if err := d.ValidateRequest(r, body); err != nil {
+                report(r, err)
+            }
+
+            recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+            next.ServeHTTP(recorder, r)
+
+            err = d.ValidateResponse(r, recorder.status, w.Header(), recorder.body.Bytes())
+            if err != nil && !errors.Is(err, ErrNoOperation) {
+                report(r, err)
+            }
+        })
+    }
+}
+
+// responseRecorder keeps a copy of the response it passes on.
+type responseRecorder struct {
+    http.ResponseWriter
+    status      int
+    wroteHeader bool
+    body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+    if !rec.wroteHeader {
+        rec.status = status
+        rec.wroteHeader = true
+    }
+    rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+    rec.wroteHeader = true
+    rec.body.Write(b)
+    return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,79 @@
+package openapi
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestValidator(t *testing.T) {
+    const product = `{"product_id":"0192a3b4-c5d6-7e8f-9a0b-1c2d3e4f5a6b"}`
+    const notFound = `{"error":{"status":404,"code":"not_found","message":"No such product."}}`
+
+    tests := []struct {
+        name     string
+        method   string
+        path     string
+        body     string
+        status   int
+        response string
+        want     []int
+    }{
+        {name: "conforming", method: "POST", path: "/api/v1/cart/items", body: product, status: 404, response: notFound},
+        {name: "bad request", method: "POST", path: "/api/v1/cart/items", body: `{"quantity":0}`, status: 404, response: notFound, want: []int{0}},
+        {name: "bad response", method: "POST", path: "/api/v1/cart/items", body: product, status: 404, response: `{"error":"No such product."}`, want: []int{404}},
+        {name: "both", method: "POST", path: "/api/v1/cart/items", body: `{`, status: 404, response: `not json`, want: []int{0, 404}},
+        {name: "bad path parameter", method: "GET", path: "/api/v1/products/7", status: 404, response: notFound, want: []int{0}},
+        {name: "not in the document", method: "GET", path: "/static/app.css", status: 200, response: `body {}`},
+    }
+
+    doc, err := Load()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var got []int
+            report := func(r *http.Request, err error) {
+                var invalid *ValidationError
+                if !errors.As(err, &invalid) {
+                    t.Fatalf("report(%v), want a *ValidationError", err)
+                }
+                got = append(got, invalid.Status)
+            }
+
+            var received string
+            handler := doc.Validator(report)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                body, _ := io.ReadAll(r.Body)
+                received = string(body)
+                w.Header().Set("Content-Type", "application/json; charset=utf-8")
+                w.WriteHeader(tt.status)
+                io.WriteString(w, tt.response)
+            }))
+
+            rec := httptest.NewRecorder()
+            req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+            req.Header.Set("Content-Type", "application/json")
+            handler.ServeHTTP(rec, req)
+
+            if received != tt.body {
+                t.Errorf("handler read %q, want the request body %q", received, tt.body)
+            }
+            if rec.Code != tt.status || rec.Body.String() != tt.response {
+                t.Errorf("response = %d %q, want %d %q passed through", rec.Code, rec.Body, tt.status, tt.response)
+            }
+            if len(got) != len(tt.want) {
+                t.Fatalf("reported %v, want %v", got, tt.want)
+            }
+            for i := range got {
+                if got[i] != tt.want[i] {
+                    t.Errorf("reported %v, want %v", got, tt.want)
+                }
+            }
+        })
+    }
+}
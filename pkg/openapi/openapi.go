@@ -0,0 +1,352 @@
+// Package openapi holds the OpenAPI document of the JSON API, the contract
+// partner services code against, and checks requests and responses against
+// it. The document is openapi.json next to this file; the Go client in
+// pkg/client is generated from it.
+package openapi
+
+import (
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+)
+
+//go:embed openapi.json
+var Spec []byte
+
+// Document is the part of an OpenAPI 3.0 document that the validator and
+// the client generator use. Anything else in the document is ignored.
+type Document struct {
+    OpenAPI    string               `json:"openapi"`
+    Info       Info                 `json:"info"`
+    Servers    []Server             `json:"servers"`
+    Paths      map[string]*PathItem `json:"paths"`
+    Components Components           `json:"components"`
+}
+
+type Info struct {
+    Title       string `json:"title"`
+    Version     string `json:"version"`
+    Description string `json:"description"`
+}
+
+type Server struct {
+    URL string `json:"url"`
+}
+
+type PathItem struct {
+    Parameters []*Parameter `json:"parameters"`
+    Get        *Operation   `json:"get"`
+    Put        *Operation   `json:"put"`
+    Post       *Operation   `json:"post"`
+    Delete     *Operation   `json:"delete"`
+    Patch      *Operation   `json:"patch"`
+}
+
+// Operations returns the operations of the path by HTTP method.
+func (p *PathItem) Operations() map[string]*Operation {
+    operations := make(map[string]*Operation)
+    for method, operation := range map[string]*Operation{
+        http.MethodGet:    p.Get,
+        http.MethodPut:    p.Put,
+        http.MethodPost:   p.Post,
+        http.MethodDelete: p.Delete,
+        http.MethodPatch:  p.Patch,
+    } {
+        if operation != nil {
+            operations[method] = operation
+        }
+    }
+    return operations
+}
+
+type Operation struct {
+    OperationID string               `json:"operationId"`
+    Summary     string               `json:"summary"`
+    Description string               `json:"description"`
+    Tags        []string             `json:"tags"`
+    Parameters  []*Parameter         `json:"parameters"`
+    RequestBody *RequestBody         `json:"requestBody"`
+    Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+    Ref         string  `json:"$ref"`
+    Name        string  `json:"name"`
+    In          string  `json:"in"`
+    Description string  `json:"description"`
+    Required    bool    `json:"required"`
+    Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+    Required bool                  `json:"required"`
+    Content  map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+    Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+    Ref         string                `json:"$ref"`
+    Description string                `json:"description"`
+    Content     map[string]*MediaType `json:"content"`
+}
+
+// Schema is the subset of JSON Schema the document uses.
+// AdditionalProperties may only be false, which forbids properties that
+// are not listed.
+type Schema struct {
+    Ref                  string             `json:"$ref"`
+    Type                 string             `json:"type"`
+    Format               string             `json:"format"`
+    Description          string             `json:"description"`
+    Nullable             bool               `json:"nullable"`
+    Enum                 []any              `json:"enum"`
+    Properties           map[string]*Schema `json:"properties"`
+    Required             []string           `json:"required"`
+    AdditionalProperties *bool              `json:"additionalProperties"`
+    Items                *Schema            `json:"items"`
+    AllOf                []*Schema          `json:"allOf"`
+    Minimum              *float64           `json:"minimum"`
+    Maximum              *float64           `json:"maximum"`
+    MinLength            *int               `json:"minLength"`
+    Pattern              string             `json:"pattern"`
+    Default              any                `json:"default"`
+}
+
+type Components struct {
+    Schemas    map[string]*Schema    `json:"schemas"`
+    Parameters map[string]*Parameter `json:"parameters"`
+    Responses  map[string]*Response  `json:"responses"`
+}
+
+// Load parses the embedded document and checks that its references
+// resolve.
+func Load() (*Document, error) {
+    return Parse(Spec)
+}
+
+func Parse(data []byte) (*Document, error) {
+    var doc Document
+    if err := json.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("openapi: %w", err)
+    }
+    if len(doc.Servers) != 1 {
+        return nil, errors.New("openapi: the document must have exactly one server")
+    }
+
+    var problems []string
+    for _, path := range doc.PathNames() {
+        item := doc.Paths[path]
+        for _, parameter := range item.Parameters {
+            problems = append(problems, doc.checkParameter(parameter, path)...)
+        }
+        for method, operation := range item.Operations() {
+            at := method + " " + path
+            if operation.OperationID == "" {
+                problems = append(problems, at+": no operationId")
+            }
+            for _, parameter := range operation.Parameters {
+                problems = append(problems, doc.checkParameter(parameter, at)...)
+            }
+            if operation.RequestBody != nil {
+                for _, media := range operation.RequestBody.Content {
+                    problems = append(problems, doc.checkSchema(media.Schema, at+" request")...)
+                }
+            }
+            for status, response := range operation.Responses {
+                resolved, err := doc.Response(response)
+                if err != nil {
+                    problems = append(problems, at+" "+status+": "+err.Error())
+                    continue
+                }
+                for _, media := range resolved.Content {
+                    problems = append(problems, doc.checkSchema(media.Schema, at+" "+status)...)
+                }
+            }
+        }
+    }
+    for name, schema := range doc.Components.Schemas {
+        problems = append(problems, doc.checkSchema(schema, name)...)
+    }
+    if len(problems) > 0 {
+        return nil, errors.New("openapi: " + strings.Join(problems, "; "))
+    }
+
+    return &doc, nil
+}
+
+// BasePath is the path the operations' paths are relative to.
+func (d *Document) BasePath() string {
+    return strings.TrimSuffix(d.Servers[0].URL, "/")
+}
+
+// PathNames returns the paths of the document in order.
+func (d *Document) PathNames() []string {
+    names := make([]string, 0, len(d.Paths))
+    for name := range d.Paths {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Schema follows a reference to a named schema.
+func (d *Document) Schema(s *Schema) (*Schema, error) {
+    if s == nil || s.Ref == "" {
+        return s, nil
+    }
+    name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+    if schema := d.Components.Schemas[name]; ok && schema != nil {
+        return schema, nil
+    }
+    return nil, fmt.Errorf("unknown schema %s", s.Ref)
+}
+
+// Parameter follows a reference to a named parameter.
+func (d *Document) Parameter(p *Parameter) (*Parameter, error) {
+    if p.Ref == "" {
+        return p, nil
+    }
+    name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+    if parameter := d.Components.Parameters[name]; ok && parameter != nil {
+        return parameter, nil
+    }
+    return nil, fmt.Errorf("unknown parameter %s", p.Ref)
+}
+
+// Response follows a reference to a named response.
+func (d *Document) Response(r *Response) (*Response, error) {
+    if r.Ref == "" {
+        return r, nil
+    }
+    name, ok := strings.CutPrefix(r.Ref, "#/components/responses/")
+    if response := d.Components.Responses[name]; ok && response != nil {
+        return response, nil
+    }
+    return nil, fmt.Errorf("unknown response %s", r.Ref)
+}
+
+func (d *Document) checkParameter(p *Parameter, at string) []string {
+    parameter, err := d.Parameter(p)
+    if err != nil {
+        return []string{at + ": " + err.Error()}
+    }
+    return d.checkSchema(parameter.Schema, at+" parameter "+parameter.Name)
+}
+
+func (d *Document) checkSchema(s *Schema, at string) []string {
+    if s == nil {
+        return nil
+    }
+    if s.Ref != "" {
+        if _, err := d.Schema(s); err != nil {
+            return []string{at + ": " + err.Error()}
+        }
+        return nil
+    }
+
+    var problems []string
+    for name, property := range s.Properties {
+        problems = append(problems, d.checkSchema(property, at+"."+name)...)
+    }
+    for _, schema := range s.AllOf {
+        problems = append(problems, d.checkSchema(schema, at)...)
+    }
+    problems = append(problems, d.checkSchema(s.Items, at+"[]")...)
+    return problems
+}
+
+// Route is an operation of the document matched to a request.
+type Route struct {
+    Method     string
+    Path       string
+    Operation  *Operation
+    Parameters []*Parameter
+    PathValues map[string]string
+}
+
+// ErrNoOperation is returned by Find for requests the document does not
+// describe.
+var ErrNoOperation = errors.New("openapi: no operation matches the request")
+
+// Find matches a request method and URL path to an operation. Literal path
+// segments win over templated ones, so /orders/mine would beat
+// /orders/{id}.
+func (d *Document) Find(method, urlPath string) (*Route, error) {
+    path, ok := strings.CutPrefix(urlPath, d.BasePath())
+    if !ok || (path != "" && path[0] != '/') {
+        return nil, ErrNoOperation
+    }
+
+    segments := strings.Split(strings.Trim(path, "/"), "/")
+
+    var best *Route
+    bestScore := -1
+    for _, template := range d.PathNames() {
+        item := d.Paths[template]
+        operation := item.Operations()[method]
+        if operation == nil {
+            continue
+        }
+
+        values, score, ok := matchPath(strings.Split(strings.Trim(template, "/"), "/"), segments)
+        if !ok || score <= bestScore {
+            continue
+        }
+
+        best = &Route{Method: method, Path: template, Operation: operation, PathValues: values}
+        bestScore = score
+    }
+    if best == nil {
+        return nil, ErrNoOperation
+    }
+
+    // Operation parameters override path parameters of the same name.
+    seen := make(map[string]bool)
+    for _, parameters := range [][]*Parameter{best.Operation.Parameters, d.Paths[best.Path].Parameters} {
+        for _, p := range parameters {
+            parameter, err := d.Parameter(p)
+            if err != nil {
+                return nil, err
+            }
+            if key := parameter.In + " " + parameter.Name; !seen[key] {
+                seen[key] = true
+                best.Parameters = append(best.Parameters, parameter)
+            }
+        }
+    }
+
+    return best, nil
+}
+
+// matchPath matches path segments to a path template, returning the values
+// of its {parameters} and how many segments matched literally.
+func matchPath(template, segments []string) (map[string]string, int, bool) {
+    if len(template) != len(segments) {
+        return nil, 0, false
+    }
+
+    values := make(map[string]string)
+    score := 0
+    for i, part := range template {
+        if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+            if segments[i] == "" {
+                return nil, 0, false
+            }
+            values[part[1:len(part)-1]] = segments[i]
+            continue
+        }
+        if part != segments[i] {
+            return nil, 0, false
+        }
+        score++
+    }
+
+    return values, score, true
+}
@@ -0,0 +1,321 @@
+package openapi
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "github.com/google/uuid"
+    "io"
+    "mime"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+// ValidationError lists how a request or response breaks the document.
+type ValidationError struct {
+    Method   string
+    Path     string
+    Status   int
+    Problems []string
+}
+
+func (e *ValidationError) Error() string {
+    subject := "request"
+    if e.Status != 0 {
+        subject = strconv.Itoa(e.Status) + " response"
+    }
+    return fmt.Sprintf("openapi: %s to %s %s: %s", subject, e.Method, e.Path, strings.Join(e.Problems, "; "))
+}
+
+// ValidateRequest checks the parameters and body of r against its
+// operation. The body is passed separately because reading r.Body uses it
+// up. It returns ErrNoOperation for requests the document does not
+// describe and a *ValidationError for those that break it.
+func (d *Document) ValidateRequest(r *http.Request, body []byte) error {
+    route, err := d.Find(r.Method, r.URL.Path)
+    if err != nil {
+        return err
+    }
+
+    var problems []string
+    query := r.URL.Query()
+    for _, parameter := range route.Parameters {
+        var value string
+        var present bool
+        switch parameter.In {
+        case "path":
+            value, present = route.PathValues[parameter.Name]
+        case "query":
+            value, present = query.Get(parameter.Name), query.Has(parameter.Name)
+        case "header":
+            value, present = r.Header.Get(parameter.Name), r.Header.Get(parameter.Name) != ""
+        default:
+            continue
+        }
+
+        at := parameter.In + " parameter " + parameter.Name
+        if !present {
+            if parameter.Required {
+                problems = append(problems, at+" is required")
+            }
+            continue
+        }
+        problems = append(problems, d.validateParameter(parameter.Schema, value, at)...)
+    }
+
+    requestBody := route.Operation.RequestBody
+    switch {
+    case len(body) == 0:
+        if requestBody != nil && requestBody.Required {
+            problems = append(problems, "the request body is required")
+        }
+    case requestBody == nil:
+        problems = append(problems, "the operation takes no request body")
+    default:
+        problems = append(problems, d.validateContent(requestBody.Content, r.Header.Get("Content-Type"), body)...)
+    }
+
+    if len(problems) > 0 {
+        return &ValidationError{Method: r.Method, Path: route.Path, Problems: problems}
+    }
+    return nil
+}
+
+// ValidateResponse checks a response to r against the responses of its
+// operation, falling back to the default response for statuses that are
+// not listed.
+func (d *Document) ValidateResponse(r *http.Request, status int, header http.Header, body []byte) error {
+    route, err := d.Find(r.Method, r.URL.Path)
+    if err != nil {
+        return err
+    }
+
+    var problems []string
+    response, ok := route.Operation.Responses[strconv.Itoa(status)]
+    if !ok {
+        response, ok = route.Operation.Responses["default"]
+    }
+    if !ok {
+        problems = append(problems, "the status is not documented")
+    } else if response, err = d.Response(response); err != nil {
+        problems = append(problems, err.Error())
+    } else if len(response.Content) == 0 {
+        if len(body) > 0 {
+            problems = append(problems, "the response should have no body")
+        }
+    } else {
+        problems = append(problems, d.validateContent(response.Content, header.Get("Content-Type"), body)...)
+    }
+
+    if len(problems) > 0 {
+        return &ValidationError{Method: r.Method, Path: route.Path, Status: status, Problems: problems}
+    }
+    return nil
+}
+
+// validateContent checks a body against the media type it claims to be.
+// Only JSON bodies are checked beyond their media type.
+func (d *Document) validateContent(content map[string]*MediaType, contentType string, body []byte) []string {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return []string{"invalid Content-Type " + strconv.Quote(contentType)}
+    }
+
+    media, ok := content[mediaType]
+    if !ok {
+        return []string{"Content-Type " + mediaType + " is not documented"}
+    }
+    if media.Schema == nil || mediaType != "application/json" {
+        return nil
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(body))
+    decoder.UseNumber()
+
+    var value any
+    if err := decoder.Decode(&value); err != nil {
+        return []string{"the body is not valid JSON: " + err.Error()}
+    }
+    if _, err := decoder.Token(); err != io.EOF {
+        return []string{"the body has data after its JSON value"}
+    }
+
+    return d.validate(media.Schema, value, "body")
+}
+
+// validateParameter checks the text of a parameter as the type its schema
+// says it is.
+func (d *Document) validateParameter(s *Schema, text, at string) []string {
+    schema, err := d.Schema(s)
+    if err != nil {
+        return []string{at + ": " + err.Error()}
+    }
+    if schema == nil {
+        return nil
+    }
+
+    var value any = text
+    switch schema.Type {
+    case "integer", "number":
+        value = json.Number(text)
+    case "boolean":
+        parsed, err := strconv.ParseBool(text)
+        if err != nil {
+            return []string{at + " should be a boolean"}
+        }
+        value = parsed
+    }
+
+    return d.validate(schema, value, at)
+}
+
+// validate checks a decoded JSON value against a schema and returns a
+// problem for each way it does not fit, naming where in the value it is.
+func (d *Document) validate(s *Schema, value any, at string) []string {
+    schema, err := d.Schema(s)
+    if err != nil {
+        return []string{at + ": " + err.Error()}
+    }
+    if schema == nil {
+        return nil
+    }
+
+    if value == nil {
+        if schema.Nullable {
+            return nil
+        }
+        // A null still fits a schema without a type, unless allOf says
+        // otherwise.
+        if schema.Type != "" {
+            return []string{at + " should not be null"}
+        }
+    }
+
+    var problems []string
+    for _, part := range schema.AllOf {
+        problems = append(problems, d.validate(part, value, at)...)
+    }
+
+    switch schema.Type {
+    case "object":
+        object, ok := value.(map[string]any)
+        if !ok {
+            return append(problems, at+" should be an object")
+        }
+        for _, name := range schema.Required {
+            if _, ok := object[name]; !ok {
+                problems = append(problems, at+"."+name+" is required")
+            }
+        }
+        names := make([]string, 0, len(object))
+        for name := range object {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            property := object[name]
+            if propertySchema, ok := schema.Properties[name]; ok {
+                problems = append(problems, d.validate(propertySchema, property, at+"."+name)...)
+            } else if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+                problems = append(problems, at+"."+name+" is not allowed")
+            }
+        }
+
+    case "array":
+        array, ok := value.([]any)
+        if !ok {
+            return append(problems, at+" should be an array")
+        }
+        for i, item := range array {
+            problems = append(problems, d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+        }
+
+    case "string":
+        text, ok := value.(string)
+        if !ok {
+            return append(problems, at+" should be a string")
+        }
+        problems = append(problems, validateString(schema, text, at)...)
+
+    case "integer", "number":
+        number, ok := value.(json.Number)
+        if !ok {
+            return append(problems, at+" should be a number")
+        }
+        problems = append(problems, validateNumber(schema, number, at)...)
+
+    case "boolean":
+        if _, ok := value.(bool); !ok {
+            return append(problems, at+" should be a boolean")
+        }
+    }
+
+    if len(schema.Enum) > 0 {
+        found := false
+        for _, allowed := range schema.Enum {
+            if fmt.Sprint(allowed) == fmt.Sprint(value) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            problems = append(problems, fmt.Sprintf("%s should be one of %v, not %v", at, schema.Enum, value))
+        }
+    }
+
+    return problems
+}
+
+func validateString(schema *Schema, text, at string) []string {
+    var problems []string
+
+    if schema.MinLength != nil && utf8.RuneCountInString(text) < *schema.MinLength {
+        problems = append(problems, fmt.Sprintf("%s should be at least %d characters", at, *schema.MinLength))
+    }
+    if schema.Pattern != "" {
+        if matched, err := regexp.MatchString(schema.Pattern, text); err != nil || !matched {
+            problems = append(problems, at+" should match "+schema.Pattern)
+        }
+    }
+
+    var err error
+    switch schema.Format {
+    case "uuid":
+        _, err = uuid.Parse(text)
+    case "date-time":
+        _, err = time.Parse(time.RFC3339, text)
+    case "date":
+        _, err = time.Parse(time.DateOnly, text)
+    }
+    if err != nil {
+        problems = append(problems, at+" should be a "+schema.Format)
+    }
+
+    return problems
+}
+
+func validateNumber(schema *Schema, number json.Number, at string) []string {
+    value, err := number.Float64()
+    if err != nil {
+        return []string{at + " should be a number"}
+    }
+    if schema.Type == "integer" {
+        if _, err := number.Int64(); err != nil {
+            return []string{at + " should be an integer"}
+        }
+    }
+
+    var problems []string
+    if schema.Minimum != nil && value < *schema.Minimum {
+        problems = append(problems, fmt.Sprintf("%s should be at least %v", at, *schema.Minimum))
+    }
+    if schema.Maximum != nil && value > *schema.Maximum {
+        problems = append(problems, fmt.Sprintf("%s should be at most %v", at, *schema.Maximum))
+    }
+    return problems
+}
@@ -0,0 +1,5 @@
+// Package orderpb holds the protobuf messages and gRPC stubs of the order
+// service, generated from orders.proto.
+package orderpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative orders.proto
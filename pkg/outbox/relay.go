@@ -0,0 +1,175 @@
+package outbox
+
+import (
+    "context"
+    "fmt"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "log/slog"
+    "time"
+)
+
+const (
+    baseBackoff  = 5 * time.Second
+    maxBackoff   = time.Hour
+    claimTimeout = 2 * time.Minute
+    batchSize    = 50
+    retention    = 7 * 24 * time.Hour
+    // maxAttempts is how often a message is tried, about a day's worth at
+    // maxBackoff, before it is given up on as dead.
+    maxAttempts  = 30
+)
+
+// HandlerFunc consumes one outbox message. A subscriber that handled a
+// message is not handed it again, but a crash between the two can still
+// deliver it twice, so a handler must tolerate seeing the same message
+// again; the message ID is stable and can be used to deduplicate.
+type HandlerFunc func(ctx context.Context, message OutboxMessage) error
+
+type subscriber struct {
+    name    string
+    handler HandlerFunc
+}
+
+// Relay publishes outbox messages to its subscribers and marks them
+// processed once every subscriber has handled them. A message that fails is
+// retried later with exponential backoff, for the subscribers that have not
+// handled it yet, and again by whichever relay picks it up if this process
+// dies half way. After maxAttempts it is marked dead.
+type Relay struct {
+    repo         *repository.OutboxRepository
+    subscribers  []subscriber
+    pollInterval time.Duration
+    // Heartbeat, if set, is called on every poll and every message, so
+    // that a health check can tell the relay is still making progress.
+    Heartbeat    func()
+}
+
+func NewRelay(repo *repository.OutboxRepository) *Relay {
+    return &Relay{
+        repo:         repo,
+        pollInterval: time.Second,
+    }
+}
+
+// Subscribe registers handler for every message. Subscribers run in the order
+// they were added. The name records which subscribers handled a message, so
+// it must stay the same across releases.
+func (r *Relay) Subscribe(name string, handler HandlerFunc) {
+    r.subscribers = append(r.subscribers, subscriber{name: name, handler: handler})
+}
+
+// Run relays due messages until ctx is cancelled. Processed messages are
+// kept for a week so they can be inspected, then deleted.
+func (r *Relay) Run(ctx context.Context) {
+    ticker := time.NewTicker(r.pollInterval)
+    defer ticker.Stop()
+
+    cleanup := time.NewTicker(time.Hour)
+    defer cleanup.Stop()
+
+    for {
+        r.beat()
+        r.relayDue(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-cleanup.C:
+            if _, err := r.repo.DeleteProcessed(ctx, time.Now().Add(-retention)); err != nil {
+                slog.Error("outbox: delete processed", "error", err)
+            }
+        case <-ticker.C:
+        }
+    }
+}
+
+func (r *Relay) relayDue(ctx context.Context) {
+    messages, err := r.repo.FindDue(ctx, time.Now(), batchSize)
+    if err != nil {
+        slog.Error("outbox: find due messages", "error", err)
+        return
+    }
+
+    for _, message := range messages {
+        if ctx.Err() != nil {
+            return
+        }
+        r.beat()
+
+        claimed, err := r.repo.Claim(ctx, &message, time.Now().Add(claimTimeout))
+        if err != nil {
+            slog.Error("outbox: claim message", "message_id", message.ID, "error", err)
+            continue
+        }
+        if !claimed {
+            continue
+        }
+
+        // The outcome is recorded even if shutdown began meanwhile.
+        record := context.WithoutCancel(ctx)
+        if err := r.relay(ctx, record, message); err != nil {
+            message.Attempts++
+            message.LastError = err.Error()
+
+            if message.Attempts >= maxAttempts {
+                slog.Error("outbox: giving up on message", "topic", message.Topic, "message_id", message.ID, "attempt", message.Attempts, "error", err)
+                if err := r.repo.MarkDead(record, &message); err != nil {
+                    slog.Error("outbox: mark dead", "message_id", message.ID, "error", err)
+                }
+                continue
+            }
+
+            message.AvailableAt = time.Now().Add(backoff(message.Attempts))
+            slog.Warn("outbox: relay", "topic", message.Topic, "message_id", message.ID, "attempt", message.Attempts, "error", err)
+
+            if err := r.repo.RecordFailure(record, &message); err != nil {
+                slog.Error("outbox: record failure", "message_id", message.ID, "error", err)
+            }
+            continue
+        }
+
+        if err := r.repo.MarkProcessed(record, message.ID); err != nil {
+            slog.Error("outbox: mark processed", "message_id", message.ID, "error", err)
+        }
+    }
+}
+
+func (r *Relay) beat() {
+    if r.Heartbeat != nil {
+        r.Heartbeat()
+    }
+}
+
+// relay hands message to the subscribers that have not handled it yet,
+// recording each that succeeds with the record context.
+func (r *Relay) relay(ctx, record context.Context, message OutboxMessage) error {
+    delivered, err := r.repo.Delivered(ctx, message.ID)
+    if err != nil {
+        return fmt.Errorf("find deliveries: %w", err)
+    }
+
+    for _, subscriber := range r.subscribers {
+        if delivered[subscriber.name] {
+            continue
+        }
+        if err := subscriber.handler(ctx, message); err != nil {
+            return fmt.Errorf("%s: %w", subscriber.name, err)
+        }
+        if err := r.repo.MarkDelivered(record, message.ID, subscriber.name); err != nil {
+            return fmt.Errorf("%s: mark delivered: %w", subscriber.name, err)
+        }
+    }
+    return nil
+}
+
+// backoff doubles the wait after every failed attempt, up to maxBackoff.
+// A side effect that keeps failing shows up in the log and in the last_error
+// column, and stays there once the message is dead, until it is fixed.
+func backoff(attempts int) time.Duration {
+    wait := baseBackoff << (attempts - 1)
+    if wait <= 0 || wait > maxBackoff {
+        return maxBackoff
+    }
+    return wait
+}
@@ -0,0 +1,308 @@
+package payment
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "github.com/google/uuid"
+    "log/slog"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Test card numbers understood by FakeProvider. Any other number that passes
+// the Luhn check is authorized.
+const (
+    CardSuccess           = "4242424242424242"
+    CardDeclined          = "4000000000000002"
+    CardInsufficientFunds = "4000000000009995"
+    CardRequiresAction    = "4000000000003220"
+    CardTimeout           = "4000000000000119"
+)
+
+const fakePath = "/payments/fake/"
+
+// FakeProvider is an in-memory gateway for development. Its behaviour
+// depends only on the card number, so every scenario can be reproduced on
+// demand. Intents live in memory and are lost on restart.
+//
+// CardTimeout authorizes the payment but then blocks until the caller's
+// context is done, like a gateway whose response never arrives.
+// CardRequiresAction redirects the customer to a page served by the
+// provider itself, where they approve or fail the authentication.
+//
+// Outcomes the caller did not hear about synchronously, a timed out
+// authorization and a finished authentication, are also sent as signed
+// webhooks to webhookURL a moment later, as a real gateway would.
+type FakeProvider struct {
+    mu      sync.Mutex
+    intents map[string]*fakeIntent
+    keys    map[string]string
+
+    webhookURL    string
+    webhookSecret string
+    client        *http.Client
+    // notifyDelay is how long a webhook waits before it is first sent.
+    notifyDelay   time.Duration
+}
+
+type fakeIntent struct {
+    Intent
+    returnURL string
+}
+
+// NewFakeProvider returns a fake gateway that sends its webhooks to
+// webhookURL, signed with webhookSecret. An empty webhookURL disables them.
+func NewFakeProvider(webhookURL, webhookSecret string) *FakeProvider {
+    return &FakeProvider{
+        intents:       make(map[string]*fakeIntent),
+        keys:          make(map[string]string),
+        webhookURL:    webhookURL,
+        webhookSecret: webhookSecret,
+        client:        &http.Client{Timeout: 10 * time.Second},
+        notifyDelay:   3 * time.Second,
+    }
+}
+
+func (p *FakeProvider) Name() string {
+    return "fake"
+}
+
+func (p *FakeProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Intent, error) {
+    p.mu.Lock()
+    if ref, ok := p.keys[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+        intent := p.intents[ref].Intent
+        p.mu.Unlock()
+        return &intent, nil
+    }
+
+    ref := "fake_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+    intent := &fakeIntent{
+        Intent: Intent{
+            Ref:       ref,
+            Reference: req.IdempotencyKey,
+            Status:    IntentAuthorized,
+            Amount:    req.Amount,
+            Currency:  req.Currency,
+        },
+        returnURL: req.ReturnURL,
+    }
+
+    card := strings.ReplaceAll(req.CardNumber, " ", "")
+    switch {
+    case card == CardDeclined:
+        intent.Status = IntentDeclined
+        intent.DeclineReason = "Your card was declined."
+    case card == CardInsufficientFunds:
+        intent.Status = IntentDeclined
+        intent.DeclineReason = "Your card has insufficient funds."
+    case card == CardRequiresAction:
+        intent.Status = IntentRequiresAction
+        intent.RedirectURL = fakePath + "authenticate/" + ref
+    case !luhnValid(card):
+        intent.Status = IntentDeclined
+        intent.DeclineReason = "Your card number is incorrect."
+    }
+
+    p.intents[ref] = intent
+    if req.IdempotencyKey != "" {
+        p.keys[req.IdempotencyKey] = ref
+    }
+    result := intent.Intent
+    p.mu.Unlock()
+
+    if card == CardTimeout {
+        <-ctx.Done()
+        p.notify(EventIntentAuthorized, result)
+        return nil, fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+    }
+
+    return &result, nil
+}
+
+func (p *FakeProvider) Get(ctx context.Context, ref string) (*Intent, error) {
+    return p.update(ref, func(intent *Intent) error {
+        return nil
+    })
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, ref string, amount int64) (*Intent, error) {
+    return p.update(ref, func(intent *Intent) error {
+        if intent.Status != IntentAuthorized || amount <= 0 || amount > intent.Amount {
+            return ErrInvalidState
+        }
+        intent.Status = IntentCaptured
+        intent.Captured = amount
+        return nil
+    })
+}
+
+func (p *FakeProvider) Void(ctx context.Context, ref string) (*Intent, error) {
+    return p.update(ref, func(intent *Intent) error {
+        if intent.Status != IntentAuthorized && intent.Status != IntentRequiresAction {
+            return ErrInvalidState
+        }
+        intent.Status = IntentVoided
+        return nil
+    })
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, ref string, amount int64) (*Intent, error) {
+    return p.update(ref, func(intent *Intent) error {
+        if intent.Status != IntentCaptured || amount <= 0 || intent.Refunded+amount > intent.Captured {
+            return ErrInvalidState
+        }
+        intent.Refunded += amount
+        return nil
+    })
+}
+
+func (p *FakeProvider) update(ref string, apply func(intent *Intent) error) (*Intent, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    intent, ok := p.intents[ref]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    if err := apply(&intent.Intent); err != nil {
+        return nil, err
+    }
+
+    result := intent.Intent
+    return &result, nil
+}
+
+// ServeHTTP serves the authentication page customers are redirected to for
+// CardRequiresAction. Mount it at /payments/fake/.
+func (p *FakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    ref, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, fakePath+"authenticate/"), "/")
+
+    p.mu.Lock()
+    intent, ok := p.intents[ref]
+    p.mu.Unlock()
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+
+    switch action {
+    case "":
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        fmt.Fprintf(w, fakeAuthenticatePage, float64(intent.Amount)/100, intent.Currency, ref, ref)
+        return
+    case "approve", "fail":
+    default:
+        http.NotFound(w, r)
+        return
+    }
+
+    authenticated, err := p.update(ref, func(intent *Intent) error {
+        if intent.Status != IntentRequiresAction {
+            return ErrInvalidState
+        }
+        if action == "approve" {
+            intent.Status = IntentAuthorized
+        } else {
+            intent.Status = IntentDeclined
+            intent.DeclineReason = "Your bank could not authenticate the payment."
+        }
+        intent.RedirectURL = ""
+        return nil
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+
+    if authenticated.Status == IntentAuthorized {
+        p.notify(EventIntentAuthorized, *authenticated)
+    } else {
+        p.notify(EventIntentDeclined, *authenticated)
+    }
+
+    http.Redirect(w, r, intent.returnURL, http.StatusSeeOther)
+}
+
+// ParseWebhook verifies webhooks signed with the fake provider's secret,
+// whether it sent them itself or they are fixtures posted by a test.
+func (p *FakeProvider) ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+    return VerifyWebhook(p.webhookSecret, header.Get(SignatureHeader), body, time.Now())
+}
+
+// notify sends a webhook about intent in the background. It waits a little
+// first so that a customer who does come back is usually handled before the
+// webhook arrives, and retries a few times like a real gateway.
+func (p *FakeProvider) notify(eventType string, intent Intent) {
+    if p.webhookURL == "" {
+        return
+    }
+
+    event := WebhookEvent{
+        ID:        "evt_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+        Type:      eventType,
+        CreatedAt: time.Now().UTC(),
+        Intent:    intent,
+    }
+    body, err := json.Marshal(event)
+    if err != nil {
+        slog.Error("payment: fake webhook", "error", err)
+        return
+    }
+
+    go func() {
+        wait := p.notifyDelay
+        for attempt := 1; attempt <= 5; attempt++ {
+            time.Sleep(wait)
+            err := PostWebhook(context.Background(), p.client, p.webhookURL, p.webhookSecret, body)
+            if err == nil {
+                return
+            }
+            slog.Warn("payment: fake webhook", "event_id", event.ID, "attempt", attempt, "error", err)
+            wait *= 2
+        }
+    }()
+}
+
+const fakeAuthenticatePage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Fake bank authentication</title></head>
+<body style="font-family: sans-serif; max-width: 30em; margin: 4em auto; text-align: center">
+    <h1>Fake bank</h1>
+    <p>Confirm the payment of %.2f %s.</p>
+    <p>
+        <a href="` + fakePath + `authenticate/%s/approve">Complete authentication</a>
+        &nbsp;|&nbsp;
+        <a href="` + fakePath + `authenticate/%s/fail">Fail authentication</a>
+    </p>
+</body>
+</html>
+`
+
+// luhnValid reports whether number is a plausible card number.
+func luhnValid(number string) bool {
+    if len(number) < 12 || len(number) > 19 {
+        return false
+    }
+
+    sum := 0
+    double := false
+    for i := len(number) - 1; i >= 0; i-- {
+        digit := int(number[i] - '0')
+        if digit < 0 || digit > 9 {
+            return false
+        }
+        if double {
+            digit *= 2
+            if digit > 9 {
+                digit -= 9
+            }
+        }
+        sum += digit
+        double = !double
+    }
+
+    return sum%10 == 0
+}
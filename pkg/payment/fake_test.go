@@ -0,0 +1,206 @@
+package payment
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func authorize(t *testing.T, provider *FakeProvider, card string) *Intent {
+    t.Helper()
+
+    intent, err := provider.Authorize(context.Background(), AuthorizeRequest{
+        IdempotencyKey: "key-" + card,
+        Amount:         4599,
+        Currency:       "usd",
+        CardNumber:     card,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return intent
+}
+
+func TestFakeProviderAuthorize(t *testing.T) {
+    tests := []struct {
+        card          string
+        status        IntentStatus
+        declineReason string
+    }{
+        {CardSuccess, IntentAuthorized, ""},
+        {"4242 4242 4242 4242", IntentAuthorized, ""},
+        {CardDeclined, IntentDeclined, "Your card was declined."},
+        {CardInsufficientFunds, IntentDeclined, "Your card has insufficient funds."},
+        {CardRequiresAction, IntentRequiresAction, ""},
+        {"4242424242424241", IntentDeclined, "Your card number is incorrect."},
+        {"4242", IntentDeclined, "Your card number is incorrect."},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.card, func(t *testing.T) {
+            intent := authorize(t, NewFakeProvider("", fixtureSecret), tt.card)
+
+            if intent.Status != tt.status || intent.DeclineReason != tt.declineReason {
+                t.Errorf("intent = %+v, want %s %q", intent, tt.status, tt.declineReason)
+            }
+            if intent.Amount != 4599 || intent.Currency != "usd" || intent.Reference != "key-"+tt.card {
+                t.Errorf("intent = %+v, want the requested amount, currency and key", intent)
+            }
+            if (intent.RedirectURL != "") != (tt.status == IntentRequiresAction) {
+                t.Errorf("RedirectURL = %q for a %s intent", intent.RedirectURL, intent.Status)
+            }
+        })
+    }
+}
+
+func TestFakeProviderIdempotency(t *testing.T) {
+    provider := NewFakeProvider("", fixtureSecret)
+
+    first := authorize(t, provider, CardSuccess)
+    second := authorize(t, provider, CardSuccess)
+    if first.Ref != second.Ref {
+        t.Errorf("authorizing again with the same key created intent %s, want %s", second.Ref, first.Ref)
+    }
+}
+
+func TestFakeProviderLifecycle(t *testing.T) {
+    provider := NewFakeProvider("", fixtureSecret)
+    ctx := context.Background()
+    ref := authorize(t, provider, CardSuccess).Ref
+
+    if _, err := provider.Refund(ctx, ref, 100); !errors.Is(err, ErrInvalidState) {
+        t.Errorf("Refund before capture = %v, want %v", err, ErrInvalidState)
+    }
+    if _, err := provider.Capture(ctx, ref, 5000); !errors.Is(err, ErrInvalidState) {
+        t.Errorf("Capture of more than was authorized = %v, want %v", err, ErrInvalidState)
+    }
+
+    intent, err := provider.Capture(ctx, ref, 4599)
+    if err != nil || intent.Status != IntentCaptured || intent.Captured != 4599 {
+        t.Fatalf("Capture = %+v, %v", intent, err)
+    }
+    if _, err := provider.Void(ctx, ref); !errors.Is(err, ErrInvalidState) {
+        t.Errorf("Void after capture = %v, want %v", err, ErrInvalidState)
+    }
+
+    intent, err = provider.Refund(ctx, ref, 1500)
+    if err != nil || intent.Refunded != 1500 {
+        t.Fatalf("Refund = %+v, %v", intent, err)
+    }
+    if _, err := provider.Refund(ctx, ref, 3100); !errors.Is(err, ErrInvalidState) {
+        t.Errorf("Refund beyond what was captured = %v, want %v", err, ErrInvalidState)
+    }
+    if _, err := provider.Refund(ctx, ref, 0); !errors.Is(err, ErrInvalidState) {
+        t.Errorf("Refund of nothing = %v, want %v", err, ErrInvalidState)
+    }
+
+    got, err := provider.Get(ctx, ref)
+    if err != nil || got.Refunded != 1500 || got.Status != IntentCaptured {
+        t.Errorf("Get = %+v, %v, want the captured intent with 1500 refunded", got, err)
+    }
+    if _, err := provider.Get(ctx, "fake_missing"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Get of an unknown intent = %v, want %v", err, ErrNotFound)
+    }
+}
+
+// webhookReceiver collects the events the fake provider posts, verified
+// the way the shop verifies them.
+func webhookReceiver(t *testing.T, provider **FakeProvider) (*httptest.Server, <-chan *WebhookEvent) {
+    t.Helper()
+
+    events := make(chan *WebhookEvent, 10)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        event, err := (*provider).ParseWebhook(r.Header, body)
+        if err != nil {
+            t.Errorf("fake provider posted a webhook that does not verify: %v", err)
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        events <- event
+    }))
+    t.Cleanup(server.Close)
+
+    return server, events
+}
+
+func receive(t *testing.T, events <-chan *WebhookEvent) *WebhookEvent {
+    t.Helper()
+
+    select {
+    case event := <-events:
+        return event
+    case <-time.After(5 * time.Second):
+        t.Fatal("no webhook arrived")
+        return nil
+    }
+}
+
+func TestFakeProviderTimeoutSendsWebhook(t *testing.T) {
+    var provider *FakeProvider
+    server, events := webhookReceiver(t, &provider)
+    provider = NewFakeProvider(server.URL, fixtureSecret)
+    provider.notifyDelay = 0
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+
+    _, err := provider.Authorize(ctx, AuthorizeRequest{IdempotencyKey: "order-1", Amount: 100, Currency: "usd", CardNumber: CardTimeout})
+    if !IsTimeout(err) {
+        t.Fatalf("Authorize = %v, want a timeout", err)
+    }
+
+    event := receive(t, events)
+    if event.Type != EventIntentAuthorized || event.Intent.Reference != "order-1" || event.Intent.Status != IntentAuthorized {
+        t.Errorf("webhook = %+v, want the authorization the caller missed", event)
+    }
+}
+
+func TestFakeProviderAuthentication(t *testing.T) {
+    tests := []struct {
+        action string
+        want   string
+        status IntentStatus
+    }{
+        {"approve", EventIntentAuthorized, IntentAuthorized},
+        {"fail", EventIntentDeclined, IntentDeclined},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.action, func(t *testing.T) {
+            var provider *FakeProvider
+            server, events := webhookReceiver(t, &provider)
+            provider = NewFakeProvider(server.URL, fixtureSecret)
+            provider.notifyDelay = 0
+
+            intent := authorize(t, provider, CardRequiresAction)
+
+            page := httptest.NewRecorder()
+            provider.ServeHTTP(page, httptest.NewRequest(http.MethodGet, intent.RedirectURL, nil))
+            if page.Code != http.StatusOK {
+                t.Fatalf("authentication page = %d", page.Code)
+            }
+
+            rec := httptest.NewRecorder()
+            provider.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, intent.RedirectURL+"/"+tt.action, nil))
+            if rec.Code != http.StatusSeeOther {
+                t.Fatalf("%s = %d, want the redirect back to the shop: %s", tt.action, rec.Code, rec.Body)
+            }
+
+            event := receive(t, events)
+            if event.Type != tt.want || event.Intent.Ref != intent.Ref || event.Intent.Status != tt.status {
+                t.Errorf("webhook = %+v, want %s for %s", event, tt.want, intent.Ref)
+            }
+
+            again := httptest.NewRecorder()
+            provider.ServeHTTP(again, httptest.NewRequest(http.MethodGet, intent.RedirectURL+"/approve", nil))
+            if again.Code != http.StatusConflict {
+                t.Errorf("authenticating twice = %d, want %d", again.Code, http.StatusConflict)
+            }
+        })
+    }
+}
@@ -0,0 +1,97 @@
+package payment
+
+import (
+    "context"
+    "errors"
+    "math"
+    "net/http"
+)
+
+// Currency is the only currency the shop charges in.
+const Currency = "USD"
+
+var (
+    // ErrTimeout means the provider did not answer in time. The outcome of
+    // the operation is unknown; it is settled later by the provider's
+    // asynchronous confirmation.
+    ErrTimeout = errors.New("payment provider timed out")
+
+    ErrNotFound     = errors.New("payment intent not found")
+    ErrInvalidState = errors.New("payment intent is not in a state that allows this operation")
+)
+
+// PaymentProvider is a payment gateway. Amounts are in the smallest unit of
+// the currency, e.g. cents.
+type PaymentProvider interface {
+    Name() string
+
+    // Authorize reserves the amount on the customer's payment method. The
+    // returned intent is authorized, declined, or requires the customer to
+    // complete an action at its RedirectURL first. Calling Authorize again
+    // with the same IdempotencyKey returns the same intent.
+    Authorize(ctx context.Context, req AuthorizeRequest) (*Intent, error)
+
+    // Get returns the current state of an intent.
+    Get(ctx context.Context, ref string) (*Intent, error)
+
+    // Capture collects an authorized amount.
+    Capture(ctx context.Context, ref string, amount int64) (*Intent, error)
+
+    // Void releases an authorization that was never captured.
+    Void(ctx context.Context, ref string) (*Intent, error)
+
+    // Refund returns up to the captured amount to the customer.
+    Refund(ctx context.Context, ref string, amount int64) (*Intent, error)
+
+    // ParseWebhook verifies the signature of a webhook request sent by the
+    // provider and decodes its event.
+    ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error)
+}
+
+type AuthorizeRequest struct {
+    IdempotencyKey string
+    Amount         int64
+    Currency       string
+    Description    string
+    Email          string
+    // CardNumber stands in for the tokenised payment method a hosted
+    // payment form would produce.
+    CardNumber string
+    // ReturnURL is where the customer comes back to after completing a
+    // required action.
+    ReturnURL string
+}
+
+type IntentStatus string
+
+const (
+    IntentRequiresAction IntentStatus = "requires_action"
+    IntentAuthorized     IntentStatus = "authorized"
+    IntentCaptured       IntentStatus = "captured"
+    IntentDeclined       IntentStatus = "declined"
+    IntentVoided         IntentStatus = "voided"
+)
+
+// Intent is the provider's view of a payment. Reference is the idempotency
+// key it was authorized with.
+type Intent struct {
+    Ref           string       `json:"ref"`
+    Reference     string       `json:"reference"`
+    Status        IntentStatus `json:"status"`
+    Amount        int64        `json:"amount"`
+    Captured      int64        `json:"captured"`
+    Refunded      int64        `json:"refunded"`
+    Currency      string       `json:"currency"`
+    RedirectURL   string       `json:"redirect_url,omitempty"`
+    DeclineReason string       `json:"decline_reason,omitempty"`
+}
+
+// IsTimeout reports whether err means the provider never answered.
+func IsTimeout(err error) bool {
+    return errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Cents converts a price in dollars to the amount charged.
+func Cents(amount float64) int64 {
+    return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,78 @@
+package payment
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/signature"
+    "net/http"
+    "time"
+)
+
+const SignatureHeader = "X-Payment-Signature"
+
+// Webhook event types. Every event carries the intent as it is after the
+// change; after a refund, or a refund that failed, its Refunded is the total
+// the provider has paid back so far.
+const (
+    EventIntentAuthorized   = "intent.authorized"
+    EventIntentCaptured     = "intent.captured"
+    EventIntentDeclined     = "intent.declined"
+    EventIntentVoided       = "intent.voided"
+    EventIntentRefunded     = "intent.refunded"
+    EventIntentRefundFailed = "intent.refund_failed"
+)
+
+var ErrInvalidSignature = signature.ErrInvalid
+
+// WebhookEvent is an asynchronous notification from a provider. ID is unique
+// per event and stays the same when the provider retries it.
+type WebhookEvent struct {
+    ID        string    `json:"id"`
+    Type      string    `json:"type"`
+    CreatedAt time.Time `json:"created_at"`
+    Intent    Intent    `json:"intent"`
+}
+
+// VerifyWebhook checks the signature header of body against secret and
+// decodes the event.
+func VerifyWebhook(secret, header string, body []byte, now time.Time) (*WebhookEvent, error) {
+    if err := signature.Verify(secret, header, body, now); err != nil {
+        return nil, err
+    }
+
+    var event WebhookEvent
+    if err := json.Unmarshal(body, &event); err != nil {
+        return nil, err
+    }
+    if event.ID == "" || event.Type == "" {
+        return nil, errors.New("webhook event without id or type")
+    }
+
+    return &event, nil
+}
+
+// PostWebhook signs body and POSTs it to url, the way a provider delivers an
+// event. The fake provider uses it, and so can anything that needs to play a
+// provider locally.
+func PostWebhook(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(SignatureHeader, signature.Header(secret, time.Now(), body))
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("unexpected response status %s", resp.Status)
+    }
+    return nil
+}
@@ -0,0 +1,173 @@
+package payment
+
+import (
+    "context"
+    "errors"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/signature"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+// The fixtures in testdata are events as the provider sends them: a .json
+// body and the .sig header it came with, signed with fixtureSecret at
+// fixtureTime. The forged_ ones were not signed by the provider.
+const fixtureSecret = "whsec_fixture"
+
+var fixtureTime = time.Unix(1760000000, 0)
+
+// fixture returns the signature header and body of a webhook fixture.
+func fixture(t *testing.T, name string) (string, []byte) {
+    t.Helper()
+
+    body, err := os.ReadFile("testdata/" + name + ".json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    header, err := os.ReadFile("testdata/" + name + ".sig")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return strings.TrimSpace(string(header)), body
+}
+
+func TestVerifyWebhookFixtures(t *testing.T) {
+    tests := []struct {
+        name     string
+        fixture  string
+        now      time.Time
+        wantType string
+        wantErr  error
+    }{
+        {name: "captured", fixture: "intent_captured", now: fixtureTime, wantType: EventIntentCaptured},
+        {name: "declined", fixture: "intent_declined", now: fixtureTime, wantType: EventIntentDeclined},
+        {name: "refunded", fixture: "intent_refunded", now: fixtureTime, wantType: EventIntentRefunded},
+        {name: "refund failed", fixture: "intent_refund_failed", now: fixtureTime, wantType: EventIntentRefundFailed},
+        {name: "within tolerance", fixture: "intent_captured", now: fixtureTime.Add(signature.Tolerance), wantType: EventIntentCaptured},
+        {name: "tampered body", fixture: "forged_tampered", now: fixtureTime, wantErr: ErrInvalidSignature},
+        {name: "wrong secret", fixture: "forged_secret", now: fixtureTime, wantErr: ErrInvalidSignature},
+        {name: "replayed late", fixture: "intent_captured", now: fixtureTime.Add(signature.Tolerance + time.Second), wantErr: ErrInvalidSignature},
+        {name: "from the future", fixture: "intent_captured", now: fixtureTime.Add(-signature.Tolerance - time.Second), wantErr: ErrInvalidSignature},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            header, body := fixture(t, tt.fixture)
+
+            event, err := VerifyWebhook(fixtureSecret, header, body, tt.now)
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("VerifyWebhook = %v, want %v", err, tt.wantErr)
+                }
+                if event != nil {
+                    t.Error("VerifyWebhook returned an event along with its error")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("VerifyWebhook = %v", err)
+            }
+            if event.Type != tt.wantType || event.ID == "" || event.Intent.Ref == "" {
+                t.Errorf("event = %+v, want a %s event with its intent", event, tt.wantType)
+            }
+        })
+    }
+}
+
+func TestVerifyWebhookFixtureIntent(t *testing.T) {
+    header, body := fixture(t, "intent_refunded")
+
+    event, err := VerifyWebhook(fixtureSecret, header, body, fixtureTime)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := Intent{
+        Ref:       "fake_5b7e0d",
+        Reference: "0192a3b4-c5d6-7e8f-9a0b-1c2d3e4f5a6b",
+        Status:    IntentCaptured,
+        Amount:    4599,
+        Captured:  4599,
+        Refunded:  1500,
+        Currency:  "usd",
+    }
+    if event.Intent != want {
+        t.Errorf("intent = %+v, want %+v", event.Intent, want)
+    }
+}
+
+func TestVerifyWebhookMalformed(t *testing.T) {
+    _, body := fixture(t, "intent_captured")
+    signed := func(body string) (string, []byte) {
+        return signature.Header(fixtureSecret, fixtureTime, []byte(body)), []byte(body)
+    }
+    _, v1, _ := strings.Cut(signature.Header(fixtureSecret, fixtureTime, body), ",")
+
+    tests := []struct {
+        name   string
+        header string
+        body   []byte
+    }{
+        {"no header", "", body},
+        {"no timestamp", v1, body},
+        {"bad timestamp", "t=yesterday," + v1, body},
+        {"no signature", "t=1760000000", body},
+        {"empty signature", "t=1760000000,v1=", body},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := VerifyWebhook(fixtureSecret, tt.header, tt.body, fixtureTime); !errors.Is(err, ErrInvalidSignature) {
+                t.Errorf("VerifyWebhook = %v, want %v", err, ErrInvalidSignature)
+            }
+        })
+    }
+
+    if _, err := VerifyWebhook("", signature.Header("", fixtureTime, body), body, fixtureTime); !errors.Is(err, ErrInvalidSignature) {
+        t.Errorf("VerifyWebhook with no secret = %v, want %v", err, ErrInvalidSignature)
+    }
+
+    // Signed by the provider but not an event.
+    for _, raw := range []string{`{`, `{"type":"intent.captured"}`, `{"id":"evt_1"}`} {
+        header, body := signed(raw)
+        event, err := VerifyWebhook(fixtureSecret, header, body, fixtureTime)
+        if err == nil || errors.Is(err, ErrInvalidSignature) {
+            t.Errorf("VerifyWebhook(%s) = %v, %v, want a decoding error", raw, event, err)
+        }
+    }
+}
+
+func TestPostWebhook(t *testing.T) {
+    _, body := fixture(t, "intent_captured")
+    provider := NewFakeProvider("", fixtureSecret)
+
+    var got *WebhookEvent
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            t.Error(err)
+        }
+
+        event, err := provider.ParseWebhook(r.Header, body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        got = event
+    }))
+    defer server.Close()
+
+    if err := PostWebhook(context.Background(), server.Client(), server.URL, fixtureSecret, body); err != nil {
+        t.Fatal(err)
+    }
+    if got == nil || got.Type != EventIntentCaptured {
+        t.Errorf("receiver parsed %+v, want the captured event", got)
+    }
+
+    if err := PostWebhook(context.Background(), server.Client(), server.URL, "whsec_guessed", body); err == nil {
+        t.Error("PostWebhook signed with another secret succeeded, want the receiver's 400")
+    }
+}
@@ -0,0 +1,197 @@
+// Package pdf writes simple PDF documents: text and lines on A4 pages in the
+// standard Helvetica fonts, which every PDF reader has, so no font needs to
+// be embedded. Coordinates are in points from the top left corner.
+package pdf
+
+import (
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "strings"
+)
+
+const (
+    PageWidth  = 595.0
+    PageHeight = 842.0
+)
+
+type Document struct {
+    pages []*bytes.Buffer
+}
+
+func NewDocument() *Document {
+    d := &Document{}
+    d.AddPage()
+    return d
+}
+
+// AddPage starts a new page; later drawing goes on it.
+func (d *Document) AddPage() {
+    d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *Document) page() *bytes.Buffer {
+    return d.pages[len(d.pages)-1]
+}
+
+// Text draws s with its baseline at y, starting at x.
+func (d *Document) Text(x, y, size float64, bold bool, s string) {
+    font := "F1"
+    if bold {
+        font = "F2"
+    }
+    fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(s))
+}
+
+// TextRight draws s so that it ends at x, for right-aligned columns.
+func (d *Document) TextRight(x, y, size float64, bold bool, s string) {
+    d.Text(x-TextWidth(s, size, bold), y, size, bold, s)
+}
+
+// Line draws a thin line from (x1, y1) to (x2, y2).
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+    fmt.Fprintf(d.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Rect draws the outline of a rectangle whose top left corner is (x, y).
+func (d *Document) Rect(x, y, width, height float64) {
+    fmt.Fprintf(d.page(), "0.5 w %.2f %.2f %.2f %.2f re S\n", x, PageHeight-y-height, width, height)
+}
+
+// Bytes returns the finished PDF file.
+func (d *Document) Bytes() ([]byte, error) {
+    var out bytes.Buffer
+    var offsets []int
+
+    object := func(body string) {
+        offsets = append(offsets, out.Len())
+        fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+    }
+
+    out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+    // Objects 1 to 4 are fixed; each page then takes two, the page itself
+    // and its content stream.
+    kids := make([]string, len(d.pages))
+    for i := range d.pages {
+        kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+    }
+
+    object("<< /Type /Catalog /Pages 2 0 R >>")
+    object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+    object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+    object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+    for i, page := range d.pages {
+        var content bytes.Buffer
+        w := zlib.NewWriter(&content)
+        if _, err := w.Write(page.Bytes()); err != nil {
+            return nil, err
+        }
+        if err := w.Close(); err != nil {
+            return nil, err
+        }
+
+        object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 6+2*i))
+        object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+    }
+
+    xref := out.Len()
+    fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+    for _, offset := range offsets {
+        fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+    }
+    fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+    return out.Bytes(), nil
+}
+
+// TextWidth returns how wide s is when drawn at size.
+func TextWidth(s string, size float64, bold bool) float64 {
+    widths := &helvetica
+    if bold {
+        widths = &helveticaBold
+    }
+
+    var total int
+    for _, b := range encode(s) {
+        if b >= 32 && b <= 126 {
+            total += widths[b-32]
+        } else {
+            total += 556
+        }
+    }
+
+    return float64(total) * size / 1000
+}
+
+// Fit shortens s with an ellipsis until it is at most width wide in the
+// regular font.
+func Fit(s string, width, size float64) string {
+    if TextWidth(s, size, false) <= width {
+        return s
+    }
+
+    runes := []rune(s)
+    for len(runes) > 0 && TextWidth(string(runes)+"...", size, false) > width {
+        runes = runes[:len(runes)-1]
+    }
+    return string(runes) + "..."
+}
+
+// escape encodes s as the body of a PDF string in WinAnsiEncoding. Characters
+// the encoding lacks become "?".
+func escape(s string) string {
+    var b strings.Builder
+    for _, c := range encode(s) {
+        switch c {
+        case '\\', '(', ')':
+            b.WriteByte('\\')
+            b.WriteByte(c)
+        default:
+            b.WriteByte(c)
+        }
+    }
+    return b.String()
+}
+
+func encode(s string) []byte {
+    encoded := make([]byte, 0, len(s))
+    for _, r := range s {
+        switch {
+        case r == '\n' || r == '\t':
+            encoded = append(encoded, ' ')
+        case r >= 32 && r <= 126, r >= 160 && r <= 255:
+            encoded = append(encoded, byte(r))
+        case r == '€':
+            encoded = append(encoded, 128)
+        case r == '–':
+            encoded = append(encoded, 150)
+        case r == '—':
+            encoded = append(encoded, 151)
+        default:
+            encoded = append(encoded, '?')
+        }
+    }
+    return encoded
+}
+
+// Glyph widths of the printable ASCII characters, from the Adobe font
+// metrics, in thousandths of the font size.
+var helvetica = [95]int{
+    278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+    556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+    1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+    667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+    333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+    556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBold = [95]int{
+    278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+    556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+    975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+    667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+    333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+    611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
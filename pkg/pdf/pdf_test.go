@@ -0,0 +1,136 @@
+package pdf
+
+import (
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "regexp"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// contents returns the decompressed content stream of every page of file.
+func contents(t *testing.T, file []byte) []string {
+    t.Helper()
+
+    var pages []string
+    for _, match := range regexp.MustCompile(`(?s)/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(file, -1) {
+        length, _ := strconv.Atoi(string(file[match[2]:match[3]]))
+        r, err := zlib.NewReader(bytes.NewReader(file[match[1] : match[1]+length]))
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, err := io.ReadAll(r)
+        if err != nil {
+            t.Fatal(err)
+        }
+        pages = append(pages, string(content))
+    }
+    return pages
+}
+
+func TestBytesXref(t *testing.T) {
+    for _, pages := range []int{1, 3} {
+        t.Run(fmt.Sprintf("%d pages", pages), func(t *testing.T) {
+            doc := NewDocument()
+            for i := 0; i < pages; i++ {
+                if i > 0 {
+                    doc.AddPage()
+                }
+                doc.Text(50, 50, 12, i%2 == 1, fmt.Sprintf("Page (%d)", i+1))
+                doc.Line(50, 60, 200, 60)
+            }
+            file, err := doc.Bytes()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(file)
+            if match == nil {
+                t.Fatalf("no startxref at the end of the file")
+            }
+            xref, _ := strconv.Atoi(string(match[1]))
+            if !bytes.HasPrefix(file[xref:], []byte("xref\n")) {
+                t.Fatalf("startxref %d points at %q", xref, file[xref:min(xref+20, len(file))])
+            }
+
+            // The catalog, the page tree, two fonts and two objects a page.
+            objects := 4 + 2*pages
+            lines := strings.Split(string(file[xref:]), "\n")
+            if lines[1] != fmt.Sprintf("0 %d", objects+1) || lines[2] != "0000000000 65535 f " {
+                t.Fatalf("xref header = %q", lines[:3])
+            }
+            for i := 1; i <= objects; i++ {
+                entry := lines[2+i]
+                if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+                    t.Fatalf("xref entry %d = %q, want 20 bytes in use", i, entry)
+                }
+                offset, _ := strconv.Atoi(entry[:10])
+                if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(file[offset:], []byte(want)) {
+                    t.Errorf("object %d at %d starts %q", i, offset, file[offset:min(offset+12, len(file))])
+                }
+            }
+            if !bytes.Contains(file, []byte(fmt.Sprintf("/Size %d /Root 1 0 R", objects+1))) {
+                t.Errorf("trailer does not give the size %d", objects+1)
+            }
+            if got := len(contents(t, file)); got != pages {
+                t.Errorf("%d content streams, want %d", got, pages)
+            }
+        })
+    }
+}
+
+func TestTextEscaping(t *testing.T) {
+    tests := []struct {
+        text string
+        want string
+    }{
+        {"plain", "(plain) Tj"},
+        {"Total (incl. tax)", `(Total \(incl. tax\)) Tj`},
+        {"unbalanced ) and (", `(unbalanced \) and \() Tj`},
+        {`C:\invoices\`, `(C:\\invoices\\) Tj`},
+        {`\(`, `(\\\() Tj`},
+        {"tab\tand\nnewline", "(tab and newline) Tj"},
+        {"café 5€ – 6", "(caf\xe9 5\x80 \x96 6) Tj"},
+        {"日本", "(??) Tj"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.text, func(t *testing.T) {
+            doc := NewDocument()
+            doc.Text(50, 50, 12, false, tt.text)
+            file, err := doc.Bytes()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if page := contents(t, file)[0]; !strings.Contains(page, tt.want) {
+                t.Errorf("content = %q, want %q", page, tt.want)
+            }
+        })
+    }
+}
+
+func TestTextRightAndFit(t *testing.T) {
+    if got := TextWidth("Hello", 10, false); got != 22.78 {
+        t.Errorf("TextWidth = %v, want 22.78", got)
+    }
+
+    doc := NewDocument()
+    doc.TextRight(100, 50, 10, false, "Hello")
+    file, err := doc.Bytes()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if page := contents(t, file)[0]; !strings.Contains(page, "77.22 792.00 Td (Hello) Tj") {
+        t.Errorf("content = %q, want Hello ending at 100", page)
+    }
+
+    if got := Fit("short", 100, 10); got != "short" {
+        t.Errorf("Fit = %q, want it unchanged", got)
+    }
+    if got := Fit("A description far too long for its column", 100, 10); !strings.HasSuffix(got, "...") || TextWidth(got, 10, false) > 100 {
+        t.Errorf("Fit = %q, %v wide, want it cut to 100", got, TextWidth(got, 10, false))
+    }
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/logging"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/metrics"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/tracing"
+    "go.opentelemetry.io/otel/attribute"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+    "log/slog"
+    "strings"
+    "time"
+)
+
+// slowQuery is how long a statement may take before it is logged as a
+// warning; faster ones are only logged at debug level.
+const slowQuery = 250 * time.Millisecond
+
+// loggedDB is the connection pool a repository queries through. Statements
+// run as part of a repository method, named with op or begin, and each one
+// gets a span in the trace of the method's ctx, is timed for the method's
+// metrics and is logged, without its arguments, with ctx's logger.
+type loggedDB struct {
+    *sql.DB
+    repository string
+}
+
+func newLoggedDB(db *sql.DB, repository string) *loggedDB {
+    return &loggedDB{DB: db, repository: repository}
+}
+
+// op returns what method runs its statements with outside a transaction.
+func (db *loggedDB) op(ctx context.Context, method string) *loggedOp {
+    return &loggedOp{conn: db.DB, ctx: ctx, repository: db.repository, method: method}
+}
+
+// begin starts a transaction for method with a span of its own, which the
+// spans of its statements go under and which ends with the commit or
+// rollback. Cancelling ctx rolls the transaction back.
+func (db *loggedDB) begin(ctx context.Context, method string) (*loggedTx, error) {
+    ctx, span := tracing.Tracer().Start(ctx, "TRANSACTION "+db.repository+"."+method,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(semconv.DBSystemMySQL, semconv.CodeNamespace(db.repository), semconv.CodeFunction(method)),
+    )
+
+    tx, err := db.DB.BeginTx(ctx, nil)
+    if err != nil {
+        tracing.End(span, err)
+        return nil, err
+    }
+    return &loggedTx{
+        loggedOp: loggedOp{conn: tx, ctx: ctx, repository: db.repository, method: method},
+        tx:       tx,
+        span:     span,
+    }, nil
+}
+
+// conn is a *sql.DB or a *sql.Tx.
+type conn interface {
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// loggedOp runs the statements of one call of a repository method.
+type loggedOp struct {
+    conn       conn
+    ctx        context.Context
+    repository string
+    method     string
+}
+
+func (op *loggedOp) Query(query string, args ...any) (*loggedRows, error) {
+    ctx, done := op.observe(query)
+    rows, err := op.conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        done(err)
+        return nil, err
+    }
+    return &loggedRows{Rows: rows, done: done}, nil
+}
+
+func (op *loggedOp) QueryRow(query string, args ...any) *sql.Row {
+    ctx, done := op.observe(query)
+    row := op.conn.QueryRowContext(ctx, query, args...)
+    done(row.Err())
+    return row
+}
+
+func (op *loggedOp) Exec(query string, args ...any) (sql.Result, error) {
+    ctx, done := op.observe(query)
+    result, err := op.conn.ExecContext(ctx, query, args...)
+    done(err)
+    return result, err
+}
+
+// observe starts a span for a statement and returns the function that
+// records its outcome.
+func (op *loggedOp) observe(query string) (context.Context, func(err error)) {
+    operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+    operation = strings.ToUpper(operation)
+
+    start := time.Now()
+    ctx, span := tracing.Tracer().Start(op.ctx, operation+" "+op.repository+"."+op.method,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            semconv.DBSystemMySQL,
+            semconv.DBOperationName(operation),
+            semconv.DBQueryText(query),
+            semconv.CodeNamespace(op.repository),
+            semconv.CodeFunction(op.method),
+        ),
+    )
+
+    return ctx, func(err error) {
+        elapsed := time.Since(start)
+        tracing.End(span, err)
+        metrics.ObserveQuery(op.repository, op.method, elapsed)
+        logQuery(logging.FromContext(op.ctx), query, elapsed, err)
+    }
+}
+
+// loggedRows ends the span of its query when closed, so that the span and
+// the timing cover reading the rows as well.
+type loggedRows struct {
+    *sql.Rows
+    done func(err error)
+}
+
+func (rows *loggedRows) Close() error {
+    err := rows.Rows.Close()
+    if rows.done != nil {
+        rows.done(rows.Rows.Err())
+        rows.done = nil
+    }
+    return err
+}
+
+// loggedTx observes the statements of a transaction like loggedOp.
+type loggedTx struct {
+    loggedOp
+    tx   *sql.Tx
+    span trace.Span
+}
+
+func (tx *loggedTx) Commit() error {
+    err := tx.tx.Commit()
+    tracing.End(tx.span, err)
+    return err
+}
+
+// Rollback ends the span unless the transaction was already committed, as
+// deferred rollbacks run after every commit.
+func (tx *loggedTx) Rollback() error {
+    err := tx.tx.Rollback()
+    if !errors.Is(err, sql.ErrTxDone) {
+        tx.span.SetAttributes(attribute.Bool("db.rolled_back", true))
+        tracing.End(tx.span, err)
+    }
+    return err
+}
+
+func logQuery(logger *slog.Logger, query string, elapsed time.Duration, err error) {
+    level := slog.LevelDebug
+    if elapsed >= slowQuery {
+        level = slog.LevelWarn
+    }
+    if !logger.Enabled(context.Background(), level) {
+        return
+    }
+
+    attrs := []slog.Attr{
+        slog.String("query", query),
+        slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+    }
+    if err != nil {
+        attrs = append(attrs, slog.String("error", err.Error()))
+    }
+    message := "query"
+    if level == slog.LevelWarn {
+        message = "slow query"
+    }
+    logger.LogAttrs(context.Background(), level, message, attrs...)
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+type InvoiceRepository struct {
+    db *loggedDB
+}
+
+func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
+    return &InvoiceRepository{
+        db: newLoggedDB(db, "InvoiceRepository"),
+    }
+}
+
+// Issue numbers and stores invoice unless a document was already issued for
+// source, e.g. "order:<id>", in which case that one is returned unchanged.
+// render turns the numbered invoice into its PDF. Numbers of each kind run
+// without gaps because they are taken from a counter locked until the
+// document is stored.
+func (r *InvoiceRepository) Issue(ctx context.Context, invoice *Invoice, source string, render func(*Invoice) ([]byte, error)) (*Invoice, error) {
+    tx, err := r.db.begin(ctx, "Issue")
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var last int
+    err = tx.QueryRow("select last_number from invoice_counters where kind = ? for update", invoice.Kind).Scan(&last)
+    if err != nil {
+        return nil, err
+    }
+
+    // The counter lock also serialises issuing for the same source.
+    existing, err := scanInvoice(tx.QueryRow("select "+invoiceColumns+" from invoices where source = ?", source))
+    if err == nil {
+        return existing, nil
+    }
+    if !errors.Is(err, sql.ErrNoRows) {
+        return nil, err
+    }
+
+    id, err := uuid.NewV7()
+    if err != nil {
+        return nil, err
+    }
+
+    invoice.ID = id
+    invoice.Number = fmt.Sprintf("%s%06d", invoice.Kind.Prefix(), last+1)
+    invoice.IssuedAt = time.Now()
+
+    invoice.PDF, err = render(invoice)
+    if err != nil {
+        return nil, err
+    }
+
+    document, err := json.Marshal(invoice)
+    if err != nil {
+        return nil, err
+    }
+
+    query := `
+            insert into invoices(id, kind, number, source, order_id, refund_id, document, pdf, issued_at)
+            values (?, ?, ?, ?, ?, ?, ?, ?, ?)
+            `
+    _, err = tx.Exec(query, invoice.ID, invoice.Kind, invoice.Number, source, invoice.OrderID, invoice.RefundID, document, invoice.PDF, invoice.IssuedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec("update invoice_counters set last_number = ? where kind = ?", last+1, invoice.Kind)
+    if err != nil {
+        return nil, err
+    }
+
+    return invoice, tx.Commit()
+}
+
+func (r *InvoiceRepository) Get(ctx context.Context, id uuid.UUID) (*Invoice, error) {
+    return scanInvoice(r.db.op(ctx, "Get").QueryRow("select "+invoiceColumns+" from invoices where id = ?", id))
+}
+
+func (r *InvoiceRepository) GetBySource(ctx context.Context, source string) (*Invoice, error) {
+    return scanInvoice(r.db.op(ctx, "GetBySource").QueryRow("select "+invoiceColumns+" from invoices where source = ?", source))
+}
+
+// FindByOrder returns the invoice and credit notes of an order, oldest first,
+// without their PDFs.
+func (r *InvoiceRepository) FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Invoice, error) {
+    var invoices []Invoice
+
+    rows, err := r.db.op(ctx, "FindByOrder").Query("select document from invoices where order_id = ? order by issued_at", orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var document []byte
+        if err := rows.Scan(&document); err != nil {
+            return nil, err
+        }
+
+        var invoice Invoice
+        if err := json.Unmarshal(document, &invoice); err != nil {
+            return nil, err
+        }
+
+        invoices = append(invoices, invoice)
+    }
+
+    return invoices, rows.Err()
+}
+
+const invoiceColumns = "document, pdf"
+
+func scanInvoice(row *sql.Row) (*Invoice, error) {
+    var document, pdf []byte
+    if err := row.Scan(&document, &pdf); err != nil {
+        return nil, err
+    }
+
+    invoice := &Invoice{}
+    if err := json.Unmarshal(document, invoice); err != nil {
+        return nil, err
+    }
+    invoice.PDF = pdf
+
+    return invoice, nil
+}
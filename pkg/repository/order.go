@@ -1,52 +1,94 @@
 package repository
 
 import (
+    "context"
     "database/sql"
+    "errors"
     "github.com/google/uuid"
     . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "strings"
+    "time"
 )
 
 type OrderRepository struct {
-    db *sql.DB
+    db *loggedDB
 }
 
 func NewOrderRepository(db *sql.DB) *OrderRepository {
     return &OrderRepository{
-        db: db,
+        db: newLoggedDB(db, "OrderRepository"),
     }
 }
 
-func (r *OrderRepository) PlaceOrderWithItems(orderItems []OrderItem) error {
-    tx, err := r.db.Begin()
+// PlaceOrderWithItems stores order together with its items. The caller sets
+// UserID (empty for guest checkout), Email and Items; the ID and status are
+// assigned here. An order.placed message is written to the outbox in the same
+// transaction.
+func (r *OrderRepository) PlaceOrderWithItems(ctx context.Context, order *Order) error {
+    tx, err := r.db.begin(ctx, "PlaceOrderWithItems")
     if err != nil {
         return err
     }
 
-    order := &Order{
-        ID:     uuid.Must(uuid.NewV7()),
-        UserID: "kimhien2301@gmail.com",
-        Status: Ordered,
-        Items:  orderItems,
-    }
+    order.ID = uuid.Must(uuid.NewV7())
+    order.Status = Ordered
+    order.PaymentStatus = PaymentUnpaid
 
-    query := "insert into orders(id, user_id, status) values (?, ?, ?)"
-    result, err := tx.Exec(query, order.ID, order.UserID, order.Status)
+    query := "insert into orders(id, user_id, email, status, payment_status) values (?, ?, ?, ?, ?)"
+    result, err := tx.Exec(query, order.ID, order.UserID, order.Email, order.Status, order.PaymentStatus)
     if err != nil {
         tx.Rollback()
         return err
     }
 
     if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        tx.Rollback()
         return sql.ErrNoRows
     }
 
-    for _, item := range orderItems {
-        query = "insert into order_items(order_id, product_id, quantity, cost) values (?, ?, ?, ?)"
-        _, err = tx.Exec(query, order.ID, item.ProductID, item.Quantity, item.Cost)
+    for i, item := range order.Items {
+        err = takeStock(tx, item.ProductID, item.Quantity, item.Product)
+        if err != nil {
+            tx.Rollback()
+            return err
+        }
+
+        // The line keeps the product's current name and the price the cart
+        // charges.
+        unitPrice := item.Cost / float64(item.Quantity)
+        query = `
+                insert into order_items(order_id, product_id, product_name, unit_price, quantity, cost)
+                select ?, id, name, ?, ?, ? from products where id = ?
+                `
+        _, err = tx.Exec(query, order.ID, unitPrice, item.Quantity, item.Cost, item.ProductID)
         if err != nil {
             tx.Rollback()
             return err
         }
+        order.Items[i].OrderID = order.ID
+        order.Items[i].UnitPrice = unitPrice
+        if item.Product != nil {
+            order.Items[i].Name = item.Product.Name
+        }
+        order.Subtotal += item.Cost
+    }
+    order.Total = order.Subtotal
+
+    err = insertOrderEvent(tx, &OrderEvent{
+        OrderID:  order.ID,
+        Type:     OrderPlaced,
+        ToStatus: order.Status,
+        Actor:    order.Email,
+    })
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    err = insertOutbox(tx, EventOrderPlaced, EventOrderPlaced+":"+order.ID.String(), order)
+    if err != nil {
+        tx.Rollback()
+        return err
     }
 
     err = tx.Commit()
@@ -57,52 +99,156 @@ func (r *OrderRepository) PlaceOrderWithItems(orderItems []OrderItem) error {
     return nil
 }
 
-func (r *OrderRepository) GetById(id uuid.UUID) (*Order, error) {
-    query := "select id, user_id, status, date from orders where id = ?"
-    row := r.db.QueryRow(query, id)
+func (r *OrderRepository) GetById(ctx context.Context, id uuid.UUID) (*Order, error) {
+    query := "select id, user_id, email, status, payment_status, refunded_amount, date from orders where id = ?"
+    row := r.db.op(ctx, "GetById").QueryRow(query, id)
 
     order := &Order{}
-    err := row.Scan(&order.ID, &order.UserID, &order.Status, &order.Date)
+    var refunded int64
+    err := row.Scan(&order.ID, &order.UserID, &order.Email, &order.Status, &order.PaymentStatus, &refunded, &order.Date)
     if err != nil {
         return nil, err
     }
+    setRefunded(order, refunded)
 
     return order, nil
 }
 
-func (r *OrderRepository) Update(id uuid.UUID, order *Order) error {
-    query := "update orders set status = ?, date = ? where id = ?"
-    stmt, err := r.db.Prepare(query)
+// UpdateStatus moves the order to status if the order state machine allows
+// it, returning a *TransitionError otherwise. Cancelling an order puts its
+// items back in stock. The change is recorded on the order timeline with the
+// actor who made it and an optional note, the event is written to the outbox
+// and returned. It returns a nil event when the order already has status.
+func (r *OrderRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status OrderStatus, actor, note string) (*OrderEvent, error) {
+    tx, err := r.db.begin(ctx, "UpdateStatus")
     if err != nil {
-        return err
+        return nil, err
     }
+    defer tx.Rollback()
 
-    _, err = stmt.Exec(order.Status, order.Date, id)
-    return err
+    event, err := updateOrderStatus(tx, id, status, actor, note)
+    if err != nil {
+        return nil, err
+    }
+
+    return event, tx.Commit()
 }
 
-func (r *OrderRepository) UpdateStatus(id uuid.UUID, status OrderStatus) error {
-    query := "update orders set status = ? where id = ?"
-    stmt, err := r.db.Prepare(query)
+func updateOrderStatus(tx *loggedTx, id uuid.UUID, status OrderStatus, actor, note string) (*OrderEvent, error) {
+    var current OrderStatus
+    err := tx.QueryRow("select status from orders where id = ? for update", id).Scan(&current)
     if err != nil {
-        return err
+        return nil, err
     }
 
-    _, err = stmt.Exec(status, id)
-    return err
+    if current == status {
+        return nil, nil
+    }
+
+    if !current.CanTransitionTo(status) {
+        return nil, &TransitionError{From: current, To: status}
+    }
+
+    _, err = tx.Exec("update orders set status = ? where id = ?", status, id)
+    if err != nil {
+        return nil, err
+    }
+
+    event := &OrderEvent{
+        OrderID:    id,
+        Type:       OrderStatusChanged,
+        FromStatus: current,
+        ToStatus:   status,
+        Actor:      actor,
+        Note:       note,
+    }
+    err = insertOrderEvent(tx, event)
+    if err != nil {
+        return nil, err
+    }
+
+    if status == Cancel {
+        // Items already restocked by a refund are not put back twice.
+        query := `
+                update products p
+                    inner join order_items oi on oi.product_id = p.id
+                set p.stock = p.stock + (oi.quantity - oi.restocked_quantity),
+                    oi.restocked_quantity = oi.quantity
+                where oi.order_id = ?
+                `
+        if _, err := tx.Exec(query, id); err != nil {
+            return nil, err
+        }
+    }
+
+    err = insertOutbox(tx, EventOrderStatusChanged, EventOrderStatusChanged+":"+event.ID.String(), event)
+    if err != nil {
+        return nil, err
+    }
+
+    return event, nil
 }
 
-func (r *OrderRepository) Delete(id uuid.UUID) error {
-    _, err := r.db.Exec("delete from orders where id = ?", id)
+func (r *OrderRepository) Delete(ctx context.Context, id uuid.UUID) error {
+    _, err := r.db.op(ctx, "Delete").Exec("delete from orders where id = ?", id)
     return err
 }
 
-func (r *OrderRepository) Find(page, size int) ([]Order, error) {
+// OrderFilter narrows the orders listed in the admin order table and its
+// exports. Zero fields match every order; To includes the whole of its day.
+type OrderFilter struct {
+    From     time.Time
+    To       time.Time
+    Status   OrderStatus
+    Customer string
+    // UserID limits the orders to those of one account.
+    UserID   uuid.NullUUID
+}
+
+// where returns the filter as a where clause on orders aliased o, with its
+// arguments.
+func (f OrderFilter) where() (string, []any) {
+    var conditions []string
+    var args []any
+
+    if !f.From.IsZero() {
+        conditions = append(conditions, "o.date >= ?")
+        args = append(args, f.From)
+    }
+    if !f.To.IsZero() {
+        conditions = append(conditions, "o.date < ?")
+        args = append(args, f.To.AddDate(0, 0, 1))
+    }
+    if f.Status != "" {
+        conditions = append(conditions, "o.status = ?")
+        args = append(args, f.Status)
+    }
+    if f.Customer != "" {
+        conditions = append(conditions, "(o.email like ? or o.user_id = ?)")
+        args = append(args, "%"+f.Customer+"%", f.Customer)
+    }
+    if f.UserID.Valid {
+        conditions = append(conditions, "o.user_id = ?")
+        args = append(args, f.UserID.UUID)
+    }
+
+    if len(conditions) == 0 {
+        return "", nil
+    }
+    return "where " + strings.Join(conditions, " and "), args
+}
+
+func (r *OrderRepository) Find(ctx context.Context, filter OrderFilter, page, size int) ([]Order, error) {
     var orders []Order
 
+    where, args := filter.where()
     query := `
             with result as (
-                    select id, user_id, status, date from orders limit ? offset ?
+                    select o.id, o.user_id, o.email, o.status, o.payment_status, o.refunded_amount, o.date
+                    from orders o
+                    ` + where + `
+                    order by o.date desc
+                    limit ? offset ?
                 ),
                 cost(id, total) as (
                     select r.id, sum(oi.cost)
@@ -110,11 +256,133 @@ func (r *OrderRepository) Find(page, size int) ([]Order, error) {
                         inner join order_items oi on r.id = oi.order_id
                     group by r.id
                 )
-                select r.id, r.user_id, r.status, r.date, coalesce(c.total, 0)
+                select r.id, r.user_id, r.email, r.status, r.payment_status, r.refunded_amount, r.date, coalesce(c.total, 0)
                 from result r
                     left join cost c on r.id = c.id
+                order by r.date desc
+            `
+    rows, err := r.db.op(ctx, "Find").Query(query, append(args, size, (page-1)*size)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var order Order
+        var refunded int64
+
+        err := rows.Scan(&order.ID, &order.UserID, &order.Email, &order.Status, &order.PaymentStatus, &refunded, &order.Date, &order.Subtotal)
+        if err != nil {
+            return nil, err
+        }
+        setRefunded(&order, refunded)
+
+        orders = append(orders, order)
+    }
+
+    return orders, nil
+}
+
+// Export calls fn with each order matching filter, oldest first, with its
+// totals but without items. Orders are read from the database one at a time
+// rather than collected, so any number can be exported; fn must not keep
+// the order, which is reused.
+func (r *OrderRepository) Export(ctx context.Context, filter OrderFilter, fn func(*Order) error) error {
+    where, args := filter.where()
+    query := `
+            select o.id, o.user_id, o.email, o.status, o.payment_status, o.refunded_amount, o.date, coalesce(sum(oi.cost), 0)
+            from orders o
+                left join order_items oi on o.id = oi.order_id
+            ` + where + `
+            group by o.id, o.user_id, o.email, o.status, o.payment_status, o.refunded_amount, o.date
+            order by o.date, o.id
+            `
+    rows, err := r.db.op(ctx, "Export").Query(query, args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var order Order
+    for rows.Next() {
+        var refunded int64
+
+        err := rows.Scan(&order.ID, &order.UserID, &order.Email, &order.Status, &order.PaymentStatus, &refunded, &order.Date, &order.Subtotal)
+        if err != nil {
+            return err
+        }
+        setRefunded(&order, refunded)
+
+        if err := fn(&order); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
+}
+
+// ExportItems is Export with a call per order line instead of per order. The
+// order passed along has no totals.
+func (r *OrderRepository) ExportItems(ctx context.Context, filter OrderFilter, fn func(*Order, *OrderItem) error) error {
+    where, args := filter.where()
+    query := `
+            select o.id, o.user_id, o.email, o.status, o.payment_status, o.date,
+                oi.product_id, oi.quantity, oi.refunded_quantity, oi.cost, coalesce(p.name, oi.product_name)
+            from orders o
+                inner join order_items oi on o.id = oi.order_id
+                left join products p on p.id = oi.product_id
+            ` + where + `
+            order by o.date, o.id, p.name
+            `
+    rows, err := r.db.op(ctx, "ExportItems").Query(query, args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var order Order
+    var product Product
+    item := OrderItem{Product: &product}
+    for rows.Next() {
+        err := rows.Scan(
+            &order.ID,
+            &order.UserID,
+            &order.Email,
+            &order.Status,
+            &order.PaymentStatus,
+            &order.Date,
+            &item.ProductID,
+            &item.Quantity,
+            &item.RefundedQuantity,
+            &item.Cost,
+            &product.Name,
+        )
+        if err != nil {
+            return err
+        }
+        item.OrderID = order.ID
+        product.ID = item.ProductID
+
+        if err := fn(&order, &item); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
+}
+
+func (r *OrderRepository) FindByUser(ctx context.Context, userID string) ([]Order, error) {
+    var orders []Order
+
+    query := `
+            select o.id, o.user_id, o.email, o.status, o.payment_status, o.refunded_amount, o.date, coalesce(sum(oi.cost), 0)
+            from orders o
+                left join order_items oi on o.id = oi.order_id
+            where o.user_id = ?
+            group by o.id, o.user_id, o.email, o.status, o.payment_status, o.refunded_amount, o.date
+            order by o.date desc
             `
-    rows, err := r.db.Query(query, size, (page-1)*size)
+    rows, err := r.db.op(ctx, "FindByUser").Query(query, userID)
     if err != nil {
         return nil, err
     }
@@ -122,11 +390,13 @@ func (r *OrderRepository) Find(page, size int) ([]Order, error) {
 
     for rows.Next() {
         var order Order
+        var refunded int64
 
-        err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Date, &order.Total)
+        err := rows.Scan(&order.ID, &order.UserID, &order.Email, &order.Status, &order.PaymentStatus, &refunded, &order.Date, &order.Subtotal)
         if err != nil {
             return nil, err
         }
+        setRefunded(&order, refunded)
 
         orders = append(orders, order)
     }
@@ -134,33 +404,41 @@ func (r *OrderRepository) Find(page, size int) ([]Order, error) {
     return orders, nil
 }
 
-func (r *OrderRepository) Count() (int, error) {
+func (r *OrderRepository) Count(ctx context.Context, filter OrderFilter) (int, error) {
     var count int
-    err := r.db.QueryRow("select count(*) from orders").Scan(&count)
+    where, args := filter.where()
+    err := r.db.op(ctx, "Count").QueryRow("select count(*) from orders o "+where, args...).Scan(&count)
     if err != nil {
         return 0, err
     }
     return count, nil
 }
 
-func (r *OrderRepository) GetOrderWithProduct(id uuid.UUID) (*Order, error) {
-    query := "select id, user_id, status, date from orders where id = ?"
-    row := r.db.QueryRow(query, id)
+func (r *OrderRepository) GetOrderWithProduct(ctx context.Context, id uuid.UUID) (*Order, error) {
+    db := r.db.op(ctx, "GetOrderWithProduct")
+
+    query := "select id, user_id, email, status, payment_status, refunded_amount, date from orders where id = ?"
+    row := db.QueryRow(query, id)
 
     order := &Order{}
-    err := row.Scan(&order.ID, &order.UserID, &order.Status, &order.Date)
+    var refunded int64
+    err := row.Scan(&order.ID, &order.UserID, &order.Email, &order.Status, &order.PaymentStatus, &refunded, &order.Date)
     if err != nil {
         return nil, err
     }
 
+    // A deleted product is stood in for by what the line kept of it.
     itemsQuery := `
-                    select i.product_id, i.quantity, i.cost, p.name, p.price, p.description, p.image, p.created_date, p.modified_date
+                    select i.product_id, i.product_name, i.unit_price, i.quantity, i.refunded_quantity, i.cost,
+                        coalesce(p.sku, ''), coalesce(p.name, i.product_name), coalesce(p.price, i.unit_price), coalesce(p.description, ''), coalesce(p.image, ''),
+                        coalesce(p.created_date, o.date), coalesce(p.modified_date, o.date)
                     from order_items i
-                    inner join products p on p.id = i.product_id
-                    where order_id = ?
+                        inner join orders o on o.id = i.order_id
+                        left join products p on p.id = i.product_id
+                    where i.order_id = ?
                     `
 
-    rows, err := r.db.Query(itemsQuery, id)
+    rows, err := db.Query(itemsQuery, id)
     if err != nil {
         return nil, err
     }
@@ -171,8 +449,12 @@ func (r *OrderRepository) GetOrderWithProduct(id uuid.UUID) (*Order, error) {
         var product Product
         err := rows.Scan(
             &item.ProductID,
+            &item.Name,
+            &item.UnitPrice,
             &item.Quantity,
+            &item.RefundedQuantity,
             &item.Cost,
+            &product.SKU,
             &product.Name,
             &product.Price,
             &product.Description,
@@ -185,6 +467,7 @@ func (r *OrderRepository) GetOrderWithProduct(id uuid.UUID) (*Order, error) {
         }
 
         item.OrderID = id
+        product.ID = item.ProductID
         item.Product = &product
         //item.Cost = float64(item.Quantity) * item.Product.Price
         //item.Product.ID = item.ProductID
@@ -193,8 +476,166 @@ func (r *OrderRepository) GetOrderWithProduct(id uuid.UUID) (*Order, error) {
     }
 
     for _, item := range order.Items {
-        order.Total += item.Cost
+        order.Subtotal += item.Cost
+    }
+    setRefunded(order, refunded)
+
+    order.Events, err = r.FindEvents(ctx, id)
+    if err != nil {
+        return nil, err
     }
 
     return order, nil
 }
+
+// GetOrdersWithProduct returns the orders with the given IDs and their items,
+// in the order the IDs were given. Unknown IDs are skipped.
+func (r *OrderRepository) GetOrdersWithProduct(ctx context.Context, ids []uuid.UUID) ([]Order, error) {
+    var orders []Order
+
+    for _, id := range ids {
+        order, err := r.GetOrderWithProduct(ctx, id)
+        if errors.Is(err, sql.ErrNoRows) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        orders = append(orders, *order)
+    }
+
+    return orders, nil
+}
+
+// FindItems returns the items of the given orders, without their products,
+// in one query.
+func (r *OrderRepository) FindItems(ctx context.Context, orderIDs []uuid.UUID) (map[uuid.UUID][]OrderItem, error) {
+    items := make(map[uuid.UUID][]OrderItem, len(orderIDs))
+    if len(orderIDs) == 0 {
+        return items, nil
+    }
+
+    query, args := inClause("select order_id, product_id, product_name, unit_price, quantity, refunded_quantity, cost from order_items where order_id in", orderIDs)
+    rows, err := r.db.op(ctx, "FindItems").Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var item OrderItem
+        if err := rows.Scan(&item.OrderID, &item.ProductID, &item.Name, &item.UnitPrice, &item.Quantity, &item.RefundedQuantity, &item.Cost); err != nil {
+            return nil, err
+        }
+        items[item.OrderID] = append(items[item.OrderID], item)
+    }
+
+    return items, rows.Err()
+}
+
+// FindIDsByStatus returns the IDs of up to limit orders with status, oldest
+// first.
+func (r *OrderRepository) FindIDsByStatus(ctx context.Context, status OrderStatus, limit int) ([]uuid.UUID, error) {
+    var ids []uuid.UUID
+
+    rows, err := r.db.op(ctx, "FindIDsByStatus").Query("select id from orders where status = ? order by date limit ?", status, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var id uuid.UUID
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+
+    return ids, rows.Err()
+}
+
+// FindEvents returns the timeline of an order, oldest first.
+func (r *OrderRepository) FindEvents(ctx context.Context, orderID uuid.UUID) ([]OrderEvent, error) {
+    var events []OrderEvent
+
+    query := `
+            select id, order_id, event_type, from_status, to_status, actor, note, created_at
+            from order_events
+            where order_id = ?
+            order by created_at
+            `
+    rows, err := r.db.op(ctx, "FindEvents").Query(query, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var event OrderEvent
+
+        err := rows.Scan(&event.ID, &event.OrderID, &event.Type, &event.FromStatus, &event.ToStatus, &event.Actor, &event.Note, &event.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        events = append(events, event)
+    }
+
+    return events, nil
+}
+
+// FindEventsAfter returns up to limit events of any order recorded after the
+// event with ID after, oldest first. Event IDs are UUIDv7, so they sort in
+// the order the events were recorded.
+func (r *OrderRepository) FindEventsAfter(ctx context.Context, after uuid.UUID, limit int) ([]OrderEvent, error) {
+    var events []OrderEvent
+
+    query := `
+            select id, order_id, event_type, from_status, to_status, actor, note, created_at
+            from order_events
+            where id > ?
+            order by id
+            limit ?
+            `
+    rows, err := r.db.op(ctx, "FindEventsAfter").Query(query, after, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var event OrderEvent
+
+        err := rows.Scan(&event.ID, &event.OrderID, &event.Type, &event.FromStatus, &event.ToStatus, &event.Actor, &event.Note, &event.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        events = append(events, event)
+    }
+
+    return events, rows.Err()
+}
+
+func insertOrderEvent(tx *loggedTx, event *OrderEvent) error {
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    event.ID = id
+    event.CreatedAt = time.Now()
+
+    query := "insert into order_events(id, order_id, event_type, from_status, to_status, actor, note, created_at) values (?, ?, ?, ?, ?, ?, ?, ?)"
+    _, err = tx.Exec(query, event.ID, event.OrderID, event.Type, event.FromStatus, event.ToStatus, event.Actor, event.Note, event.CreatedAt)
+    return err
+}
+
+// setRefunded fills in the refunded amount, given in cents, and the net total
+// of an order whose Subtotal is known.
+func setRefunded(order *Order, refunded int64) {
+    order.Refunded = float64(refunded) / 100
+    order.Total = order.Subtotal - order.Refunded
+}
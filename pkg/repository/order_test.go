@@ -0,0 +1,82 @@
+package repository
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "testing"
+)
+
+func TestPlaceOrderWithItemsStock(t *testing.T) {
+    tests := []struct {
+        name string
+        // stock is the product's stock column: nil when it is not tracked.
+        stock       driver.Value
+        missing     bool
+        decremented bool
+        outOfStock  bool
+    }{
+        {name: "untracked", stock: nil},
+        {name: "in stock", stock: int64(5), decremented: true},
+        {name: "exactly enough", stock: int64(2), decremented: true},
+        {name: "short", stock: int64(1), outOfStock: true},
+        {name: "deleted product", missing: true, outOfStock: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            scripted, db := newScriptedDB(t)
+            product := &Product{ID: uuid.New(), Name: "Hat"}
+
+            scripted.on("insert into orders", affected(1))
+            if tt.missing {
+                scripted.on("select stock from products", rows("stock"))
+            } else {
+                scripted.on("select stock from products", rows("stock", tt.stock))
+            }
+            scripted.on("update products set stock = stock - ?", func(args []any) scriptedResult {
+                // Only a tracked stock that is high enough changes.
+                if stock, ok := tt.stock.(int64); ok && stock >= args[2].(int64) {
+                    return scriptedResult{affected: 1}
+                }
+                return scriptedResult{}
+            })
+            scripted.on("insert into order_items", affected(1))
+            scripted.on("insert into order_events", affected(1))
+            scripted.on("insert into outbox", affected(1))
+
+            order := &Order{Email: "jane@example.com", Items: []OrderItem{{ProductID: product.ID, Product: product, Quantity: 2, Cost: 20}}}
+            err := NewOrderRepository(db).PlaceOrderWithItems(context.Background(), order)
+
+            if tt.outOfStock {
+                var outOfStock *OutOfStockError
+                if !errors.As(err, &outOfStock) || outOfStock.ProductID != product.ID || outOfStock.Name != "Hat" {
+                    t.Fatalf("PlaceOrderWithItems = %v, want Hat out of stock", err)
+                }
+                if scripted.committed != 0 || len(scripted.ran("insert into order_items")) != 0 {
+                    t.Error("an order was placed for a product that is out of stock")
+                }
+                return
+            }
+
+            if err != nil {
+                t.Fatalf("PlaceOrderWithItems = %v", err)
+            }
+            if scripted.committed != 1 || order.Total != 20 || order.Items[0].OrderID != order.ID {
+                t.Errorf("order = %+v, committed %d times", order, scripted.committed)
+            }
+            updates := 0
+            if tt.decremented {
+                updates = 1
+            }
+            if got := len(scripted.ran("update products")); got != updates {
+                t.Errorf("stock updated %d times, want %d", got, updates)
+            }
+            if locked := scripted.ran("select stock from products"); len(locked) != 1 || locked[0].query != "select stock from products where id = ? for update" {
+                t.Errorf("stock read with %v, want it locked", locked)
+            }
+        })
+    }
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+type OutboxRepository struct {
+    db *loggedDB
+}
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+    return &OutboxRepository{
+        db: newLoggedDB(db, "OutboxRepository"),
+    }
+}
+
+// FindDue returns unprocessed messages that are available now, oldest first.
+// Dead messages are left out.
+func (r *OutboxRepository) FindDue(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+    var messages []OutboxMessage
+
+    query := "select " + outboxColumns + " from outbox where processed_at is null and dead_at is null and available_at <= ? order by available_at, id limit ?"
+    rows, err := r.db.op(ctx, "FindDue").Query(query, now, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var message OutboxMessage
+
+        err := rows.Scan(outboxFields(&message)...)
+        if err != nil {
+            return nil, err
+        }
+
+        messages = append(messages, message)
+    }
+
+    return messages, nil
+}
+
+// Claim pushes the availability of a due message to until so other relays
+// leave it alone while it is being handled. It reports whether this caller
+// won the claim. If the relay dies before marking the message, it becomes
+// available again at until.
+func (r *OutboxRepository) Claim(ctx context.Context, message *OutboxMessage, until time.Time) (bool, error) {
+    query := "update outbox set available_at = ? where id = ? and processed_at is null and available_at = ?"
+    result, err := r.db.op(ctx, "Claim").Exec(query, until, message.ID, message.AvailableAt)
+    if err != nil {
+        return false, err
+    }
+
+    rowAffected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return rowAffected == 1, nil
+}
+
+// Delivered returns the names of the subscribers that have handled the
+// message.
+func (r *OutboxRepository) Delivered(ctx context.Context, id uuid.UUID) (map[string]bool, error) {
+    rows, err := r.db.op(ctx, "Delivered").Query("select subscriber from outbox_deliveries where message_id = ?", id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    delivered := make(map[string]bool)
+    for rows.Next() {
+        var subscriber string
+        if err := rows.Scan(&subscriber); err != nil {
+            return nil, err
+        }
+        delivered[subscriber] = true
+    }
+
+    return delivered, rows.Err()
+}
+
+// MarkDelivered records that subscriber has handled the message.
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id uuid.UUID, subscriber string) error {
+    query := "insert ignore into outbox_deliveries(message_id, subscriber, delivered_at) values (?, ?, ?)"
+    _, err := r.db.op(ctx, "MarkDelivered").Exec(query, id, subscriber, time.Now())
+    return err
+}
+
+func (r *OutboxRepository) MarkProcessed(ctx context.Context, id uuid.UUID) error {
+    _, err := r.db.op(ctx, "MarkProcessed").Exec("update outbox set processed_at = ? where id = ?", time.Now(), id)
+    return err
+}
+
+// RecordFailure stores a failed attempt and when the message may be retried.
+func (r *OutboxRepository) RecordFailure(ctx context.Context, message *OutboxMessage) error {
+    query := "update outbox set attempts = ?, last_error = ?, available_at = ? where id = ?"
+    _, err := r.db.op(ctx, "RecordFailure").Exec(query, message.Attempts, message.LastError, message.AvailableAt, message.ID)
+    return err
+}
+
+// MarkDead stores the last failed attempt of a message that is given up on.
+// Clearing dead_at makes it due again.
+func (r *OutboxRepository) MarkDead(ctx context.Context, message *OutboxMessage) error {
+    query := "update outbox set attempts = ?, last_error = ?, dead_at = ? where id = ?"
+    _, err := r.db.op(ctx, "MarkDead").Exec(query, message.Attempts, message.LastError, time.Now(), message.ID)
+    return err
+}
+
+// DeleteProcessed removes messages processed before the given time.
+func (r *OutboxRepository) DeleteProcessed(ctx context.Context, before time.Time) (int64, error) {
+    result, err := r.db.op(ctx, "DeleteProcessed").Exec("delete from outbox where processed_at < ?", before)
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+// insertOutbox records a message about data in tx. A message whose dedupKey
+// is already in the outbox is ignored, so retrying the same change never
+// emits its side effects twice.
+func insertOutbox(tx *loggedTx, topic, dedupKey string, data any) error {
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    payload, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+
+    query := `
+            insert into outbox(id, topic, dedup_key, payload, available_at, last_error)
+            values (?, ?, ?, ?, ?, '')
+            on duplicate key update dedup_key = dedup_key
+            `
+    _, err = tx.Exec(query, id, topic, dedupKey, string(payload), time.Now())
+    return err
+}
+
+const outboxColumns = "id, topic, dedup_key, payload, attempts, last_error, available_at, created_at, processed_at, dead_at"
+
+func outboxFields(m *OutboxMessage) []any {
+    return []any{&m.ID, &m.Topic, &m.DedupKey, &m.Payload, &m.Attempts, &m.LastError, &m.AvailableAt, &m.CreatedAt, &m.ProcessedAt, &m.DeadAt}
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+// PaymentActor is recorded on the order timeline for changes made because of
+// a payment outcome.
+const PaymentActor = "payment"
+
+// PaymentStore is what the handlers need of *PaymentRepository, so that
+// their tests can keep payments in memory instead.
+type PaymentStore interface {
+    Create(ctx context.Context, payment *Payment) error
+    GetById(ctx context.Context, id uuid.UUID) (*Payment, error)
+    GetByProviderRef(ctx context.Context, provider, ref string) (*Payment, error)
+    FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Payment, error)
+    Update(ctx context.Context, payment *Payment, orderStatus OrderStatus, note string) error
+    CreateEvent(ctx context.Context, event *PaymentEvent) (bool, error)
+    GetEvent(ctx context.Context, id uuid.UUID) (*PaymentEvent, error)
+    FindEvents(ctx context.Context, limit int) ([]PaymentEvent, error)
+    RecordEventOutcome(ctx context.Context, event *PaymentEvent) error
+}
+
+type PaymentRepository struct {
+    db *loggedDB
+}
+
+func NewPaymentRepository(db *sql.DB) *PaymentRepository {
+    return &PaymentRepository{
+        db: newLoggedDB(db, "PaymentRepository"),
+    }
+}
+
+// Create stores a new payment attempt and makes its status the payment
+// status of the order.
+func (r *PaymentRepository) Create(ctx context.Context, payment *Payment) error {
+    tx, err := r.db.begin(ctx, "Create")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Random rather than time ordered: the ID appears in the customer's
+    // return URL and must not be guessable.
+    id, err := uuid.NewRandom()
+    if err != nil {
+        return err
+    }
+
+    payment.ID = id
+    payment.CreatedAt = time.Now()
+    payment.UpdatedAt = payment.CreatedAt
+
+    query := `
+            insert into payments(id, order_id, provider, provider_ref, amount, currency, status, redirect_url, last_error, created_at, updated_at)
+            values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+            `
+    _, err = tx.Exec(query, payment.ID, payment.OrderID, payment.Provider, payment.ProviderRef, payment.Amount, payment.Currency, payment.Status, payment.RedirectURL, payment.LastError, payment.CreatedAt, payment.UpdatedAt)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec("update orders set payment_status = ? where id = ?", payment.Status, payment.OrderID)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+func (r *PaymentRepository) GetById(ctx context.Context, id uuid.UUID) (*Payment, error) {
+    query := "select " + paymentColumns + " from payments where id = ?"
+    return scanPayment(r.db.op(ctx, "GetById").QueryRow(query, id))
+}
+
+func (r *PaymentRepository) GetByProviderRef(ctx context.Context, provider, ref string) (*Payment, error) {
+    query := "select " + paymentColumns + " from payments where provider = ? and provider_ref = ?"
+    return scanPayment(r.db.op(ctx, "GetByProviderRef").QueryRow(query, provider, ref))
+}
+
+// FindByOrder returns the payment attempts of an order, oldest first.
+func (r *PaymentRepository) FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Payment, error) {
+    var payments []Payment
+
+    query := "select " + paymentColumns + " from payments where order_id = ? order by created_at"
+    rows, err := r.db.op(ctx, "FindByOrder").Query(query, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var payment Payment
+
+        err := rows.Scan(paymentFields(&payment)...)
+        if err != nil {
+            return nil, err
+        }
+
+        payments = append(payments, payment)
+    }
+
+    return payments, nil
+}
+
+// Update stores the new state of payment and mirrors its status onto the
+// order. When orderStatus is set the order is moved there as well, if its
+// state machine still allows it; an order that moved on in the meantime keeps
+// its status. A payment that becomes paid writes order.paid to the outbox.
+// Everything happens in one transaction.
+func (r *PaymentRepository) Update(ctx context.Context, payment *Payment, orderStatus OrderStatus, note string) error {
+    tx, err := r.db.begin(ctx, "Update")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var previous PaymentStatus
+    err = tx.QueryRow("select status from payments where id = ? for update", payment.ID).Scan(&previous)
+    if err != nil {
+        return err
+    }
+
+    payment.UpdatedAt = time.Now()
+
+    query := "update payments set provider_ref = ?, status = ?, redirect_url = ?, last_error = ?, updated_at = ? where id = ?"
+    _, err = tx.Exec(query, payment.ProviderRef, payment.Status, payment.RedirectURL, payment.LastError, payment.UpdatedAt, payment.ID)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec("update orders set payment_status = ? where id = ?", payment.Status, payment.OrderID)
+    if err != nil {
+        return err
+    }
+
+    if orderStatus != "" {
+        _, err = updateOrderStatus(tx, payment.OrderID, orderStatus, PaymentActor, note)
+        var transitionErr *TransitionError
+        if err != nil && !errors.As(err, &transitionErr) {
+            return err
+        }
+    }
+
+    if payment.Status == PaymentPaid && previous != PaymentPaid {
+        err = insertOutbox(tx, EventOrderPaid, EventOrderPaid+":"+payment.ID.String(), payment)
+        if err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
+
+const paymentColumns = "id, order_id, provider, provider_ref, amount, currency, status, redirect_url, last_error, created_at, updated_at"
+
+func paymentFields(p *Payment) []any {
+    return []any{&p.ID, &p.OrderID, &p.Provider, &p.ProviderRef, &p.Amount, &p.Currency, &p.Status, &p.RedirectURL, &p.LastError, &p.CreatedAt, &p.UpdatedAt}
+}
+
+func scanPayment(row *sql.Row) (*Payment, error) {
+    payment := &Payment{}
+    err := row.Scan(paymentFields(payment)...)
+    if err != nil {
+        return nil, err
+    }
+    return payment, nil
+}
+
+// CreateEvent stores a received provider webhook. It reports false, and
+// loads the stored copy into event, when the provider already sent an event
+// with the same ID.
+func (r *PaymentRepository) CreateEvent(ctx context.Context, event *PaymentEvent) (bool, error) {
+    db := r.db.op(ctx, "CreateEvent")
+
+    id, err := uuid.NewV7()
+    if err != nil {
+        return false, err
+    }
+
+    event.ID = id
+    event.Status = PaymentEventReceived
+    event.ReceivedAt = time.Now()
+
+    query := `
+            insert into payment_events(id, provider, event_id, event_type, provider_ref, payload, status, error, received_at)
+            values (?, ?, ?, ?, ?, ?, ?, '', ?)
+            on duplicate key update id = id
+            `
+    result, err := db.Exec(query, event.ID, event.Provider, event.EventID, event.EventType, event.ProviderRef, event.Payload, event.Status, event.ReceivedAt)
+    if err != nil {
+        return false, err
+    }
+
+    rowAffected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    if rowAffected == 1 {
+        return true, nil
+    }
+
+    query = "select " + paymentEventColumns + " from payment_events where provider = ? and event_id = ?"
+    err = db.QueryRow(query, event.Provider, event.EventID).Scan(paymentEventFields(event)...)
+    return false, err
+}
+
+func (r *PaymentRepository) GetEvent(ctx context.Context, id uuid.UUID) (*PaymentEvent, error) {
+    query := "select " + paymentEventColumns + " from payment_events where id = ?"
+
+    event := &PaymentEvent{}
+    err := r.db.op(ctx, "GetEvent").QueryRow(query, id).Scan(paymentEventFields(event)...)
+    if err != nil {
+        return nil, err
+    }
+
+    return event, nil
+}
+
+// FindEvents returns the most recently received provider webhooks.
+func (r *PaymentRepository) FindEvents(ctx context.Context, limit int) ([]PaymentEvent, error) {
+    var events []PaymentEvent
+
+    query := "select " + paymentEventColumns + " from payment_events order by received_at desc limit ?"
+    rows, err := r.db.op(ctx, "FindEvents").Query(query, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var event PaymentEvent
+
+        err := rows.Scan(paymentEventFields(&event)...)
+        if err != nil {
+            return nil, err
+        }
+
+        events = append(events, event)
+    }
+
+    return events, nil
+}
+
+// RecordEventOutcome stores how processing an event went.
+func (r *PaymentRepository) RecordEventOutcome(ctx context.Context, event *PaymentEvent) error {
+    query := "update payment_events set payment_id = ?, status = ?, error = ?, processed_at = ? where id = ?"
+    _, err := r.db.op(ctx, "RecordEventOutcome").Exec(query, event.PaymentID, event.Status, event.Error, event.ProcessedAt, event.ID)
+    return err
+}
+
+const paymentEventColumns = "id, provider, event_id, event_type, provider_ref, payment_id, payload, status, error, received_at, processed_at"
+
+func paymentEventFields(e *PaymentEvent) []any {
+    return []any{&e.ID, &e.Provider, &e.EventID, &e.EventType, &e.ProviderRef, &e.PaymentID, &e.Payload, &e.Status, &e.Error, &e.ReceivedAt, &e.ProcessedAt}
+}
@@ -1,29 +1,33 @@
 package repository
 
 import (
+    "context"
     "database/sql"
+    "errors"
+    "fmt"
     "github.com/google/uuid"
     . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "strconv"
+    "strings"
     "time"
 )
 
 type ProductRepository struct {
-    db *sql.DB
+    db *loggedDB
 }
 
 func NewProductRepository(db *sql.DB) *ProductRepository {
     return &ProductRepository{
-        db: db,
+        db: newLoggedDB(db, "ProductRepository"),
     }
 }
 
-func (r *ProductRepository) Create(product *Product) (*Product, error) {
-    query := "insert into products(id, name, price, description, image) values (?, ?, ?, ?, ?)"
-    stmt, err := r.db.Prepare(query)
+func (r *ProductRepository) Create(ctx context.Context, product *Product) (*Product, error) {
+    tx, err := r.db.begin(ctx, "Create")
     if err != nil {
         return nil, err
     }
-    defer stmt.Close()
+    defer tx.Rollback()
 
     newId, err := uuid.NewUUID()
     if err != nil {
@@ -31,52 +35,204 @@ func (r *ProductRepository) Create(product *Product) (*Product, error) {
     }
 
     product.ID = newId
-
-    result, err := stmt.Exec(product.ID, product.Name, product.Price, product.Description, product.Image)
+    err = insertProduct(tx, product)
     if err != nil {
         return nil, err
     }
 
-    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
-        return nil, sql.ErrNoRows
+    return product, tx.Commit()
+}
+
+func (r *ProductRepository) GetById(ctx context.Context, id uuid.UUID) (*Product, error) {
+    query := "select id, coalesce(sku, ''), name, price, description, image, stock, created_date, modified_date from products where id = ?"
+    row := r.db.op(ctx, "GetById").QueryRow(query, id)
+
+    product := &Product{}
+    err := row.Scan(&product.ID, &product.SKU, &product.Name, &product.Price, &product.Description, &product.Image, &product.Stock, &product.CreatedDate, &product.ModifiedDate)
+    if err != nil {
+        return nil, err
     }
 
     return product, nil
 }
 
-func (r *ProductRepository) GetById(id uuid.UUID) (*Product, error) {
-    query := "select id, name, price, description, image, created_date, modified_date from products where id = ?"
-    row := r.db.QueryRow(query, id)
+// GetByIds returns the products with the given IDs in one query, in no
+// particular order. Unknown IDs are skipped.
+func (r *ProductRepository) GetByIds(ctx context.Context, ids []uuid.UUID) ([]Product, error) {
+    if len(ids) == 0 {
+        return nil, nil
+    }
 
-    product := &Product{}
-    err := row.Scan(&product.ID, &product.Name, &product.Price, &product.Description, &product.Image, &product.CreatedDate, &product.ModifiedDate)
+    query, args := inClause("select id, coalesce(sku, ''), name, price, description, image, stock, created_date, modified_date from products where id in", ids)
+    rows, err := r.db.op(ctx, "GetByIds").Query(query, args...)
     if err != nil {
         return nil, err
     }
+    defer rows.Close()
 
-    return product, nil
+    var products []Product
+    for rows.Next() {
+        var product Product
+        err := rows.Scan(&product.ID, &product.SKU, &product.Name, &product.Price, &product.Description, &product.Image, &product.Stock, &product.CreatedDate, &product.ModifiedDate)
+        if err != nil {
+            return nil, err
+        }
+        products = append(products, product)
+    }
+
+    return products, rows.Err()
+}
+
+// inClause appends "(?, ?, ...)" with a placeholder per ID to query.
+func inClause(query string, ids []uuid.UUID) (string, []any) {
+    args := make([]any, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    return query + " (?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+func (r *ProductRepository) Update(ctx context.Context, id uuid.UUID, product *Product) error {
+    tx, err := r.db.begin(ctx, "Update")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    product.ID = id
+    err = updateProduct(tx, product)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// Import creates and updates products in one transaction, so that either
+// the whole import is applied or none of it is.
+func (r *ProductRepository) Import(ctx context.Context, creates, updates []*Product) error {
+    tx, err := r.db.begin(ctx, "Import")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, product := range creates {
+        err = insertProduct(tx, product)
+        if err != nil {
+            return fmt.Errorf("create %s: %w", product.Name, err)
+        }
+    }
+
+    for _, product := range updates {
+        err = updateProduct(tx, product)
+        if err != nil {
+            return fmt.Errorf("update %s: %w", product.Name, err)
+        }
+    }
+
+    return tx.Commit()
+}
+
+// Export calls fn with each product, oldest first, reading them from the
+// database one at a time. fn must not keep the product, which is reused.
+func (r *ProductRepository) Export(ctx context.Context, fn func(*Product) error) error {
+    query := "select id, coalesce(sku, ''), name, price, description, image, stock, created_date, modified_date from products order by created_date, id"
+    rows, err := r.db.op(ctx, "Export").Query(query)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var product Product
+    for rows.Next() {
+        err := rows.Scan(&product.ID, &product.SKU, &product.Name, &product.Price, &product.Description, &product.Image, &product.Stock, &product.CreatedDate, &product.ModifiedDate)
+        if err != nil {
+            return err
+        }
+
+        if err := fn(&product); err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
 }
 
-func (r *ProductRepository) Update(id uuid.UUID, product *Product) error {
-    query := "update products set name = ?, price = ?, description = ?, image = ?, modified_date = ? where id = ?"
-    stmt, err := r.db.Prepare(query)
+func insertProduct(tx *loggedTx, product *Product) error {
+    product.CreatedDate = time.Now()
+    product.ModifiedDate = product.CreatedDate
+
+    query := "insert into products(id, sku, name, price, description, image, stock, created_date, modified_date) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+    result, err := tx.Exec(query, product.ID, nullString(product.SKU), product.Name, product.Price, product.Description, product.Image, product.Stock, product.CreatedDate, product.ModifiedDate)
     if err != nil {
         return err
     }
 
-    _, err = stmt.Exec(product.Name, product.Price, product.Description, product.Image, time.Now(), id)
-    return err
+    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        return sql.ErrNoRows
+    }
+
+    return insertOutbox(tx, EventProductCreated, EventProductCreated+":"+product.ID.String(), product)
 }
 
-func (r *ProductRepository) Delete(id uuid.UUID) error {
-    _, err := r.db.Exec("delete from products where id = ?", id)
-    return err
+func updateProduct(tx *loggedTx, product *Product) error {
+    product.ModifiedDate = time.Now()
+
+    query := "update products set sku = ?, name = ?, price = ?, description = ?, image = ?, stock = ?, modified_date = ? where id = ?"
+    result, err := tx.Exec(query, nullString(product.SKU), product.Name, product.Price, product.Description, product.Image, product.Stock, product.ModifiedDate, product.ID)
+    if err != nil {
+        return err
+    }
+
+    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        return sql.ErrNoRows
+    }
+
+    // Every edit is its own event, so the key includes the modification time.
+    dedupKey := EventProductUpdated + ":" + product.ID.String() + ":" + strconv.FormatInt(product.ModifiedDate.UnixNano(), 10)
+    return insertOutbox(tx, EventProductUpdated, dedupKey, product)
+}
+
+// nullString stores an empty s as NULL, for optional unique columns.
+func nullString(s string) sql.NullString {
+    return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Delete removes the product and writes its last state to the outbox.
+func (r *ProductRepository) Delete(ctx context.Context, id uuid.UUID) error {
+    tx, err := r.db.begin(ctx, "Delete")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query := "select id, coalesce(sku, ''), name, price, description, image, stock, created_date, modified_date from products where id = ? for update"
+    product := &Product{}
+    err = tx.QueryRow(query, id).Scan(&product.ID, &product.SKU, &product.Name, &product.Price, &product.Description, &product.Image, &product.Stock, &product.CreatedDate, &product.ModifiedDate)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec("delete from products where id = ?", id)
+    if err != nil {
+        return err
+    }
+
+    err = insertOutbox(tx, EventProductDeleted, EventProductDeleted+":"+id.String(), product)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
 }
 
-func (r *ProductRepository) Find(whereClause string, page, size int) ([]Product, error) {
+func (r *ProductRepository) Find(ctx context.Context, whereClause string, page, size int) ([]Product, error) {
+    db := r.db.op(ctx, "Find")
+
     var products []Product
 
-    query := `select id, name, price, description, image, created_date, modified_date from products`
+    query := `select id, coalesce(sku, ''), name, price, description, image, stock, created_date, modified_date from products`
 
     if whereClause != "" {
         query += " where " + whereClause
@@ -84,13 +240,13 @@ func (r *ProductRepository) Find(whereClause string, page, size int) ([]Product,
 
     query += " order by created_date desc"
 
-    var rows *sql.Rows
+    var rows *loggedRows
     var err error
     if size != -1 {
         query += " limit ? offset ?"
-        rows, err = r.db.Query(query, size, (page-1)*size)
+        rows, err = db.Query(query, size, (page-1)*size)
     } else {
-        rows, err = r.db.Query(query)
+        rows, err = db.Query(query)
     }
     if err != nil {
         return nil, err
@@ -100,7 +256,7 @@ func (r *ProductRepository) Find(whereClause string, page, size int) ([]Product,
     for rows.Next() {
         var product Product
 
-        err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.Description, &product.Image, &product.CreatedDate, &product.ModifiedDate)
+        err := rows.Scan(&product.ID, &product.SKU, &product.Name, &product.Price, &product.Description, &product.Image, &product.Stock, &product.CreatedDate, &product.ModifiedDate)
         if err != nil {
             return nil, err
         }
@@ -111,11 +267,45 @@ func (r *ProductRepository) Find(whereClause string, page, size int) ([]Product,
     return products, nil
 }
 
-func (r *ProductRepository) Count() (int, error) {
+func (r *ProductRepository) Count(ctx context.Context) (int, error) {
     var count int
-    err := r.db.QueryRow("select count(*) from products").Scan(&count)
+    err := r.db.op(ctx, "Count").QueryRow("select count(*) from products").Scan(&count)
     if err != nil {
         return 0, err
     }
     return count, nil
 }
+
+// takeStock takes quantity units of a product out of stock in tx, returning
+// an *OutOfStockError if there are not that many; product, if known, names
+// it. Products that do not track their stock have a null stock, which is
+// left alone. The row is locked and read first because MySQL reports the
+// rows an update changed rather than those it matched, so decrementing a
+// null stock would look like a product that ran out.
+func takeStock(tx *loggedTx, productID uuid.UUID, quantity int, product *Product) error {
+    var stock sql.NullInt64
+    err := tx.QueryRow("select stock from products where id = ? for update", productID).Scan(&stock)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return err
+    }
+    if err == nil && !stock.Valid {
+        return nil
+    }
+
+    if err == nil {
+        query := "update products set stock = stock - ? where id = ? and stock >= ?"
+        result, err := tx.Exec(query, quantity, productID, quantity)
+        if err != nil {
+            return err
+        }
+        if rowAffected, err := result.RowsAffected(); err == nil && rowAffected == 1 {
+            return nil
+        }
+    }
+
+    outOfStock := &OutOfStockError{ProductID: productID}
+    if product != nil {
+        outOfStock.Name = product.Name
+    }
+    return outOfStock
+}
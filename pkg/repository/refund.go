@@ -0,0 +1,386 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+type RefundRepository struct {
+    db *loggedDB
+}
+
+func NewRefundRepository(db *sql.DB) *RefundRepository {
+    return &RefundRepository{
+        db: newLoggedDB(db, "RefundRepository"),
+    }
+}
+
+// Create stores refund as pending after checking, with the order locked, that
+// its lines and amount are still refundable from its payment. The lines and
+// amount count as refunded from here on, so concurrent refunds cannot exceed
+// what was paid; Fail gives them back. A refusal is a *RefundError.
+func (r *RefundRepository) Create(ctx context.Context, refund *Refund) error {
+    tx, err := r.db.begin(ctx, "Create")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var refunded int64
+    err = tx.QueryRow("select refunded_amount from orders where id = ? for update", refund.OrderID).Scan(&refunded)
+    if err != nil {
+        return err
+    }
+
+    var paid int64
+    var paymentStatus PaymentStatus
+    query := "select amount, status from payments where id = ? and order_id = ?"
+    err = tx.QueryRow(query, refund.PaymentID, refund.OrderID).Scan(&paid, &paymentStatus)
+    if err != nil {
+        return err
+    }
+
+    if paymentStatus != PaymentPaid && paymentStatus != PaymentPartlyRefunded {
+        return &RefundError{Message: "The payment for this order has not been captured, so there is nothing to refund."}
+    }
+    if refund.Amount <= 0 {
+        return &RefundError{Message: "Choose items or an amount to refund."}
+    }
+    if refunded+refund.Amount > paid {
+        return &RefundError{Message: fmt.Sprintf("Only $%.2f of this order is left to refund.", float64(paid-refunded)/100)}
+    }
+
+    for _, item := range refund.Items {
+        var quantity, refundedQuantity int
+        query := "select quantity, refunded_quantity from order_items where order_id = ? and product_id = ?"
+        err := tx.QueryRow(query, refund.OrderID, item.ProductID).Scan(&quantity, &refundedQuantity)
+        if err != nil {
+            return err
+        }
+
+        if item.Quantity > quantity-refundedQuantity {
+            return &RefundError{Message: fmt.Sprintf("Only %d of an item are left to refund.", quantity-refundedQuantity)}
+        }
+    }
+
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    refund.ID = id
+    refund.Status = RefundPending
+    refund.CreatedAt = time.Now()
+
+    query = `
+            insert into refunds(id, order_id, return_id, payment_id, amount, reason, restock, status, error, actor, created_at)
+            values (?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?)
+            `
+    _, err = tx.Exec(query, refund.ID, refund.OrderID, refund.ReturnID, refund.PaymentID, refund.Amount, refund.Reason, refund.Restock, refund.Status, refund.Actor, refund.CreatedAt)
+    if err != nil {
+        return err
+    }
+
+    for _, item := range refund.Items {
+        _, err := tx.Exec("insert into refund_items(refund_id, product_id, quantity, amount) values (?, ?, ?, ?)", refund.ID, item.ProductID, item.Quantity, item.Amount)
+        if err != nil {
+            return err
+        }
+
+        query := "update order_items set refunded_quantity = refunded_quantity + ? where order_id = ? and product_id = ?"
+        if _, err := tx.Exec(query, item.Quantity, refund.OrderID, item.ProductID); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec("update orders set refunded_amount = refunded_amount + ? where id = ?", refund.Amount, refund.OrderID)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// Complete records that the provider refunded refund. Refunded lines go back
+// in stock if the refund asked for it and they are not there already, the
+// payment becomes partially or fully refunded, and the refund is added to the
+// order timeline and the outbox. Products whose stock is not tracked, or
+// that are gone, are not restocked. A refund that is no longer pending is
+// left as it is.
+func (r *RefundRepository) Complete(ctx context.Context, refund *Refund) error {
+    tx, err := r.db.begin(ctx, "Complete")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query := "update refunds set status = ?, provider_ref = ? where id = ? and status = ?"
+    result, err := tx.Exec(query, RefundSucceeded, refund.ProviderRef, refund.ID, RefundPending)
+    if err != nil {
+        return err
+    }
+    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        return err
+    }
+    refund.Status = RefundSucceeded
+
+    putBack := false
+    if refund.Restock {
+        for _, item := range refund.Items {
+            var stock sql.NullInt64
+            err := tx.QueryRow("select stock from products where id = ? for update", item.ProductID).Scan(&stock)
+            if errors.Is(err, sql.ErrNoRows) || err == nil && !stock.Valid {
+                continue
+            }
+            if err != nil {
+                return err
+            }
+
+            var quantity, restocked int
+            query := "select quantity, restocked_quantity from order_items where order_id = ? and product_id = ? for update"
+            err = tx.QueryRow(query, refund.OrderID, item.ProductID).Scan(&quantity, &restocked)
+            if err != nil {
+                return err
+            }
+
+            restock := min(item.Quantity, quantity-restocked)
+            if restock <= 0 {
+                continue
+            }
+
+            query = "update order_items set restocked_quantity = restocked_quantity + ? where order_id = ? and product_id = ?"
+            if _, err := tx.Exec(query, restock, refund.OrderID, item.ProductID); err != nil {
+                return err
+            }
+            if _, err := tx.Exec("update products set stock = stock + ? where id = ?", restock, item.ProductID); err != nil {
+                return err
+            }
+            putBack = true
+        }
+    }
+
+    query = `
+            select p.amount, o.refunded_amount
+            from payments p
+                inner join orders o on o.id = p.order_id
+            where p.id = ?
+            `
+    var paid, refunded int64
+    if err := tx.QueryRow(query, refund.PaymentID).Scan(&paid, &refunded); err != nil {
+        return err
+    }
+
+    paymentStatus := PaymentPartlyRefunded
+    if refunded >= paid {
+        paymentStatus = PaymentRefunded
+    }
+
+    _, err = tx.Exec("update payments set status = ?, updated_at = ? where id = ?", paymentStatus, time.Now(), refund.PaymentID)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec("update orders set payment_status = ? where id = ?", paymentStatus, refund.OrderID)
+    if err != nil {
+        return err
+    }
+
+    note := fmt.Sprintf("Refunded $%.2f", float64(refund.Amount)/100)
+    if putBack {
+        note += " and restocked the items"
+    }
+    if refund.Reason != "" {
+        note += ": " + refund.Reason
+    }
+
+    err = insertOrderEvent(tx, &OrderEvent{
+        OrderID: refund.OrderID,
+        Type:    OrderRefunded,
+        Actor:   refund.Actor,
+        Note:    note,
+    })
+    if err != nil {
+        return err
+    }
+
+    err = insertOutbox(tx, EventOrderRefunded, EventOrderRefunded+":"+refund.ID.String(), refund)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// Fail records that the provider refused refund and makes its lines and
+// amount refundable again. A refund that is no longer pending is left as it
+// is.
+func (r *RefundRepository) Fail(ctx context.Context, refund *Refund) error {
+    tx, err := r.db.begin(ctx, "Fail")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query := "update refunds set status = ?, error = ? where id = ? and status = ?"
+    result, err := tx.Exec(query, RefundFailed, refund.Error, refund.ID, RefundPending)
+    if err != nil {
+        return err
+    }
+    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        return err
+    }
+    refund.Status = RefundFailed
+
+    for _, item := range refund.Items {
+        query := "update order_items set refunded_quantity = refunded_quantity - ? where order_id = ? and product_id = ?"
+        if _, err := tx.Exec(query, item.Quantity, refund.OrderID, item.ProductID); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec("update orders set refunded_amount = refunded_amount - ? where id = ?", refund.Amount, refund.OrderID)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// Get returns a refund with its items.
+func (r *RefundRepository) Get(ctx context.Context, id uuid.UUID) (*Refund, error) {
+    db := r.db.op(ctx, "Get")
+
+    query := `
+            select id, order_id, return_id, payment_id, amount, reason, restock, status, provider_ref, error, actor, created_at
+            from refunds
+            where id = ?
+            `
+    refund := &Refund{}
+    err := db.QueryRow(query, id).Scan(&refund.ID, &refund.OrderID, &refund.ReturnID, &refund.PaymentID, &refund.Amount, &refund.Reason, &refund.Restock, &refund.Status, &refund.ProviderRef, &refund.Error, &refund.Actor, &refund.CreatedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    refund.Items, err = findRefundItems(db, id)
+    if err != nil {
+        return nil, err
+    }
+
+    return refund, nil
+}
+
+// FindPending returns up to limit refunds that are still pending and were
+// created before the given time, oldest first, with their items.
+func (r *RefundRepository) FindPending(ctx context.Context, before time.Time, limit int) ([]Refund, error) {
+    return findPendingRefunds(r.db.op(ctx, "FindPending"), "and created_at < ? order by created_at limit ?", before, limit)
+}
+
+// FindPendingByPayment returns the pending refunds of a payment, oldest
+// first, with their items.
+func (r *RefundRepository) FindPendingByPayment(ctx context.Context, paymentID uuid.UUID) ([]Refund, error) {
+    return findPendingRefunds(r.db.op(ctx, "FindPendingByPayment"), "and payment_id = ? order by created_at", paymentID)
+}
+
+// SucceededAmount returns how much of a payment the provider has refunded
+// by the refunds recorded as succeeded, in cents.
+func (r *RefundRepository) SucceededAmount(ctx context.Context, paymentID uuid.UUID) (int64, error) {
+    var amount int64
+    query := "select coalesce(sum(amount), 0) from refunds where payment_id = ? and status = ?"
+    err := r.db.op(ctx, "SucceededAmount").QueryRow(query, paymentID, RefundSucceeded).Scan(&amount)
+    return amount, err
+}
+
+// findPendingRefunds returns the pending refunds that also match where, with
+// their items.
+func findPendingRefunds(q queryer, where string, args ...any) ([]Refund, error) {
+    var refunds []Refund
+
+    query := `
+            select id, order_id, return_id, payment_id, amount, reason, restock, status, provider_ref, error, actor, created_at
+            from refunds
+            where status = ? ` + where
+    rows, err := q.Query(query, append([]any{RefundPending}, args...)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var refund Refund
+
+        err := rows.Scan(&refund.ID, &refund.OrderID, &refund.ReturnID, &refund.PaymentID, &refund.Amount, &refund.Reason, &refund.Restock, &refund.Status, &refund.ProviderRef, &refund.Error, &refund.Actor, &refund.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        refunds = append(refunds, refund)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    for i := range refunds {
+        refunds[i].Items, err = findRefundItems(q, refunds[i].ID)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return refunds, nil
+}
+
+func findRefundItems(q queryer, refundID uuid.UUID) ([]RefundItem, error) {
+    var items []RefundItem
+
+    rows, err := q.Query("select product_id, quantity, amount from refund_items where refund_id = ?", refundID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var item RefundItem
+        if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Amount); err != nil {
+            return nil, err
+        }
+        items = append(items, item)
+    }
+
+    return items, rows.Err()
+}
+
+// FindByOrder returns the refunds of an order, oldest first, without their
+// items.
+func (r *RefundRepository) FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Refund, error) {
+    var refunds []Refund
+
+    query := `
+            select id, order_id, return_id, payment_id, amount, reason, restock, status, provider_ref, error, actor, created_at
+            from refunds
+            where order_id = ?
+            order by created_at
+            `
+    rows, err := r.db.op(ctx, "FindByOrder").Query(query, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var refund Refund
+
+        err := rows.Scan(&refund.ID, &refund.OrderID, &refund.ReturnID, &refund.PaymentID, &refund.Amount, &refund.Reason, &refund.Restock, &refund.Status, &refund.ProviderRef, &refund.Error, &refund.Actor, &refund.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        refunds = append(refunds, refund)
+    }
+
+    return refunds, nil
+}
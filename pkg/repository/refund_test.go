@@ -0,0 +1,105 @@
+package repository
+
+import (
+    "context"
+    "database/sql/driver"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "strings"
+    "testing"
+)
+
+func TestCompleteRestock(t *testing.T) {
+    hat, giftCard := uuid.New(), uuid.New()
+
+    tests := []struct {
+        name      string
+        restock   bool
+        items     []RefundItem
+        restocked []uuid.UUID
+    }{
+        {
+            name:      "tracked",
+            restock:   true,
+            items:     []RefundItem{{ProductID: hat, Quantity: 1, Amount: 2299}},
+            restocked: []uuid.UUID{hat},
+        },
+        {
+            name:    "untracked",
+            restock: true,
+            items:   []RefundItem{{ProductID: giftCard, Quantity: 1, Amount: 2500}},
+        },
+        {
+            name:      "tracked and untracked",
+            restock:   true,
+            items:     []RefundItem{{ProductID: giftCard, Quantity: 1, Amount: 2500}, {ProductID: hat, Quantity: 2, Amount: 4598}},
+            restocked: []uuid.UUID{hat},
+        },
+        {
+            name:  "not asked for",
+            items: []RefundItem{{ProductID: hat, Quantity: 1, Amount: 2299}},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            scripted, db := newScriptedDB(t)
+
+            scripted.on("update refunds set status", affected(1))
+            scripted.on("select stock from products", func(args []any) scriptedResult {
+                if args[0] == giftCard.String() {
+                    return rows("stock", nil)(args)
+                }
+                return rows("stock", int64(3))(args)
+            })
+            scripted.on("select quantity, restocked_quantity from order_items", func([]any) scriptedResult {
+                return scriptedResult{columns: []string{"quantity", "restocked_quantity"}, rows: [][]driver.Value{{int64(2), int64(0)}}}
+            })
+            scripted.on("update order_items set restocked_quantity", affected(1))
+            scripted.on("update products set stock = stock + ?", affected(1))
+            scripted.on("select p.amount, o.refunded_amount", func([]any) scriptedResult {
+                return scriptedResult{columns: []string{"amount", "refunded_amount"}, rows: [][]driver.Value{{int64(9598), int64(2299)}}}
+            })
+            scripted.on("update payments set status", affected(1))
+            scripted.on("update orders set payment_status", affected(1))
+            scripted.on("insert into order_events", affected(1))
+            scripted.on("insert into outbox", affected(1))
+
+            refund := &Refund{ID: uuid.New(), OrderID: uuid.New(), PaymentID: uuid.New(), Amount: 2299, Restock: tt.restock, Items: tt.items}
+            if err := NewRefundRepository(db).Complete(context.Background(), refund); err != nil {
+                t.Fatalf("Complete = %v", err)
+            }
+            if scripted.committed != 1 || refund.Status != RefundSucceeded {
+                t.Fatalf("refund = %+v, committed %d times", refund, scripted.committed)
+            }
+
+            updates := scripted.ran("update products set stock = stock + ?")
+            if len(updates) != len(tt.restocked) {
+                t.Fatalf("stock updates = %v, want %d", updates, len(tt.restocked))
+            }
+            for i, product := range tt.restocked {
+                if updates[i].args[1] != product.String() {
+                    t.Errorf("restocked %v, want %s", updates[i].args[1], product)
+                }
+            }
+            if n := len(scripted.ran("update order_items set restocked_quantity")); n != len(tt.restocked) {
+                t.Errorf("%d order items marked restocked, want %d", n, len(tt.restocked))
+            }
+
+            // The timeline only claims a restock that happened.
+            events := scripted.ran("insert into order_events")
+            if len(events) != 1 {
+                t.Fatalf("%d order events, want 1", len(events))
+            }
+            claimed := false
+            for _, arg := range events[0].args {
+                if note, ok := arg.(string); ok && strings.Contains(note, "restocked") {
+                    claimed = true
+                }
+            }
+            if claimed != (len(tt.restocked) > 0) {
+                t.Errorf("order event %v claims a restock: %v, want %v", events[0].args, claimed, len(tt.restocked) > 0)
+            }
+        })
+    }
+}
@@ -1,15 +1,33 @@
 package repository
 
-import "database/sql"
+import (
+    "database/sql"
+)
 
 type Repository struct {
     Product *ProductRepository
     Order   *OrderRepository
+    User    *UserRepository
+    Session *SessionRepository
+    Webhook *WebhookRepository
+    Outbox  *OutboxRepository
+    Payment PaymentStore
+    Refund  *RefundRepository
+    Return  *ReturnRepository
+    Invoice *InvoiceRepository
 }
 
 func NewRepository(db *sql.DB) *Repository {
     return &Repository{
         Product: NewProductRepository(db),
         Order:   NewOrderRepository(db),
+        User:    NewUserRepository(db),
+        Session: NewSessionRepository(db),
+        Webhook: NewWebhookRepository(db),
+        Outbox:  NewOutboxRepository(db),
+        Payment: NewPaymentRepository(db),
+        Refund:  NewRefundRepository(db),
+        Return:  NewReturnRepository(db),
+        Invoice: NewInvoiceRepository(db),
     }
 }
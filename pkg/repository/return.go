@@ -0,0 +1,407 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "strings"
+    "time"
+)
+
+// ReturnActor is the actor recorded for return requests made by customers.
+const ReturnActor = "customer"
+
+// queryer is what both loggedOp and loggedTx offer for reading.
+type queryer interface {
+    Query(query string, args ...any) (*loggedRows, error)
+    QueryRow(query string, args ...any) *sql.Row
+}
+
+type ReturnRepository struct {
+    db *loggedDB
+}
+
+func NewReturnRepository(db *sql.DB) *ReturnRepository {
+    return &ReturnRepository{
+        db: newLoggedDB(db, "ReturnRepository"),
+    }
+}
+
+// Create stores ret as requested after checking, with the order locked, that
+// the order was delivered and its items can still be returned. The request is
+// added to the order timeline and the outbox. A refusal is a *ReturnError.
+func (r *ReturnRepository) Create(ctx context.Context, ret *Return) error {
+    tx, err := r.db.begin(ctx, "Create")
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var status OrderStatus
+    err = tx.QueryRow("select status, email from orders where id = ? for update", ret.OrderID).Scan(&status, &ret.Email)
+    if err != nil {
+        return err
+    }
+
+    if status != Delivered {
+        return &ReturnError{Message: "Items can only be returned once the order has been delivered."}
+    }
+    if !ret.Resolution.IsValid() {
+        return &ReturnError{Message: "Choose a refund or an exchange."}
+    }
+    if ret.Reason == "" {
+        return &ReturnError{Message: "Please tell us why you are returning the items."}
+    }
+    if len(ret.Items) == 0 {
+        return &ReturnError{Message: "Choose the items you want to return."}
+    }
+
+    returnable, err := returnableQuantities(tx, ret.OrderID)
+    if err != nil {
+        return err
+    }
+    for _, item := range ret.Items {
+        if item.Quantity <= 0 || item.Quantity > returnable[item.ProductID] {
+            return &ReturnError{Message: fmt.Sprintf("Only %d of an item can be returned.", returnable[item.ProductID])}
+        }
+    }
+
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    ret.ID = id
+    ret.Status = ReturnRequested
+    ret.CreatedAt = time.Now()
+    ret.UpdatedAt = ret.CreatedAt
+
+    query := `
+            insert into returns(id, order_id, status, resolution, reason, note, created_at, updated_at)
+            values (?, ?, ?, ?, ?, '', ?, ?)
+            `
+    _, err = tx.Exec(query, ret.ID, ret.OrderID, ret.Status, ret.Resolution, ret.Reason, ret.CreatedAt, ret.UpdatedAt)
+    if err != nil {
+        return err
+    }
+
+    for _, item := range ret.Items {
+        _, err := tx.Exec("insert into return_items(return_id, product_id, quantity) values (?, ?, ?)", ret.ID, item.ProductID, item.Quantity)
+        if err != nil {
+            return err
+        }
+    }
+
+    event := &OrderEvent{
+        OrderID: ret.OrderID,
+        Type:    OrderReturnUpdated,
+        Actor:   ReturnActor,
+        Note:    fmt.Sprintf("Return requested for %s: %s", ret.Resolution, ret.Reason),
+    }
+    if err := insertOrderEvent(tx, event); err != nil {
+        return err
+    }
+
+    // Read it back so the outbox message carries the product names.
+    stored, err := getReturn(tx, ret.ID)
+    if err != nil {
+        return err
+    }
+
+    err = insertOutbox(tx, EventReturnUpdated, EventReturnUpdated+":"+event.ID.String(), stored)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// UpdateStatus moves the return to status if the return state machine allows
+// it, returning a *ReturnTransitionError otherwise. Approving a return issues
+// its RMA number. The change is recorded on the order timeline with the actor
+// and optional note, which is kept on the return, and written to the outbox.
+// Exchanging a return places the replacement order in the same transaction,
+// returning an *OutOfStockError if a returned product has run out.
+func (r *ReturnRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status ReturnStatus, actor, note string) (*Return, error) {
+    return r.updateStatus(ctx, "UpdateStatus", id, status, actor, note, uuid.NullUUID{})
+}
+
+// MarkRefunded moves a received return to refunded, linking the refund that
+// paid the customer back.
+func (r *ReturnRepository) MarkRefunded(ctx context.Context, id uuid.UUID, refundID uuid.UUID, actor, note string) (*Return, error) {
+    return r.updateStatus(ctx, "MarkRefunded", id, ReturnRefunded, actor, note, uuid.NullUUID{UUID: refundID, Valid: true})
+}
+
+func (r *ReturnRepository) updateStatus(ctx context.Context, method string, id uuid.UUID, status ReturnStatus, actor, note string, refundID uuid.NullUUID) (*Return, error) {
+    tx, err := r.db.begin(ctx, method)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    var current ReturnStatus
+    var rma sql.NullString
+    err = tx.QueryRow("select status, rma from returns where id = ? for update", id).Scan(&current, &rma)
+    if err != nil {
+        return nil, err
+    }
+
+    if !current.CanTransitionTo(status) {
+        return nil, &ReturnTransitionError{From: current, To: status}
+    }
+
+    if status == ReturnApproved && !rma.Valid {
+        rma = sql.NullString{String: "RMA-" + strings.ToUpper(uuid.NewString()[:8]), Valid: true}
+    }
+
+    query := `
+            update returns
+            set status = ?, rma = ?, note = ?, refund_id = coalesce(?, refund_id), updated_at = ?
+            where id = ?
+            `
+    _, err = tx.Exec(query, status, rma, note, refundID, time.Now(), id)
+    if err != nil {
+        return nil, err
+    }
+
+    ret, err := getReturn(tx, id)
+    if err != nil {
+        return nil, err
+    }
+
+    if status == ReturnExchanged {
+        if err := placeReplacement(tx, ret, actor); err != nil {
+            return nil, err
+        }
+    }
+
+    eventNote := fmt.Sprintf("Return %s %s", ret.RMA, status)
+    if ret.RMA == "" {
+        eventNote = fmt.Sprintf("Return %s", status)
+    }
+    if note != "" {
+        eventNote += ": " + note
+    }
+
+    event := &OrderEvent{
+        OrderID: ret.OrderID,
+        Type:    OrderReturnUpdated,
+        Actor:   actor,
+        Note:    eventNote,
+    }
+    if err := insertOrderEvent(tx, event); err != nil {
+        return nil, err
+    }
+
+    err = insertOutbox(tx, EventReturnUpdated, EventReturnUpdated+":"+event.ID.String(), ret)
+    if err != nil {
+        return nil, err
+    }
+
+    return ret, tx.Commit()
+}
+
+// placeReplacement places an order that ships the returned items again to
+// the customer of ret, and links it to ret. Nothing is owed for it, so it is
+// placed paid with a zero total.
+func placeReplacement(tx *loggedTx, ret *Return, actor string) error {
+    order := &Order{
+        ID:            uuid.Must(uuid.NewV7()),
+        Email:         ret.Email,
+        Status:        Ordered,
+        PaymentStatus: PaymentPaid,
+    }
+
+    query := `
+            insert into orders(id, user_id, email, status, payment_status)
+            select ?, user_id, email, ?, ? from orders where id = ?
+            `
+    _, err := tx.Exec(query, order.ID, order.Status, order.PaymentStatus, ret.OrderID)
+    if err != nil {
+        return err
+    }
+
+    for _, item := range ret.Items {
+        err = takeStock(tx, item.ProductID, item.Quantity, item.Product)
+        if err != nil {
+            return err
+        }
+
+        query = `
+                insert into order_items(order_id, product_id, product_name, unit_price, quantity, cost)
+                select ?, id, name, 0, ?, 0 from products where id = ?
+                `
+        _, err = tx.Exec(query, order.ID, item.Quantity, item.ProductID)
+        if err != nil {
+            return err
+        }
+
+        orderItem := OrderItem{OrderID: order.ID, ProductID: item.ProductID, Quantity: item.Quantity}
+        if item.Product != nil {
+            orderItem.Name = item.Product.Name
+        }
+        order.Items = append(order.Items, orderItem)
+    }
+
+    _, err = tx.Exec("update returns set replacement_order_id = ? where id = ?", order.ID, ret.ID)
+    if err != nil {
+        return err
+    }
+    ret.ReplacementOrderID = uuid.NullUUID{UUID: order.ID, Valid: true}
+
+    err = insertOrderEvent(tx, &OrderEvent{
+        OrderID:  order.ID,
+        Type:     OrderPlaced,
+        ToStatus: order.Status,
+        Actor:    actor,
+        Note:     fmt.Sprintf("Replacement for return %s", ret.RMA),
+    })
+    if err != nil {
+        return err
+    }
+
+    return insertOutbox(tx, EventOrderPlaced, EventOrderPlaced+":"+order.ID.String(), order)
+}
+
+// Get returns a return with its items and their products.
+func (r *ReturnRepository) Get(ctx context.Context, id uuid.UUID) (*Return, error) {
+    return getReturn(r.db.op(ctx, "Get"), id)
+}
+
+// FindByOrder returns the returns of an order, oldest first, with their items.
+func (r *ReturnRepository) FindByOrder(ctx context.Context, orderID uuid.UUID) ([]Return, error) {
+    return findReturns(r.db.op(ctx, "FindByOrder"), "where r.order_id = ? order by r.created_at", orderID)
+}
+
+// Find returns up to limit returns, oldest first so the queue is worked in
+// order, with their items. An empty status returns the open ones.
+func (r *ReturnRepository) Find(ctx context.Context, status ReturnStatus, limit int) ([]Return, error) {
+    db := r.db.op(ctx, "Find")
+
+    if status == "" {
+        return findReturns(db, "where r.status in (?, ?, ?) order by r.created_at limit ?", ReturnRequested, ReturnApproved, ReturnReceived, limit)
+    }
+    return findReturns(db, "where r.status = ? order by r.created_at limit ?", status, limit)
+}
+
+// Returnable returns how many units of each order line can still be
+// returned: what was bought, less what was refunded or is in a return that
+// was not rejected.
+func (r *ReturnRepository) Returnable(ctx context.Context, orderID uuid.UUID) (map[uuid.UUID]int, error) {
+    return returnableQuantities(r.db.op(ctx, "Returnable"), orderID)
+}
+
+func returnableQuantities(q queryer, orderID uuid.UUID) (map[uuid.UUID]int, error) {
+    // Refunded returns are already counted in refunded_quantity.
+    query := `
+            select oi.product_id, oi.quantity - oi.refunded_quantity - coalesce(sum(ri.quantity), 0)
+            from order_items oi
+                left join returns r on r.order_id = oi.order_id and r.status in (?, ?, ?, ?)
+                left join return_items ri on ri.return_id = r.id and ri.product_id = oi.product_id
+            where oi.order_id = ?
+            group by oi.product_id, oi.quantity, oi.refunded_quantity
+            `
+    rows, err := q.Query(query, ReturnRequested, ReturnApproved, ReturnReceived, ReturnExchanged, orderID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    returnable := make(map[uuid.UUID]int)
+    for rows.Next() {
+        var productID uuid.UUID
+        var quantity int
+        if err := rows.Scan(&productID, &quantity); err != nil {
+            return nil, err
+        }
+        returnable[productID] = max(quantity, 0)
+    }
+
+    return returnable, rows.Err()
+}
+
+func getReturn(q queryer, id uuid.UUID) (*Return, error) {
+    returns, err := findReturns(q, "where r.id = ?", id)
+    if err != nil {
+        return nil, err
+    }
+    if len(returns) == 0 {
+        return nil, sql.ErrNoRows
+    }
+    return &returns[0], nil
+}
+
+func findReturns(q queryer, where string, args ...any) ([]Return, error) {
+    var returns []Return
+
+    query := `
+            select r.id, r.rma, r.order_id, o.email, r.status, r.resolution, r.reason, r.note, r.refund_id, r.replacement_order_id, r.created_at, r.updated_at
+            from returns r
+                inner join orders o on o.id = r.order_id
+            ` + where
+    rows, err := q.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var ret Return
+        var rma sql.NullString
+
+        err := rows.Scan(&ret.ID, &rma, &ret.OrderID, &ret.Email, &ret.Status, &ret.Resolution, &ret.Reason, &ret.Note, &ret.RefundID, &ret.ReplacementOrderID, &ret.CreatedAt, &ret.UpdatedAt)
+        if err != nil {
+            return nil, err
+        }
+        ret.RMA = rma.String
+
+        returns = append(returns, ret)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    for i := range returns {
+        returns[i].Items, err = findReturnItems(q, returns[i].ID)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return returns, nil
+}
+
+func findReturnItems(q queryer, returnID uuid.UUID) ([]ReturnItem, error) {
+    var items []ReturnItem
+
+    query := `
+            select ri.product_id, ri.quantity, p.name, p.price, p.image
+            from return_items ri
+                inner join products p on p.id = ri.product_id
+            where ri.return_id = ?
+            `
+    rows, err := q.Query(query, returnID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var item ReturnItem
+        var product Product
+
+        err := rows.Scan(&item.ProductID, &item.Quantity, &product.Name, &product.Price, &product.Image)
+        if err != nil {
+            return nil, err
+        }
+
+        product.ID = item.ProductID
+        item.Product = &product
+        items = append(items, item)
+    }
+
+    return items, rows.Err()
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+    "context"
+    "errors"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "testing"
+)
+
+func TestPlaceReplacementUntrackedStock(t *testing.T) {
+    scripted, db := newScriptedDB(t)
+    tracked := &Product{ID: uuid.New(), Name: "Hat"}
+    untracked := &Product{ID: uuid.New(), Name: "Gift card"}
+
+    scripted.on("insert into orders", affected(1))
+    scripted.on("select stock from products", func(args []any) scriptedResult {
+        if args[0] == untracked.ID.String() {
+            return rows("stock", nil)(args)
+        }
+        return rows("stock", int64(3))(args)
+    })
+    scripted.on("update products set stock = stock - ?", affected(1))
+    scripted.on("insert into order_items", affected(1))
+    scripted.on("update returns set replacement_order_id", affected(1))
+    scripted.on("insert into order_events", affected(1))
+    scripted.on("insert into outbox", affected(1))
+
+    tx, err := newLoggedDB(db, "ReturnRepository").begin(context.Background(), "UpdateStatus")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer tx.Rollback()
+
+    ret := &Return{ID: uuid.New(), OrderID: uuid.New(), RMA: "RMA-1234ABCD", Email: "jane@example.com", Items: []ReturnItem{
+        {ProductID: untracked.ID, Product: untracked, Quantity: 1},
+        {ProductID: tracked.ID, Product: tracked, Quantity: 2},
+    }}
+    if err := placeReplacement(tx, ret, "admin@example.com"); err != nil {
+        t.Fatalf("placeReplacement = %v, want the replacement placed", err)
+    }
+
+    if !ret.ReplacementOrderID.Valid {
+        t.Error("the return is not linked to its replacement")
+    }
+    updates := scripted.ran("update products")
+    if len(updates) != 1 || updates[0].args[1] != tracked.ID.String() {
+        t.Errorf("stock updates = %v, want only the tracked product's", updates)
+    }
+    if n := len(scripted.ran("insert into order_items")); n != 2 {
+        t.Errorf("%d replacement items, want 2", n)
+    }
+}
+
+func TestPlaceReplacementOutOfStock(t *testing.T) {
+    scripted, db := newScriptedDB(t)
+    product := &Product{ID: uuid.New(), Name: "Hat"}
+
+    scripted.on("insert into orders", affected(1))
+    scripted.on("select stock from products", rows("stock", int64(1)))
+    scripted.on("update products set stock = stock - ?", affected(0))
+
+    tx, err := newLoggedDB(db, "ReturnRepository").begin(context.Background(), "UpdateStatus")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer tx.Rollback()
+
+    ret := &Return{ID: uuid.New(), OrderID: uuid.New(), Items: []ReturnItem{{ProductID: product.ID, Product: product, Quantity: 2}}}
+    var outOfStock *OutOfStockError
+    if err := placeReplacement(tx, ret, "admin@example.com"); !errors.As(err, &outOfStock) || outOfStock.Name != "Hat" {
+        t.Errorf("placeReplacement = %v, want Hat out of stock", err)
+    }
+    if len(scripted.ran("insert into order_items")) != 0 || ret.ReplacementOrderID.Valid {
+        t.Error("a replacement was placed for a product that is out of stock")
+    }
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "io"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// scriptedDB is a database/sql driver that answers statements from a
+// script, so that tests can check what a repository method asks of MySQL
+// without a server. Like MySQL without clientFoundRows, an update reports
+// the rows it changed: the script says how many that is.
+type scriptedDB struct {
+    mu         sync.Mutex
+    script     []scriptedStatement
+    executed   []executedStatement
+    committed  int
+    rolledBack int
+}
+
+type scriptedStatement struct {
+    prefix string
+    answer func(args []any) scriptedResult
+}
+
+// scriptedResult is the answer to a statement: rows for a query, the rows
+// affected for anything else.
+type scriptedResult struct {
+    columns  []string
+    rows     [][]driver.Value
+    affected int64
+}
+
+type executedStatement struct {
+    query string
+    args  []any
+}
+
+// newScriptedDB returns the driver and a database that uses it.
+func newScriptedDB(t *testing.T) (*scriptedDB, *sql.DB) {
+    t.Helper()
+
+    scripted := &scriptedDB{}
+    db := sql.OpenDB(scripted)
+    t.Cleanup(func() { db.Close() })
+    return scripted, db
+}
+
+// on answers statements that start with prefix, ignoring differences in
+// white space. The first matching entry answers.
+func (s *scriptedDB) on(prefix string, answer func(args []any) scriptedResult) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.script = append(s.script, scriptedStatement{prefix: normalizeQuery(prefix), answer: answer})
+}
+
+// ran returns the statements run that start with prefix.
+func (s *scriptedDB) ran(prefix string) []executedStatement {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var ran []executedStatement
+    for _, statement := range s.executed {
+        if strings.HasPrefix(statement.query, normalizeQuery(prefix)) {
+            ran = append(ran, statement)
+        }
+    }
+    return ran
+}
+
+func (s *scriptedDB) run(query string, named []driver.NamedValue) (scriptedResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    query = normalizeQuery(query)
+    args := make([]any, len(named))
+    for i, arg := range named {
+        args[i] = arg.Value
+    }
+    s.executed = append(s.executed, executedStatement{query: query, args: args})
+
+    for _, statement := range s.script {
+        if strings.HasPrefix(query, statement.prefix) {
+            return statement.answer(args), nil
+        }
+    }
+    return scriptedResult{}, errors.New("unscripted statement: " + query)
+}
+
+func normalizeQuery(query string) string {
+    return strings.Join(strings.Fields(query), " ")
+}
+
+// rows answers a query with one column and the given values, one per row.
+func rows(column string, values ...driver.Value) func([]any) scriptedResult {
+    return func([]any) scriptedResult {
+        result := scriptedResult{columns: []string{column}}
+        for _, value := range values {
+            result.rows = append(result.rows, []driver.Value{value})
+        }
+        return result
+    }
+}
+
+// affected answers a statement with n rows changed.
+func affected(n int64) func([]any) scriptedResult {
+    return func([]any) scriptedResult {
+        return scriptedResult{affected: n}
+    }
+}
+
+func (s *scriptedDB) Connect(ctx context.Context) (driver.Conn, error) {
+    return &scriptedConn{db: s}, nil
+}
+
+func (s *scriptedDB) Driver() driver.Driver {
+    return nil
+}
+
+type scriptedConn struct {
+    db *scriptedDB
+}
+
+func (c *scriptedConn) Prepare(query string) (driver.Stmt, error) {
+    return nil, errors.New("scriptedDB does not prepare statements")
+}
+
+func (c *scriptedConn) Close() error {
+    return nil
+}
+
+func (c *scriptedConn) Begin() (driver.Tx, error) {
+    return c, nil
+}
+
+func (c *scriptedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+    return c, nil
+}
+
+func (c *scriptedConn) Commit() error {
+    c.db.mu.Lock()
+    defer c.db.mu.Unlock()
+    c.db.committed++
+    return nil
+}
+
+func (c *scriptedConn) Rollback() error {
+    c.db.mu.Lock()
+    defer c.db.mu.Unlock()
+    c.db.rolledBack++
+    return nil
+}
+
+func (c *scriptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+    result, err := c.db.run(query, args)
+    if err != nil {
+        return nil, err
+    }
+    return driver.RowsAffected(result.affected), nil
+}
+
+func (c *scriptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+    result, err := c.db.run(query, args)
+    if err != nil {
+        return nil, err
+    }
+    return &scriptedRows{result: result}, nil
+}
+
+type scriptedRows struct {
+    result scriptedResult
+    next   int
+}
+
+func (r *scriptedRows) Columns() []string {
+    return r.result.columns
+}
+
+func (r *scriptedRows) Close() error {
+    return nil
+}
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+    if r.next >= len(r.result.rows) {
+        return io.EOF
+    }
+    copy(dest, r.result.rows[r.next])
+    r.next++
+    return nil
+}
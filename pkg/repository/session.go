@@ -0,0 +1,54 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+type SessionRepository struct {
+    db *loggedDB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+    return &SessionRepository{
+        db: newLoggedDB(db, "SessionRepository"),
+    }
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *Session) error {
+    query := "insert into sessions(id, user_id, csrf_token, expires_at) values (?, ?, ?, ?)"
+    _, err := r.db.op(ctx, "Create").Exec(query, session.ID, session.UserID, session.CSRFToken, session.ExpiresAt)
+    return err
+}
+
+// GetById returns the session with the given id, or sql.ErrNoRows when it
+// does not exist or has already expired.
+func (r *SessionRepository) GetById(ctx context.Context, id string) (*Session, error) {
+    query := "select id, user_id, csrf_token, expires_at from sessions where id = ? and expires_at > ?"
+    row := r.db.op(ctx, "GetById").QueryRow(query, id, time.Now())
+
+    session := &Session{}
+    err := row.Scan(&session.ID, &session.UserID, &session.CSRFToken, &session.ExpiresAt)
+    if err != nil {
+        return nil, err
+    }
+
+    return session, nil
+}
+
+func (r *SessionRepository) Delete(ctx context.Context, id string) error {
+    _, err := r.db.op(ctx, "Delete").Exec("delete from sessions where id = ?", id)
+    return err
+}
+
+// DeleteExpired deletes the sessions that have expired and returns how many
+// there were.
+func (r *SessionRepository) DeleteExpired(ctx context.Context) (int64, error) {
+    result, err := r.db.op(ctx, "DeleteExpired").Exec("delete from sessions where expires_at <= ?", time.Now())
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
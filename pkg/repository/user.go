@@ -0,0 +1,139 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "github.com/go-sql-driver/mysql"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "time"
+)
+
+// mysqlDuplicateEntry is the MySQL error number for a violated unique key.
+const mysqlDuplicateEntry = 1062
+
+type UserRepository struct {
+    db *loggedDB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+    return &UserRepository{
+        db: newLoggedDB(db, "UserRepository"),
+    }
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *User) (*User, error) {
+    tx, err := r.db.begin(ctx, "Create")
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    if err := insertUser(tx, user); err != nil {
+        return nil, err
+    }
+
+    return user, tx.Commit()
+}
+
+// Register creates a customer account and writes a user.registered message
+// to the outbox in the same transaction.
+func (r *UserRepository) Register(ctx context.Context, user *User) (*User, error) {
+    tx, err := r.db.begin(ctx, "Register")
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    user.Role = Customer
+    if err := insertUser(tx, user); err != nil {
+        return nil, err
+    }
+
+    err = insertOutbox(tx, TopicUserRegistered, TopicUserRegistered+":"+user.ID.String(), user)
+    if err != nil {
+        return nil, err
+    }
+
+    return user, tx.Commit()
+}
+
+func (r *UserRepository) GetById(ctx context.Context, id uuid.UUID) (*User, error) {
+    query := "select id, email, name, password_hash, role, created_date from users where id = ?"
+    return scanUser(r.db.op(ctx, "GetById").QueryRow(query, id))
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+    query := "select id, email, name, password_hash, role, created_date from users where email = ?"
+    return scanUser(r.db.op(ctx, "GetByEmail").QueryRow(query, email))
+}
+
+// FindStaff returns every user that holds one of the admin roles.
+func (r *UserRepository) FindStaff(ctx context.Context) ([]User, error) {
+    var users []User
+
+    query := "select id, email, name, password_hash, role, created_date from users where role <> ? order by created_date"
+    rows, err := r.db.op(ctx, "FindStaff").Query(query, Customer)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var user User
+
+        err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.Role, &user.CreatedDate)
+        if err != nil {
+            return nil, err
+        }
+
+        users = append(users, user)
+    }
+
+    return users, nil
+}
+
+func (r *UserRepository) UpdateRole(ctx context.Context, id uuid.UUID, role Role) error {
+    _, err := r.db.op(ctx, "UpdateRole").Exec("update users set role = ? where id = ?", role, id)
+    return err
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+    user := &User{}
+    err := row.Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.Role, &user.CreatedDate)
+    if err != nil {
+        return nil, err
+    }
+
+    return user, nil
+}
+
+func insertUser(tx *loggedTx, user *User) error {
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    user.ID = id
+    user.CreatedDate = time.Now()
+    if user.Role == "" {
+        user.Role = Customer
+    }
+
+    query := "insert into users(id, email, name, password_hash, role, created_date) values (?, ?, ?, ?, ?, ?)"
+    result, err := tx.Exec(query, user.ID, user.Email, user.Name, user.PasswordHash, user.Role, user.CreatedDate)
+    var mysqlErr *mysql.MySQLError
+    if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+        return ErrEmailTaken
+    }
+    if err != nil {
+        return err
+    }
+
+    if rowAffected, err := result.RowsAffected(); err != nil || rowAffected == 0 {
+        return sql.ErrNoRows
+    }
+
+    return nil
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "strings"
+    "time"
+)
+
+type WebhookRepository struct {
+    db *loggedDB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+    return &WebhookRepository{
+        db: newLoggedDB(db, "WebhookRepository"),
+    }
+}
+
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, subscription *WebhookSubscription) (*WebhookSubscription, error) {
+    id, err := uuid.NewV7()
+    if err != nil {
+        return nil, err
+    }
+
+    subscription.ID = id
+
+    query := "insert into webhook_subscriptions(id, url, events, secret, active) values (?, ?, ?, ?, ?)"
+    _, err = r.db.op(ctx, "CreateSubscription").Exec(query, subscription.ID, subscription.URL, strings.Join(subscription.Events, ","), subscription.Secret, subscription.Active)
+    if err != nil {
+        return nil, err
+    }
+
+    return subscription, nil
+}
+
+func (r *WebhookRepository) GetSubscription(ctx context.Context, id uuid.UUID) (*WebhookSubscription, error) {
+    query := "select id, url, events, secret, active, created_date from webhook_subscriptions where id = ?"
+    row := r.db.op(ctx, "GetSubscription").QueryRow(query, id)
+
+    subscription := &WebhookSubscription{}
+    var events string
+    err := row.Scan(&subscription.ID, &subscription.URL, &events, &subscription.Secret, &subscription.Active, &subscription.CreatedDate)
+    if err != nil {
+        return nil, err
+    }
+    subscription.Events = splitEvents(events)
+
+    return subscription, nil
+}
+
+// FindSubscriptions returns all subscriptions, or only the active ones when
+// activeOnly is set.
+func (r *WebhookRepository) FindSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error) {
+    var subscriptions []WebhookSubscription
+
+    query := "select id, url, events, secret, active, created_date from webhook_subscriptions"
+    if activeOnly {
+        query += " where active"
+    }
+    query += " order by created_date"
+
+    rows, err := r.db.op(ctx, "FindSubscriptions").Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var subscription WebhookSubscription
+        var events string
+
+        err := rows.Scan(&subscription.ID, &subscription.URL, &events, &subscription.Secret, &subscription.Active, &subscription.CreatedDate)
+        if err != nil {
+            return nil, err
+        }
+        subscription.Events = splitEvents(events)
+
+        subscriptions = append(subscriptions, subscription)
+    }
+
+    return subscriptions, nil
+}
+
+func (r *WebhookRepository) SetSubscriptionActive(ctx context.Context, id uuid.UUID, active bool) error {
+    _, err := r.db.op(ctx, "SetSubscriptionActive").Exec("update webhook_subscriptions set active = ? where id = ?", active, id)
+    return err
+}
+
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+    _, err := r.db.op(ctx, "DeleteSubscription").Exec("delete from webhook_subscriptions where id = ?", id)
+    return err
+}
+
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+    id, err := uuid.NewV7()
+    if err != nil {
+        return err
+    }
+
+    delivery.ID = id
+    delivery.Status = DeliveryPending
+
+    query := `
+            insert into webhook_deliveries(id, subscription_id, event_id, event_type, payload, status, next_attempt_at, last_error)
+            values (?, ?, ?, ?, ?, ?, ?, '')
+            `
+    _, err = r.db.op(ctx, "CreateDelivery").Exec(query, delivery.ID, delivery.SubscriptionID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.Status, delivery.NextAttemptAt)
+    return err
+}
+
+// DeliveryExists reports whether eventID was already queued for the
+// subscription.
+func (r *WebhookRepository) DeliveryExists(ctx context.Context, subscriptionID, eventID uuid.UUID) (bool, error) {
+    var exists bool
+    query := "select exists(select 1 from webhook_deliveries where subscription_id = ? and event_id = ?)"
+    err := r.db.op(ctx, "DeliveryExists").QueryRow(query, subscriptionID, eventID).Scan(&exists)
+    return exists, err
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+    query := "select " + deliveryColumns + " from webhook_deliveries where id = ?"
+    row := r.db.op(ctx, "GetDelivery").QueryRow(query, id)
+
+    delivery := &WebhookDelivery{}
+    err := row.Scan(deliveryFields(delivery)...)
+    if err != nil {
+        return nil, err
+    }
+
+    return delivery, nil
+}
+
+// FindDueDeliveries returns pending deliveries whose next attempt is due.
+func (r *WebhookRepository) FindDueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+    query := "select " + deliveryColumns + " from webhook_deliveries where status = ? and next_attempt_at <= ? order by next_attempt_at limit ?"
+    return findDeliveries(r.db.op(ctx, "FindDueDeliveries"), query, DeliveryPending, now, limit)
+}
+
+// FindDeliveries returns the most recent deliveries of a subscription.
+func (r *WebhookRepository) FindDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+    query := "select " + deliveryColumns + " from webhook_deliveries where subscription_id = ? order by created_at desc limit ?"
+    return findDeliveries(r.db.op(ctx, "FindDeliveries"), query, subscriptionID, limit)
+}
+
+// ClaimDelivery pushes the next attempt of a due delivery to until so other
+// workers leave it alone while it is being sent. It reports whether this
+// caller won the claim.
+func (r *WebhookRepository) ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error) {
+    query := "update webhook_deliveries set next_attempt_at = ? where id = ? and status = ? and next_attempt_at = ?"
+    result, err := r.db.op(ctx, "ClaimDelivery").Exec(query, until, delivery.ID, DeliveryPending, delivery.NextAttemptAt)
+    if err != nil {
+        return false, err
+    }
+
+    rowAffected, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return rowAffected == 1, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+    query := `
+            update webhook_deliveries
+            set status = ?, attempts = ?, next_attempt_at = ?, response_status = ?, last_error = ?, delivered_at = ?
+            where id = ?
+            `
+    _, err := r.db.op(ctx, "RecordAttempt").Exec(query, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.ResponseStatus, delivery.LastError, delivery.DeliveredAt, delivery.ID)
+    return err
+}
+
+func findDeliveries(db *loggedOp, query string, args ...any) ([]WebhookDelivery, error) {
+    var deliveries []WebhookDelivery
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var delivery WebhookDelivery
+
+        err := rows.Scan(deliveryFields(&delivery)...)
+        if err != nil {
+            return nil, err
+        }
+
+        deliveries = append(deliveries, delivery)
+    }
+
+    return deliveries, nil
+}
+
+const deliveryColumns = "id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, response_status, last_error, created_at, delivered_at"
+
+func deliveryFields(d *WebhookDelivery) []any {
+    return []any{&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt}
+}
+
+func splitEvents(events string) []string {
+    if events == "" {
+        return nil
+    }
+    return strings.Split(events, ",")
+}
@@ -0,0 +1,62 @@
+// Package signature signs webhook bodies and verifies them, in the header
+// format the shop's outbound webhooks and its payment provider's share:
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "t.body">".
+package signature
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Tolerance bounds how old a signed body may be, which limits replays of
+// captured requests.
+const Tolerance = 5 * time.Minute
+
+var ErrInvalid = errors.New("invalid webhook signature")
+
+// Header returns the signature header value for body signed at timestamp.
+func Header(secret string, timestamp time.Time, body []byte) string {
+    t := strconv.FormatInt(timestamp.Unix(), 10)
+    return "t=" + t + ",v1=" + compute(secret, t, body)
+}
+
+// Verify checks the signature header of body against secret, and that it
+// was signed within Tolerance of now.
+func Verify(secret, header string, body []byte, now time.Time) error {
+    var timestamp, signed string
+    for _, part := range strings.Split(header, ",") {
+        key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+        switch key {
+        case "t":
+            timestamp = value
+        case "v1":
+            signed = value
+        }
+    }
+
+    unix, err := strconv.ParseInt(timestamp, 10, 64)
+    if err != nil || signed == "" || secret == "" {
+        return ErrInvalid
+    }
+    if !hmac.Equal([]byte(signed), []byte(compute(secret, timestamp, body))) {
+        return ErrInvalid
+    }
+    if age := now.Sub(time.Unix(unix, 0)); age > Tolerance || age < -Tolerance {
+        return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalid)
+    }
+    return nil
+}
+
+func compute(secret, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp))
+    mac.Write([]byte("."))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,35 @@
+package tracing
+
+import (
+    "github.com/gorilla/mux"
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+    "net/http"
+)
+
+// Middleware starts a server span for every request, continuing the trace
+// of a caller that sent a traceparent header. It wraps the whole router;
+// Route renames the span once a route matched.
+func Middleware(next http.Handler) http.Handler {
+    return otelhttp.NewHandler(next, "http.server",
+        otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+            return r.Method
+        }),
+    )
+}
+
+// Route names the request's span after the template of the matched route,
+// such as GET /product/{id}. It is router middleware.
+func Route(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if route := mux.CurrentRoute(r); route != nil {
+            if template, err := route.GetPathTemplate(); err == nil {
+                span := trace.SpanFromContext(r.Context())
+                span.SetName(r.Method + " " + template)
+                span.SetAttributes(semconv.HTTPRoute(template))
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,115 @@
+// Package tracing sets up OpenTelemetry tracing: a span for each request
+// named after its route, spans for template executions and SQL statements
+// made with Tracer, and W3C trace context propagation so that traces carry
+// on across services.
+package tracing
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+    "io"
+    "os"
+)
+
+const instrumentationName = "github.com/kimhien2301/go-htmx-shopping-app"
+
+// Exporters.
+const (
+    // None records no spans, but trace context is still passed on.
+    None = "none"
+    // OTLP sends spans to a collector over OTLP/HTTP, configured with the
+    // standard OTEL_EXPORTER_OTLP_* variables.
+    OTLP = "otlp"
+    // Stdout writes spans to standard output as JSON.
+    Stdout = "stdout"
+    // File writes spans as JSON to Config.File, for local runs without a
+    // collector.
+    File = "file"
+)
+
+type Config struct {
+    ServiceName string
+    // Exporter is one of None, OTLP, Stdout or File.
+    Exporter string
+    File     string
+}
+
+// Tracer returns the tracer for the shop's own spans.
+func Tracer() trace.Tracer {
+    return otel.Tracer(instrumentationName)
+}
+
+// Setup installs the tracer provider and the W3C trace context and baggage
+// propagators. The returned function flushes the spans not yet exported;
+// call it on shutdown. The sampler can be chosen with OTEL_TRACES_SAMPLER.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+    var exporter sdktrace.SpanExporter
+    var closer io.Closer
+    var err error
+    switch config.Exporter {
+    case "", None:
+        return func(context.Context) error { return nil }, nil
+    case OTLP:
+        exporter, err = otlptracehttp.New(ctx)
+    case Stdout:
+        exporter, err = stdouttrace.New()
+    case File:
+        var file *os.File
+        file, err = os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+        if err != nil {
+            return nil, err
+        }
+        closer = file
+        exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+    default:
+        return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    // OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+    res, err := resource.New(ctx,
+        resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+        resource.WithFromEnv(),
+        resource.WithTelemetrySDK(),
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(res),
+    )
+    otel.SetTracerProvider(provider)
+
+    return func(ctx context.Context) error {
+        err := provider.Shutdown(ctx)
+        if closer != nil {
+            err = errors.Join(err, closer.Close())
+        }
+        return err
+    }, nil
+}
+
+// End ends span, marking it as failed if err is not nil.
+func End(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
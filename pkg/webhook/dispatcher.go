@@ -0,0 +1,264 @@
+package webhook
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/repository"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/signature"
+    "io"
+    "log/slog"
+    "net/http"
+    "slices"
+    "time"
+)
+
+const (
+    SignatureHeader = "X-Webhook-Signature"
+    EventHeader     = "X-Webhook-Event"
+    IDHeader        = "X-Webhook-Id"
+
+    maxAttempts  = 10
+    baseBackoff  = 30 * time.Second
+    maxBackoff   = 6 * time.Hour
+    claimTimeout = time.Minute
+    batchSize    = 20
+)
+
+// Event is the JSON document POSTed to subscribers.
+type Event struct {
+    ID        uuid.UUID `json:"id"`
+    Type      string    `json:"type"`
+    CreatedAt time.Time `json:"created_at"`
+    Data      any       `json:"data"`
+}
+
+// store is what the dispatcher needs of *repository.WebhookRepository.
+type store interface {
+    GetSubscription(ctx context.Context, id uuid.UUID) (*WebhookSubscription, error)
+    FindSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error)
+    CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+    DeliveryExists(ctx context.Context, subscriptionID, eventID uuid.UUID) (bool, error)
+    GetDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error)
+    FindDueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+    ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error)
+    RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error
+}
+
+// Dispatcher fans events out to webhook subscriptions and delivers them.
+// Events arrive from the outbox relay through HandleOutbox, which only queues
+// deliveries in the database; Run sends them and retries failures with
+// exponential backoff, so nothing is lost across restarts.
+type Dispatcher struct {
+    repo         store
+    client       *http.Client
+    pollInterval time.Duration
+    // Heartbeat, if set, is called on every poll and every delivery, so
+    // that a health check can tell the dispatcher is still making progress.
+    Heartbeat    func()
+}
+
+func NewDispatcher(repo *repository.WebhookRepository) *Dispatcher {
+    return &Dispatcher{
+        repo:         repo,
+        client:       &http.Client{Timeout: 10 * time.Second},
+        pollInterval: 5 * time.Second,
+    }
+}
+
+// HandleOutbox publishes outbox messages whose topic is a webhook event. The
+// event takes the message ID, so relaying a message again neither queues a
+// second delivery nor changes the ID receivers deduplicate on.
+func (d *Dispatcher) HandleOutbox(ctx context.Context, message OutboxMessage) error {
+    if !slices.Contains(WebhookEvents, message.Topic) {
+        return nil
+    }
+
+    return d.PublishEvent(ctx, Event{
+        ID:        message.ID,
+        Type:      message.Topic,
+        CreatedAt: message.CreatedAt.UTC(),
+        Data:      json.RawMessage(message.Payload),
+    })
+}
+
+// PublishEvent queues an already built event, keeping its ID so receivers
+// can deduplicate. Subscriptions that already have a delivery of the event
+// are skipped.
+func (d *Dispatcher) PublishEvent(ctx context.Context, event Event) error {
+    subscriptions, err := d.repo.FindSubscriptions(ctx, true)
+    if err != nil {
+        return err
+    }
+
+    payload, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    for _, subscription := range subscriptions {
+        if !subscription.Wants(event.Type) {
+            continue
+        }
+
+        exists, err := d.repo.DeliveryExists(ctx, subscription.ID, event.ID)
+        if err != nil {
+            return err
+        }
+        if exists {
+            continue
+        }
+
+        err = d.repo.CreateDelivery(ctx, &WebhookDelivery{
+            SubscriptionID: subscription.ID,
+            EventID:        event.ID,
+            EventType:      event.Type,
+            Payload:        string(payload),
+            NextAttemptAt:  time.Now(),
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Replay queues a fresh delivery of the same event to the same subscription.
+func (d *Dispatcher) Replay(ctx context.Context, deliveryID uuid.UUID) error {
+    delivery, err := d.repo.GetDelivery(ctx, deliveryID)
+    if err != nil {
+        return err
+    }
+
+    return d.repo.CreateDelivery(ctx, &WebhookDelivery{
+        SubscriptionID: delivery.SubscriptionID,
+        EventID:        delivery.EventID,
+        EventType:      delivery.EventType,
+        Payload:        delivery.Payload,
+        NextAttemptAt:  time.Now(),
+    })
+}
+
+// Run delivers due webhooks until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+    ticker := time.NewTicker(d.pollInterval)
+    defer ticker.Stop()
+
+    for {
+        d.beat()
+        d.deliverDue(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (d *Dispatcher) beat() {
+    if d.Heartbeat != nil {
+        d.Heartbeat()
+    }
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+    deliveries, err := d.repo.FindDueDeliveries(ctx, time.Now(), batchSize)
+    if err != nil {
+        slog.Error("webhook: find due deliveries", "error", err)
+        return
+    }
+
+    for _, delivery := range deliveries {
+        if ctx.Err() != nil {
+            return
+        }
+        d.beat()
+
+        claimed, err := d.repo.ClaimDelivery(ctx, &delivery, time.Now().Add(claimTimeout))
+        if err != nil {
+            slog.Error("webhook: claim delivery", "delivery_id", delivery.ID, "error", err)
+            continue
+        }
+        if !claimed {
+            continue
+        }
+
+        d.deliver(ctx, &delivery)
+
+        // The outcome is recorded even if shutdown began meanwhile.
+        if err := d.repo.RecordAttempt(context.WithoutCancel(ctx), &delivery); err != nil {
+            slog.Error("webhook: record attempt", "delivery_id", delivery.ID, "error", err)
+        }
+    }
+}
+
+// deliver sends one delivery and updates it with the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *WebhookDelivery) {
+    delivery.Attempts++
+
+    status, err := d.send(ctx, delivery)
+    delivery.ResponseStatus = status
+
+    if err == nil {
+        delivery.Status = DeliverySucceeded
+        delivery.LastError = ""
+        delivery.DeliveredAt = sql.NullTime{Time: time.Now(), Valid: true}
+        return
+    }
+
+    delivery.LastError = err.Error()
+    if delivery.Attempts >= maxAttempts {
+        delivery.Status = DeliveryFailed
+        return
+    }
+    delivery.NextAttemptAt = time.Now().Add(backoff(delivery.Attempts))
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *WebhookDelivery) (int, error) {
+    subscription, err := d.repo.GetSubscription(ctx, delivery.SubscriptionID)
+    if err != nil {
+        return 0, err
+    }
+    if !subscription.Active {
+        return 0, fmt.Errorf("subscription is disabled")
+    }
+
+    body := []byte(delivery.Payload)
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(IDHeader, delivery.EventID.String())
+    req.Header.Set(EventHeader, delivery.EventType)
+    req.Header.Set(SignatureHeader, signature.Header(subscription.Secret, time.Now(), body))
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+    }
+
+    return resp.StatusCode, nil
+}
+
+// backoff doubles the wait after every failed attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+    wait := baseBackoff << (attempts - 1)
+    if wait <= 0 || wait > maxBackoff {
+        return maxBackoff
+    }
+    return wait
+}
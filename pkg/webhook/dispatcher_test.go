@@ -0,0 +1,423 @@
+package webhook
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "github.com/google/uuid"
+    . "github.com/kimhien2301/go-htmx-shopping-app/pkg/models"
+    "github.com/kimhien2301/go-htmx-shopping-app/pkg/signature"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+const testSecret = "whsec_test"
+
+// memStore keeps subscriptions and deliveries in memory, the way
+// *repository.WebhookRepository keeps them in the database.
+type memStore struct {
+    mu            sync.Mutex
+    subscriptions []WebhookSubscription
+    deliveries    []*WebhookDelivery
+}
+
+func (s *memStore) GetSubscription(ctx context.Context, id uuid.UUID) (*WebhookSubscription, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, subscription := range s.subscriptions {
+        if subscription.ID == id {
+            return &subscription, nil
+        }
+    }
+    return nil, sql.ErrNoRows
+}
+
+func (s *memStore) FindSubscriptions(ctx context.Context, activeOnly bool) ([]WebhookSubscription, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var subscriptions []WebhookSubscription
+    for _, subscription := range s.subscriptions {
+        if subscription.Active || !activeOnly {
+            subscriptions = append(subscriptions, subscription)
+        }
+    }
+    return subscriptions, nil
+}
+
+func (s *memStore) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delivery.ID = uuid.New()
+    delivery.Status = DeliveryPending
+    delivery.CreatedAt = time.Now()
+    stored := *delivery
+    s.deliveries = append(s.deliveries, &stored)
+    return nil
+}
+
+func (s *memStore) DeliveryExists(ctx context.Context, subscriptionID, eventID uuid.UUID) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, delivery := range s.deliveries {
+        if delivery.SubscriptionID == subscriptionID && delivery.EventID == eventID {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func (s *memStore) GetDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, delivery := range s.deliveries {
+        if delivery.ID == id {
+            found := *delivery
+            return &found, nil
+        }
+    }
+    return nil, sql.ErrNoRows
+}
+
+func (s *memStore) FindDueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var due []WebhookDelivery
+    for _, delivery := range s.deliveries {
+        if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) && len(due) < limit {
+            due = append(due, *delivery)
+        }
+    }
+    return due, nil
+}
+
+func (s *memStore) ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, stored := range s.deliveries {
+        if stored.ID == delivery.ID && stored.Status == DeliveryPending && stored.NextAttemptAt.Equal(delivery.NextAttemptAt) {
+            stored.NextAttemptAt = until
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func (s *memStore) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i, stored := range s.deliveries {
+        if stored.ID == delivery.ID {
+            recorded := *delivery
+            s.deliveries[i] = &recorded
+            return nil
+        }
+    }
+    return sql.ErrNoRows
+}
+
+func (s *memStore) delivery(t *testing.T, i int) WebhookDelivery {
+    t.Helper()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if i >= len(s.deliveries) {
+        t.Fatalf("got %d deliveries, want at least %d", len(s.deliveries), i+1)
+    }
+    return *s.deliveries[i]
+}
+
+// received is a request as the subscriber's endpoint saw it.
+type received struct {
+    header http.Header
+    body   []byte
+}
+
+// receiver is a subscriber's endpoint. It answers each request with the
+// next of statuses, and 200 once they run out.
+type receiver struct {
+    *httptest.Server
+    mu       sync.Mutex
+    statuses []int
+    requests []received
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+    rec := &receiver{statuses: statuses}
+    rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+
+        rec.mu.Lock()
+        rec.requests = append(rec.requests, received{header: r.Header.Clone(), body: body})
+        status := http.StatusOK
+        if len(rec.statuses) > 0 {
+            status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+        }
+        rec.mu.Unlock()
+
+        w.WriteHeader(status)
+    }))
+    t.Cleanup(rec.Close)
+    return rec
+}
+
+func (rec *receiver) received() []received {
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    return append([]received(nil), rec.requests...)
+}
+
+func newTestDispatcher(subscriptions ...WebhookSubscription) (*Dispatcher, *memStore) {
+    repo := &memStore{subscriptions: subscriptions}
+    return &Dispatcher{repo: repo, client: http.DefaultClient, pollInterval: time.Millisecond}, repo
+}
+
+func subscriptionFor(url string, events ...string) WebhookSubscription {
+    return WebhookSubscription{ID: uuid.New(), URL: url, Events: events, Secret: testSecret, Active: true}
+}
+
+func outboxMessage(topic, payload string) OutboxMessage {
+    return OutboxMessage{ID: uuid.New(), Topic: topic, Payload: payload, CreatedAt: time.Now()}
+}
+
+func TestDispatchSignsAndDelivers(t *testing.T) {
+    rec := newReceiver(t)
+    dispatcher, repo := newTestDispatcher(subscriptionFor(rec.URL))
+    ctx := context.Background()
+
+    message := outboxMessage(EventOrderPlaced, `{"id":"42"}`)
+    if err := dispatcher.HandleOutbox(ctx, message); err != nil {
+        t.Fatal(err)
+    }
+    dispatcher.deliverDue(ctx)
+
+    requests := rec.received()
+    if len(requests) != 1 {
+        t.Fatalf("receiver got %d requests, want 1", len(requests))
+    }
+    req := requests[0]
+
+    if err := signature.Verify(testSecret, req.header.Get(SignatureHeader), req.body, time.Now()); err != nil {
+        t.Errorf("signature does not verify: %v", err)
+    }
+    if err := signature.Verify("another secret", req.header.Get(SignatureHeader), req.body, time.Now()); err == nil {
+        t.Error("signature verifies with the wrong secret")
+    }
+    if got := req.header.Get(IDHeader); got != message.ID.String() {
+        t.Errorf("%s = %q, want the outbox message ID %q", IDHeader, got, message.ID)
+    }
+    if got := req.header.Get(EventHeader); got != EventOrderPlaced {
+        t.Errorf("%s = %q, want %q", EventHeader, got, EventOrderPlaced)
+    }
+
+    var event struct {
+        ID   uuid.UUID       `json:"id"`
+        Type string          `json:"type"`
+        Data json.RawMessage `json:"data"`
+    }
+    if err := json.Unmarshal(req.body, &event); err != nil {
+        t.Fatal(err)
+    }
+    if event.ID != message.ID || event.Type != EventOrderPlaced || string(event.Data) != message.Payload {
+        t.Errorf("body = %s, want the event of the outbox message", req.body)
+    }
+
+    delivery := repo.delivery(t, 0)
+    if delivery.Status != DeliverySucceeded || delivery.Attempts != 1 || !delivery.DeliveredAt.Valid {
+        t.Errorf("delivery = %+v, want succeeded after 1 attempt", delivery)
+    }
+}
+
+func TestDispatchQueuesOncePerWantingSubscription(t *testing.T) {
+    rec := newReceiver(t)
+    all := subscriptionFor(rec.URL)
+    placed := subscriptionFor(rec.URL, EventOrderPlaced)
+    paid := subscriptionFor(rec.URL, EventOrderPaid)
+    disabled := subscriptionFor(rec.URL)
+    disabled.Active = false
+    dispatcher, repo := newTestDispatcher(all, placed, paid, disabled)
+    ctx := context.Background()
+
+    message := outboxMessage(EventOrderPlaced, `{}`)
+    // The relay hands a message over again when it is retried.
+    for range 2 {
+        if err := dispatcher.HandleOutbox(ctx, message); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := dispatcher.HandleOutbox(ctx, outboxMessage(TopicUserRegistered, `{}`)); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(repo.deliveries) != 2 {
+        t.Fatalf("queued %d deliveries, want 2", len(repo.deliveries))
+    }
+    for _, delivery := range repo.deliveries {
+        if delivery.SubscriptionID != all.ID && delivery.SubscriptionID != placed.ID {
+            t.Errorf("queued a delivery for subscription %s, which does not want it", delivery.SubscriptionID)
+        }
+    }
+}
+
+func TestDispatchRetriesWithBackoff(t *testing.T) {
+    rec := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+    dispatcher, repo := newTestDispatcher(subscriptionFor(rec.URL))
+    ctx := context.Background()
+
+    if err := dispatcher.HandleOutbox(ctx, outboxMessage(EventOrderPaid, `{}`)); err != nil {
+        t.Fatal(err)
+    }
+
+    for attempt := 1; attempt <= 2; attempt++ {
+        before := time.Now()
+        dispatcher.deliverDue(ctx)
+
+        delivery := repo.delivery(t, 0)
+        if delivery.Status != DeliveryPending || delivery.Attempts != attempt || delivery.LastError == "" {
+            t.Fatalf("after attempt %d: delivery = %+v, want pending with an error", attempt, delivery)
+        }
+        if delivery.NextAttemptAt.Before(before.Add(backoff(attempt))) {
+            t.Errorf("after attempt %d: next attempt at %v, want at least %v later", attempt, delivery.NextAttemptAt, backoff(attempt))
+        }
+
+        // Not due yet, so polling again sends nothing.
+        dispatcher.deliverDue(ctx)
+        if got := len(rec.received()); got != attempt {
+            t.Fatalf("receiver got %d requests before the retry was due, want %d", got, attempt)
+        }
+
+        repo.deliveries[0].NextAttemptAt = time.Now()
+    }
+
+    dispatcher.deliverDue(ctx)
+
+    delivery := repo.delivery(t, 0)
+    if delivery.Status != DeliverySucceeded || delivery.Attempts != 3 || delivery.LastError != "" || delivery.ResponseStatus != http.StatusOK {
+        t.Errorf("delivery = %+v, want succeeded on the third attempt", delivery)
+    }
+
+    requests := rec.received()
+    if len(requests) != 3 {
+        t.Fatalf("receiver got %d requests, want 3", len(requests))
+    }
+    for _, req := range requests[1:] {
+        if req.header.Get(IDHeader) != requests[0].header.Get(IDHeader) {
+            t.Error("a retry changed the event ID receivers deduplicate on")
+        }
+    }
+}
+
+func TestDispatchGivesUpAfterMaxAttempts(t *testing.T) {
+    rec := newReceiver(t, http.StatusGone)
+    dispatcher, repo := newTestDispatcher(subscriptionFor(rec.URL))
+    ctx := context.Background()
+
+    if err := dispatcher.HandleOutbox(ctx, outboxMessage(EventOrderPaid, `{}`)); err != nil {
+        t.Fatal(err)
+    }
+    repo.deliveries[0].Attempts = maxAttempts - 1
+
+    dispatcher.deliverDue(ctx)
+
+    delivery := repo.delivery(t, 0)
+    if delivery.Status != DeliveryFailed || delivery.ResponseStatus != http.StatusGone {
+        t.Errorf("delivery = %+v, want failed with the last response status", delivery)
+    }
+}
+
+func TestDispatchSkipsDisabledSubscription(t *testing.T) {
+    rec := newReceiver(t)
+    subscription := subscriptionFor(rec.URL)
+    dispatcher, repo := newTestDispatcher(subscription)
+    ctx := context.Background()
+
+    if err := dispatcher.HandleOutbox(ctx, outboxMessage(EventOrderPaid, `{}`)); err != nil {
+        t.Fatal(err)
+    }
+    repo.subscriptions[0].Active = false
+
+    dispatcher.deliverDue(ctx)
+
+    if got := len(rec.received()); got != 0 {
+        t.Errorf("receiver got %d requests for a disabled subscription", got)
+    }
+    if delivery := repo.delivery(t, 0); delivery.Status != DeliveryPending || delivery.LastError == "" {
+        t.Errorf("delivery = %+v, want pending with an error", delivery)
+    }
+}
+
+func TestReplay(t *testing.T) {
+    rec := newReceiver(t)
+    dispatcher, repo := newTestDispatcher(subscriptionFor(rec.URL))
+    ctx := context.Background()
+
+    message := outboxMessage(EventOrderRefunded, `{"amount":100}`)
+    if err := dispatcher.HandleOutbox(ctx, message); err != nil {
+        t.Fatal(err)
+    }
+    dispatcher.deliverDue(ctx)
+
+    original := repo.delivery(t, 0)
+    if err := dispatcher.Replay(ctx, original.ID); err != nil {
+        t.Fatal(err)
+    }
+    dispatcher.deliverDue(ctx)
+
+    replayed := repo.delivery(t, 1)
+    if replayed.ID == original.ID || replayed.EventID != original.EventID || replayed.Status != DeliverySucceeded {
+        t.Errorf("replayed delivery = %+v, want a new succeeded delivery of event %s", replayed, original.EventID)
+    }
+    if got := repo.delivery(t, 0); got.Attempts != 1 {
+        t.Errorf("original delivery has %d attempts after the replay, want 1", got.Attempts)
+    }
+
+    requests := rec.received()
+    if len(requests) != 2 {
+        t.Fatalf("receiver got %d requests, want 2", len(requests))
+    }
+    if string(requests[1].body) != string(requests[0].body) || requests[1].header.Get(IDHeader) != message.ID.String() {
+        t.Error("the replay did not resend the same event")
+    }
+    if err := signature.Verify(testSecret, requests[1].header.Get(SignatureHeader), requests[1].body, time.Now()); err != nil {
+        t.Errorf("replayed signature does not verify: %v", err)
+    }
+}
+
+func TestReplayUnknownDelivery(t *testing.T) {
+    dispatcher, _ := newTestDispatcher()
+
+    if err := dispatcher.Replay(context.Background(), uuid.New()); err != sql.ErrNoRows {
+        t.Errorf("Replay of an unknown delivery = %v, want sql.ErrNoRows", err)
+    }
+}
+
+func TestBackoff(t *testing.T) {
+    tests := []struct {
+        attempts int
+        want     time.Duration
+    }{
+        {1, baseBackoff},
+        {2, 2 * baseBackoff},
+        {5, 16 * baseBackoff},
+        {10, 512 * baseBackoff},
+        {11, maxBackoff},
+        {100, maxBackoff},
+    }
+
+    for _, tt := range tests {
+        if got := backoff(tt.attempts); got != tt.want {
+            t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+        }
+    }
+}
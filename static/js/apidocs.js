@@ -0,0 +1,249 @@
+// Renders the API reference at /api/docs from the OpenAPI document, without
+// any third-party viewer: each operation by tag, then the schemas they use.
+
+(function () {
+    const main = document.getElementById('apiDocs');
+    const nav = document.getElementById('apiNav');
+    const methods = ['get', 'post', 'put', 'patch', 'delete'];
+
+    function el(tag, attrs, ...children) {
+        const node = document.createElement(tag);
+        for (const [name, value] of Object.entries(attrs || {})) {
+            node.setAttribute(name, value);
+        }
+        for (const child of children) {
+            if (child !== null && child !== undefined) {
+                node.append(child);
+            }
+        }
+        return node;
+    }
+
+    // text turns Markdown-ish descriptions into paragraphs, with `code`.
+    function text(description) {
+        const fragment = document.createDocumentFragment();
+        for (const paragraph of (description || '').split(/\n\s*\n/)) {
+            if (!paragraph.trim()) {
+                continue;
+            }
+            const p = el('p');
+            paragraph.split(/(`[^`]*`)/).forEach(part => {
+                p.append(part.startsWith('`') && part.endsWith('`') ? el('code', {}, part.slice(1, -1)) : part);
+            });
+            fragment.append(p);
+        }
+        return fragment;
+    }
+
+    function refName(ref) {
+        return ref.split('/').pop();
+    }
+
+    function resolve(spec, object) {
+        while (object && object.$ref) {
+            const path = object.$ref.replace(/^#\//, '').split('/');
+            object = path.reduce((node, key) => node && node[key], spec);
+        }
+        return object;
+    }
+
+    function typeOf(schema) {
+        if (!schema) {
+            return el('span', {class: 'type'}, 'any');
+        }
+        if (schema.$ref) {
+            return el('a', {class: 'type', href: '#schema-' + refName(schema.$ref)}, refName(schema.$ref));
+        }
+        if (schema.allOf && schema.allOf.length === 1) {
+            const type = el('span', {}, typeOf(schema.allOf[0]));
+            if (schema.nullable) {
+                type.append(el('span', {class: 'type'}, ' | null'));
+            }
+            return type;
+        }
+        if (schema.type === 'array') {
+            return el('span', {}, el('span', {class: 'type'}, 'array of '), typeOf(schema.items));
+        }
+        let type = schema.type || 'any';
+        if (schema.format) {
+            type += ' (' + schema.format + ')';
+        }
+        if (schema.nullable) {
+            type += ' | null';
+        }
+        return el('span', {class: 'type'}, type);
+    }
+
+    function constraints(schema) {
+        const parts = [];
+        if (schema.enum) {
+            parts.push('one of ' + schema.enum.join(', '));
+        }
+        if (schema.minimum !== undefined) {
+            parts.push('min ' + schema.minimum);
+        }
+        if (schema.maximum !== undefined) {
+            parts.push('max ' + schema.maximum);
+        }
+        if (schema.minLength !== undefined) {
+            parts.push('min length ' + schema.minLength);
+        }
+        if (schema.pattern) {
+            parts.push('pattern ' + schema.pattern);
+        }
+        if (schema.default !== undefined) {
+            parts.push('default ' + schema.default);
+        }
+        return parts.length ? el('span', {class: 'text-muted'}, ' - ' + parts.join('; ')) : null;
+    }
+
+    // schemaBody lists the properties of an object schema; named schemas
+    // they refer to are links rather than expanded, so cycles are harmless.
+    function schemaBody(schema) {
+        const body = el('div', {class: 'schema'});
+        if (schema.description) {
+            body.append(text(schema.description));
+        }
+        if (schema.type !== 'object' || !schema.properties) {
+            body.append(el('div', {}, typeOf(schema), constraints(schema)));
+            return body;
+        }
+
+        const list = el('ul');
+        const required = schema.required || [];
+        for (const [name, property] of Object.entries(schema.properties)) {
+            list.append(el('li', {},
+                el('code', {}, name), ' ', typeOf(property),
+                required.includes(name) ? el('span', {class: 'required'}, ' required') : null,
+                constraints(property),
+                property.description ? el('div', {class: 'text-muted'}, property.description) : null));
+        }
+        body.append(list);
+        if (schema.additionalProperties === false) {
+            body.append(el('div', {class: 'text-muted'}, 'Other properties are rejected.'));
+        }
+        return body;
+    }
+
+    function content(spec, object) {
+        const media = object && object.content && object.content['application/json'];
+        if (!media || !media.schema) {
+            return null;
+        }
+        return media.schema.$ref ? el('div', {class: 'schema'}, typeOf(media.schema)) : schemaBody(media.schema);
+    }
+
+    function operation(spec, path, method, op, shared) {
+        const id = 'op-' + op.operationId;
+        const card = el('section', {class: 'card mb-3', id: id});
+        card.append(el('div', {class: 'card-header'},
+            el('span', {class: 'badge method method-' + method + ' me-2'}, method.toUpperCase()),
+            el('code', {}, path), ' ', el('span', {class: 'text-muted'}, op.summary || '')));
+
+        const body = el('div', {class: 'card-body'});
+        body.append(text(op.description));
+
+        const security = op.security || spec.security || [];
+        body.append(el('p', {class: 'small text-muted'},
+            security.length ? 'Send the session token as a bearer token.' : 'No session needed.'));
+
+        const parameters = [...(op.parameters || []), ...shared].map(p => resolve(spec, p));
+        if (parameters.length) {
+            const rows = el('tbody');
+            for (const p of parameters) {
+                rows.append(el('tr', {},
+                    el('td', {}, el('code', {}, p.name), p.required ? el('span', {class: 'required'}, ' required') : null),
+                    el('td', {}, p.in),
+                    el('td', {}, typeOf(p.schema), constraints(resolve(spec, p.schema) || {})),
+                    el('td', {}, p.description || '')));
+            }
+            body.append(el('h6', {}, 'Parameters'), el('table', {class: 'table table-sm small'},
+                el('thead', {}, el('tr', {}, el('th', {}, 'Name'), el('th', {}, 'In'), el('th', {}, 'Type'), el('th', {}, 'Description'))),
+                rows));
+        }
+
+        if (op.requestBody) {
+            body.append(el('h6', {}, 'Request body', op.requestBody.required ? '' : ' (optional)'), content(spec, op.requestBody));
+        }
+
+        const responses = el('dl', {class: 'row small mb-0'});
+        for (const [status, ref] of Object.entries(op.responses || {})) {
+            const response = resolve(spec, ref);
+            responses.append(
+                el('dt', {class: 'col-sm-2'}, status),
+                el('dd', {class: 'col-sm-10'}, response.description || '', content(spec, response)));
+        }
+        body.append(el('h6', {class: 'mt-3'}, 'Responses'), responses);
+
+        card.append(body);
+        return card;
+    }
+
+    function render(spec) {
+        main.replaceChildren();
+        nav.replaceChildren();
+
+        const base = (spec.servers && spec.servers[0] && spec.servers[0].url) || '';
+        main.append(el('h1', {}, spec.info.title, ' ', el('small', {class: 'text-muted'}, spec.info.version)));
+        main.append(el('p', {}, 'Base path ', el('code', {}, base)));
+        main.append(text(spec.info.description));
+
+        const tags = (spec.tags || []).map(tag => tag.name);
+        const byTag = {};
+        for (const [path, item] of Object.entries(spec.paths)) {
+            for (const method of methods) {
+                const op = item[method];
+                if (!op) {
+                    continue;
+                }
+                const tag = (op.tags && op.tags[0]) || 'Other';
+                if (!tags.includes(tag)) {
+                    tags.push(tag);
+                }
+                const group = byTag[tag] = byTag[tag] || {cards: [], links: []};
+                group.cards.push(operation(spec, path, method, op, item.parameters || []));
+                group.links.push(el('li', {}, el('a', {href: '#op-' + op.operationId},
+                    el('span', {class: 'type'}, method.toUpperCase()), ' ', path)));
+            }
+        }
+
+        for (const tag of tags) {
+            if (!byTag[tag]) {
+                continue;
+            }
+            main.append(el('h2', {class: 'mt-4', id: 'tag-' + tag}, tag), ...byTag[tag].cards);
+            nav.append(el('h6', {class: 'mt-3'}, el('a', {href: '#tag-' + tag}, tag)),
+                el('ul', {class: 'list-unstyled'}, ...byTag[tag].links));
+        }
+
+        const schemas = Object.entries((spec.components && spec.components.schemas) || {});
+        if (schemas.length) {
+            main.append(el('h2', {class: 'mt-4', id: 'schemas'}, 'Schemas'));
+            nav.append(el('h6', {class: 'mt-3'}, el('a', {href: '#schemas'}, 'Schemas')));
+            for (const [name, schema] of schemas) {
+                main.append(el('section', {class: 'card mb-3', id: 'schema-' + name},
+                    el('div', {class: 'card-header'}, el('code', {}, name)),
+                    el('div', {class: 'card-body'}, schemaBody(schema))));
+            }
+        }
+
+        if (location.hash) {
+            const target = document.getElementById(decodeURIComponent(location.hash.slice(1)));
+            if (target) {
+                target.scrollIntoView();
+            }
+        }
+    }
+
+    fetch(main.dataset.spec, {headers: {Accept: 'application/json'}})
+        .then(response => {
+            if (!response.ok) {
+                throw new Error(response.status + ' ' + response.statusText);
+            }
+            return response.json();
+        })
+        .then(render)
+        .catch(err => {
+            main.replaceChildren(el('div', {class: 'alert alert-danger'}, 'Could not load the API reference: ' + err.message));
+        });
+})();